@@ -31,12 +31,24 @@ function appendQueryParams(url: string, queryParams): string {
   return url + "?" + queryParams.toString();
 }
 
+// double-submit token, set by backend in "csrf" cookie
+function getCsrfToken(): string {
+  const match = document.cookie.match(/(?:^|; )csrf=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : "";
+}
+
 function prepareBody(body, method) {
   if (!methodsWithBody.includes(method)) {
     return;
   }
 
+  if (!body) {
+    body = new URLSearchParams();
+  }
+
   if (body instanceof URLSearchParams || body instanceof FormData) {
+    // no-cors mode drops custom headers, so token goes in body
+    body.append("csrf_token", getCsrfToken());
     return body;
   }
 
@@ -52,7 +64,7 @@ function getOptions(props) {
   } = props;
 
   return {
-    headers: new Headers({ ...headers }),
+    headers: new Headers({ ...headers, 'X-CSRF-Token': getCsrfToken() }),
     body: prepareBody(body, method),
     mode: 'no-cors',
     method,
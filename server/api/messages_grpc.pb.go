@@ -11,6 +11,7 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
 const _ = grpc.SupportPackageIsVersion7
 
 // MessagesClient is the client API for Messages service.
@@ -20,6 +21,31 @@ type MessagesClient interface {
 	GetServers(ctx context.Context, in *GetServersRequest, opts ...grpc.CallOption) (*GetServersResponse, error)
 	SaveMessage(ctx context.Context, in *SaveMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error)
 	ReadUserMessages(ctx context.Context, in *ReadUserMessagesRequest, opts ...grpc.CallOption) (*ReadUserMessagesResponse, error)
+	UpdateMessage(ctx context.Context, in *UpdateMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error)
+	// message versions, restore one, prune old ones
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error)
+	PruneVersions(ctx context.Context, in *PruneVersionsRequest, opts ...grpc.CallOption) (*PruneVersionsResponse, error)
+	// deleted messages go to trash, purged after retention
+	DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error)
+	ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ReadUserMessagesResponse, error)
+	RestoreMessage(ctx context.Context, in *RestoreMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error)
+	EmptyTrash(ctx context.Context, in *EmptyTrashRequest, opts ...grpc.CallOption) (*EmptyTrashResponse, error)
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*UsageResponse, error)
+	// changes of user messages after since token
+	SyncMessages(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error)
+	// live events of user messages, resumes after last_event_id
+	Feed(ctx context.Context, in *FeedRequest, opts ...grpc.CallOption) (Messages_FeedClient, error)
+	// ordered changes with log index, for internal consumers
+	WatchMessages(ctx context.Context, in *WatchMessagesRequest, opts ...grpc.CallOption) (Messages_WatchMessagesClient, error)
+	// admin, service callers only
+	ListUsage(ctx context.Context, in *ListUsageRequest, opts ...grpc.CallOption) (*ListUsageResponse, error)
+	SetQuota(ctx context.Context, in *SetQuotaRequest, opts ...grpc.CallOption) (*UsageResponse, error)
+	// gossip keyring, service callers only
+	ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*KeysResponse, error)
+	InstallKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeysResponse, error)
+	UseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeysResponse, error)
+	RemoveKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeysResponse, error)
 }
 
 type messagesClient struct {
@@ -57,6 +83,214 @@ func (c *messagesClient) ReadUserMessages(ctx context.Context, in *ReadUserMessa
 	return out, nil
 }
 
+func (c *messagesClient) UpdateMessage(ctx context.Context, in *UpdateMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error) {
+	out := new(SaveMessageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/UpdateMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/ListVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error) {
+	out := new(SaveMessageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/RestoreVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) PruneVersions(ctx context.Context, in *PruneVersionsRequest, opts ...grpc.CallOption) (*PruneVersionsResponse, error) {
+	out := new(PruneVersionsResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/PruneVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error) {
+	out := new(SaveMessageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/DeleteMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ReadUserMessagesResponse, error) {
+	out := new(ReadUserMessagesResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/ListTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) RestoreMessage(ctx context.Context, in *RestoreMessageRequest, opts ...grpc.CallOption) (*SaveMessageResponse, error) {
+	out := new(SaveMessageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/RestoreMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) EmptyTrash(ctx context.Context, in *EmptyTrashRequest, opts ...grpc.CallOption) (*EmptyTrashResponse, error) {
+	out := new(EmptyTrashResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/EmptyTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*UsageResponse, error) {
+	out := new(UsageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/GetUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) SyncMessages(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error) {
+	out := new(SyncResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/SyncMessages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) Feed(ctx context.Context, in *FeedRequest, opts ...grpc.CallOption) (Messages_FeedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Messages_ServiceDesc.Streams[0], "/messages.v1.Messages/Feed", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messagesFeedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Messages_FeedClient interface {
+	Recv() (*MessageEvent, error)
+	grpc.ClientStream
+}
+
+type messagesFeedClient struct {
+	grpc.ClientStream
+}
+
+func (x *messagesFeedClient) Recv() (*MessageEvent, error) {
+	m := new(MessageEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messagesClient) WatchMessages(ctx context.Context, in *WatchMessagesRequest, opts ...grpc.CallOption) (Messages_WatchMessagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Messages_ServiceDesc.Streams[1], "/messages.v1.Messages/WatchMessages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messagesWatchMessagesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Messages_WatchMessagesClient interface {
+	Recv() (*MessageEvent, error)
+	grpc.ClientStream
+}
+
+type messagesWatchMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *messagesWatchMessagesClient) Recv() (*MessageEvent, error) {
+	m := new(MessageEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messagesClient) ListUsage(ctx context.Context, in *ListUsageRequest, opts ...grpc.CallOption) (*ListUsageResponse, error) {
+	out := new(ListUsageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/ListUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) SetQuota(ctx context.Context, in *SetQuotaRequest, opts ...grpc.CallOption) (*UsageResponse, error) {
+	out := new(UsageResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/SetQuota", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) ListKeys(ctx context.Context, in *ListKeysRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
+	out := new(KeysResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/ListKeys", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) InstallKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
+	out := new(KeysResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/InstallKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) UseKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
+	out := new(KeysResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/UseKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesClient) RemoveKey(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*KeysResponse, error) {
+	out := new(KeysResponse)
+	err := c.cc.Invoke(ctx, "/messages.v1.Messages/RemoveKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MessagesServer is the server API for Messages service.
 // All implementations must embed UnimplementedMessagesServer
 // for forward compatibility
@@ -64,6 +298,31 @@ type MessagesServer interface {
 	GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error)
 	SaveMessage(context.Context, *SaveMessageRequest) (*SaveMessageResponse, error)
 	ReadUserMessages(context.Context, *ReadUserMessagesRequest) (*ReadUserMessagesResponse, error)
+	UpdateMessage(context.Context, *UpdateMessageRequest) (*SaveMessageResponse, error)
+	// message versions, restore one, prune old ones
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
+	RestoreVersion(context.Context, *RestoreVersionRequest) (*SaveMessageResponse, error)
+	PruneVersions(context.Context, *PruneVersionsRequest) (*PruneVersionsResponse, error)
+	// deleted messages go to trash, purged after retention
+	DeleteMessage(context.Context, *DeleteMessageRequest) (*SaveMessageResponse, error)
+	ListTrash(context.Context, *ListTrashRequest) (*ReadUserMessagesResponse, error)
+	RestoreMessage(context.Context, *RestoreMessageRequest) (*SaveMessageResponse, error)
+	EmptyTrash(context.Context, *EmptyTrashRequest) (*EmptyTrashResponse, error)
+	GetUsage(context.Context, *GetUsageRequest) (*UsageResponse, error)
+	// changes of user messages after since token
+	SyncMessages(context.Context, *SyncRequest) (*SyncResponse, error)
+	// live events of user messages, resumes after last_event_id
+	Feed(*FeedRequest, Messages_FeedServer) error
+	// ordered changes with log index, for internal consumers
+	WatchMessages(*WatchMessagesRequest, Messages_WatchMessagesServer) error
+	// admin, service callers only
+	ListUsage(context.Context, *ListUsageRequest) (*ListUsageResponse, error)
+	SetQuota(context.Context, *SetQuotaRequest) (*UsageResponse, error)
+	// gossip keyring, service callers only
+	ListKeys(context.Context, *ListKeysRequest) (*KeysResponse, error)
+	InstallKey(context.Context, *KeyRequest) (*KeysResponse, error)
+	UseKey(context.Context, *KeyRequest) (*KeysResponse, error)
+	RemoveKey(context.Context, *KeyRequest) (*KeysResponse, error)
 	mustEmbedUnimplementedMessagesServer()
 }
 
@@ -80,6 +339,60 @@ func (UnimplementedMessagesServer) SaveMessage(context.Context, *SaveMessageRequ
 func (UnimplementedMessagesServer) ReadUserMessages(context.Context, *ReadUserMessagesRequest) (*ReadUserMessagesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReadUserMessages not implemented")
 }
+func (UnimplementedMessagesServer) UpdateMessage(context.Context, *UpdateMessageRequest) (*SaveMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateMessage not implemented")
+}
+func (UnimplementedMessagesServer) ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedMessagesServer) RestoreVersion(context.Context, *RestoreVersionRequest) (*SaveMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedMessagesServer) PruneVersions(context.Context, *PruneVersionsRequest) (*PruneVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PruneVersions not implemented")
+}
+func (UnimplementedMessagesServer) DeleteMessage(context.Context, *DeleteMessageRequest) (*SaveMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMessage not implemented")
+}
+func (UnimplementedMessagesServer) ListTrash(context.Context, *ListTrashRequest) (*ReadUserMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrash not implemented")
+}
+func (UnimplementedMessagesServer) RestoreMessage(context.Context, *RestoreMessageRequest) (*SaveMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreMessage not implemented")
+}
+func (UnimplementedMessagesServer) EmptyTrash(context.Context, *EmptyTrashRequest) (*EmptyTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EmptyTrash not implemented")
+}
+func (UnimplementedMessagesServer) GetUsage(context.Context, *GetUsageRequest) (*UsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedMessagesServer) SyncMessages(context.Context, *SyncRequest) (*SyncResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SyncMessages not implemented")
+}
+func (UnimplementedMessagesServer) Feed(*FeedRequest, Messages_FeedServer) error {
+	return status.Errorf(codes.Unimplemented, "method Feed not implemented")
+}
+func (UnimplementedMessagesServer) WatchMessages(*WatchMessagesRequest, Messages_WatchMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMessages not implemented")
+}
+func (UnimplementedMessagesServer) ListUsage(context.Context, *ListUsageRequest) (*ListUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsage not implemented")
+}
+func (UnimplementedMessagesServer) SetQuota(context.Context, *SetQuotaRequest) (*UsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetQuota not implemented")
+}
+func (UnimplementedMessagesServer) ListKeys(context.Context, *ListKeysRequest) (*KeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListKeys not implemented")
+}
+func (UnimplementedMessagesServer) InstallKey(context.Context, *KeyRequest) (*KeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InstallKey not implemented")
+}
+func (UnimplementedMessagesServer) UseKey(context.Context, *KeyRequest) (*KeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UseKey not implemented")
+}
+func (UnimplementedMessagesServer) RemoveKey(context.Context, *KeyRequest) (*KeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveKey not implemented")
+}
 func (UnimplementedMessagesServer) mustEmbedUnimplementedMessagesServer() {}
 
 // UnsafeMessagesServer may be embedded to opt out of forward compatibility for this service.
@@ -89,8 +402,8 @@ type UnsafeMessagesServer interface {
 	mustEmbedUnimplementedMessagesServer()
 }
 
-func RegisterMessagesServer(s *grpc.Server, srv MessagesServer) {
-	s.RegisterService(&_Messages_serviceDesc, srv)
+func RegisterMessagesServer(s grpc.ServiceRegistrar, srv MessagesServer) {
+	s.RegisterService(&Messages_ServiceDesc, srv)
 }
 
 func _Messages_GetServers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
@@ -147,7 +460,340 @@ func _Messages_ReadUserMessages_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
-var _Messages_serviceDesc = grpc.ServiceDesc{
+func _Messages_UpdateMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).UpdateMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/UpdateMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).UpdateMessage(ctx, req.(*UpdateMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/ListVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/RestoreVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).RestoreVersion(ctx, req.(*RestoreVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_PruneVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PruneVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).PruneVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/PruneVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).PruneVersions(ctx, req.(*PruneVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_DeleteMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).DeleteMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/DeleteMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).DeleteMessage(ctx, req.(*DeleteMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_ListTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).ListTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/ListTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).ListTrash(ctx, req.(*ListTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_RestoreMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).RestoreMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/RestoreMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).RestoreMessage(ctx, req.(*RestoreMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_EmptyTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmptyTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).EmptyTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/EmptyTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).EmptyTrash(ctx, req.(*EmptyTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/GetUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_SyncMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).SyncMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/SyncMessages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).SyncMessages(ctx, req.(*SyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_Feed_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FeedRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessagesServer).Feed(m, &messagesFeedServer{stream})
+}
+
+type Messages_FeedServer interface {
+	Send(*MessageEvent) error
+	grpc.ServerStream
+}
+
+type messagesFeedServer struct {
+	grpc.ServerStream
+}
+
+func (x *messagesFeedServer) Send(m *MessageEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Messages_WatchMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMessagesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessagesServer).WatchMessages(m, &messagesWatchMessagesServer{stream})
+}
+
+type Messages_WatchMessagesServer interface {
+	Send(*MessageEvent) error
+	grpc.ServerStream
+}
+
+type messagesWatchMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *messagesWatchMessagesServer) Send(m *MessageEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Messages_ListUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).ListUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/ListUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).ListUsage(ctx, req.(*ListUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_SetQuota_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetQuotaRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).SetQuota(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/SetQuota",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).SetQuota(ctx, req.(*SetQuotaRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_ListKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).ListKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/ListKeys",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).ListKeys(ctx, req.(*ListKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_InstallKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).InstallKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/InstallKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).InstallKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_UseKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).UseKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/UseKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).UseKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Messages_RemoveKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServer).RemoveKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/messages.v1.Messages/RemoveKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServer).RemoveKey(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Messages_ServiceDesc is the grpc.ServiceDesc for Messages service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Messages_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "messages.v1.Messages",
 	HandlerType: (*MessagesServer)(nil),
 	Methods: []grpc.MethodDesc{
@@ -163,7 +809,82 @@ var _Messages_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ReadUserMessages",
 			Handler:    _Messages_ReadUserMessages_Handler,
 		},
+		{
+			MethodName: "UpdateMessage",
+			Handler:    _Messages_UpdateMessage_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _Messages_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _Messages_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "PruneVersions",
+			Handler:    _Messages_PruneVersions_Handler,
+		},
+		{
+			MethodName: "DeleteMessage",
+			Handler:    _Messages_DeleteMessage_Handler,
+		},
+		{
+			MethodName: "ListTrash",
+			Handler:    _Messages_ListTrash_Handler,
+		},
+		{
+			MethodName: "RestoreMessage",
+			Handler:    _Messages_RestoreMessage_Handler,
+		},
+		{
+			MethodName: "EmptyTrash",
+			Handler:    _Messages_EmptyTrash_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _Messages_GetUsage_Handler,
+		},
+		{
+			MethodName: "SyncMessages",
+			Handler:    _Messages_SyncMessages_Handler,
+		},
+		{
+			MethodName: "ListUsage",
+			Handler:    _Messages_ListUsage_Handler,
+		},
+		{
+			MethodName: "SetQuota",
+			Handler:    _Messages_SetQuota_Handler,
+		},
+		{
+			MethodName: "ListKeys",
+			Handler:    _Messages_ListKeys_Handler,
+		},
+		{
+			MethodName: "InstallKey",
+			Handler:    _Messages_InstallKey_Handler,
+		},
+		{
+			MethodName: "UseKey",
+			Handler:    _Messages_UseKey_Handler,
+		},
+		{
+			MethodName: "RemoveKey",
+			Handler:    _Messages_RemoveKey_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Feed",
+			Handler:       _Messages_Feed_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchMessages",
+			Handler:       _Messages_WatchMessages_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "protos/messages.proto",
 }
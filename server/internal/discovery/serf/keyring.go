@@ -0,0 +1,133 @@
+package discovery
+
+import (
+  "os"
+  "fmt"
+  "errors"
+  "strings"
+  "encoding/json"
+  "encoding/base64"
+
+  "github.com/hashicorp/serf/serf"
+  "github.com/hashicorp/memberlist"
+)
+
+var ErrNoEncryption = errors.New("gossip encryption is not enabled")
+
+/**
+ * Keys maps base64 key to number of nodes having it installed.
+ * Rotation is complete once NumNodes have the new primary key
+ */
+type Keys struct {
+  Keys map[string]int
+  NumNodes int
+}
+
+/**
+ * loadKeyring reads keyring file, json array of base64 keys,
+ * the first one is primary. Missing file is created from
+ * EncryptKey, so serf records later rotations in it
+ */
+func (m *Membership) loadKeyring() (*memberlist.Keyring, error) {
+  if m.KeyringFile != "" {
+    b, err := os.ReadFile(m.KeyringFile)
+    switch {
+    case err == nil:
+      return parseKeyring(b)
+    case !os.IsNotExist(err):
+      return nil, err
+    }
+  }
+
+  if len(m.EncryptKey) == 0 {
+    return nil, nil
+  }
+  if m.KeyringFile != "" {
+    b, err := json.Marshal([]string{base64.StdEncoding.EncodeToString(m.EncryptKey)})
+    if err != nil {
+      return nil, err
+    }
+    if err := os.WriteFile(m.KeyringFile, b, 0600); err != nil {
+      return nil, err
+    }
+  }
+  return memberlist.NewKeyring(nil, m.EncryptKey)
+}
+
+func parseKeyring(b []byte) (*memberlist.Keyring, error) {
+  var encoded []string
+  if err := json.Unmarshal(b, &encoded); err != nil {
+    return nil, err
+  }
+  if len(encoded) == 0 {
+    return nil, errors.New("keyring file has no keys")
+  }
+
+  keys := make([][]byte, 0, len(encoded))
+  for _, s := range encoded {
+    key, err := ParseKey(s)
+    if err != nil {
+      return nil, err
+    }
+    keys = append(keys, key)
+  }
+  return memberlist.NewKeyring(keys, keys[0])
+}
+
+// InstallKey adds key to every node keyring
+func (m *Membership) InstallKey(key string) (*Keys, error) {
+  if err := m.checkKey(key); err != nil {
+    return nil, err
+  }
+  return keys(m.serf.KeyManager().InstallKey(key))
+}
+
+// UseKey makes installed key primary, it encrypts gossip from now on
+func (m *Membership) UseKey(key string) (*Keys, error) {
+  if err := m.checkKey(key); err != nil {
+    return nil, err
+  }
+  return keys(m.serf.KeyManager().UseKey(key))
+}
+
+// RemoveKey drops key from every node, primary key cannot be removed
+func (m *Membership) RemoveKey(key string) (*Keys, error) {
+  if err := m.checkKey(key); err != nil {
+    return nil, err
+  }
+  return keys(m.serf.KeyManager().RemoveKey(key))
+}
+
+func (m *Membership) ListKeys() (*Keys, error) {
+  if !m.serf.EncryptionEnabled() {
+    return nil, ErrNoEncryption
+  }
+  return keys(m.serf.KeyManager().ListKeys())
+}
+
+func (m *Membership) checkKey(key string) error {
+  if !m.serf.EncryptionEnabled() {
+    return ErrNoEncryption
+  }
+  b, err := ParseKey(key)
+  if err == nil && b == nil {
+    err = errors.New("key is empty")
+  }
+  return err
+}
+
+// keys reports failure of any node as error
+func keys(resp *serf.KeyResponse, err error) (*Keys, error) {
+  if err != nil && resp == nil {
+    return nil, err
+  }
+  if resp.NumErr != 0 || err != nil {
+    messages := make([]string, 0, len(resp.Messages))
+    for node, message := range resp.Messages {
+      messages = append(messages, fmt.Sprintf("%s: %s", node, message))
+    }
+    return nil, fmt.Errorf("%d of %d nodes failed: %s",
+      resp.NumErr, resp.NumNodes, strings.Join(messages, "; "))
+  }
+  return &Keys{Keys: resp.Keys, NumNodes: resp.NumNodes}, nil
+}
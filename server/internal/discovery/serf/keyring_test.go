@@ -0,0 +1,121 @@
+package discovery_test
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "testing"
+  "encoding/json"
+  "path/filepath"
+
+  "github.com/stretchr/testify/require"
+
+  discovery "github.com/bd878/gallery/server/internal/discovery/serf"
+)
+
+const (
+  oldKey = "T9jncgl9mbLus+baTTa7q7nPSUrXwbDi2dhbtqir37s="
+  newKey = "HvY8ubRZMgafUOWvrOadwOckVa1wN3QWAo46FVKbVN8="
+)
+
+func readKeyring(t *testing.T, path string) []string {
+  b, err := os.ReadFile(path)
+  require.NoError(t, err)
+  var keys []string
+  require.NoError(t, json.Unmarshal(b, &keys))
+  return keys
+}
+
+func TestKeyRotation(t *testing.T) {
+  dir := t.TempDir()
+  key, err := discovery.ParseKey(oldKey)
+  require.NoError(t, err)
+
+  var members []*discovery.Membership
+  var keyrings []string
+  for i := 0; i < 3; i++ {
+    cfg := discovery.Config{
+      NodeName: fmt.Sprintf("%d", i),
+      BindAddr: fmt.Sprintf("127.0.0.1:%d", 8020 + i),
+      EncryptKey: key,
+      KeyringFile: filepath.Join(dir, fmt.Sprintf("keyring-%d.json", i)),
+    }
+    if i > 0 {
+      cfg.SerfJoinAddrs = []string{"127.0.0.1:8020"}
+    }
+    m, err := discovery.New(cfg, &handler{})
+    require.NoError(t, err)
+    t.Cleanup(func() { m.Leave() })
+
+    members = append(members, m)
+    keyrings = append(keyrings, cfg.KeyringFile)
+  }
+  require.Equal(t, []string{oldKey}, readKeyring(t, keyrings[0]))
+  require.Eventually(t, func() bool {
+    for _, m := range members {
+      if len(m.Members()) != 3 {
+        return false
+      }
+    }
+    return true
+  }, 3*time.Second, 50*time.Millisecond)
+
+  keys, err := members[1].InstallKey(newKey)
+  require.NoError(t, err)
+  require.Equal(t, 3, keys.NumNodes)
+
+  keys, err = members[2].ListKeys()
+  require.NoError(t, err)
+  require.Equal(t, map[string]int{oldKey: 3, newKey: 3}, keys.Keys)
+
+  // primary key is in use, it cannot be removed
+  _, err = members[0].RemoveKey(oldKey)
+  require.Error(t, err)
+
+  _, err = members[0].UseKey(newKey)
+  require.NoError(t, err)
+  _, err = members[0].RemoveKey(oldKey)
+  require.NoError(t, err)
+
+  keys, err = members[0].ListKeys()
+  require.NoError(t, err)
+  require.Equal(t, map[string]int{newKey: 3}, keys.Keys)
+  for _, keyring := range keyrings {
+    require.Equal(t, []string{newKey}, readKeyring(t, keyring))
+  }
+
+  // restarted node reads rotated keyring, EncryptKey is ignored
+  require.NoError(t, members[2].Leave())
+  restarted, err := discovery.New(discovery.Config{
+    NodeName: "2",
+    BindAddr: "127.0.0.1:8023",
+    EncryptKey: key,
+    KeyringFile: keyrings[2],
+    SerfJoinAddrs: []string{"127.0.0.1:8020"},
+  }, &handler{})
+  require.NoError(t, err)
+  t.Cleanup(func() { restarted.Leave() })
+
+  // old key no longer lets a node in
+  _, err = discovery.New(discovery.Config{
+    NodeName: "stale",
+    BindAddr: "127.0.0.1:8024",
+    EncryptKey: key,
+    SerfJoinAddrs: []string{"127.0.0.1:8020"},
+  }, &handler{})
+  require.Error(t, err)
+}
+
+func TestKeyringWithoutEncryption(t *testing.T) {
+  m, err := discovery.New(discovery.Config{
+    NodeName: "plain",
+    BindAddr: "127.0.0.1:8025",
+  }, &handler{})
+  require.NoError(t, err)
+  defer m.Leave()
+
+  _, err = m.ListKeys()
+  require.ErrorIs(t, err, discovery.ErrNoEncryption)
+  _, err = m.InstallKey(newKey)
+  require.ErrorIs(t, err, discovery.ErrNoEncryption)
+}
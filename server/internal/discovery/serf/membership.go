@@ -3,6 +3,7 @@ package discovery
 import (
   "fmt"
   "net"
+  "encoding/base64"
   "github.com/hashicorp/serf/serf"
 )
 
@@ -18,6 +19,12 @@ type Config struct {
   BindAddr       string
   Tags           map[string]string
   SerfJoinAddrs  []string
+  // gossip encryption key, 16, 24 or 32 bytes,
+  // plain gossip when empty
+  EncryptKey     []byte
+  // keys of the keyring, kept by serf on rotation.
+  // Takes precedence over EncryptKey once written
+  KeyringFile    string
 }
 
 func New(config Config, handler Handler) (*Membership, error) {
@@ -31,6 +38,23 @@ func New(config Config, handler Handler) (*Membership, error) {
   return c, nil
 }
 
+// ParseKey decodes base64 gossip key, as "serf keygen" prints it
+func ParseKey(key string) ([]byte, error) {
+  if key == "" {
+    return nil, nil
+  }
+  b, err := base64.StdEncoding.DecodeString(key)
+  if err != nil {
+    return nil, err
+  }
+  switch len(b) {
+  case 16, 24, 32:
+    return b, nil
+  default:
+    return nil, fmt.Errorf("gossip key must be 16, 24 or 32 bytes, got %d", len(b))
+  }
+}
+
 func (m *Membership) setupSerf() error {
   addr, err := net.ResolveTCPAddr("tcp", m.BindAddr)
   if err != nil {
@@ -44,6 +68,11 @@ func (m *Membership) setupSerf() error {
   config.EventCh = m.events
   config.Tags = m.Tags
   config.NodeName = m.Config.NodeName
+  config.KeyringFile = m.KeyringFile
+  config.MemberlistConfig.Keyring, err = m.loadKeyring()
+  if err != nil {
+    return err
+  }
 
   m.serf, err = serf.Create(config)
   if err != nil {
@@ -4,7 +4,7 @@ import (
   "testing"
   "fmt"
   "time"
-  discovery "github.com/bd878/gallery/server/messages/internal/discovery/serf"
+  discovery "github.com/bd878/gallery/server/internal/discovery/serf"
 )
 
 func TestMembership(t *testing.T) {
@@ -32,7 +32,7 @@ func TestMembership(t *testing.T) {
       joins: make(chan map[string]string, 3),
       leaves: make(chan string, 3),
     }
-    m, err := discovery.New(h, c)
+    m, err := discovery.New(c, h)
     if err != nil {
       t.Fatal(err)
     }
@@ -80,4 +80,55 @@ func (h *handler) Leave(id string) error {
     h.leaves <- id
   }
   return nil
-}
\ No newline at end of file
+}
+
+func (h *handler) PrintLeader() error { return nil }
+
+func (h *handler) PrintConfig() error { return nil }
+
+func (h *handler) PrintMyAddr() error { return nil }
+
+func TestEncryption(t *testing.T) {
+  key, err := discovery.ParseKey("T9jncgl9mbLus+baTTa7q7nPSUrXwbDi2dhbtqir37s=")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := discovery.ParseKey("c2hvcnQ="); err == nil {
+    t.Fatal("short key is accepted")
+  }
+
+  h := &handler{joins: make(chan map[string]string, 3)}
+  _, err = discovery.New(discovery.Config{
+    NodeName: "0",
+    BindAddr: "127.0.0.1:8010",
+    EncryptKey: key,
+  }, h)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // plain node cannot gossip with encrypted cluster
+  _, err = discovery.New(discovery.Config{
+    NodeName: "1",
+    BindAddr: "127.0.0.1:8011",
+    SerfJoinAddrs: []string{"127.0.0.1:8010"},
+  }, &handler{})
+  if err == nil {
+    t.Error("node without key joined")
+  }
+
+  _, err = discovery.New(discovery.Config{
+    NodeName: "2",
+    BindAddr: "127.0.0.1:8012",
+    SerfJoinAddrs: []string{"127.0.0.1:8010"},
+    EncryptKey: key,
+  }, &handler{})
+  if err != nil {
+    t.Fatal(err)
+  }
+  time.Sleep(250*time.Millisecond)
+
+  if len(h.joins) != 1 {
+    t.Errorf("joins != 1, got %d\n", len(h.joins))
+  }
+}
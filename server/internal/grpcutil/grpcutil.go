@@ -2,12 +2,65 @@ package grpcutil
 
 import (
   "context"
+  "crypto/tls"
+  "crypto/subtle"
 
   "google.golang.org/grpc"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/grpc/credentials"
   "google.golang.org/grpc/credentials/insecure"
 )
 
-func ServiceConnection(_ context.Context, addr string) (*grpc.ClientConn, error) {
+// Credentials wrap tls config, nil config means plain connection
+func Credentials(tlsConfig *tls.Config) credentials.TransportCredentials {
+  if tlsConfig == nil {
+    return insecure.NewCredentials()
+  }
+  return credentials.NewTLS(tlsConfig)
+}
+
+func ServiceConnection(_ context.Context, addr string, creds credentials.TransportCredentials) (*grpc.ClientConn, error) {
   // todo: service registry
-  return grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-}
\ No newline at end of file
+  if creds == nil {
+    creds = insecure.NewCredentials()
+  }
+  return grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+}
+
+const serviceScheme = "Service "
+
+type serviceCredentials struct {
+  token string
+}
+
+/**
+ * ServiceCredentials identify cluster nodes and other
+ * services calling with shared token, in "authorization"
+ * metadata
+ */
+func ServiceCredentials(token string) credentials.PerRPCCredentials {
+  return serviceCredentials{token}
+}
+
+func (c serviceCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+  return map[string]string{"authorization": serviceScheme + c.token}, nil
+}
+
+// tls is optional, dev setups send tokens over plain tcp
+func (c serviceCredentials) RequireTransportSecurity() bool {
+  return false
+}
+
+// IsService reports call carries service token, empty token matches none
+func IsService(ctx context.Context, token string) bool {
+  if token == "" {
+    return false
+  }
+  md, _ := metadata.FromIncomingContext(ctx)
+  for _, value := range md.Get("authorization") {
+    if subtle.ConstantTimeCompare([]byte(value), []byte(serviceScheme + token)) == 1 {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,23 @@
+package grpcutil_test
+
+import (
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+  "google.golang.org/grpc/metadata"
+
+  "github.com/bd878/gallery/server/internal/grpcutil"
+)
+
+func TestIsService(t *testing.T) {
+  md, err := grpcutil.ServiceCredentials("secret").GetRequestMetadata(context.Background())
+  require.NoError(t, err)
+  ctx := metadata.NewIncomingContext(context.Background(), metadata.New(md))
+
+  require.True(t, grpcutil.IsService(ctx, "secret"))
+  require.False(t, grpcutil.IsService(ctx, "other"))
+  // no token configured, no service callers
+  require.False(t, grpcutil.IsService(ctx, ""))
+  require.False(t, grpcutil.IsService(context.Background(), "secret"))
+}
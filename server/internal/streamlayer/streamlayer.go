@@ -0,0 +1,100 @@
+package streamlayer
+
+import (
+  "io"
+  "net"
+  "time"
+  "bytes"
+  "errors"
+  "crypto/tls"
+
+  "github.com/hashicorp/raft"
+)
+
+var _ raft.StreamLayer = (*StreamLayer)(nil)
+
+type StreamLayer struct {
+  ln net.Listener
+  serverTLS *tls.Config
+  peerTLS *tls.Config
+}
+
+/**
+ * New makes raft transport on ln. Connections are
+ * wrapped in tls after the leading byte when
+ * serverTLS (accepted) and peerTLS (dialed) are set
+ */
+func New(ln net.Listener, serverTLS, peerTLS *tls.Config) *StreamLayer {
+  return &StreamLayer{
+    ln: ln,
+    serverTLS: serverTLS,
+    peerTLS: peerTLS,
+  }
+}
+
+/**
+ * Leading byte in rpc, signifies, that it is a call
+ * to raft node (not grpc)
+ */
+const RaftRPC = 1
+
+// Matches raft connections, for cmux
+func Match(r io.Reader) bool {
+  b := make([]byte, 1)
+  if _, err := r.Read(b); err != nil {
+    return false
+  }
+  return bytes.Compare(b, []byte{byte(RaftRPC)}) == 0
+}
+
+func (s *StreamLayer) Dial(
+  addr raft.ServerAddress,
+  timeout time.Duration,
+) (net.Conn, error) {
+  dialer := &net.Dialer{Timeout: timeout}
+  conn, err := dialer.Dial("tcp", string(addr))
+  if err != nil {
+    return nil, err
+  }
+
+  _, err = conn.Write([]byte{byte(RaftRPC)})
+  if err != nil {
+    conn.Close()
+    return nil, err
+  }
+
+  if s.peerTLS != nil {
+    conn = tls.Client(conn, s.peerTLS)
+  }
+  return conn, nil
+}
+
+func (s *StreamLayer) Accept() (net.Conn, error) {
+  conn, err := s.ln.Accept()
+  if err != nil {
+    return nil, err
+  }
+
+  b := make([]byte, 1)
+  if _, err := conn.Read(b); err != nil {
+    conn.Close()
+    return nil, err
+  }
+  if bytes.Compare(b, []byte{byte(RaftRPC)}) != 0 {
+    conn.Close()
+    return nil, errors.New("not a raft rpc")
+  }
+
+  if s.serverTLS != nil {
+    return tls.Server(conn, s.serverTLS), nil
+  }
+  return conn, nil
+}
+
+func (s *StreamLayer) Close() error {
+  return s.ln.Close()
+}
+
+func (s *StreamLayer) Addr() net.Addr {
+  return s.ln.Addr()
+}
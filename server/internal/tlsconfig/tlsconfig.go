@@ -0,0 +1,103 @@
+package tlsconfig
+
+import (
+  "os"
+  "fmt"
+  "errors"
+  "crypto/tls"
+  "crypto/x509"
+)
+
+var ErrPeerNotAllowed = errors.New("peer certificate is not allowed")
+
+/**
+ * Config of mutual tls between cluster nodes. Every node
+ * presents CertFile signed by CAFile and verifies the peer
+ * the same way. ServerName is expected in server
+ * certificates, AllowedPeers restricts peers by common
+ * name or dns name, empty accepts any certificate of the CA
+ */
+type Config struct {
+  CAFile string
+  CertFile string
+  KeyFile string
+  ServerName string
+  AllowedPeers []string
+}
+
+// Enabled reports tls is configured, nodes talk plain tcp otherwise
+func (c Config) Enabled() bool {
+  return c.CertFile != "" || c.CAFile != ""
+}
+
+// Server config requires and verifies client certificates
+func (c Config) Server() (*tls.Config, error) {
+  res, err := c.base()
+  if err != nil {
+    return nil, err
+  }
+  res.ClientCAs = res.RootCAs
+  res.RootCAs = nil
+  res.ClientAuth = tls.RequireAndVerifyClientCert
+  return res, nil
+}
+
+// Client config presents certificate and verifies ServerName
+func (c Config) Client() (*tls.Config, error) {
+  res, err := c.base()
+  if err != nil {
+    return nil, err
+  }
+  res.ServerName = c.ServerName
+  return res, nil
+}
+
+func (c Config) base() (*tls.Config, error) {
+  if c.CAFile == "" || c.CertFile == "" || c.KeyFile == "" {
+    return nil, errors.New("tls requires ca, cert and key files")
+  }
+
+  cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+  if err != nil {
+    return nil, err
+  }
+
+  b, err := os.ReadFile(c.CAFile)
+  if err != nil {
+    return nil, err
+  }
+  ca := x509.NewCertPool()
+  if !ca.AppendCertsFromPEM(b) {
+    return nil, fmt.Errorf("no certificates in %q", c.CAFile)
+  }
+
+  return &tls.Config{
+    Certificates: []tls.Certificate{cert},
+    RootCAs: ca,
+    MinVersion: tls.VersionTLS12,
+    VerifyConnection: c.verifyPeer,
+  }, nil
+}
+
+// verifyPeer runs after chain verification, checks peer identity
+func (c Config) verifyPeer(cs tls.ConnectionState) error {
+  if len(c.AllowedPeers) == 0 {
+    return nil
+  }
+  if len(cs.PeerCertificates) == 0 {
+    return ErrPeerNotAllowed
+  }
+
+  leaf := cs.PeerCertificates[0]
+  for _, name := range c.AllowedPeers {
+    if leaf.Subject.CommonName == name {
+      return nil
+    }
+    for _, dnsName := range leaf.DNSNames {
+      if dnsName == name {
+        return nil
+      }
+    }
+  }
+  return fmt.Errorf("%w: %q", ErrPeerNotAllowed, leaf.Subject.CommonName)
+}
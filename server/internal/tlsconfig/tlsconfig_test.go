@@ -0,0 +1,101 @@
+package tlsconfig_test
+
+import (
+  "net"
+  "testing"
+  "crypto/tls"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/internal/tlsconfig"
+  "github.com/bd878/gallery/server/internal/tlsconfig/tlstest"
+)
+
+func issue(t *testing.T, ca *tlstest.CA, name string, allowed ...string) tlsconfig.Config {
+  certFile, keyFile, err := ca.Issue(name, "node")
+  require.NoError(t, err)
+  return tlsconfig.Config{
+    CAFile: ca.File,
+    CertFile: certFile,
+    KeyFile: keyFile,
+    ServerName: "node",
+    AllowedPeers: allowed,
+  }
+}
+
+// handshake runs client against server, returns errors of both ends
+func handshake(t *testing.T, server, client *tls.Config) (error, error) {
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  require.NoError(t, err)
+  defer ln.Close()
+
+  serverErr := make(chan error, 1)
+  go func() {
+    conn, err := ln.Accept()
+    if err != nil {
+      serverErr <- err
+      return
+    }
+    defer conn.Close()
+    serverErr <- tls.Server(conn, server).Handshake()
+  }()
+
+  conn, err := net.Dial("tcp", ln.Addr().String())
+  require.NoError(t, err)
+  defer conn.Close()
+  clientErr := tls.Client(conn, client).Handshake()
+  if clientErr != nil {
+    conn.Close()
+  }
+  return <-serverErr, clientErr
+}
+
+func TestMutualTLS(t *testing.T) {
+  ca, err := tlstest.NewCA(t.TempDir())
+  require.NoError(t, err)
+  other, err := tlstest.NewCA(t.TempDir())
+  require.NoError(t, err)
+
+  serverCfg, err := issue(t, ca, "node-0", "node-0", "node-1").Server()
+  require.NoError(t, err)
+
+  client, err := issue(t, ca, "node-1", "node-0").Client()
+  require.NoError(t, err)
+  serverErr, clientErr := handshake(t, serverCfg, client)
+  require.NoError(t, serverErr)
+  require.NoError(t, clientErr)
+
+  // signed by the CA, but not among allowed peers
+  intruder, err := issue(t, ca, "intruder").Client()
+  require.NoError(t, err)
+  serverErr, _ = handshake(t, serverCfg, intruder)
+  require.ErrorIs(t, serverErr, tlsconfig.ErrPeerNotAllowed)
+
+  // certificate of another CA
+  stranger, err := issue(t, other, "node-1").Client()
+  require.NoError(t, err)
+  serverErr, clientErr = handshake(t, serverCfg, stranger)
+  require.Error(t, serverErr)
+  require.Error(t, clientErr)
+
+  // no client certificate
+  anonymous := client.Clone()
+  anonymous.Certificates = nil
+  serverErr, _ = handshake(t, serverCfg, anonymous)
+  require.Error(t, serverErr)
+
+  // server name is checked on the client side
+  misnamed := client.Clone()
+  misnamed.ServerName = "elsewhere"
+  _, clientErr = handshake(t, serverCfg, misnamed)
+  require.Error(t, clientErr)
+}
+
+func TestConfigIncomplete(t *testing.T) {
+  require.False(t, tlsconfig.Config{}.Enabled())
+
+  cfg := tlsconfig.Config{CAFile: "ca.pem"}
+  require.True(t, cfg.Enabled())
+  _, err := cfg.Server()
+  require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package tlstest
+
+import (
+  "os"
+  "net"
+  "time"
+  "math/big"
+  "crypto/rand"
+  "crypto/x509"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "encoding/pem"
+  "path/filepath"
+  "crypto/x509/pkix"
+)
+
+// CA issues throwaway certificates for tests, files are kept in dir
+type CA struct {
+  File string
+  dir string
+  cert *x509.Certificate
+  key *ecdsa.PrivateKey
+  serial int64
+}
+
+func NewCA(dir string) (*CA, error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return nil, err
+  }
+
+  template := &x509.Certificate{
+    SerialNumber: big.NewInt(1),
+    Subject: pkix.Name{CommonName: "test ca"},
+    NotBefore: time.Now().Add(-time.Hour),
+    NotAfter: time.Now().Add(24*time.Hour),
+    IsCA: true,
+    BasicConstraintsValid: true,
+    KeyUsage: x509.KeyUsageCertSign,
+  }
+  der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+  if err != nil {
+    return nil, err
+  }
+  cert, err := x509.ParseCertificate(der)
+  if err != nil {
+    return nil, err
+  }
+
+  ca := &CA{dir: dir, cert: cert, key: key, serial: 1}
+  ca.File = filepath.Join(dir, "ca.pem")
+  if err := writePEM(ca.File, "CERTIFICATE", der); err != nil {
+    return nil, err
+  }
+  return ca, nil
+}
+
+/**
+ * Issue makes certificate for both server and client use,
+ * name is common name, dnsNames are added along with 127.0.0.1
+ */
+func (ca *CA) Issue(name string, dnsNames ...string) (certFile, keyFile string, err error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return "", "", err
+  }
+
+  ca.serial += 1
+  template := &x509.Certificate{
+    SerialNumber: big.NewInt(ca.serial),
+    Subject: pkix.Name{CommonName: name},
+    DNSNames: dnsNames,
+    IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+    NotBefore: time.Now().Add(-time.Hour),
+    NotAfter: time.Now().Add(24*time.Hour),
+    KeyUsage: x509.KeyUsageDigitalSignature,
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+  }
+  der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+  if err != nil {
+    return "", "", err
+  }
+  keyDer, err := x509.MarshalECPrivateKey(key)
+  if err != nil {
+    return "", "", err
+  }
+
+  certFile = filepath.Join(ca.dir, name + ".pem")
+  keyFile = filepath.Join(ca.dir, name + "-key.pem")
+  if err := writePEM(certFile, "CERTIFICATE", der); err != nil {
+    return "", "", err
+  }
+  if err := writePEM(keyFile, "EC PRIVATE KEY", keyDer); err != nil {
+    return "", "", err
+  }
+  return certFile, keyFile, nil
+}
+
+func writePEM(path, blockType string, der []byte) error {
+  return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
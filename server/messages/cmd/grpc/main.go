@@ -5,10 +5,12 @@ import (
   "fmt"
   "path/filepath"
   "encoding/json"
+  "context"
   "os"
   "log"
 
   "github.com/bd878/gallery/server/messages/config"
+  repository "github.com/bd878/gallery/server/messages/internal/repository/sqlite"
 )
 
 var (
@@ -20,6 +22,12 @@ func main() {
 
   c := loadConfig()
 
+  // grpc -config path migrate [status|dry-run|up|baseline N]
+  if flag.Arg(0) == "migrate" {
+    runMigrate(c.DBPath, flag.Args()[1:])
+    return
+  }
+
   f := setLogOutput(c.LogPath, c.NodeName)
   defer f.Close()
 
@@ -34,6 +42,20 @@ func main() {
   server.Run()
 }
 
+func runMigrate(dbPath string, args []string) {
+  db, err := repository.Open(dbPath)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+  }
+  defer db.Close()
+
+  if err := repository.Schema.Command(context.Background(), db, os.Stdout, args); err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+  }
+}
+
 func loadConfig() config.Config {
   f, err := os.Open(*configPath)
   if err != nil {
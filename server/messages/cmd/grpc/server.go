@@ -2,8 +2,10 @@ package main
 
 import (
   "net"
-  "io"
-  "bytes"
+  "log"
+  "time"
+  "context"
+  "crypto/tls"
   "google.golang.org/grpc"
   "github.com/hashicorp/raft"
   "github.com/soheilhy/cmux"
@@ -12,10 +14,17 @@ import (
   "github.com/bd878/gallery/server/messages/config"
   hclog "github.com/hashicorp/go-hclog"
 
-  membership "github.com/bd878/gallery/server/messages/internal/discovery/serf"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/internal/streamlayer"
+  membership "github.com/bd878/gallery/server/internal/discovery/serf"
   repository "github.com/bd878/gallery/server/messages/internal/repository/sqlite"
   controller "github.com/bd878/gallery/server/messages/internal/controller/distributed"
   grpchandler "github.com/bd878/gallery/server/messages/internal/handler/grpc"
+  usergateway "github.com/bd878/gallery/server/messages/internal/gateway/user/grpc"
+  "github.com/bd878/gallery/server/messages/internal/purger"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/pkg/model"
 )
 
 type GRPCMessagesServer struct {
@@ -25,6 +34,10 @@ type GRPCMessagesServer struct {
   server *grpc.Server
   ctrl   *controller.DistributedMessages
   m      *membership.Membership
+  users  *usergateway.Gateway
+  // nil while tls is off
+  serverTLS *tls.Config
+  peerTLS   *tls.Config
 }
 
 func New(cfg config.Config) *GRPCMessagesServer {
@@ -36,17 +49,50 @@ func New(cfg config.Config) *GRPCMessagesServer {
   mux := cmux.New(ln)
 
   s := &GRPCMessagesServer{
-    cfg:  cfg,
-    mux:  mux,
-    ln:   ln,
+    cfg:   cfg,
+    mux:   mux,
+    ln:    ln,
   }
 
+  s.setupTLS()
+  s.users = usergateway.New(cfg.UsersServiceAddr, grpcutil.Credentials(s.usersTLS()), cfg.UsersServiceToken)
+
   s.setupRaft()
   s.setupGRPC()
+  s.setupPurger()
+  s.setupCompaction()
+  s.setupTrashPurge()
 
   return s
 }
 
+func (s *GRPCMessagesServer) setupTLS() {
+  tlsCfg := s.cfg.TLS.Node(s.cfg.TLS.ServerName)
+  if !tlsCfg.Enabled() {
+    return
+  }
+
+  var err error
+  s.serverTLS, err = tlsCfg.Server()
+  if err != nil {
+    panic(err)
+  }
+  s.peerTLS, err = tlsCfg.Client()
+  if err != nil {
+    panic(err)
+  }
+}
+
+// usersTLS dials users service, the certificate is the node one
+func (s *GRPCMessagesServer) usersTLS() *tls.Config {
+  if s.peerTLS == nil {
+    return nil
+  }
+  res := s.peerTLS.Clone()
+  res.ServerName = s.cfg.TLS.UsersServerName
+  return res
+}
+
 func (s *GRPCMessagesServer) setupRaft() {
   repo, err := repository.New(s.cfg.DBPath)
   if err != nil {
@@ -63,23 +109,23 @@ func (s *GRPCMessagesServer) setupRaft() {
     raftLogLevel = hclog.Info.String()
   }
 
-  raftLn := s.mux.Match(func(r io.Reader) bool {
-    b := make([]byte, 1)
-    if _, err := r.Read(b); err != nil {
-      return false
-    }
-    return bytes.Compare(b, []byte{byte(controller.RaftRPC)}) == 0
-  })
+  raftLn := s.mux.Match(streamlayer.Match)
 
   s.ctrl, err = controller.New(repo, controller.Config{
     Raft: raft.Config{
       LocalID: raft.ServerID(s.cfg.NodeName),
       LogLevel: raftLogLevel,
     },
-    StreamLayer: controller.NewStreamLayer(raftLn),
+    StreamLayer: streamlayer.New(raftLn, s.serverTLS, s.peerTLS),
     Bootstrap:   s.cfg.RaftBootstrap,
     DataDir:     s.cfg.DataPath,
     Servers:     s.cfg.RaftServers,
+    DefaultQuota: model.Quota{
+      MaxBytes: s.cfg.DefaultQuota.MaxBytes,
+      MaxFiles: s.cfg.DefaultQuota.MaxFiles,
+    },
+    FeedBacklog: s.cfg.FeedBacklog,
+    IdempotencyTTL: time.Duration(s.cfg.IdempotencyTTLSec)*time.Second,
   })
   if err != nil {
     panic(err)
@@ -87,8 +133,11 @@ func (s *GRPCMessagesServer) setupRaft() {
 }
 
 func (s *GRPCMessagesServer) setupGRPC() {
-  var err error
-  h := grpchandler.New(s.ctrl)
+  key, err := membership.ParseKey(s.cfg.SerfEncryptKey)
+  if err != nil {
+    panic(err)
+  }
+
   s.m, err = membership.New(
     membership.Config{
       NodeName: s.cfg.NodeName,
@@ -97,14 +146,31 @@ func (s *GRPCMessagesServer) setupGRPC() {
         "raft_addr": s.cfg.RpcAddr,
       },
       SerfJoinAddrs: s.cfg.SerfJoinAddrs,
+      EncryptKey: key,
+      KeyringFile: s.cfg.SerfKeyringFile,
     },
     s.ctrl,
   )
   if err != nil {
     panic(err)
   }
+  h := grpchandler.New(s.ctrl, s.m)
 
-  s.server = grpc.NewServer()
+  interceptor := auth.New(s.users, auth.Config{
+    ServiceToken: s.cfg.ServiceToken,
+  })
+  limiter := ratelimit.New(s.cfg.RateLimit)
+  s.server = grpc.NewServer(
+    grpc.Creds(grpcutil.Credentials(s.serverTLS)),
+    grpc.ChainUnaryInterceptor(
+      interceptor.Unary(),
+      limiter.UnaryInterceptor(auth.MethodClass, auth.LimitKey),
+    ),
+    grpc.ChainStreamInterceptor(
+      interceptor.Stream(),
+      limiter.StreamInterceptor(auth.MethodClass, auth.LimitKey),
+    ),
+  )
   // TODO: MessagesSerivce &api.MessagesService{
   //    Produce: s.server.Produce,
   //    Consume: s.server.Consume,
@@ -120,6 +186,85 @@ func (s *GRPCMessagesServer) setupGRPC() {
   }()
 }
 
+func (s *GRPCMessagesServer) setupPurger() {
+  interval := 10*time.Second
+  if s.cfg.PurgeIntervalSec != 0 {
+    interval = time.Duration(s.cfg.PurgeIntervalSec)*time.Second
+  }
+  batchSize := int32(100)
+  if s.cfg.PurgeBatchSize != 0 {
+    batchSize = s.cfg.PurgeBatchSize
+  }
+
+  p := purger.New(
+    s.ctrl,
+    s.users,
+    purger.Config{
+      Interval: interval,
+      BatchSize: batchSize,
+    },
+  )
+  go p.Run(context.Background())
+}
+
+/**
+ * Leader drops tombstones older than retention, followers
+ * receive it through raft. Zero retention keeps them forever
+ */
+func (s *GRPCMessagesServer) setupCompaction() {
+  if s.cfg.TombstoneRetentionDays == 0 || s.cfg.TombstoneCompactIntervalSec == 0 {
+    return
+  }
+  retention := time.Duration(s.cfg.TombstoneRetentionDays)*24*time.Hour
+
+  go func() {
+    ticker := time.NewTicker(time.Duration(s.cfg.TombstoneCompactIntervalSec)*time.Second)
+    defer ticker.Stop()
+    for range ticker.C {
+      if !s.ctrl.IsLeader() {
+        continue
+      }
+      n, err := s.ctrl.CompactTombstones(context.Background(), retention)
+      if err != nil {
+        log.Println("cannot compact tombstones", err)
+        continue
+      }
+      if n > 0 {
+        log.Println("compacted tombstones", n)
+      }
+    }
+  }()
+}
+
+/**
+ * Leader purges messages trashed longer than retention
+ * ago, along with their files. Zero retention keeps trash
+ */
+func (s *GRPCMessagesServer) setupTrashPurge() {
+  if s.cfg.TrashRetentionDays == 0 || s.cfg.TrashPurgeIntervalSec == 0 {
+    return
+  }
+  retention := time.Duration(s.cfg.TrashRetentionDays)*24*time.Hour
+
+  go func() {
+    ticker := time.NewTicker(time.Duration(s.cfg.TrashPurgeIntervalSec)*time.Second)
+    defer ticker.Stop()
+    for range ticker.C {
+      if !s.ctrl.IsLeader() {
+        continue
+      }
+      res, err := s.ctrl.PurgeTrash(context.Background(), retention)
+      if err != nil {
+        log.Println("cannot purge trash", err)
+        continue
+      }
+      if res.MessagesDeleted > 0 {
+        log.Println("purged trash messages", res.MessagesDeleted, "files", res.FilesDeleted)
+      }
+    }
+  }()
+}
+
 func (s *GRPCMessagesServer) Run() {
   defer s.mux.Close()
   s.mux.Serve()
@@ -3,6 +3,13 @@ package main
 import (
   "net/http"
 
+  "google.golang.org/grpc/credentials"
+
+  "github.com/bd878/gallery/server/pkg/cookie"
+  "github.com/bd878/gallery/server/pkg/csrf"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/internal/tlsconfig"
   config "github.com/bd878/gallery/server/messages/config"
   httphandler "github.com/bd878/gallery/server/messages/internal/handler/http"
   usergateway "github.com/bd878/gallery/server/messages/internal/gateway/user/grpc"
@@ -14,21 +21,102 @@ func New(cfg config.Config) *http.Server {
 
   ctrlCfg := controller.Config{
     RpcAddr: cfg.RpcAddr,
+    ServiceToken: cfg.ServiceToken,
+    Credentials: clientCredentials(cfg.TLS.Node(cfg.TLS.ServerName)),
   }
 
   grpcCtrl := controller.New(ctrlCfg)
-  userGateway := usergateway.New(cfg.UsersServiceAddr)
-  h := httphandler.New(grpcCtrl, userGateway, cfg.DataPath)
+  userGateway := usergateway.New(cfg.UsersServiceAddr,
+    clientCredentials(cfg.TLS.Node(cfg.TLS.UsersServerName)), cfg.UsersServiceToken)
+  h := httphandler.New(grpcCtrl, userGateway, httphandler.Config{
+    DataPath: cfg.DataPath,
+    Limiter: ratelimit.New(cfg.RateLimit),
+    TrustProxy: cfg.TrustProxy,
+    AllowedOrigins: cfg.AllowedOrigins,
+  })
+
+  cookieCfg := cookie.Config{
+    Domain: cfg.Cookie.Domain,
+    Path: cfg.Cookie.Path,
+    Secure: cfg.Cookie.Secure,
+    SameSite: cfg.Cookie.SameSite,
+  }
+  if err := cookieCfg.Validate(); err != nil {
+    panic(err)
+  }
+
+  protect := csrf.New(csrf.Config{
+    Cookie: cookieCfg,
+    AllowedOrigins: cfg.AllowedOrigins,
+  }).Protect
+
+  // user limits run after auth, coarse ip one goes first, before
+  // auth call and csrf check parsing the body, so anonymous and
+  // oversized requests are throttled too
+  type auth func(func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request)
+  limit := func(check auth, next http.HandlerFunc, classes ...ratelimit.Class) http.Handler {
+    handler := next
+    for i := len(classes)-1; i >= 0; i-- {
+      handler = h.Limit(classes[i], handler)
+    }
+    return http.HandlerFunc(h.Limit(ratelimit.IP, protect(http.HandlerFunc(check(handler))).ServeHTTP))
+  }
 
-  mux.Handle("/messages/v1/send", http.HandlerFunc(h.CheckAuth(h.SendMessage)))
-  mux.Handle("/messages/v1/read", http.HandlerFunc(h.CheckAuth(h.ReadMessages)))
-  mux.Handle("/messages/v1/status", http.HandlerFunc(h.GetStatus))
-  mux.Handle("/messages/v1/read_file", http.HandlerFunc(h.CheckAuth(h.ReadFile)))
+  mux.Handle("/messages/v1/send", limit(h.CheckAuth, h.SendMessage, ratelimit.Write, ratelimit.Upload))
+  mux.Handle("/messages/v1/read", limit(h.CheckAuth, h.ReadMessages, ratelimit.Read))
+  mux.Handle("/messages/v1/status", protect(http.HandlerFunc(h.GetStatus)))
+  mux.Handle("/messages/v1/read_file", limit(h.CheckAuth, h.ReadFile, ratelimit.Read))
+  mux.Handle("/messages/v1/update", limit(h.CheckAuth, h.UpdateMessage, ratelimit.Write, ratelimit.Upload))
+  mux.Handle("/messages/v1/history", limit(h.CheckAuth, h.History, ratelimit.Read))
+  mux.Handle("/messages/v1/restore", limit(h.CheckAuth, h.RestoreVersion, ratelimit.Write))
+  mux.Handle("/messages/v1/prune_history", limit(h.CheckAuth, h.PruneHistory, ratelimit.Write))
+  mux.Handle("/messages/v1/delete", limit(h.CheckAuth, h.DeleteMessage, ratelimit.Write))
+  mux.Handle("/messages/v1/trash", limit(h.CheckAuth, h.Trash, ratelimit.Read))
+  mux.Handle("/messages/v1/trash/restore", limit(h.CheckAuth, h.RestoreMessage, ratelimit.Write))
+  mux.Handle("/messages/v1/trash/empty", limit(h.CheckAuth, h.EmptyTrash, ratelimit.Write))
+  mux.Handle("/messages/v1/sync", limit(h.CheckAuth, h.Sync, ratelimit.Read))
+  mux.Handle("/messages/v1/stream", limit(h.CheckAuth, h.Stream, ratelimit.Read))
+  mux.Handle("/messages/v1/ws", limit(h.CheckAuth, h.WebSocket, ratelimit.Read))
+  mux.Handle("/messages/v1/usage", limit(h.CheckAuth, h.Usage, ratelimit.Read))
+  mux.Handle("/messages/v1/admin/usage", limit(h.CheckAdmin, h.AdminUsage, ratelimit.Read))
+  mux.Handle("/messages/v1/admin/quota", limit(h.CheckAdmin, h.AdminSetQuota, ratelimit.Write))
 
   srv := &http.Server{
     Addr: cfg.HttpAddr,
-    Handler: mux,
+    Handler: limitBody(mux, cfg.MaxUploadBytes),
   }
 
   return srv
-}
\ No newline at end of file
+}
+// forms are small, send and update have room for files
+const maxFormSize = 1 << 20
+
+const defaultMaxUploadSize = 100 << 20
+
+// limitBody caps request bodies before csrf check parses forms
+func limitBody(next http.Handler, maxUploadSize int64) http.Handler {
+  if maxUploadSize == 0 {
+    maxUploadSize = defaultMaxUploadSize
+  }
+  return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    size := int64(maxFormSize)
+    switch req.URL.Path {
+    case "/messages/v1/send", "/messages/v1/update":
+      size += maxUploadSize
+    }
+    req.Body = http.MaxBytesReader(w, req.Body, size)
+    next.ServeHTTP(w, req)
+  })
+}
+
+// clientCredentials dial grpc servers, plain when tls is off
+func clientCredentials(tlsCfg tlsconfig.Config) credentials.TransportCredentials {
+  if !tlsCfg.Enabled() {
+    return nil
+  }
+  res, err := tlsCfg.Client()
+  if err != nil {
+    panic(err)
+  }
+  return grpcutil.Credentials(res)
+}
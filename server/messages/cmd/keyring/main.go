@@ -0,0 +1,140 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "flag"
+  "sort"
+  "time"
+  "context"
+  "crypto/rand"
+  "encoding/json"
+  "encoding/base64"
+
+  "google.golang.org/grpc"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/messages/config"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+)
+
+var (
+  configPath = flag.String("config", "config/1.json", "config of any messages node")
+  timeout = flag.Duration("timeout", 30*time.Second, "request timeout")
+)
+
+func usage() {
+  fmt.Fprintf(os.Stderr, `Manages gossip keyring of messages cluster
+
+Usage: %s [flags] command [key]
+
+Commands:
+  generate      print new random key
+  list          keys installed and number of nodes having them
+  install key   add key to every node
+  use key       make installed key primary
+  remove key    drop key from every node
+
+Rotation: generate, install new key, use it, remove old one.
+
+Flags:
+`, os.Args[0])
+  flag.PrintDefaults()
+}
+
+func main() {
+  flag.Usage = usage
+  flag.Parse()
+
+  command := flag.Arg(0)
+  key := flag.Arg(1)
+  switch {
+  case command == "generate":
+    b := make([]byte, 32)
+    if _, err := rand.Read(b); err != nil {
+      fail(err)
+    }
+    fmt.Println(base64.StdEncoding.EncodeToString(b))
+    return
+  case command == "list" && flag.NArg() == 1:
+  case (command == "install" || command == "use" || command == "remove") && flag.NArg() == 2:
+  default:
+    usage()
+    os.Exit(2)
+  }
+
+  cfg := loadConfig()
+  client, conn := dial(cfg)
+  defer conn.Close()
+
+  ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+  defer cancel()
+
+  var res *api.KeysResponse
+  var err error
+  switch command {
+  case "list":
+    res, err = client.ListKeys(ctx, &api.ListKeysRequest{})
+  case "install":
+    res, err = client.InstallKey(ctx, &api.KeyRequest{Key: key})
+  case "use":
+    res, err = client.UseKey(ctx, &api.KeyRequest{Key: key})
+  case "remove":
+    res, err = client.RemoveKey(ctx, &api.KeyRequest{Key: key})
+  }
+  if err != nil {
+    fail(err)
+  }
+
+  keys := make([]string, 0, len(res.Keys))
+  for k := range res.Keys {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  for _, k := range keys {
+    fmt.Printf("%s [%d/%d]\n", k, res.Keys[k], res.NumNodes)
+  }
+}
+
+// dial talks to node of rpc_addr, serf spreads the request
+func dial(cfg config.Config) (api.MessagesClient, *grpc.ClientConn) {
+  tlsCfg := cfg.TLS.Node(cfg.TLS.ServerName)
+  creds := grpcutil.Credentials(nil)
+  if tlsCfg.Enabled() {
+    res, err := tlsCfg.Client()
+    if err != nil {
+      fail(err)
+    }
+    creds = grpcutil.Credentials(res)
+  }
+
+  conn, err := grpc.Dial(
+    cfg.RpcAddr,
+    grpc.WithTransportCredentials(creds),
+    grpc.WithPerRPCCredentials(auth.ServiceCredentials(cfg.ServiceToken)),
+  )
+  if err != nil {
+    fail(err)
+  }
+  return api.NewMessagesClient(conn), conn
+}
+
+func loadConfig() config.Config {
+  f, err := os.Open(*configPath)
+  if err != nil {
+    fail(err)
+  }
+  defer f.Close()
+
+  var cfg config.Config
+  if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+    fail(err)
+  }
+  return cfg
+}
+
+func fail(err error) {
+  fmt.Fprintln(os.Stderr, err)
+  os.Exit(1)
+}
@@ -1,9 +1,19 @@
 package config
 
+import (
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+  "github.com/bd878/gallery/server/internal/tlsconfig"
+)
+
 type Config struct {
   NodeName          string `json:"node_name"`
   HttpAddr          string `json:"http_addr"`
   UsersServiceAddr  string `json:"users_service_addr"`
+  // users service serviceToken, for purger calls
+  UsersServiceToken string `json:"users_service_token"`
+  // shared by http gateway and grpc servers,
+  // authenticates gateway calls to grpc
+  ServiceToken      string `json:"service_token"`
   RpcAddr           string `json:"rpc_addr"`
   SerfAddr          string `json:"serf_addr"`
   RaftServers       []string `json:"raft_servers"`
@@ -14,4 +24,75 @@ type Config struct {
   LogPath           string `json:"log_path"`
   DBPath            string `json:"db_path"`
   DataPath          string `json:"data_path"`
+
+  PurgeIntervalSec  int `json:"purge_interval_sec"`
+  PurgeBatchSize    int32 `json:"purge_batch_size"`
+  // applies to users without own quota, zeros are unlimited
+  DefaultQuota      QuotaConfig `json:"default_quota"`
+  // recent events kept for live feed resume
+  FeedBacklog       int `json:"feed_backlog"`
+  // deleted messages tombstones, kept for delta sync, zero
+  // retention keeps them forever
+  TombstoneRetentionDays      int `json:"tombstone_retention_days"`
+  TombstoneCompactIntervalSec int `json:"tombstone_compact_interval_sec"`
+  // deleted messages stay in trash this long, then leader
+  // purges them with files, zero keeps them till emptied
+  TrashRetentionDays          int `json:"trash_retention_days"`
+  TrashPurgeIntervalSec       int `json:"trash_purge_interval_sec"`
+  // how long retried save with same client id returns first message
+  IdempotencyTTLSec int `json:"idempotency_ttl_sec"`
+
+  SerfEncryptKey    string `json:"serf_encrypt_key"`
+  // rotated keys are kept here, it wins over serf_encrypt_key
+  SerfKeyringFile   string `json:"serf_keyring_file"`
+  TLS               TLSConfig `json:"tls"`
+
+  Cookie            CookieConfig `json:"cookie"`
+  AllowedOrigins    []string `json:"allowed_origins"`
+
+  // per caller limits of http and grpc calls, routes are
+  // http paths or grpc methods, zero rate is unlimited
+  RateLimit         ratelimit.Config `json:"rate_limit"`
+  // take client ip from X-Forwarded-For, set when behind nginx
+  TrustProxy        bool `json:"trust_proxy"`
+  // http body cap of files sent in one message, zero takes default
+  MaxUploadBytes    int64 `json:"max_upload_bytes"`
+}
+
+type QuotaConfig struct {
+  MaxBytes          int64 `json:"max_bytes"`
+  MaxFiles          int64 `json:"max_files"`
+}
+
+// csrf cookie attributes, same_site is "lax", "strict" or "none"
+type CookieConfig struct {
+  Domain            string `json:"domain"`
+  Path              string `json:"path"`
+  Secure            bool `json:"secure"`
+  SameSite          string `json:"same_site"`
+}
+
+/**
+ * mutual tls for raft and grpc, disabled while files are empty.
+ * server_name is in every messages server certificate,
+ * users_server_name in users ones
+ */
+type TLSConfig struct {
+  CAFile            string `json:"ca_file"`
+  CertFile          string `json:"cert_file"`
+  KeyFile           string `json:"key_file"`
+  ServerName        string `json:"server_name"`
+  UsersServerName   string `json:"users_server_name"`
+  AllowedPeers      []string `json:"allowed_peers"`
+}
+
+// Node config for peers named serverName
+func (c TLSConfig) Node(serverName string) tlsconfig.Config {
+  return tlsconfig.Config{
+    CAFile: c.CAFile,
+    CertFile: c.CertFile,
+    KeyFile: c.KeyFile,
+    ServerName: serverName,
+    AllowedPeers: c.AllowedPeers,
+  }
 }
@@ -0,0 +1,170 @@
+package auth
+
+import (
+  "context"
+  "strings"
+  "crypto/subtle"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+  "google.golang.org/grpc/metadata"
+
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const (
+  authorizationKey = "authorization"
+  bearerScheme = "Bearer"
+  serviceScheme = "Service"
+)
+
+// methods served without credentials
+var publicMethods = map[string]bool{
+  "/messages.v1.Messages/GetServers": true,
+}
+
+type userGateway interface {
+  Auth(ctx context.Context, token string) (*usermodel.User, error)
+}
+
+/**
+ * Caller is identity of the request. Service callers
+ * (http gateway) authenticate users themselves and may
+ * act on any user, User callers on their own data only
+ */
+type Caller struct {
+  Service bool
+  User *usermodel.User
+}
+
+type callerKey struct{}
+
+func FromContext(ctx context.Context) (*Caller, bool) {
+  caller, ok := ctx.Value(callerKey{}).(*Caller)
+  return caller, ok
+}
+
+// Authorize checks caller may act on userId data
+func Authorize(ctx context.Context, userId usermodel.UserId) error {
+  caller, ok := FromContext(ctx)
+  switch {
+  case !ok:
+    return status.Errorf(codes.Unauthenticated, "no credentials")
+  case caller.Service:
+    return nil
+  case caller.User == nil || caller.User.Id != userId:
+    return status.Errorf(codes.PermissionDenied, "user %d is not allowed", userId)
+  default:
+    return nil
+  }
+}
+
+// RequireService lets only service callers through, e.g. to admin calls
+func RequireService(ctx context.Context) error {
+  caller, ok := FromContext(ctx)
+  switch {
+  case !ok:
+    return status.Errorf(codes.Unauthenticated, "no credentials")
+  case !caller.Service:
+    return status.Errorf(codes.PermissionDenied, "service credentials required")
+  default:
+    return nil
+  }
+}
+
+type Config struct {
+  // shared secret of service callers, empty disables them
+  ServiceToken string
+}
+
+/**
+ * Interceptor authenticates "authorization" metadata:
+ * "Service <token>" for services, "Bearer <token>"
+ * for users, user tokens are validated by users service
+ */
+type Interceptor struct {
+  cfg Config
+  users userGateway
+}
+
+func New(users userGateway, cfg Config) *Interceptor {
+  return &Interceptor{cfg, users}
+}
+
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req any,
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler,
+  ) (any, error) {
+    ctx, err := i.authenticate(ctx, info.FullMethod)
+    if err != nil {
+      return nil, err
+    }
+    return handler(ctx, req)
+  }
+}
+
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+  return func(
+    srv any,
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler,
+  ) error {
+    ctx, err := i.authenticate(stream.Context(), info.FullMethod)
+    if err != nil {
+      return err
+    }
+    return handler(srv, &serverStream{stream, ctx})
+  }
+}
+
+func (i *Interceptor) authenticate(ctx context.Context, method string) (context.Context, error) {
+  if publicMethods[method] {
+    return ctx, nil
+  }
+
+  md, _ := metadata.FromIncomingContext(ctx)
+  values := md.Get(authorizationKey)
+  if len(values) != 1 {
+    return nil, status.Errorf(codes.Unauthenticated, "no credentials")
+  }
+  scheme, token, _ := strings.Cut(values[0], " ")
+  if token == "" {
+    return nil, status.Errorf(codes.Unauthenticated, "malformed credentials")
+  }
+
+  switch scheme {
+  case serviceScheme:
+    if i.cfg.ServiceToken == "" ||
+      subtle.ConstantTimeCompare([]byte(token), []byte(i.cfg.ServiceToken)) != 1 {
+      return nil, status.Errorf(codes.Unauthenticated, "wrong service token")
+    }
+    return context.WithValue(ctx, callerKey{}, &Caller{Service: true}), nil
+
+  case bearerScheme:
+    user, err := i.users.Auth(ctx, token)
+    if status.Code(err) == codes.Unavailable {
+      return nil, status.Errorf(codes.Unavailable, "users service is unavailable")
+    }
+    if err != nil || user == nil {
+      return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+    }
+    return context.WithValue(ctx, callerKey{}, &Caller{User: user}), nil
+
+  default:
+    return nil, status.Errorf(codes.Unauthenticated, "unknown scheme %q", scheme)
+  }
+}
+
+type serverStream struct {
+  grpc.ServerStream
+  ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+  return s.ctx
+}
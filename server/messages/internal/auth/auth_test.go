@@ -0,0 +1,242 @@
+package auth_test
+
+import (
+  "net"
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/grpc/credentials"
+  "google.golang.org/grpc/credentials/insecure"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/internal/feed"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+  grpchandler "github.com/bd878/gallery/server/messages/internal/handler/grpc"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+type users map[string]*usermodel.User
+
+func (u users) Auth(_ context.Context, token string) (*usermodel.User, error) {
+  user, ok := u[token]
+  if !ok {
+    return nil, status.Errorf(codes.NotFound, "no user")
+  }
+  return user, nil
+}
+
+type controller struct{}
+
+func (controller) SaveMessage(_ context.Context, msg *model.Message) (*model.Message, error) {
+  return msg, nil
+}
+
+func (controller) ReadUserMessages(context.Context, usermodel.UserId, int32, int32, bool) (
+  *model.MessagesList, error,
+) {
+  return &model.MessagesList{IsLastPage: true}, nil
+}
+
+func (controller) GetServers(context.Context) ([]*api.Server, error) {
+  return nil, nil
+}
+
+func (controller) GetUsage(_ context.Context, userId usermodel.UserId) (*model.Usage, *model.Quota, error) {
+  return &model.Usage{UserId: int(userId)}, &model.Quota{}, nil
+}
+
+func (controller) ListUsage(context.Context, int32, int32) ([]*model.Usage, error) {
+  return nil, nil
+}
+
+func (controller) Quota(context.Context, usermodel.UserId) (*model.Quota, error) {
+  return nil, nil
+}
+
+func (controller) SetQuota(context.Context, usermodel.UserId, *model.Quota) error {
+  return nil
+}
+
+func (controller) Watch(context.Context, usermodel.UserId, uint64, func(*model.Event) error) error {
+  return nil
+}
+
+func (controller) Sync(context.Context, usermodel.UserId, uint64, int32) (*model.SyncResult, error) {
+  return &model.SyncResult{}, nil
+}
+
+func (controller) UpdateMessage(_ context.Context, update *model.MessageUpdate) (*model.Message, error) {
+  return &model.Message{Id: update.Id}, nil
+}
+
+func (controller) ListVersions(context.Context, usermodel.UserId, model.MessageId) (
+  *model.Message, []*model.MessageVersion, error,
+) {
+  return &model.Message{}, nil, nil
+}
+
+func (controller) RestoreVersion(_ context.Context, _ usermodel.UserId, id model.MessageId, _ uint64) (*model.Message, error) {
+  return &model.Message{Id: id}, nil
+}
+
+func (controller) PruneVersions(context.Context, usermodel.UserId, model.MessageId, int32) (*model.PruneResult, error) {
+  return &model.PruneResult{}, nil
+}
+
+func (controller) DeleteMessage(_ context.Context, _ usermodel.UserId, id model.MessageId) (*model.Message, error) {
+  return &model.Message{Id: id}, nil
+}
+
+func (controller) ListTrash(context.Context, usermodel.UserId, int32, int32) (*model.MessagesList, error) {
+  return &model.MessagesList{}, nil
+}
+
+func (controller) RestoreMessage(_ context.Context, _ usermodel.UserId, id model.MessageId) (*model.Message, error) {
+  return &model.Message{Id: id}, nil
+}
+
+func (controller) EmptyTrash(context.Context, usermodel.UserId) (*model.PurgeResult, error) {
+  return &model.PurgeResult{}, nil
+}
+
+func (controller) Subscribe(userId usermodel.UserId, lastId uint64) (*feed.Subscription, []*model.Event) {
+  return feed.New(0).Subscribe(userId, lastId)
+}
+
+func TestInterceptor(t *testing.T) {
+  interceptor := auth.New(users{"alice-token": {Id: 1, Name: "alice"}}, auth.Config{
+    ServiceToken: "gateway",
+  })
+  srv := grpc.NewServer(
+    grpc.UnaryInterceptor(interceptor.Unary()),
+    grpc.StreamInterceptor(interceptor.Stream()),
+  )
+  api.RegisterMessagesServer(srv, grpchandler.New(controller{}, nil))
+
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  require.NoError(t, err)
+  go srv.Serve(ln)
+  defer srv.Stop()
+
+  client := func(creds credentials.PerRPCCredentials) api.MessagesClient {
+    opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+    if creds != nil {
+      opts = append(opts, grpc.WithPerRPCCredentials(creds))
+    }
+    conn, err := grpc.Dial(ln.Addr().String(), opts...)
+    require.NoError(t, err)
+    t.Cleanup(func() { conn.Close() })
+    return api.NewMessagesClient(conn)
+  }
+
+  ctx := context.Background()
+  read := func(c api.MessagesClient, userId uint32) error {
+    _, err := c.ReadUserMessages(ctx, &api.ReadUserMessagesRequest{UserId: userId})
+    return err
+  }
+  save := func(c api.MessagesClient, userId uint32) error {
+    _, err := c.SaveMessage(ctx, &api.SaveMessageRequest{Message: &api.Message{UserId: userId}})
+    return err
+  }
+
+  anonymous := client(nil)
+  require.Equal(t, codes.Unauthenticated, status.Code(read(anonymous, 1)))
+  require.Equal(t, codes.Unauthenticated, status.Code(save(anonymous, 1)))
+  // cluster discovery stays open
+  _, err = anonymous.GetServers(ctx, &api.GetServersRequest{})
+  require.NoError(t, err)
+
+  gateway := client(auth.ServiceCredentials("gateway"))
+  require.NoError(t, read(gateway, 1))
+  require.NoError(t, save(gateway, 2))
+
+  require.Equal(t, codes.Unauthenticated, status.Code(read(client(auth.ServiceCredentials("guess")), 1)))
+  require.Equal(t, codes.Unauthenticated, status.Code(read(client(auth.UserCredentials("guess")), 1)))
+
+  alice := client(auth.UserCredentials("alice-token"))
+  require.NoError(t, read(alice, 1))
+  require.NoError(t, save(alice, 1))
+  require.Equal(t, codes.PermissionDenied, status.Code(read(alice, 2)))
+  require.Equal(t, codes.PermissionDenied, status.Code(save(alice, 2)))
+
+  // usage is own data, quotas are admin calls
+  _, err = alice.GetUsage(ctx, &api.GetUsageRequest{UserId: 1})
+  require.NoError(t, err)
+  _, err = alice.GetUsage(ctx, &api.GetUsageRequest{UserId: 2})
+  require.Equal(t, codes.PermissionDenied, status.Code(err))
+  _, err = alice.SetQuota(ctx, &api.SetQuotaRequest{UserId: 1, Quota: &api.Quota{MaxBytes: 1 << 30}})
+  require.Equal(t, codes.PermissionDenied, status.Code(err))
+  _, err = gateway.SetQuota(ctx, &api.SetQuotaRequest{UserId: 1, Quota: &api.Quota{MaxBytes: 1 << 30}})
+  require.NoError(t, err)
+}
+
+func TestServiceDisabled(t *testing.T) {
+  // no shared secret configured, service callers are refused
+  interceptor := auth.New(users{}, auth.Config{})
+  ctx := metadata.NewIncomingContext(context.Background(),
+    metadata.Pairs("authorization", "Service anything"))
+  _, err := interceptor.Unary()(
+    ctx, nil,
+    &grpc.UnaryServerInfo{FullMethod: "/messages.v1.Messages/SaveMessage"},
+    func(context.Context, any) (any, error) { return nil, nil },
+  )
+  require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestRateLimit(t *testing.T) {
+  interceptor := auth.New(users{"alice-token": {Id: 1, Name: "alice"}}, auth.Config{
+    ServiceToken: "gateway",
+  })
+  limiter := ratelimit.New(ratelimit.Config{
+    Write: ratelimit.Limit{Rate: 0.1, Burst: 1},
+  })
+  srv := grpc.NewServer(
+    grpc.ChainUnaryInterceptor(
+      interceptor.Unary(),
+      limiter.UnaryInterceptor(auth.MethodClass, auth.LimitKey),
+    ),
+  )
+  api.RegisterMessagesServer(srv, grpchandler.New(controller{}, nil))
+
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  require.NoError(t, err)
+  go srv.Serve(ln)
+  t.Cleanup(srv.Stop)
+
+  conn, err := grpc.Dial(ln.Addr().String(),
+    grpc.WithTransportCredentials(insecure.NewCredentials()),
+    grpc.WithPerRPCCredentials(auth.ServiceCredentials("gateway")),
+  )
+  require.NoError(t, err)
+  t.Cleanup(func() { conn.Close() })
+  gateway := api.NewMessagesClient(conn)
+
+  ctx := context.Background()
+  save := func(userId uint32) (metadata.MD, error) {
+    var header metadata.MD
+    _, err := gateway.SaveMessage(ctx,
+      &api.SaveMessageRequest{Message: &api.Message{UserId: userId}},
+      grpc.Header(&header),
+    )
+    return header, err
+  }
+
+  _, err = save(1)
+  require.NoError(t, err)
+  header, err := save(1)
+  require.Equal(t, codes.ResourceExhausted, status.Code(err))
+  require.Equal(t, []string{"10"}, header.Get("retry-after"))
+  // gateway calls are limited per user they act on
+  _, err = save(2)
+  require.NoError(t, err)
+  // reads have no limit configured
+  _, err = gateway.ReadUserMessages(ctx, &api.ReadUserMessagesRequest{UserId: 1})
+  require.NoError(t, err)
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+  "fmt"
+  "context"
+  "strings"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+)
+
+type userRequest interface {
+  GetUserId() uint32
+}
+
+/**
+ * LimitKey names caller for rate limits: user callers by id,
+ * service ones by user they act on, so gateway traffic
+ * is split per user, or by service key. Anonymous by ip
+ */
+func LimitKey(ctx context.Context, req any) string {
+  caller, ok := FromContext(ctx)
+  switch {
+  case !ok:
+    return ratelimit.PeerIP(ctx)
+  case !caller.Service && caller.User != nil:
+    return fmt.Sprintf("user:%d", caller.User.Id)
+  }
+
+  switch r := req.(type) {
+  case *api.SaveMessageRequest:
+    return fmt.Sprintf("user:%d", r.GetMessage().GetUserId())
+  case userRequest:
+    return fmt.Sprintf("user:%d", r.GetUserId())
+  default:
+    return "key:" + serviceScheme
+  }
+}
+
+// writes are replicated by raft leader
+var writeMethods = []string{"SaveMessage", "SetQuota", "UpdateMessage", "RestoreVersion", "PruneVersions",
+  "DeleteMessage", "RestoreMessage", "EmptyTrash"}
+
+// MethodClass tells writes from reads
+func MethodClass(method string) ratelimit.Class {
+  for _, name := range writeMethods {
+    if strings.HasSuffix(method, "/" + name) {
+      return ratelimit.Write
+    }
+  }
+  return ratelimit.Read
+}
@@ -0,0 +1,83 @@
+package messages_test
+
+import (
+  "os"
+  "testing"
+  "context"
+  "path/filepath"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestAttachments(t *testing.T) {
+  m, dataDir := newTestRaft(t, distributed.Config{})
+
+  for _, name := range []string{"a", "b", "c"} {
+    require.NoError(t, os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644))
+  }
+
+  ctx := context.Background()
+  userId := usermodel.UserId(1)
+  album, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Attachments: []model.Attachment{
+    {FileId: "a", FileName: "a.jpg", FileSize: 10, MimeType: "image/jpeg"},
+    {FileId: "b", FileName: "b.png", FileSize: 20, MimeType: "image/png"},
+  }})
+  require.NoError(t, err)
+  // first attachment stays in place of single file
+  require.Equal(t, model.FileId("a"), album.FileId)
+
+  msg, err := m.ReadOneMessage(ctx, userId, album.Id)
+  require.NoError(t, err)
+  require.Len(t, msg.Attachments, 2)
+  require.Equal(t, "image/png", msg.Attachments[1].MimeType)
+
+  usage, _, err := m.GetUsage(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, int64(30), usage.Bytes)
+  require.Equal(t, int64(2), usage.Files)
+
+  // quota counts every file of message
+  require.NoError(t, m.SetQuota(ctx, userId, &model.Quota{MaxFiles: 3}))
+  _, err = m.SaveMessage(ctx, &model.Message{UserId: 1, Attachments: []model.Attachment{
+    {FileId: "x", FileSize: 1}, {FileId: "y", FileSize: 1},
+  }})
+  require.Error(t, err)
+  require.NoError(t, m.SetQuota(ctx, userId, &model.Quota{}))
+
+  // old files stay with version, new one counts once
+  updated, err := m.UpdateMessage(ctx, &model.MessageUpdate{Id: album.Id, UserId: 1, SetFile: true, Attachments: []model.Attachment{
+    {FileId: "b", FileName: "b.png", FileSize: 20},
+    {FileId: "c", FileName: "c.gif", FileSize: 5},
+  }})
+  require.NoError(t, err)
+  require.Len(t, updated.Attachments, 2)
+  require.Equal(t, model.FileId("b"), updated.FileId)
+  usage, _, err = m.GetUsage(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, int64(35), usage.Bytes)
+  require.Equal(t, int64(3), usage.Files)
+
+  _, versions, err := m.ListVersions(ctx, userId, album.Id)
+  require.NoError(t, err)
+  require.Len(t, versions, 1)
+  require.Len(t, versions[0].Attachments, 2)
+
+  _, err = m.DeleteMessage(ctx, userId, album.Id)
+  require.NoError(t, err)
+  purged, err := m.EmptyTrash(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, 3, purged.FilesDeleted)
+
+  for _, name := range []string{"a", "b", "c"} {
+    _, err = os.Stat(filepath.Join(dataDir, name))
+    require.True(t, os.IsNotExist(err))
+  }
+  usage, _, err = m.GetUsage(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, int64(0), usage.Bytes)
+  require.Equal(t, int64(0), usage.Files)
+}
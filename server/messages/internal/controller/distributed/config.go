@@ -1,11 +1,26 @@
 package messages
 
-import "github.com/hashicorp/raft"
+import (
+  "time"
+
+  "github.com/hashicorp/raft"
+
+  "github.com/bd878/gallery/server/internal/streamlayer"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+)
 
 type Config struct {
   Raft         raft.Config
-  StreamLayer *StreamLayer
+  StreamLayer *streamlayer.StreamLayer
   Bootstrap    bool
   DataDir      string
   Servers      []string
-}
\ No newline at end of file
+  // applies to users without own quota
+  DefaultQuota model.Quota
+  // recent events kept for feed resume, feed.DefaultBacklog when zero
+  FeedBacklog  int
+  // how long client keys dedupe saves, DefaultIdempotencyTTL when zero
+  IdempotencyTTL time.Duration
+}
+
+const DefaultIdempotencyTTL = 24*time.Hour
\ No newline at end of file
@@ -0,0 +1,199 @@
+package messages
+
+import (
+  "errors"
+  "context"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/feed"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+// messages read from repository at once on replay
+const replayPageSize = 500
+
+var errLagged = errors.New("subscriber lags behind")
+
+/**
+ * Subscribe to live events of user applied on this node,
+ * after lastId. See feed.Hub.Subscribe
+ */
+func (m *DistributedMessages) Subscribe(userId usermodel.UserId, lastId uint64) (
+  *feed.Subscription,
+  []*model.Event,
+) {
+  return m.hub.Subscribe(userId, lastId)
+}
+
+/**
+ * Watch passes events of user, zero userId for all users,
+ * with log index from startIndex on, in log order, till ctx
+ * is done or send fails. Zero startIndex starts from live events.
+ *
+ * Events older than hub keeps are replayed from repository
+ * by change seq: messages as created, or updated ones when
+ * changed since, tombstones as deleted. Replay goes up to
+ * index applied at subscribe, later events are queued by
+ * the subscription meanwhile. Tombstones compacted after
+ * start make resync event. Consumer too slow for live events
+ * does not block fsm, it is dropped by hub and catches up the same way
+ */
+func (m *DistributedMessages) Watch(
+  ctx context.Context,
+  userId usermodel.UserId,
+  startIndex uint64,
+  send func(*model.Event) error,
+) error {
+  // events up to last are sent
+  var last uint64
+  if startIndex > 0 {
+    last = startIndex - 1
+  }
+  // hub takes zero lastId as live only
+  fromStart := startIndex == 1
+
+  for {
+    sub, backlog := m.hub.Subscribe(userId, last)
+    if fromStart || (len(backlog) > 0 && backlog[0].Type == model.EventResync) {
+      fromStart = false
+      backlog = nil
+      // hub knows no index after snapshot restore
+      until := sub.Last
+      if until == 0 {
+        until = m.raft.AppliedIndex()
+      }
+      if err := m.replay(ctx, userId, &last, until, send); err != nil {
+        sub.Close()
+        return err
+      }
+    }
+
+    err := follow(ctx, sub, backlog, &last, send)
+    sub.Close()
+    if err != errLagged {
+      return err
+    }
+  }
+}
+
+// replay sends changes of repository after last up to until, sets last to until
+func (m *DistributedMessages) replay(
+  ctx context.Context,
+  userId usermodel.UserId,
+  last *uint64,
+  until uint64,
+  send func(*model.Event) error,
+) error {
+  if *last > 0 {
+    floor, err := m.repo.SyncFloor(ctx, userId)
+    if err != nil {
+      return err
+    }
+    if *last < floor {
+      *last = until
+      return send(&model.Event{Id: until, Type: model.EventResync, UserId: int(userId)})
+    }
+  }
+
+  fetch := int32(replayPageSize + 1)
+  for *last < until {
+    msgs, tombstones, err := m.repo.Changes(ctx, userId, *last, fetch)
+    if err != nil {
+      return err
+    }
+    res, ok := page(msgs, tombstones, *last, replayPageSize, fetch)
+    if !ok {
+      fetch *= 2
+      continue
+    }
+    for _, event := range changeEvents(res.Messages, res.Tombstones) {
+      // changed after subscribe, comes live
+      if event.Id > until {
+        break
+      }
+      if err := send(event); err != nil {
+        return err
+      }
+    }
+    if !res.HasMore || res.Token >= until {
+      break
+    }
+    *last = res.Token
+  }
+  *last = until
+  return nil
+}
+
+/**
+ * changeEvents turns changes, in seq order, into events.
+ * Tombstones of one seq make one deleted event
+ */
+func changeEvents(msgs []*model.Message, tombstones []*model.Tombstone) []*model.Event {
+  var res []*model.Event
+  for len(msgs) + len(tombstones) > 0 {
+    if len(tombstones) == 0 || (len(msgs) > 0 && msgs[0].Seq < tombstones[0].Seq) {
+      msg := msgs[0]
+      msgs = msgs[1:]
+      event := createdEvent(msg.Seq, msg)
+      if msg.Seq != msg.LogIndex {
+        event.Type = model.EventUpdated
+      }
+      res = append(res, event)
+      continue
+    }
+
+    event := &model.Event{
+      Id: tombstones[0].Seq,
+      Type: model.EventDeleted,
+      UserId: tombstones[0].UserId,
+    }
+    for len(tombstones) > 0 && tombstones[0].Seq == event.Id {
+      event.MessageIds = append(event.MessageIds, tombstones[0].Id)
+      tombstones = tombstones[1:]
+    }
+    res = append(res, event)
+  }
+  return res
+}
+
+// follow sends backlog and live events newer than last
+func follow(
+  ctx context.Context,
+  sub *feed.Subscription,
+  backlog []*model.Event,
+  last *uint64,
+  send func(*model.Event) error,
+) error {
+  next := func(event *model.Event) error {
+    if event.Id <= *last {
+      return nil
+    }
+    if err := send(event); err != nil {
+      return err
+    }
+    *last = event.Id
+    return nil
+  }
+
+  for _, event := range backlog {
+    if err := next(event); err != nil {
+      return err
+    }
+  }
+  for {
+    select {
+    case <-ctx.Done():
+      return nil
+    case event, ok := <-sub.C:
+      if !ok {
+        if sub.Lagged() {
+          return errLagged
+        }
+        return nil
+      }
+      if err := next(event); err != nil {
+        return err
+      }
+    }
+  }
+}
@@ -0,0 +1,40 @@
+package messages_test
+
+import (
+  "net"
+  "time"
+  "testing"
+
+  "github.com/hashicorp/raft"
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/internal/streamlayer"
+  memory "github.com/bd878/gallery/server/messages/internal/repository/memory"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+/**
+ * newTestRaft starts single node cluster over memory repository,
+ * config sets the rest, e.g. feed backlog. Returns it once
+ * the node is the leader, along with its data dir
+ */
+func newTestRaft(t *testing.T, config distributed.Config) (*distributed.DistributedMessages, string) {
+  t.Helper()
+
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  require.NoError(t, err)
+
+  config.StreamLayer = streamlayer.New(ln, nil, nil)
+  config.Raft.LocalID = raft.ServerID("test-0")
+  config.DataDir = t.TempDir()
+  config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+  config.Raft.ElectionTimeout = 50 * time.Millisecond
+  config.Raft.LeaderLeaseTimeout = 20 * time.Millisecond
+  config.Raft.CommitTimeout = 5 * time.Millisecond
+  config.Bootstrap = true
+
+  m, err := distributed.New(memory.New(), config)
+  require.NoError(t, err)
+  require.NoError(t, m.WaitForLeader(3 * time.Second))
+  return m, config.DataDir
+}
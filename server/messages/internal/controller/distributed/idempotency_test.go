@@ -0,0 +1,76 @@
+package messages_test
+
+import (
+  "time"
+  "testing"
+  "context"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestIdempotentSave(t *testing.T) {
+  for scenario, fn := range map[string]func(
+    t *testing.T,
+    m *distributed.DistributedMessages,
+  ) {
+    "retry returns first message": testRetryReturnsFirst,
+    "keys are per user":           testKeysPerUser,
+  } {
+    t.Run(scenario, func(t *testing.T) {
+      fn(t, setupIdempotency(t, 0))
+    })
+  }
+
+  t.Run("expired key saves again", func(t *testing.T) {
+    m := setupIdempotency(t, -time.Hour)
+    ctx := context.Background()
+    first, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "value", ClientId: "key"})
+    require.NoError(t, err)
+    second, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "value", ClientId: "key"})
+    require.NoError(t, err)
+    require.NotEqual(t, first.Id, second.Id)
+  })
+}
+
+func testRetryReturnsFirst(t *testing.T, m *distributed.DistributedMessages) {
+  ctx := context.Background()
+  first, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "first", ClientId: "key"})
+  require.NoError(t, err)
+  retry, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "first", ClientId: "key"})
+  require.NoError(t, err)
+  require.Equal(t, first.Id, retry.Id)
+
+  // same key, other message
+  _, err = m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "retry", ClientId: "key"})
+  require.ErrorIs(t, err, distributed.ErrIdempotencyConflict)
+
+  list, err := m.ReadUserMessages(ctx, usermodel.UserId(1), 10, 0, true)
+  require.NoError(t, err)
+  require.Len(t, list.Messages, 1)
+
+  // no key, no dedupe
+  other, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "first"})
+  require.NoError(t, err)
+  require.NotEqual(t, first.Id, other.Id)
+}
+
+func testKeysPerUser(t *testing.T, m *distributed.DistributedMessages) {
+  ctx := context.Background()
+  first, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "value", ClientId: "key"})
+  require.NoError(t, err)
+  second, err := m.SaveMessage(ctx, &model.Message{UserId: 2, Value: "value", ClientId: "key"})
+  require.NoError(t, err)
+  require.NotEqual(t, first.Id, second.Id)
+  require.Equal(t, 2, second.UserId)
+}
+
+func setupIdempotency(t *testing.T, ttl time.Duration) *distributed.DistributedMessages {
+  t.Helper()
+
+  m, _ := newTestRaft(t, distributed.Config{IdempotencyTTL: ttl})
+  return m
+}
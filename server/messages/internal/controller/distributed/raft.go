@@ -4,12 +4,14 @@ import (
   "io"
   "os"
   "fmt"
-  "net"
   "time"
   "bytes"
   "errors"
   "encoding/json"
+  "encoding/hex"
+  "crypto/sha256"
   "context"
+  "log"
   "path/filepath"
 
   raftboltdb "github.com/hashicorp/raft-boltdb"
@@ -18,11 +20,17 @@ import (
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
   "github.com/bd878/gallery/server/messages/pkg/model"
   "github.com/bd878/gallery/server/messages/internal/repository"
+  "github.com/bd878/gallery/server/messages/internal/feed"
 
   "github.com/bd878/gallery/server/api"
 )
 
-var ErrMsgExist = errors.New("message exists")
+var (
+  ErrMsgExist = errors.New("message exists")
+  ErrOverQuota = errors.New("over quota")
+  ErrIdempotencyConflict = errors.New("client id reused with other message")
+  ErrEmptyMessage = errors.New("empty message")
+)
 
 type Repository interface {
   Put(context.Context, *model.Message) (model.MessageId, error)
@@ -31,13 +39,81 @@ type Repository interface {
   PutBatch(context.Context, [](*model.Message)) error
   GetBatch(context.Context) ([]*model.Message, error)
   GetOne(context.Context, usermodel.UserId, model.MessageId) (*model.Message, error)
+  DeleteUserMessages(context.Context, usermodel.UserId, uint64, int32, string) ([]*model.Message, []model.FileId, error)
   Truncate(context.Context) error
+  GetUsage(context.Context, usermodel.UserId) (*model.Usage, error)
+  ListUsage(context.Context, int32, int32) ([]*model.Usage, error)
+  GetQuota(context.Context, usermodel.UserId) (*model.Quota, error)
+  GetQuotas(context.Context) ([]*model.UserQuota, error)
+  SetQuota(context.Context, usermodel.UserId, *model.Quota) error
+  IdBase(context.Context) (uint64, error)
+  SetIdBase(context.Context, uint64) error
+  Changes(context.Context, usermodel.UserId, uint64, int32) ([]*model.Message, []*model.Tombstone, error)
+  SyncFloor(context.Context, usermodel.UserId) (uint64, error)
+  CompactTombstones(context.Context, string) (int, error)
+  GetSyncState(context.Context) ([]*model.Tombstone, []*model.SyncFloor, error)
+  PutSyncState(context.Context, []*model.Tombstone, []*model.SyncFloor) error
+  GetIdempotencyKey(context.Context, usermodel.UserId, string, string) (*model.IdempotencyKey, error)
+  PutWithIdempotencyKey(context.Context, *model.Message, *model.IdempotencyKey) (model.MessageId, error)
+  PutIdempotencyKey(context.Context, *model.IdempotencyKey) error
+  DeleteExpiredIdempotencyKeys(context.Context, string) error
+  GetIdempotencyKeys(context.Context) ([]*model.IdempotencyKey, error)
+  UpdateMessage(context.Context, *model.Message, *model.MessageVersion) error
+  GetVersions(context.Context, usermodel.UserId, model.MessageId) ([]*model.MessageVersion, error)
+  GetVersion(context.Context, usermodel.UserId, model.MessageId, uint64) (*model.MessageVersion, error)
+  PruneVersions(context.Context, usermodel.UserId, model.MessageId, int32, uint64) (int, []model.FileId, error)
+  GetAllVersions(context.Context) ([]*model.MessageVersion, error)
+  PutVersions(context.Context, []*model.MessageVersion) error
+  TrashMessage(context.Context, usermodel.UserId, model.MessageId, uint64, string) (*model.Message, error)
+  RestoreMessage(context.Context, usermodel.UserId, model.MessageId, uint64) (*model.Message, error)
+  GetTrash(context.Context, usermodel.UserId, int32, int32) (*model.MessagesList, error)
+  PurgeTrash(context.Context, usermodel.UserId, string, uint64, int32) ([]*model.Message, []model.FileId, error)
+}
+
+/**
+ * Leading byte of raft log data, tells fsm
+ * how to decode the rest of the record
+ */
+type RequestType uint8
+
+const (
+  AppendRequestType RequestType = 0
+  DeleteUserRequestType RequestType = 1
+  SetQuotaRequestType RequestType = 2
+  CompactTombstonesRequestType RequestType = 3
+  UpdateRequestType RequestType = 4
+  RestoreVersionRequestType RequestType = 5
+  PruneVersionsRequestType RequestType = 6
+  TrashRequestType RequestType = 7
+  RestoreMessageRequestType RequestType = 8
+  PurgeTrashRequestType RequestType = 9
+)
+
+/**
+ * Message with quota effective on the leader. Fsm checks it
+ * against replicated usage, so every node accepts or rejects
+ * the same records. Older records have no quota
+ */
+type appendRequest struct {
+  *model.Message
+  Quota *model.Quota `json:"quota,omitempty"`
+  // leader time and key expiry, set when message has client id
+  Time string        `json:"time,omitempty"`
+  Expires string     `json:"expires,omitempty"`
+}
+
+type deleteUserRequest struct {
+  UserId usermodel.UserId `json:"userid"`
+  Limit int32             `json:"limit"`
+  // leader time, tombstones keep it
+  Time string             `json:"time,omitempty"`
 }
 
 type DistributedMessages struct {
   config   Config
   raft    *raft.Raft
   repo     Repository
+  hub     *feed.Hub
 }
 
 func New(repo Repository, config Config) (
@@ -47,6 +123,7 @@ func New(repo Repository, config Config) (
   m := &DistributedMessages{
     repo: repo,
     config: config,
+    hub: feed.New(config.FeedBacklog),
   }
   if err := m.setupRaft(); err != nil {
     return nil, err
@@ -55,7 +132,7 @@ func New(repo Repository, config Config) (
 }
 
 func (m *DistributedMessages) setupRaft() error {
-  fsm := &fsm{repo: m.repo}
+  fsm := &fsm{repo: m.repo, dataDir: m.config.DataDir, hub: m.hub}
 
   raftPath := filepath.Join(m.config.DataDir, "raft")
   if err := os.MkdirAll(raftPath, 0755); err != nil {
@@ -154,35 +231,92 @@ func (m *DistributedMessages) setupRaft() error {
   return err
 }
 
-func (m *DistributedMessages) SaveMessage(ctx context.Context, msg *model.Message) (resMsg *model.Message, err error) {
-  msg.CreateTime = time.Now().String()
-  if resMsg, err = m.apply(ctx, msg); err != nil {
+func (m *DistributedMessages) SaveMessage(ctx context.Context, msg *model.Message) (*model.Message, error) {
+  // new message is never trashed or updated, seq comes from log
+  msg.CreateTime = model.FormatTime(time.Now())
+  msg.UpdateTime = ""
+  msg.DeletedAt = ""
+  msg.Seq = 0
+  quota, err := m.Quota(ctx, usermodel.UserId(msg.UserId))
+  if err != nil {
     return nil, err
   }
-  return resMsg, nil
+
+  req := &appendRequest{Message: msg, Quota: quota}
+  if msg.ClientId != "" {
+    ttl := m.config.IdempotencyTTL
+    if ttl == 0 {
+      ttl = DefaultIdempotencyTTL
+    }
+    now := time.Now()
+    req.Time = model.FormatTime(now)
+    req.Expires = model.FormatTime(now.Add(ttl))
+  }
+
+  res, err := m.apply(ctx, AppendRequestType, req)
+  if err != nil {
+    return nil, err
+  }
+
+  switch val := res.(type) {
+  case model.Message:
+    return &val, nil
+  default:
+    return nil, errors.New("fsm.apply returns undefined result")
+  }
 }
 
-func (m *DistributedMessages) apply(ctx context.Context, msg *model.Message) (*model.Message, error) {
-  b, err := json.Marshal(msg)
+/**
+ * Deletes one batch of user messages and their files
+ * on every node. Caller repeats until nothing is deleted
+ */
+func (m *DistributedMessages) DeleteUserMessages(
+  ctx context.Context,
+  userId usermodel.UserId,
+  limit int32,
+) (
+  *model.PurgeResult,
+  error,
+) {
+  res, err := m.apply(ctx, DeleteUserRequestType, &deleteUserRequest{
+    UserId: userId,
+    Limit: limit,
+    Time: model.FormatTime(time.Now()),
+  })
   if err != nil {
     return nil, err
   }
 
+  switch val := res.(type) {
+  case model.PurgeResult:
+    return &val, nil
+  default:
+    return nil, errors.New("fsm.apply returns undefined result")
+  }
+}
+
+func (m *DistributedMessages) apply(ctx context.Context, reqType RequestType, req interface{}) (interface{}, error) {
+  var buf bytes.Buffer
+  buf.WriteByte(byte(reqType))
+  if err := json.NewEncoder(&buf).Encode(req); err != nil {
+    return nil, err
+  }
+
   timeout := 10*time.Second
-  future := m.raft.Apply(b, timeout)
+  future := m.raft.Apply(buf.Bytes(), timeout)
   if future.Error() != nil {
     return nil, future.Error()
   }
 
   res := future.Response()
-  switch val := res.(type) {
-  case error:
-    return nil, val
-  case model.Message:
-    return &val, nil
-  default:
-    return nil, errors.New("fsm.apply returns undefined result")
+  if err, ok := res.(error); ok {
+    return nil, err
   }
+  return res, nil
+}
+
+func (m *DistributedMessages) IsLeader() bool {
+  return m.raft.State() == raft.Leader
 }
 
 func (m *DistributedMessages) ReadUserMessages(
@@ -343,16 +477,69 @@ var _ raft.FSM = (*fsm)(nil)
 
 type fsm struct {
   repo Repository
+  dataDir string
+  hub *feed.Hub
 }
 
 /**
  * Returns empty interface. It is either an error,
- * or new msg with unique id, saved in repo.
+ * or the result of request applied to repo.
  * 
  * Apply replicates log state from the bottom up.
  * Leader makes Apply on start.
  */
 func (f *fsm) Apply(record *raft.Log) interface{} {
+  res, event := f.apply(record)
+  f.hub.Publish(record.Index, event)
+  return res
+}
+
+// apply returns result and event for live feed, nil when nothing changed
+func (f *fsm) apply(record *raft.Log) (interface{}, *model.Event) {
+  buf := record.Data
+  if len(buf) == 0 {
+    return errors.New("empty log record"), nil
+  }
+
+  /* records written before request types are plain json messages */
+  if buf[0] == '{' {
+    return f.applyAppend(buf, record)
+  }
+
+  switch RequestType(buf[0]) {
+  case AppendRequestType:
+    return f.applyAppend(buf[1:], record)
+  case DeleteUserRequestType:
+    return f.applyDeleteUser(buf[1:], record)
+  case SetQuotaRequestType:
+    return f.applySetQuota(buf[1:]), nil
+  case CompactTombstonesRequestType:
+    return f.applyCompactTombstones(buf[1:]), nil
+  case UpdateRequestType:
+    return f.applyUpdate(buf[1:], record)
+  case RestoreVersionRequestType:
+    return f.applyRestoreVersion(buf[1:], record)
+  case PruneVersionsRequestType:
+    return f.applyPruneVersions(buf[1:], record), nil
+  case TrashRequestType:
+    return f.applyTrash(buf[1:], record)
+  case RestoreMessageRequestType:
+    return f.applyRestoreMessage(buf[1:], record)
+  case PurgeTrashRequestType:
+    return f.applyPurgeTrash(buf[1:], record), nil
+  default:
+    return fmt.Errorf("unknown request type: %d", buf[0]), nil
+  }
+}
+
+/**
+ * Returns new msg with unique id, saved in repo,
+ * or ErrOverQuota. Message replayed on start
+ * still makes event, so feed may resume after restart.
+ * Message with known client id returns the one saved first,
+ * or ErrIdempotencyConflict when it is another message
+ */
+func (f *fsm) applyAppend(buf []byte, record *raft.Log) (interface{}, *model.Event) {
   var msg *model.Message
   var err error
 
@@ -360,62 +547,282 @@ func (f *fsm) Apply(record *raft.Log) interface{} {
   if err != nil {
     /* not found is expected behaviour */
     if !errors.Is(err, repository.ErrNotFound) {
-      return err
+      return err, nil
     }
   }
   if msg != nil {
-    return ErrMsgExist
+    return ErrMsgExist, createdEvent(record.Index, msg)
   }
 
-  buf := record.Data
-  err = json.Unmarshal(buf, &msg)
+  var req appendRequest
+  err = json.Unmarshal(buf, &req)
   if err != nil {
-    return err
+    return err, nil
+  }
+  if req.Message == nil {
+    return errors.New("empty message"), nil
+  }
+  msg = req.Message
+  msg.NormalizeAttachments()
+
+  if msg.ClientId != "" && req.Time != "" {
+    saved, err := f.findByClientId(msg, req.Time)
+    if err != nil {
+      return err, nil
+    }
+    if saved != nil {
+      return *saved, nil
+    }
   }
+
+  if req.Quota != nil {
+    usage, err := f.repo.GetUsage(context.Background(), usermodel.UserId(msg.UserId))
+    if err != nil {
+      return err, nil
+    }
+    if !req.Quota.Allows(usage, model.FilesSize(msg.Attachments), int64(len(msg.Attachments))) {
+      return ErrOverQuota, nil
+    }
+  }
+
+  // log index is same on every node and never reused,
+  // base keeps it above ids of messages older than log
+  base, err := f.repo.IdBase(context.Background())
+  if err != nil {
+    return err, nil
+  }
+  msg.Id = model.MessageId(record.Index + base)
   msg.LogIndex = record.Index
   msg.LogTerm = record.Term
+  msg.Seq = record.Index
+
+  if msg.ClientId != "" && req.Expires != "" {
+    msg.Id, err = f.repo.PutWithIdempotencyKey(context.Background(), msg, &model.IdempotencyKey{
+      UserId: msg.UserId,
+      Key: msg.ClientId,
+      Expires: req.Expires,
+      Hash: payloadHash(msg),
+    })
+  } else {
+    msg.Id, err = f.repo.Put(context.Background(), msg)
+  }
+  if err != nil {
+    return err, nil
+  }
+
+  return *msg, createdEvent(record.Index, msg)
+}
+
+/**
+ * findByClientId returns message saved with same client id
+ * before, nil when key expired at leader time or message
+ * is deleted. Expired keys are dropped on the way.
+ * Other payload under the key is ErrIdempotencyConflict
+ */
+func (f *fsm) findByClientId(msg *model.Message, now string) (*model.Message, error) {
+  ctx := context.Background()
+  if err := f.repo.DeleteExpiredIdempotencyKeys(ctx, now); err != nil {
+    return nil, err
+  }
 
-  msg.Id, err = f.repo.Put(context.Background(), msg)
+  key, err := f.repo.GetIdempotencyKey(ctx, usermodel.UserId(msg.UserId), msg.ClientId, now)
+  if errors.Is(err, repository.ErrNotFound) {
+    return nil, nil
+  }
   if err != nil {
-    return err
+    return nil, err
+  }
+  // keys saved before hashes match any payload
+  if key.Hash != "" && key.Hash != payloadHash(msg) {
+    return nil, ErrIdempotencyConflict
+  }
+
+  saved, err := f.repo.GetOne(ctx, usermodel.UserId(msg.UserId), key.MessageId)
+  if errors.Is(err, repository.ErrNotFound) {
+    return nil, nil
+  }
+  if err != nil {
+    return nil, err
   }
+  saved.ClientId = msg.ClientId
+  return saved, nil
+}
 
-  return *msg
+/**
+ * payloadHash identifies message sent under client id. Retried
+ * upload saves files anew, so attachments count without file ids
+ */
+func payloadHash(msg *model.Message) string {
+  type file struct {
+    Name string `json:"name"`
+    Size int64  `json:"size"`
+    Type string `json:"type"`
+  }
+  payload := struct {
+    Value string `json:"value"`
+    Files []file `json:"files"`
+  }{Value: msg.Value}
+  for _, attachment := range msg.Attachments {
+    payload.Files = append(payload.Files, file{attachment.FileName, attachment.FileSize, attachment.MimeType})
+  }
+
+  data, _ := json.Marshal(payload)
+  sum := sha256.Sum256(data)
+  return hex.EncodeToString(sum[:])
+}
+
+func createdEvent(index uint64, msg *model.Message) *model.Event {
+  res := *msg
+  return &model.Event{
+    Id: index,
+    Type: model.EventCreated,
+    UserId: msg.UserId,
+    Message: &res,
+  }
+}
+
+/**
+ * Only messages appended before this record are deleted,
+ * so replaying the log never removes newer messages
+ */
+func (f *fsm) applyDeleteUser(buf []byte, record *raft.Log) (interface{}, *model.Event) {
+  var req deleteUserRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err, nil
+  }
+
+  msgs, versionFiles, err := f.repo.DeleteUserMessages(context.Background(), req.UserId, record.Index, req.Limit, req.Time)
+  if err != nil {
+    return err, nil
+  }
+
+  var event *model.Event
+  if len(msgs) > 0 {
+    event = &model.Event{
+      Id: record.Index,
+      Type: model.EventDeleted,
+      UserId: int(req.UserId),
+    }
+  }
+
+  res := model.PurgeResult{MessagesDeleted: len(msgs)}
+  for _, msg := range msgs {
+    event.MessageIds = append(event.MessageIds, msg.Id)
+    res.FilesDeleted += f.removeFiles(msg.Attachments)
+  }
+  for _, fileId := range versionFiles {
+    if f.removeFile(fileId) {
+      res.FilesDeleted += 1
+    }
+  }
+  return res, event
+}
+
+// removeFiles returns number of attachment files that were there
+func (f *fsm) removeFiles(attachments []model.Attachment) int {
+  n := 0
+  for _, attachment := range attachments {
+    if f.removeFile(attachment.FileId) {
+      n += 1
+    }
+  }
+  return n
+}
+
+// removeFile reports whether file was there
+func (f *fsm) removeFile(fileId model.FileId) bool {
+  err := os.Remove(filepath.Join(f.dataDir, filepath.Base(string(fileId))))
+  if err != nil && !os.IsNotExist(err) {
+    log.Println("failed to remove file:", err)
+  }
+  return err == nil
 }
 
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
   return &snapshot{repo: f.repo}, nil
 }
 
-// TODO: restore will reapply same messages with ids,
-// check whether msg with id exists
+/**
+ * Snapshot of repository state. Snapshots taken before
+ * quotas are a plain array of messages
+ */
+type snapshotData struct {
+  Messages []*model.Message      `json:"messages"`
+  Quotas []*model.UserQuota      `json:"quotas"`
+  Tombstones []*model.Tombstone  `json:"tombstones,omitempty"`
+  SyncFloors []*model.SyncFloor  `json:"syncfloors,omitempty"`
+  IdempotencyKeys []*model.IdempotencyKey `json:"idempotencykeys,omitempty"`
+  Versions []*model.MessageVersion `json:"versions,omitempty"`
+  IdBase *uint64 `json:"idbase,omitempty"`
+}
+
+// Restore replaces repo state, messages keep their ids
 func (f *fsm) Restore(r io.ReadCloser) error {
-  var buf *bytes.Buffer
-  var msgs []model.Message
+  defer r.Close()
 
-  _, err := io.Copy(buf, r)
-  if err == io.EOF {
-    return err
-  } else if err != nil {
+  b, err := io.ReadAll(r)
+  if err != nil {
     return err
   }
-  err = json.Unmarshal(buf.Bytes(), &msgs)
+
+  var data snapshotData
+  if len(b) > 0 && b[0] == '[' {
+    err = json.Unmarshal(b, &data.Messages)
+  } else {
+    err = json.Unmarshal(b, &data)
+  }
   if err != nil {
     return err
   }
 
+  f.hub.Reset()
+
   ctx := context.Background()
   err = f.repo.Truncate(ctx)
   if err != nil {
     return err
   }
-  for _, msg := range msgs {
-    _, err := f.repo.Put(ctx, &msg)
+  for _, msg := range data.Messages {
+    _, err := f.repo.Put(ctx, msg)
     if err != nil {
       return err
     }
   }
-  return nil
+  for _, quota := range data.Quotas {
+    err := f.repo.SetQuota(ctx, usermodel.UserId(quota.UserId), &quota.Quota)
+    if err != nil {
+      return err
+    }
+  }
+  if err := f.repo.SetIdBase(ctx, idBase(&data)); err != nil {
+    return err
+  }
+  if err := f.repo.PutVersions(ctx, data.Versions); err != nil {
+    return err
+  }
+  for _, key := range data.IdempotencyKeys {
+    if err := f.repo.PutIdempotencyKey(ctx, key); err != nil {
+      return err
+    }
+  }
+  return f.repo.PutSyncState(ctx, data.Tombstones, data.SyncFloors)
+}
+
+/**
+ * idBase of older snapshots is taken same way
+ * as migration does, from messages older than log
+ */
+func idBase(data *snapshotData) uint64 {
+  if data.IdBase != nil {
+    return *data.IdBase
+  }
+  var base uint64
+  for _, msg := range data.Messages {
+    if msg.LogIndex == 0 && uint64(msg.Id) > base {
+      base = uint64(msg.Id)
+    }
+  }
+  return base
 }
 
 type snapshot struct {
@@ -425,75 +832,52 @@ type snapshot struct {
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
   msgs, err := s.repo.GetBatch(context.Background())
   if err != nil {
+    _ = sink.Cancel()
     return err
   }
-
-  b, err := json.Marshal(msgs)
+  quotas, err := s.repo.GetQuotas(context.Background())
   if err != nil {
+    _ = sink.Cancel()
     return err
   }
-  if _, err := io.Copy(sink, bytes.NewReader(b)); err != nil {
+  tombstones, floors, err := s.repo.GetSyncState(context.Background())
+  if err != nil {
     _ = sink.Cancel()
     return err
   }
-  return sink.Close()
-}
-
-func (s *snapshot) Release() {}
-
-type StreamLayer struct {
-  ln net.Listener
-}
-
-func NewStreamLayer(ln net.Listener) *StreamLayer {
-  return &StreamLayer{ln: ln}
-}
-
-/**
- * Leading byte in rpc, signifies, that it is a call
- * to raft node (not grpc)
- */
-const RaftRPC = 1
-
-func (s *StreamLayer) Dial(
-  addr raft.ServerAddress,
-  timeout time.Duration,
-) (net.Conn, error) {
-  dialer := &net.Dialer{Timeout: timeout}
-  conn, err := dialer.Dial("tcp", string(addr))
+  keys, err := s.repo.GetIdempotencyKeys(context.Background())
   if err != nil {
-    return nil, err
+    _ = sink.Cancel()
+    return err
   }
-
-  _, err = conn.Write([]byte{byte(RaftRPC)})
+  versions, err := s.repo.GetAllVersions(context.Background())
   if err != nil {
-    return nil, err
+    _ = sink.Cancel()
+    return err
   }
-
-  return conn, err
-}
-
-func (s *StreamLayer) Accept() (net.Conn, error) {
-  conn, err := s.ln.Accept()
+  base, err := s.repo.IdBase(context.Background())
   if err != nil {
-    return nil, err
+    _ = sink.Cancel()
+    return err
   }
 
-  b := make([]byte, 1)
-  if _, err := conn.Read(b); err != nil {
-    return nil, err
+  b, err := json.Marshal(&snapshotData{
+    Messages: msgs,
+    Quotas: quotas,
+    Tombstones: tombstones,
+    SyncFloors: floors,
+    IdempotencyKeys: keys,
+    Versions: versions,
+    IdBase: &base,
+  })
+  if err != nil {
+    return err
   }
-  if bytes.Compare(b, []byte{byte(RaftRPC)}) != 0 {
-    return nil, errors.New("not a raft rpc")
+  if _, err := io.Copy(sink, bytes.NewReader(b)); err != nil {
+    _ = sink.Cancel()
+    return err
   }
-
-  return conn, nil
-}
-
-func (s *StreamLayer) Close() error {
-  return s.ln.Close()
+  return sink.Close()
 }
 
-func (s *StreamLayer) Addr() net.Addr {
-  return s.ln.Addr()
-}
\ No newline at end of file
+func (s *snapshot) Release() {}
@@ -13,6 +13,7 @@ import (
 
   "github.com/bd878/gallery/server/messages/pkg/model"
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/internal/streamlayer"
   memory "github.com/bd878/gallery/server/messages/internal/repository/memory"
   distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
 )
@@ -33,7 +34,7 @@ func TestDistributed(t *testing.T) {
     require.NoError(t, err)
 
     config := distributed.Config{}
-    config.StreamLayer = distributed.NewStreamLayer(ln)
+    config.StreamLayer = streamlayer.New(ln, nil, nil)
     config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
     config.DataDir = dataDir
     config.Raft.HeartbeatTimeout = 50 * time.Millisecond
@@ -68,8 +69,13 @@ func TestDistributed(t *testing.T) {
   }
 
   for _, msg := range messages {
-    _, err := logs[0].SaveMessage(context.Background(), msg)
+    saved, err := logs[0].SaveMessage(context.Background(), msg)
     require.NoError(t, err)
+    // ids are log indexes assigned by fsm
+    require.Equal(t, model.MessageId(saved.LogIndex), saved.Id)
+    _, err = time.Parse(time.RFC3339Nano, saved.CreateTime)
+    require.NoError(t, err)
+    msg.Id = saved.Id
     require.Eventually(t, func() bool {
       for j := 0; j < nodeCount; j++ {
         got, err := logs[j].ReadOneMessage(
@@ -89,7 +95,7 @@ func TestDistributed(t *testing.T) {
     }, 500*time.Millisecond, 50*time.Millisecond)
   }
 
-  servers, err := logs[0].GetServers()
+  servers, err := logs[0].GetServers(context.Background())
   require.NoError(t, err)
   require.Equal(t, nodeCount, len(servers))
   require.True(t, servers[0].IsLeader)
@@ -101,19 +107,18 @@ func TestDistributed(t *testing.T) {
 
   time.Sleep(50 *time.Millisecond)
 
-  servers, err = logs[0].GetServers()
+  servers, err = logs[0].GetServers(context.Background())
   require.NoError(t, err)
   require.Equal(t, nodeCount-1, len(servers))
 
-  _, err = logs[0].SaveMessage(context.Background(), &model.Message{
-    Id: 3,
+  saved, err := logs[0].SaveMessage(context.Background(), &model.Message{
     UserId: 1,
     Value: "third",
   })
   require.NoError(t, err)
 
   time.Sleep(50 * time.Millisecond)
-  message, err := logs[2].ReadOneMessage(context.Background(), usermodel.UserId(1), 3)
+  message, err := logs[2].ReadOneMessage(context.Background(), usermodel.UserId(1), saved.Id)
   require.NoError(t, err)
   require.Equal(t, "third", message.Value)
 }
\ No newline at end of file
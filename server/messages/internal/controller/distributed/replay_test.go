@@ -0,0 +1,136 @@
+package messages
+
+import (
+  "io"
+  "os"
+  "time"
+  "bytes"
+  "context"
+  "testing"
+  "path/filepath"
+  "encoding/json"
+
+  "github.com/hashicorp/raft"
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/internal/feed"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  memory "github.com/bd878/gallery/server/messages/internal/repository/memory"
+  sqlite "github.com/bd878/gallery/server/messages/internal/repository/sqlite"
+)
+
+func TestPruneReplay(t *testing.T) {
+  sqliteRepo, err := sqlite.New(filepath.Join(t.TempDir(), "messages.db"))
+  require.NoError(t, err)
+
+  for name, repo := range map[string]Repository{"memory": memory.New(), "sqlite": sqliteRepo} {
+    t.Run(name, func(t *testing.T) {
+      testPruneReplay(t, repo)
+    })
+  }
+}
+
+func testPruneReplay(t *testing.T, repo Repository) {
+  f := &fsm{repo: repo, dataDir: t.TempDir(), hub: feed.New(10)}
+  var records []*raft.Log
+  apply := func(reqType RequestType, req interface{}) interface{} {
+    var buf bytes.Buffer
+    buf.WriteByte(byte(reqType))
+    require.NoError(t, json.NewEncoder(&buf).Encode(req))
+    record := &raft.Log{Index: uint64(len(records) + 1), Term: 1, Data: buf.Bytes()}
+    records = append(records, record)
+    return f.Apply(record)
+  }
+  file := func(name string) []model.Attachment {
+    require.NoError(t, os.WriteFile(filepath.Join(f.dataDir, name), []byte(name), 0644))
+    return []model.Attachment{{FileId: model.FileId(name), FileName: name, FileSize: 1}}
+  }
+  update := func(msg model.Message, value string) {
+    res := apply(UpdateRequestType, &updateRequest{
+      MessageUpdate: &model.MessageUpdate{Id: msg.Id, UserId: msg.UserId,
+        SetValue: true, Value: value, SetFile: true, Attachments: file(value)},
+      Time: model.FormatTime(time.Now()),
+    })
+    require.IsType(t, model.Message{}, res)
+  }
+
+  res := apply(AppendRequestType, &appendRequest{Message: &model.Message{
+    UserId: 1, Value: "a", Attachments: file("a"),
+  }})
+  msg, ok := res.(model.Message)
+  require.True(t, ok, res)
+  update(msg, "b")
+  update(msg, "c")
+
+  prune := &pruneVersionsRequest{UserId: 1, Id: msg.Id, Keep: 0}
+  pruned, ok := apply(PruneVersionsRequestType, prune).(model.PruneResult)
+  require.True(t, ok)
+  require.Equal(t, 2, pruned.VersionsDeleted)
+  require.Equal(t, 2, pruned.FilesDeleted)
+  pruneRecord := records[len(records)-1]
+
+  update(msg, "d")
+
+  // replayed prune spares version made after it, and its file
+  replayed, ok := f.Apply(pruneRecord).(model.PruneResult)
+  require.True(t, ok)
+  require.Equal(t, 0, replayed.VersionsDeleted)
+
+  versions, err := repo.GetVersions(context.Background(), 1, msg.Id)
+  require.NoError(t, err)
+  require.Len(t, versions, 1)
+  require.Equal(t, "c", versions[0].Value)
+  _, err = os.Stat(filepath.Join(f.dataDir, "c"))
+  require.NoError(t, err)
+}
+
+func TestIdBase(t *testing.T) {
+  sqliteRepo, err := sqlite.New(filepath.Join(t.TempDir(), "messages.db"))
+  require.NoError(t, err)
+
+  for name, repo := range map[string]Repository{"memory": memory.New(), "sqlite": sqliteRepo} {
+    t.Run(name, func(t *testing.T) {
+      testIdBase(t, repo)
+    })
+  }
+}
+
+func testIdBase(t *testing.T, repo Repository) {
+  ctx := context.Background()
+  f := &fsm{repo: repo, dataDir: t.TempDir(), hub: feed.New(10)}
+  require.NoError(t, repo.SetIdBase(ctx, 10))
+
+  var buf bytes.Buffer
+  buf.WriteByte(byte(AppendRequestType))
+  require.NoError(t, json.NewEncoder(&buf).Encode(&appendRequest{Message: &model.Message{UserId: 1, Value: "a"}}))
+  msg, ok := f.Apply(&raft.Log{Index: 3, Term: 1, Data: buf.Bytes()}).(model.Message)
+  require.True(t, ok)
+  require.Equal(t, model.MessageId(13), msg.Id)
+
+  // snapshot carries base to restored nodes
+  store := raft.NewInmemSnapshotStore()
+  sink, err := store.Create(raft.SnapshotVersionMax, 3, 1, raft.Configuration{}, 0, nil)
+  require.NoError(t, err)
+  snap, err := f.Snapshot()
+  require.NoError(t, err)
+  require.NoError(t, snap.Persist(sink))
+  _, r, err := store.Open(sink.ID())
+  require.NoError(t, err)
+
+  restored := &fsm{repo: memory.New(), dataDir: t.TempDir(), hub: feed.New(10)}
+  require.NoError(t, restored.Restore(r))
+  base, err := restored.repo.IdBase(ctx)
+  require.NoError(t, err)
+  require.Equal(t, uint64(10), base)
+
+  // older snapshots take base from messages older than log
+  old, err := json.Marshal(&snapshotData{Messages: []*model.Message{
+    {Id: 7, UserId: 1, Value: "legacy"},
+    {Id: 8, UserId: 1, Value: "from log", LogIndex: 1, LogTerm: 1},
+  }})
+  require.NoError(t, err)
+  require.NoError(t, restored.Restore(io.NopCloser(bytes.NewReader(old))))
+  base, err = restored.repo.IdBase(ctx)
+  require.NoError(t, err)
+  require.Equal(t, uint64(7), base)
+}
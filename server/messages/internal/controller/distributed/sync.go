@@ -0,0 +1,146 @@
+package messages
+
+import (
+  "time"
+  "context"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+type compactTombstonesRequest struct {
+  // RFC3339 leader time, older tombstones are dropped
+  Before string `json:"before"`
+}
+
+/**
+ * Sync returns up to limit changes of user after since token,
+ * messages and tombstones merged in seq order. Since below
+ * compacted tombstones asks client to resync from zero
+ */
+func (m *DistributedMessages) Sync(
+  ctx context.Context,
+  userId usermodel.UserId,
+  since uint64,
+  limit int32,
+) (
+  *model.SyncResult,
+  error,
+) {
+  if since > 0 {
+    floor, err := m.repo.SyncFloor(ctx, userId)
+    if err != nil {
+      return nil, err
+    }
+    if since < floor {
+      return &model.SyncResult{Token: since, Resync: true}, nil
+    }
+  }
+
+  // all up to applied index is in repo before changes are read
+  applied := m.raft.AppliedIndex()
+
+  // one more of each tells there is next page
+  fetch := limit + 1
+  for {
+    msgs, tombstones, err := m.repo.Changes(ctx, userId, since, fetch)
+    if err != nil {
+      return nil, err
+    }
+    if res, ok := page(msgs, tombstones, since, limit, fetch); ok {
+      // last page moves token past floors raised by migration
+      if !res.HasMore && res.Token < applied {
+        res.Token = applied
+      }
+      return res, nil
+    }
+    fetch *= 2
+  }
+}
+
+/**
+ * page merges changes into groups of one seq, one delete
+ * makes many tombstones of it, so token never splits a change.
+ * First group may exceed limit, it is not ok when fetched
+ * rows may cut it
+ */
+func page(
+  msgs []*model.Message,
+  tombstones []*model.Tombstone,
+  since uint64,
+  limit int32,
+  fetch int32,
+) (*model.SyncResult, bool) {
+  res := &model.SyncResult{
+    Messages: make([]*model.Message, 0),
+    Tombstones: make([]*model.Tombstone, 0),
+    Token: since,
+  }
+
+  msgsCut := int32(len(msgs)) == fetch
+  tombstonesCut := int32(len(tombstones)) == fetch
+  for len(msgs) + len(tombstones) > 0 {
+    var seq uint64
+    if len(tombstones) == 0 || (len(msgs) > 0 && msgs[0].Seq < tombstones[0].Seq) {
+      seq = msgs[0].Seq
+    } else {
+      seq = tombstones[0].Seq
+    }
+
+    var m, t int
+    for m < len(msgs) && msgs[m].Seq == seq {
+      m += 1
+    }
+    for t < len(tombstones) && tombstones[t].Seq == seq {
+      t += 1
+    }
+
+    taken := len(res.Messages) + len(res.Tombstones)
+    if taken > 0 && int32(taken + m + t) > limit {
+      res.HasMore = true
+      break
+    }
+    if (m > 0 && m == len(msgs) && msgsCut) || (t > 0 && t == len(tombstones) && tombstonesCut) {
+      if taken == 0 {
+        return nil, false
+      }
+      res.HasMore = true
+      break
+    }
+
+    res.Messages = append(res.Messages, msgs[:m]...)
+    res.Tombstones = append(res.Tombstones, tombstones[:t]...)
+    msgs, tombstones = msgs[m:], tombstones[t:]
+    res.Token = seq
+  }
+  return res, true
+}
+
+/**
+ * CompactTombstones drops tombstones older than retention
+ * on every node. Called by the leader, clients synced
+ * before them get resync signal
+ */
+func (m *DistributedMessages) CompactTombstones(ctx context.Context, retention time.Duration) (int, error) {
+  res, err := m.apply(ctx, CompactTombstonesRequestType, &compactTombstonesRequest{
+    Before: model.FormatTime(time.Now().Add(-retention)),
+  })
+  if err != nil {
+    return 0, err
+  }
+  n, _ := res.(int)
+  return n, nil
+}
+
+func (f *fsm) applyCompactTombstones(buf []byte) interface{} {
+  var req compactTombstonesRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err
+  }
+  n, err := f.repo.CompactTombstones(context.Background(), req.Before)
+  if err != nil {
+    return err
+  }
+  return n
+}
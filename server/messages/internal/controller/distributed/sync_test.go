@@ -0,0 +1,90 @@
+package messages_test
+
+import (
+  "time"
+  "testing"
+  "context"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestSync(t *testing.T) {
+  m, _ := newTestRaft(t, distributed.Config{})
+
+  ctx := context.Background()
+  var saved []*model.Message
+  for i := 0; i < 4; i++ {
+    msg, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "value"})
+    require.NoError(t, err)
+    saved = append(saved, msg)
+  }
+  _, err := m.SaveMessage(ctx, &model.Message{UserId: 2, Value: "other user"})
+  require.NoError(t, err)
+
+  // full sync, in pages
+  res, err := m.Sync(ctx, 1, 0, 3)
+  require.NoError(t, err)
+  require.Len(t, res.Messages, 3)
+  require.True(t, res.HasMore)
+  require.Equal(t, saved[2].Seq, res.Token)
+
+  res, err = m.Sync(ctx, 1, res.Token, 3)
+  require.NoError(t, err)
+  require.Len(t, res.Messages, 1)
+  require.False(t, res.HasMore)
+  token := res.Token
+  // last page token is applied index, past other user message too
+  require.Greater(t, token, saved[3].Seq)
+
+  // nothing changed, same token
+  res, err = m.Sync(ctx, 1, token, 3)
+  require.NoError(t, err)
+  require.Empty(t, res.Messages)
+  require.Empty(t, res.Tombstones)
+  require.Equal(t, token, res.Token)
+
+  // one delete makes three tombstones of one seq, page keeps them together
+  _, err = m.DeleteUserMessages(ctx, 1, 3)
+  require.NoError(t, err)
+  last, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "last"})
+  require.NoError(t, err)
+
+  res, err = m.Sync(ctx, 1, token, 2)
+  require.NoError(t, err)
+  require.Empty(t, res.Messages)
+  require.Len(t, res.Tombstones, 3)
+  require.True(t, res.HasMore)
+  for i, tombstone := range res.Tombstones {
+    require.Equal(t, saved[i].Id, tombstone.Id)
+  }
+
+  res, err = m.Sync(ctx, 1, res.Token, 2)
+  require.NoError(t, err)
+  require.Len(t, res.Messages, 1)
+  require.Equal(t, last.Id, res.Messages[0].Id)
+  require.False(t, res.HasMore)
+
+  // compacted tombstones ask clients synced before to start over
+  n, err := m.CompactTombstones(ctx, -time.Hour)
+  require.NoError(t, err)
+  require.Equal(t, 3, n)
+
+  res, err = m.Sync(ctx, 1, token, 10)
+  require.NoError(t, err)
+  require.True(t, res.Resync)
+
+  res, err = m.Sync(ctx, 1, 0, 10)
+  require.NoError(t, err)
+  require.False(t, res.Resync)
+  require.Len(t, res.Messages, 2)
+  require.Empty(t, res.Tombstones)
+
+  // resynced token is above floor
+  res, err = m.Sync(ctx, 1, res.Token, 10)
+  require.NoError(t, err)
+  require.False(t, res.Resync)
+  require.Empty(t, res.Messages)
+}
@@ -0,0 +1,195 @@
+package messages
+
+import (
+  "time"
+  "errors"
+  "context"
+  "encoding/json"
+
+  "github.com/hashicorp/raft"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+// messages purged by one log record
+const trashBatchSize = 100
+
+type trashRequest struct {
+  UserId usermodel.UserId `json:"userid"`
+  Id model.MessageId      `json:"id"`
+  // leader time, message and tombstone keep it
+  Time string             `json:"time"`
+}
+
+/**
+ * Purge of messages trashed at Before or earlier,
+ * zero UserId purges trash of all users
+ */
+type purgeTrashRequest struct {
+  UserId usermodel.UserId `json:"userid"`
+  Before string           `json:"before"`
+  Limit int32             `json:"limit"`
+}
+
+// DeleteMessage moves message to trash, it can be restored till purged
+func (m *DistributedMessages) DeleteMessage(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+) (
+  *model.Message,
+  error,
+) {
+  res, err := m.apply(ctx, TrashRequestType, &trashRequest{
+    UserId: userId,
+    Id: id,
+    Time: model.FormatTime(time.Now()),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return messageResult(res)
+}
+
+// ListTrash returns trashed user messages, recently deleted first
+func (m *DistributedMessages) ListTrash(
+  ctx context.Context,
+  userId usermodel.UserId,
+  limit int32,
+  offset int32,
+) (
+  *model.MessagesList,
+  error,
+) {
+  return m.repo.GetTrash(ctx, userId, limit, offset)
+}
+
+// RestoreMessage brings message back from trash
+func (m *DistributedMessages) RestoreMessage(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+) (
+  *model.Message,
+  error,
+) {
+  res, err := m.apply(ctx, RestoreMessageRequestType, &trashRequest{
+    UserId: userId,
+    Id: id,
+    Time: model.FormatTime(time.Now()),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return messageResult(res)
+}
+
+// EmptyTrash purges whole trash of user along with files, batch by batch
+func (m *DistributedMessages) EmptyTrash(ctx context.Context, userId usermodel.UserId) (*model.PurgeResult, error) {
+  return m.purgeTrash(ctx, userId, time.Now())
+}
+
+/**
+ * PurgeTrash purges messages of all users trashed longer
+ * than retention ago. Called by the leader, followers
+ * receive it through raft
+ */
+func (m *DistributedMessages) PurgeTrash(ctx context.Context, retention time.Duration) (*model.PurgeResult, error) {
+  return m.purgeTrash(ctx, 0, time.Now().Add(-retention))
+}
+
+func (m *DistributedMessages) purgeTrash(
+  ctx context.Context,
+  userId usermodel.UserId,
+  before time.Time,
+) (
+  *model.PurgeResult,
+  error,
+) {
+  req := &purgeTrashRequest{
+    UserId: userId,
+    Before: model.FormatTime(before),
+    Limit: trashBatchSize,
+  }
+
+  total := &model.PurgeResult{}
+  for {
+    res, err := m.apply(ctx, PurgeTrashRequestType, req)
+    if err != nil {
+      return total, err
+    }
+    batch, ok := res.(model.PurgeResult)
+    if !ok {
+      return total, errors.New("fsm.apply returns undefined result")
+    }
+    total.MessagesDeleted += batch.MessagesDeleted
+    total.FilesDeleted += batch.FilesDeleted
+    if int32(batch.MessagesDeleted) < req.Limit {
+      return total, nil
+    }
+  }
+}
+
+/**
+ * Trashed message looks deleted to clients: feed gets
+ * deleted event, sync gets tombstone
+ */
+func (f *fsm) applyTrash(buf []byte, record *raft.Log) (interface{}, *model.Event) {
+  var req trashRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err, nil
+  }
+
+  msg, err := f.repo.TrashMessage(context.Background(), req.UserId, req.Id, record.Index, req.Time)
+  if err != nil {
+    return err, nil
+  }
+  return *msg, &model.Event{
+    Id: record.Index,
+    Type: model.EventDeleted,
+    UserId: msg.UserId,
+    MessageIds: []model.MessageId{msg.Id},
+  }
+}
+
+// restored message comes back to clients as created one
+func (f *fsm) applyRestoreMessage(buf []byte, record *raft.Log) (interface{}, *model.Event) {
+  var req trashRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err, nil
+  }
+
+  msg, err := f.repo.RestoreMessage(context.Background(), req.UserId, req.Id, record.Index)
+  if err != nil {
+    return err, nil
+  }
+  return *msg, createdEvent(record.Index, msg)
+}
+
+/**
+ * Only messages trashed before this record are purged,
+ * so replaying the log never purges ones trashed again later
+ */
+func (f *fsm) applyPurgeTrash(buf []byte, record *raft.Log) interface{} {
+  var req purgeTrashRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err
+  }
+
+  msgs, versionFiles, err := f.repo.PurgeTrash(context.Background(), req.UserId, req.Before, record.Index, req.Limit)
+  if err != nil {
+    return err
+  }
+
+  res := model.PurgeResult{MessagesDeleted: len(msgs)}
+  for _, msg := range msgs {
+    res.FilesDeleted += f.removeFiles(msg.Attachments)
+  }
+  for _, fileId := range versionFiles {
+    if f.removeFile(fileId) {
+      res.FilesDeleted += 1
+    }
+  }
+  return res
+}
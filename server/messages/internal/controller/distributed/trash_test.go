@@ -0,0 +1,120 @@
+package messages_test
+
+import (
+  "os"
+  "time"
+  "testing"
+  "context"
+  "path/filepath"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestTrash(t *testing.T) {
+  m, dataDir := newTestRaft(t, distributed.Config{})
+
+  require.NoError(t, os.WriteFile(filepath.Join(dataDir, "a"), []byte("a"), 0644))
+
+  ctx := context.Background()
+  userId := usermodel.UserId(1)
+  photo, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "photo", FileName: "a.jpg", FileId: "a", FileSize: 10})
+  require.NoError(t, err)
+  note, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "note"})
+  require.NoError(t, err)
+
+  trashed, err := m.DeleteMessage(ctx, userId, photo.Id)
+  require.NoError(t, err)
+  require.NotEmpty(t, trashed.DeletedAt)
+
+  _, err = m.DeleteMessage(ctx, userId, photo.Id)
+  require.ErrorIs(t, err, repository.ErrNotFound)
+  _, err = m.ReadOneMessage(ctx, userId, photo.Id)
+  require.ErrorIs(t, err, repository.ErrNotFound)
+
+  list, err := m.ReadUserMessages(ctx, userId, 10, 0, true)
+  require.NoError(t, err)
+  require.Len(t, list.Messages, 1)
+  require.Equal(t, note.Id, list.Messages[0].Id)
+
+  trash, err := m.ListTrash(ctx, userId, 10, 0)
+  require.NoError(t, err)
+  require.Len(t, trash.Messages, 1)
+  require.Equal(t, photo.Id, trash.Messages[0].Id)
+
+  // clients see trashed message as deleted one
+  res, err := m.Sync(ctx, userId, note.Seq, 10)
+  require.NoError(t, err)
+  require.Empty(t, res.Messages)
+  require.Len(t, res.Tombstones, 1)
+  require.Equal(t, photo.Id, res.Tombstones[0].Id)
+
+  restored, err := m.RestoreMessage(ctx, userId, photo.Id)
+  require.NoError(t, err)
+  require.Empty(t, restored.DeletedAt)
+  res, err = m.Sync(ctx, userId, res.Token, 10)
+  require.NoError(t, err)
+  require.Len(t, res.Messages, 1)
+  require.Empty(t, res.Tombstones)
+
+  _, err = m.RestoreMessage(ctx, userId, photo.Id)
+  require.ErrorIs(t, err, repository.ErrNotFound)
+
+  // trashed one keeps its usage till purged
+  _, err = m.DeleteMessage(ctx, userId, photo.Id)
+  require.NoError(t, err)
+  usage, _, err := m.GetUsage(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, int64(10), usage.Bytes)
+
+  purged, err := m.PurgeTrash(ctx, time.Hour)
+  require.NoError(t, err)
+  require.Equal(t, 0, purged.MessagesDeleted)
+
+  purged, err = m.EmptyTrash(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, 1, purged.MessagesDeleted)
+  require.Equal(t, 1, purged.FilesDeleted)
+
+  _, err = os.Stat(filepath.Join(dataDir, "a"))
+  require.True(t, os.IsNotExist(err))
+  usage, _, err = m.GetUsage(ctx, userId)
+  require.NoError(t, err)
+  require.Equal(t, int64(0), usage.Bytes)
+  require.Equal(t, int64(1), usage.Messages)
+
+  trash, err = m.ListTrash(ctx, userId, 10, 0)
+  require.NoError(t, err)
+  require.Empty(t, trash.Messages)
+  _, err = m.RestoreMessage(ctx, userId, photo.Id)
+  require.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestSaveDropsServerFields(t *testing.T) {
+  m, _ := newTestRaft(t, distributed.Config{})
+
+  ctx := context.Background()
+  saved, err := m.SaveMessage(ctx, &model.Message{
+    UserId: 1,
+    Value: "note",
+    DeletedAt: model.FormatTime(time.Now()),
+    UpdateTime: "2000-01-01T00:00:00.000Z",
+    Seq: 1000,
+  })
+  require.NoError(t, err)
+  require.Empty(t, saved.DeletedAt)
+  require.Empty(t, saved.UpdateTime)
+  require.Less(t, saved.Seq, uint64(1000))
+
+  // caller can not put message straight into trash
+  list, err := m.ReadUserMessages(ctx, 1, 10, 0, true)
+  require.NoError(t, err)
+  require.Len(t, list.Messages, 1)
+  trash, err := m.ListTrash(ctx, 1, 10, 0)
+  require.NoError(t, err)
+  require.Empty(t, trash.Messages)
+}
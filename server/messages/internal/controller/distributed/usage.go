@@ -0,0 +1,76 @@
+package messages
+
+import (
+  "context"
+  "errors"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+type setQuotaRequest struct {
+  UserId usermodel.UserId `json:"userid"`
+  // nil drops user quota
+  Quota *model.Quota      `json:"quota,omitempty"`
+}
+
+// Quota is user own quota, or default one. Nil means unlimited
+func (m *DistributedMessages) Quota(ctx context.Context, userId usermodel.UserId) (*model.Quota, error) {
+  quota, err := m.repo.GetQuota(ctx, userId)
+  switch {
+  case err == nil:
+    return quota, nil
+  case !errors.Is(err, repository.ErrNotFound):
+    return nil, err
+  case m.config.DefaultQuota == (model.Quota{}):
+    return nil, nil
+  default:
+    res := m.config.DefaultQuota
+    return &res, nil
+  }
+}
+
+func (m *DistributedMessages) GetUsage(ctx context.Context, userId usermodel.UserId) (
+  *model.Usage,
+  *model.Quota,
+  error,
+) {
+  usage, err := m.repo.GetUsage(ctx, userId)
+  if err != nil {
+    return nil, nil, err
+  }
+  quota, err := m.Quota(ctx, userId)
+  if err != nil {
+    return nil, nil, err
+  }
+  if quota == nil {
+    quota = &model.Quota{}
+  }
+  return usage, quota, nil
+}
+
+func (m *DistributedMessages) ListUsage(ctx context.Context, limit, offset int32) ([]*model.Usage, error) {
+  return m.repo.ListUsage(ctx, limit, offset)
+}
+
+// SetQuota replaces user quota, nil quota brings back default one
+func (m *DistributedMessages) SetQuota(ctx context.Context, userId usermodel.UserId, quota *model.Quota) error {
+  _, err := m.apply(ctx, SetQuotaRequestType, &setQuotaRequest{
+    UserId: userId,
+    Quota: quota,
+  })
+  return err
+}
+
+func (f *fsm) applySetQuota(buf []byte) interface{} {
+  var req setQuotaRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err
+  }
+  if err := f.repo.SetQuota(context.Background(), req.UserId, req.Quota); err != nil {
+    return err
+  }
+  return req
+}
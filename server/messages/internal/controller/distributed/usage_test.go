@@ -0,0 +1,118 @@
+package messages_test
+
+import (
+  "net"
+  "time"
+  "testing"
+  "context"
+
+  "github.com/hashicorp/raft"
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/internal/streamlayer"
+  memory "github.com/bd878/gallery/server/messages/internal/repository/memory"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestQuota(t *testing.T) {
+  ctx := context.Background()
+  defaultQuota := model.Quota{MaxBytes: 100, MaxFiles: 2}
+
+  var nodes []*distributed.DistributedMessages
+  for i, id := range []string{"quota-0", "quota-1"} {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    require.NoError(t, err)
+
+    config := distributed.Config{DefaultQuota: defaultQuota}
+    config.StreamLayer = streamlayer.New(ln, nil, nil)
+    config.Raft.LocalID = raft.ServerID(id)
+    config.DataDir = t.TempDir()
+    config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+    config.Raft.ElectionTimeout = 50 * time.Millisecond
+    config.Raft.LeaderLeaseTimeout = 20 * time.Millisecond
+    config.Raft.CommitTimeout = 5 * time.Millisecond
+    config.Bootstrap = i == 0
+
+    m, err := distributed.New(memory.New(), config)
+    require.NoError(t, err)
+    if i == 0 {
+      require.NoError(t, m.WaitForLeader(3 * time.Second))
+    } else {
+      require.NoError(t, nodes[0].Join(id, ln.Addr().String()))
+    }
+    nodes = append(nodes, m)
+  }
+  leader, follower := nodes[0], nodes[1]
+
+  // follower fsm feeds events of applied records
+  sub, _ := follower.Subscribe(1, 0)
+  defer sub.Close()
+
+  save := func(size int64) error {
+    _, err := leader.SaveMessage(ctx, &model.Message{
+      UserId: 1,
+      Value: "file",
+      FileName: "a.pdf",
+      FileId: "a.pdf",
+      FileSize: size,
+    })
+    return err
+  }
+
+  require.NoError(t, save(60))
+  require.ErrorIs(t, save(50), distributed.ErrOverQuota)
+  require.NoError(t, save(40))
+  // files limit is reached, bytes are too
+  require.ErrorIs(t, save(0), distributed.ErrOverQuota)
+
+  usage, quota, err := leader.GetUsage(ctx, 1)
+  require.NoError(t, err)
+  require.Equal(t, &model.Usage{UserId: 1, Bytes: 100, Files: 2, Messages: 2}, usage)
+  require.Equal(t, &defaultQuota, quota)
+
+  // raised quota is replicated and applies to next writes
+  require.NoError(t, leader.SetQuota(ctx, 1, &model.Quota{MaxBytes: 1000}))
+  require.NoError(t, save(500))
+  require.Eventually(t, func() bool {
+    usage, quota, err := follower.GetUsage(ctx, 1)
+    return err == nil && usage.Bytes == 600 && quota.MaxBytes == 1000
+  }, time.Second, 50*time.Millisecond)
+
+  // text messages without files are never refused by files limit
+  require.NoError(t, leader.SetQuota(ctx, 1, &model.Quota{MaxFiles: 1}))
+  _, err = leader.SaveMessage(ctx, &model.Message{UserId: 1, Value: "text"})
+  require.NoError(t, err)
+
+  // deleted messages free their room
+  res, err := leader.DeleteUserMessages(ctx, usermodel.UserId(1), 10)
+  require.NoError(t, err)
+  require.Equal(t, 4, res.MessagesDeleted)
+  usage, _, err = leader.GetUsage(ctx, 1)
+  require.NoError(t, err)
+  require.Equal(t, &model.Usage{UserId: 1}, usage)
+
+  // rejected messages make no events
+  var events []*model.Event
+  require.Eventually(t, func() bool {
+    select {
+    case event := <-sub.C:
+      events = append(events, event)
+    default:
+    }
+    return len(events) == 5
+  }, time.Second, 10*time.Millisecond)
+  for _, event := range events[:4] {
+    require.Equal(t, model.EventCreated, event.Type)
+  }
+  require.Equal(t, model.EventDeleted, events[4].Type)
+  require.Len(t, events[4].MessageIds, 4)
+  require.Less(t, events[0].Id, events[4].Id)
+
+  // reset brings back default quota
+  require.NoError(t, leader.SetQuota(ctx, 1, nil))
+  _, quota, err = leader.GetUsage(ctx, 1)
+  require.NoError(t, err)
+  require.Equal(t, &defaultQuota, quota)
+}
@@ -0,0 +1,266 @@
+package messages
+
+import (
+  "time"
+  "errors"
+  "context"
+  "encoding/json"
+
+  "github.com/hashicorp/raft"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * Update with quota effective on the leader, checked
+ * when it brings new file. Time is leader time of change
+ */
+type updateRequest struct {
+  *model.MessageUpdate
+  Quota *model.Quota `json:"quota,omitempty"`
+  Time string        `json:"time"`
+}
+
+type restoreVersionRequest struct {
+  UserId usermodel.UserId `json:"userid"`
+  Id model.MessageId      `json:"id"`
+  Seq uint64              `json:"seq"`
+  Time string             `json:"time"`
+}
+
+type pruneVersionsRequest struct {
+  UserId usermodel.UserId `json:"userid"`
+  Id model.MessageId      `json:"id"`
+  Keep int32              `json:"keep"`
+}
+
+// UpdateMessage changes text or attachments of message, old state becomes version
+func (m *DistributedMessages) UpdateMessage(ctx context.Context, update *model.MessageUpdate) (*model.Message, error) {
+  update.NormalizeAttachments()
+
+  var quota *model.Quota
+  if update.SetFile && len(update.Attachments) > 0 {
+    var err error
+    quota, err = m.Quota(ctx, usermodel.UserId(update.UserId))
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  res, err := m.apply(ctx, UpdateRequestType, &updateRequest{
+    MessageUpdate: update,
+    Quota: quota,
+    Time: model.FormatTime(time.Now()),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return messageResult(res)
+}
+
+// ListVersions returns message and its versions, newest first
+func (m *DistributedMessages) ListVersions(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+) (
+  *model.Message,
+  []*model.MessageVersion,
+  error,
+) {
+  msg, err := m.repo.GetOne(ctx, userId, id)
+  if err != nil {
+    return nil, nil, err
+  }
+  versions, err := m.repo.GetVersions(ctx, userId, id)
+  if err != nil {
+    return nil, nil, err
+  }
+  return msg, versions, nil
+}
+
+// RestoreVersion brings back message state of version seq, current one becomes version
+func (m *DistributedMessages) RestoreVersion(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  seq uint64,
+) (
+  *model.Message,
+  error,
+) {
+  res, err := m.apply(ctx, RestoreVersionRequestType, &restoreVersionRequest{
+    UserId: userId,
+    Id: id,
+    Seq: seq,
+    Time: model.FormatTime(time.Now()),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return messageResult(res)
+}
+
+// PruneVersions drops versions of message but keep newest ones
+func (m *DistributedMessages) PruneVersions(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  keep int32,
+) (
+  *model.PruneResult,
+  error,
+) {
+  res, err := m.apply(ctx, PruneVersionsRequestType, &pruneVersionsRequest{
+    UserId: userId,
+    Id: id,
+    Keep: keep,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  switch val := res.(type) {
+  case model.PruneResult:
+    return &val, nil
+  default:
+    return nil, errors.New("fsm.apply returns undefined result")
+  }
+}
+
+func messageResult(res interface{}) (*model.Message, error) {
+  switch val := res.(type) {
+  case model.Message:
+    return &val, nil
+  default:
+    return nil, errors.New("fsm.apply returns undefined result")
+  }
+}
+
+func (f *fsm) applyUpdate(buf []byte, record *raft.Log) (interface{}, *model.Event) {
+  var req updateRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err, nil
+  }
+  if req.MessageUpdate == nil {
+    return errors.New("empty update"), nil
+  }
+  req.NormalizeAttachments()
+
+  ctx := context.Background()
+  current, err := f.repo.GetOne(ctx, usermodel.UserId(req.UserId), req.Id)
+  if err != nil {
+    return err, nil
+  }
+  // replayed on start, change is there already
+  if current.Seq >= record.Index {
+    return *current, nil
+  }
+
+  next := *current
+  if req.SetValue {
+    next.Value = req.Value
+  }
+  if req.SetFile {
+    next.Attachments = req.Attachments
+  }
+  if next.Value == "" && len(next.Attachments) == 0 {
+    return ErrEmptyMessage, nil
+  }
+
+  if req.SetFile && len(req.Attachments) > 0 && req.Quota != nil {
+    usage, err := f.repo.GetUsage(ctx, usermodel.UserId(req.UserId))
+    if err != nil {
+      return err, nil
+    }
+    if !req.Quota.Allows(usage, model.FilesSize(req.Attachments), int64(len(req.Attachments))) {
+      return ErrOverQuota, nil
+    }
+  }
+
+  return f.replace(current, &next, record, req.Time)
+}
+
+func (f *fsm) applyRestoreVersion(buf []byte, record *raft.Log) (interface{}, *model.Event) {
+  var req restoreVersionRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err, nil
+  }
+
+  ctx := context.Background()
+  current, err := f.repo.GetOne(ctx, req.UserId, req.Id)
+  if err != nil {
+    return err, nil
+  }
+  if current.Seq >= record.Index {
+    return *current, nil
+  }
+
+  version, err := f.repo.GetVersion(ctx, req.UserId, req.Id, req.Seq)
+  if err != nil {
+    return err, nil
+  }
+
+  next := *current
+  next.Value = version.Value
+  next.Attachments = version.Attachments
+  return f.replace(current, &next, record, req.Time)
+}
+
+// replace makes next current state of message, keeping current as version
+func (f *fsm) replace(current, next *model.Message, record *raft.Log, now string) (interface{}, *model.Event) {
+  made := current.UpdateTime
+  if made == "" {
+    made = current.CreateTime
+  }
+  prev := &model.MessageVersion{
+    MessageId: current.Id,
+    UserId: current.UserId,
+    Seq: current.Seq,
+    Value: current.Value,
+    Attachments: current.Attachments,
+    Time: made,
+    ReplaceTime: now,
+  }
+  prev.NormalizeAttachments()
+
+  next.NormalizeAttachments()
+  next.Seq = record.Index
+  next.UpdateTime = now
+  if err := f.repo.UpdateMessage(context.Background(), next, prev); err != nil {
+    return err, nil
+  }
+
+  res := *next
+  return *next, &model.Event{
+    Id: record.Index,
+    Type: model.EventUpdated,
+    UserId: next.UserId,
+    Message: &res,
+  }
+}
+
+/**
+ * Versions replaced at record index or later are not
+ * counted nor pruned, replay keeps newer ones
+ */
+func (f *fsm) applyPruneVersions(buf []byte, record *raft.Log) interface{} {
+  var req pruneVersionsRequest
+  if err := json.Unmarshal(buf, &req); err != nil {
+    return err
+  }
+
+  n, files, err := f.repo.PruneVersions(context.Background(), req.UserId, req.Id, req.Keep, record.Index)
+  if err != nil {
+    return err
+  }
+
+  res := model.PruneResult{VersionsDeleted: n}
+  for _, fileId := range files {
+    if f.removeFile(fileId) {
+      res.FilesDeleted += 1
+    }
+  }
+  return res
+}
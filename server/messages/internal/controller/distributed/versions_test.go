@@ -0,0 +1,82 @@
+package messages_test
+
+import (
+  "os"
+  "testing"
+  "context"
+  "path/filepath"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestVersions(t *testing.T) {
+  m, dataDir := newTestRaft(t, distributed.Config{})
+
+  for _, name := range []string{"a", "b"} {
+    require.NoError(t, os.WriteFile(filepath.Join(dataDir, name), []byte(name), 0644))
+  }
+
+  ctx := context.Background()
+  msg, err := m.SaveMessage(ctx, &model.Message{UserId: 1, Value: "first", FileName: "a.txt", FileId: "a", FileSize: 10})
+  require.NoError(t, err)
+
+  // text edit keeps file, file edit keeps both files in usage
+  _, err = m.UpdateMessage(ctx, &model.MessageUpdate{Id: msg.Id, UserId: 1, SetValue: true, Value: "second"})
+  require.NoError(t, err)
+  updated, err := m.UpdateMessage(ctx, &model.MessageUpdate{
+    Id: msg.Id, UserId: 1, SetFile: true, FileName: "b.txt", FileId: "b", FileSize: 20,
+  })
+  require.NoError(t, err)
+  require.Equal(t, "second", updated.Value)
+  require.Equal(t, model.FileId("b"), updated.FileId)
+  require.NotEmpty(t, updated.UpdateTime)
+
+  usage, _, err := m.GetUsage(ctx, usermodel.UserId(1))
+  require.NoError(t, err)
+  require.Equal(t, int64(30), usage.Bytes)
+  require.Equal(t, int64(2), usage.Files)
+  require.Equal(t, int64(1), usage.Messages)
+
+  _, err = m.UpdateMessage(ctx, &model.MessageUpdate{Id: msg.Id, UserId: 1, SetValue: true, SetFile: true})
+  require.Error(t, err)
+
+  current, versions, err := m.ListVersions(ctx, usermodel.UserId(1), msg.Id)
+  require.NoError(t, err)
+  require.Equal(t, "second", current.Value)
+  require.Len(t, versions, 2)
+  require.Equal(t, "second", versions[0].Value)
+  require.Equal(t, "first", versions[1].Value)
+  require.Equal(t, model.FileId("a"), versions[1].FileId)
+
+  restored, err := m.RestoreVersion(ctx, usermodel.UserId(1), msg.Id, versions[1].Seq)
+  require.NoError(t, err)
+  require.Equal(t, "first", restored.Value)
+  require.Equal(t, model.FileId("a"), restored.FileId)
+
+  _, err = m.RestoreVersion(ctx, usermodel.UserId(1), msg.Id, 1 << 40)
+  require.Error(t, err)
+
+  // "b" is only in versions, pruning all of them frees it
+  res, err := m.PruneVersions(ctx, usermodel.UserId(1), msg.Id, 0)
+  require.NoError(t, err)
+  require.Equal(t, 3, res.VersionsDeleted)
+  require.Equal(t, 1, res.FilesDeleted)
+
+  _, err = os.Stat(filepath.Join(dataDir, "b"))
+  require.True(t, os.IsNotExist(err))
+  _, err = os.Stat(filepath.Join(dataDir, "a"))
+  require.NoError(t, err)
+
+  usage, _, err = m.GetUsage(ctx, usermodel.UserId(1))
+  require.NoError(t, err)
+  require.Equal(t, int64(10), usage.Bytes)
+  require.Equal(t, int64(1), usage.Files)
+
+  _, versions, err = m.ListVersions(ctx, usermodel.UserId(1), msg.Id)
+  require.NoError(t, err)
+  require.Empty(t, versions)
+}
@@ -0,0 +1,103 @@
+package messages_test
+
+import (
+  "time"
+  "testing"
+  "context"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  distributed "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+)
+
+func TestWatch(t *testing.T) {
+  // hub keeps two events, older ones come from repository
+  m, _ := newTestRaft(t, distributed.Config{FeedBacklog: 2})
+
+  ctx := context.Background()
+  save := func(userId int) *model.Message {
+    msg, err := m.SaveMessage(ctx, &model.Message{UserId: userId, Value: "value"})
+    require.NoError(t, err)
+    return msg
+  }
+
+  var saved []*model.Message
+  for _, userId := range []int{1, 2, 1, 2, 1} {
+    saved = append(saved, save(userId))
+  }
+
+  watch := func(userId usermodel.UserId, startIndex uint64) (<-chan *model.Event, context.CancelFunc) {
+    ctx, cancel := context.WithCancel(ctx)
+    events := make(chan *model.Event, 100)
+    go m.Watch(ctx, userId, startIndex, func(event *model.Event) error {
+      events <- event
+      return nil
+    })
+    return events, cancel
+  }
+  next := func(events <-chan *model.Event) *model.Event {
+    select {
+    case event := <-events:
+      return event
+    case <-time.After(time.Second):
+      t.Fatal("no event")
+      return nil
+    }
+  }
+
+  all, cancel := watch(0, 1)
+  defer cancel()
+  for _, msg := range saved {
+    event := next(all)
+    require.Equal(t, msg.LogIndex, event.Id)
+    require.Equal(t, model.EventCreated, event.Type)
+    require.Equal(t, msg.Id, event.Message.Id)
+  }
+
+  user, cancel := watch(1, saved[2].LogIndex)
+  defer cancel()
+  require.Equal(t, saved[2].Id, next(user).Message.Id)
+  require.Equal(t, saved[4].Id, next(user).Message.Id)
+
+  // live events follow replayed ones, in log order
+  last := save(1)
+  require.Equal(t, last.LogIndex, next(all).Id)
+  require.Equal(t, last.LogIndex, next(user).Id)
+
+  res, err := m.DeleteUserMessages(ctx, 2, 10)
+  require.NoError(t, err)
+  require.Equal(t, 2, res.MessagesDeleted)
+  event := next(all)
+  require.Equal(t, model.EventDeleted, event.Type)
+  require.Equal(t, []model.MessageId{saved[1].Id, saved[3].Id}, event.MessageIds)
+
+  select {
+  case event := <-user:
+    t.Fatalf("unexpected event of user %d", event.UserId)
+  case <-time.After(50 * time.Millisecond):
+  }
+
+  // replay goes by change, updates and deletes included
+  updated, err := m.UpdateMessage(ctx, &model.MessageUpdate{Id: saved[0].Id, UserId: 1, SetValue: true, Value: "new"})
+  require.NoError(t, err)
+  _, err = m.DeleteMessage(ctx, 1, saved[2].Id)
+  require.NoError(t, err)
+
+  replayed, cancel := watch(1, 1)
+  defer cancel()
+  require.Equal(t, saved[4].Id, next(replayed).Message.Id)
+  require.Equal(t, last.Id, next(replayed).Message.Id)
+  event = next(replayed)
+  require.Equal(t, model.EventUpdated, event.Type)
+  require.Equal(t, updated.Seq, event.Id)
+  require.Equal(t, "new", event.Message.Value)
+  event = next(replayed)
+  require.Equal(t, model.EventDeleted, event.Type)
+  require.Equal(t, []model.MessageId{saved[2].Id}, event.MessageIds)
+
+  // live ones follow without gap
+  later := save(1)
+  require.Equal(t, later.LogIndex, next(replayed).Id)
+}
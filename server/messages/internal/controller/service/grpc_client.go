@@ -1,13 +1,16 @@
 package service
 
 import (
+  "io"
   "context"
   "fmt"
 
   "google.golang.org/grpc"
+  "google.golang.org/grpc/credentials"
   "google.golang.org/grpc/credentials/insecure"
 
   "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
   "github.com/bd878/gallery/server/messages/internal/loadbalance"
   "github.com/bd878/gallery/server/messages/pkg/model"
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
@@ -15,6 +18,9 @@ import (
 
 type Config struct {
   RpcAddr string
+  ServiceToken string
+  // plain connection when nil
+  Credentials credentials.TransportCredentials
 }
 
 type Messages struct {
@@ -24,13 +30,18 @@ type Messages struct {
 }
 
 func New(cfg Config) *Messages {
+  creds := cfg.Credentials
+  if creds == nil {
+    creds = insecure.NewCredentials()
+  }
   conn, err := grpc.Dial(
     fmt.Sprintf(
       "%s:///%s",
       loadbalance.Name,
       cfg.RpcAddr,
     ),
-    grpc.WithTransportCredentials(insecure.NewCredentials()),
+    grpc.WithTransportCredentials(creds),
+    grpc.WithPerRPCCredentials(auth.ServiceCredentials(cfg.ServiceToken)),
   )
   if err != nil {
     panic(err)
@@ -86,4 +97,226 @@ func (s *Messages) ReadUserMessages(
     Messages: model.MapMessagesFromProto(model.MessageFromProto, res.Messages),
     IsLastPage: res.IsLastPage,
   }, err
-}
\ No newline at end of file
+}
+func (s *Messages) GetUsage(ctx context.Context, userId usermodel.UserId) (
+  *model.Usage,
+  *model.Quota,
+  error,
+) {
+  res, err := s.client.GetUsage(ctx, &api.GetUsageRequest{UserId: uint32(userId)})
+  if err != nil {
+    return nil, nil, err
+  }
+  return model.UsageFromProto(res.Usage), model.QuotaFromProto(res.Quota), nil
+}
+
+func (s *Messages) ListUsage(ctx context.Context, limit, offset int32) (
+  []*model.UserUsage,
+  error,
+) {
+  res, err := s.client.ListUsage(ctx, &api.ListUsageRequest{
+    Limit: limit,
+    Offset: offset,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  usages := make([]*model.UserUsage, 0, len(res.Usages))
+  for _, usage := range res.Usages {
+    usages = append(usages, &model.UserUsage{
+      Usage: *model.UsageFromProto(usage.Usage),
+      Quota: *model.QuotaFromProto(usage.Quota),
+    })
+  }
+  return usages, nil
+}
+
+// SetQuota replaces user quota, nil quota brings back default one
+func (s *Messages) SetQuota(ctx context.Context, userId usermodel.UserId, quota *model.Quota) (
+  *model.Usage,
+  *model.Quota,
+  error,
+) {
+  req := &api.SetQuotaRequest{UserId: uint32(userId), Reset_: quota == nil}
+  if quota != nil {
+    req.Quota = model.QuotaToProto(quota)
+  }
+  res, err := s.client.SetQuota(ctx, req)
+  if err != nil {
+    return nil, nil, err
+  }
+  return model.UsageFromProto(res.Usage), model.QuotaFromProto(res.Quota), nil
+}
+
+/**
+ * Feed passes events of user after lastId to handle
+ * till ctx is done or handle fails
+ */
+func (s *Messages) Feed(
+  ctx context.Context,
+  userId usermodel.UserId,
+  lastId uint64,
+  handle func(*model.Event) error,
+) error {
+  stream, err := s.client.Feed(ctx, &api.FeedRequest{
+    UserId: uint32(userId),
+    LastEventId: lastId,
+  })
+  if err != nil {
+    return err
+  }
+
+  for {
+    event, err := stream.Recv()
+    if err == io.EOF || ctx.Err() != nil {
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    if err := handle(model.EventFromProto(event)); err != nil {
+      return err
+    }
+  }
+}
+
+func (s *Messages) Sync(ctx context.Context, userId usermodel.UserId, since uint64, limit int32) (
+  *model.SyncResult,
+  error,
+) {
+  res, err := s.client.SyncMessages(ctx, &api.SyncRequest{
+    UserId: uint32(userId),
+    Since: since,
+    Limit: limit,
+  })
+  if err != nil {
+    return nil, err
+  }
+  return model.SyncResultFromProto(res), nil
+}
+
+func (s *Messages) UpdateMessage(ctx context.Context, update *model.MessageUpdate) (
+  *model.Message,
+  error,
+) {
+  res, err := s.client.UpdateMessage(ctx, model.MessageUpdateToProto(update))
+  if err != nil {
+    return nil, err
+  }
+  return model.MessageFromProto(res.Message), nil
+}
+
+func (s *Messages) ListVersions(ctx context.Context, userId usermodel.UserId, id model.MessageId) (
+  *model.Message,
+  []*model.MessageVersion,
+  error,
+) {
+  res, err := s.client.ListVersions(ctx, &api.ListVersionsRequest{
+    UserId: uint32(userId),
+    Id: uint32(id),
+  })
+  if err != nil {
+    return nil, nil, err
+  }
+  versions := make([]*model.MessageVersion, len(res.Versions))
+  for i, version := range res.Versions {
+    versions[i] = model.MessageVersionFromProto(version)
+  }
+  return model.MessageFromProto(res.Message), versions, nil
+}
+
+func (s *Messages) RestoreVersion(ctx context.Context, userId usermodel.UserId, id model.MessageId, seq uint64) (
+  *model.Message,
+  error,
+) {
+  res, err := s.client.RestoreVersion(ctx, &api.RestoreVersionRequest{
+    UserId: uint32(userId),
+    Id: uint32(id),
+    Seq: seq,
+  })
+  if err != nil {
+    return nil, err
+  }
+  return model.MessageFromProto(res.Message), nil
+}
+
+func (s *Messages) PruneVersions(ctx context.Context, userId usermodel.UserId, id model.MessageId, keep int32) (
+  *model.PruneResult,
+  error,
+) {
+  res, err := s.client.PruneVersions(ctx, &api.PruneVersionsRequest{
+    UserId: uint32(userId),
+    Id: uint32(id),
+    Keep: keep,
+  })
+  if err != nil {
+    return nil, err
+  }
+  return &model.PruneResult{
+    VersionsDeleted: int(res.VersionsDeleted),
+    FilesDeleted: int(res.FilesDeleted),
+  }, nil
+}
+
+func (s *Messages) DeleteMessage(ctx context.Context, userId usermodel.UserId, id model.MessageId) (
+  *model.Message,
+  error,
+) {
+  res, err := s.client.DeleteMessage(ctx, &api.DeleteMessageRequest{
+    UserId: uint32(userId),
+    Id: uint32(id),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return model.MessageFromProto(res.Message), nil
+}
+
+func (s *Messages) ListTrash(ctx context.Context, userId usermodel.UserId, limit, offset int32) (
+  *model.MessagesList,
+  error,
+) {
+  res, err := s.client.ListTrash(ctx, &api.ListTrashRequest{
+    UserId: uint32(userId),
+    Limit: limit,
+    Offset: offset,
+  })
+  if err != nil {
+    return nil, err
+  }
+  return &model.MessagesList{
+    Messages: model.MapMessagesFromProto(model.MessageFromProto, res.Messages),
+    IsLastPage: res.IsLastPage,
+  }, nil
+}
+
+func (s *Messages) RestoreMessage(ctx context.Context, userId usermodel.UserId, id model.MessageId) (
+  *model.Message,
+  error,
+) {
+  res, err := s.client.RestoreMessage(ctx, &api.RestoreMessageRequest{
+    UserId: uint32(userId),
+    Id: uint32(id),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return model.MessageFromProto(res.Message), nil
+}
+
+func (s *Messages) EmptyTrash(ctx context.Context, userId usermodel.UserId) (
+  *model.PurgeResult,
+  error,
+) {
+  res, err := s.client.EmptyTrash(ctx, &api.EmptyTrashRequest{
+    UserId: uint32(userId),
+  })
+  if err != nil {
+    return nil, err
+  }
+  return &model.PurgeResult{
+    MessagesDeleted: int(res.MessagesDeleted),
+    FilesDeleted: int(res.FilesDeleted),
+  }, nil
+}
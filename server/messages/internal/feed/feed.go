@@ -0,0 +1,165 @@
+package feed
+
+import (
+  "sync"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const (
+  // recent events kept for resume
+  DefaultBacklog = 1024
+  // events queued per subscriber before it is dropped
+  subscriberBuffer = 64
+)
+
+/**
+ * Hub fans out events applied by local fsm to subscribers.
+ * Each node has own hub, ids are raft log indexes,
+ * so client may resume on any node
+ */
+type Hub struct {
+  mu sync.Mutex
+  subs map[*Subscription]struct{}
+  // recent events, oldest first
+  recent []*model.Event
+  size int
+  // events after floor are known, either recent or none
+  floor uint64
+  last uint64
+}
+
+func New(size int) *Hub {
+  if size <= 0 {
+    size = DefaultBacklog
+  }
+  return &Hub{
+    subs: make(map[*Subscription]struct{}),
+    size: size,
+  }
+}
+
+/**
+ * Publish records applied log index, event is nil when
+ * record changes no messages. Slow subscribers, having
+ * full queue, are dropped rather than block fsm
+ */
+func (h *Hub) Publish(index uint64, event *model.Event) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  if h.last == 0 {
+    h.floor = index - 1
+  }
+  h.last = index
+  if event == nil {
+    return
+  }
+
+  h.recent = append(h.recent, event)
+  if len(h.recent) > h.size {
+    h.floor = h.recent[0].Id
+    h.recent[0] = nil
+    h.recent = h.recent[1:]
+  }
+
+  for sub := range h.subs {
+    if !sub.match(event) {
+      continue
+    }
+    select {
+    case sub.c <- event:
+    default:
+      sub.lagged = true
+      h.remove(sub)
+    }
+  }
+}
+
+// Reset forgets recent events, e.g. when fsm restores snapshot
+func (h *Hub) Reset() {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  h.recent = nil
+  h.last = 0
+  h.floor = 0
+}
+
+/**
+ * Subscribe to events of user after lastId, zero userId
+ * subscribes to events of all users, zero lastId
+ * starts from live events. Backlog holds recent ones after
+ * lastId, or single EventResync one when some of them are gone,
+ * its id is the last applied index to resume after
+ */
+func (h *Hub) Subscribe(userId usermodel.UserId, lastId uint64) (
+  sub *Subscription,
+  backlog []*model.Event,
+) {
+  h.mu.Lock()
+  defer h.mu.Unlock()
+
+  c := make(chan *model.Event, subscriberBuffer)
+  sub = &Subscription{C: c, Last: h.last, c: c, hub: h, userId: userId}
+  h.subs[sub] = struct{}{}
+
+  if lastId == 0 {
+    return sub, nil
+  }
+  // unknown history, e.g. node restored from snapshot
+  if h.last == 0 || lastId < h.floor {
+    return sub, []*model.Event{{
+      Id: h.last,
+      Type: model.EventResync,
+      UserId: int(userId),
+    }}
+  }
+  for _, event := range h.recent {
+    if event.Id > lastId && sub.match(event) {
+      backlog = append(backlog, event)
+    }
+  }
+  return sub, backlog
+}
+
+func (h *Hub) remove(sub *Subscription) {
+  if _, ok := h.subs[sub]; ok {
+    delete(h.subs, sub)
+    close(sub.c)
+  }
+}
+
+/**
+ * Subscription receives events on C, C is closed on Close
+ * or when subscriber lags behind, see Lagged
+ */
+type Subscription struct {
+  C <-chan *model.Event
+  // last index applied at subscribe, later events come on C
+  Last uint64
+  c chan *model.Event
+  hub *Hub
+  userId usermodel.UserId
+  lagged bool
+}
+
+func (s *Subscription) match(event *model.Event) bool {
+  return s.userId == 0 || usermodel.UserId(event.UserId) == s.userId
+}
+
+func (s *Subscription) Close() {
+  s.hub.mu.Lock()
+  defer s.hub.mu.Unlock()
+
+  s.hub.remove(s)
+}
+
+// Lagged tells C was closed because subscriber was too slow
+func (s *Subscription) Lagged() bool {
+  s.hub.mu.Lock()
+  defer s.hub.mu.Unlock()
+
+  return s.lagged
+}
@@ -0,0 +1,82 @@
+package feed_test
+
+import (
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/feed"
+)
+
+func created(id uint64, userId int) *model.Event {
+  return &model.Event{
+    Id: id,
+    Type: model.EventCreated,
+    UserId: userId,
+    Message: &model.Message{UserId: userId},
+  }
+}
+
+func TestHub(t *testing.T) {
+  hub := feed.New(3)
+
+  live, backlog := hub.Subscribe(1, 0)
+  defer live.Close()
+  require.Empty(t, backlog)
+
+  hub.Publish(5, created(5, 1))
+  hub.Publish(6, created(6, 2))
+  // quota change, no event
+  hub.Publish(7, nil)
+  hub.Publish(8, created(8, 1))
+
+  require.Equal(t, uint64(5), (<-live.C).Id)
+  require.Equal(t, uint64(8), (<-live.C).Id)
+
+  // resume gets missed events of own user only
+  sub, backlog := hub.Subscribe(1, 5)
+  require.Len(t, backlog, 1)
+  require.Equal(t, uint64(8), backlog[0].Id)
+  sub.Close()
+
+  // nothing missed since first known record
+  sub, backlog = hub.Subscribe(1, 4)
+  require.Len(t, backlog, 2)
+  sub.Close()
+
+  // event 5 is evicted, client that saw 3 must reload
+  hub.Publish(9, created(9, 1))
+  sub, backlog = hub.Subscribe(1, 3)
+  require.Equal(t, []*model.Event{{Id: 9, Type: model.EventResync, UserId: 1}}, backlog)
+  sub.Close()
+
+  sub, backlog = hub.Subscribe(1, 5)
+  require.Len(t, backlog, 2)
+  sub.Close()
+
+  // restored snapshot has no history
+  hub.Reset()
+  sub, backlog = hub.Subscribe(1, 9)
+  require.Equal(t, model.EventResync, backlog[0].Type)
+  sub.Close()
+}
+
+func TestSlowSubscriber(t *testing.T) {
+  hub := feed.New(0)
+
+  slow, _ := hub.Subscribe(1, 0)
+  for i := uint64(1); i <= 100; i++ {
+    hub.Publish(i, created(i, 1))
+  }
+
+  n := 0
+  for range slow.C {
+    n += 1
+  }
+  require.Less(t, n, 100)
+  require.True(t, slow.Lagged())
+
+  // closed subscription is not closed twice
+  slow.Close()
+}
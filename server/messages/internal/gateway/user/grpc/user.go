@@ -2,30 +2,83 @@ package grpc
 
 import (
   "context"
+  "fmt"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/credentials"
+  "google.golang.org/grpc/credentials/insecure"
 
   "github.com/bd878/gallery/server/api"
-  "github.com/bd878/gallery/server/users/pkg/model"
   "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/loadbalance"
 )
 
 type Gateway struct {
-  userAddr string
+  client api.UserServiceClient
+  conn   *grpc.ClientConn
+}
+
+/**
+ * userAddr lists users servers, comma separated.
+ * Connection follows replicas set, so the gateway
+ * keeps working while any users server is up.
+ * Nil creds dial plain connection, serviceToken
+ * lets it to service-only calls, e.g. deletions
+ */
+func New(userAddr string, creds credentials.TransportCredentials, serviceToken string) *Gateway {
+  if creds == nil {
+    creds = insecure.NewCredentials()
+  }
+  conn, err := grpc.Dial(
+    fmt.Sprintf(
+      "%s:///%s",
+      loadbalance.UsersName,
+      userAddr,
+    ),
+    grpc.WithTransportCredentials(creds),
+    grpc.WithPerRPCCredentials(grpcutil.ServiceCredentials(serviceToken)),
+  )
+  if err != nil {
+    panic(err)
+  }
+
+  return &Gateway{api.NewUserServiceClient(conn), conn}
 }
 
-func New(userAddr string) *Gateway {
-  return &Gateway{userAddr}
+func (g *Gateway) Close() {
+  if g.conn != nil {
+    g.conn.Close()
+  }
 }
 
 func (g *Gateway) Auth(ctx context.Context, token string) (*model.User, error) {
-  conn, err := grpcutil.ServiceConnection(ctx, g.userAddr)
+  resp, err := g.client.Auth(ctx, &api.AuthUserRequest{Token: token})
   if err != nil {
     return nil, err
   }
-  defer conn.Close()
-  client := api.NewUserServiceClient(conn)
-  resp, err := client.Auth(ctx, &api.AuthUserRequest{Token: token})
+  return model.UserFromProto(resp.User), nil
+}
+
+func (g *Gateway) ListDeletions(ctx context.Context) ([]*model.Deletion, error) {
+  resp, err := g.client.ListDeletions(ctx, &api.ListDeletionsRequest{})
   if err != nil {
     return nil, err
   }
-  return model.UserFromProto(resp.User), nil
-}
\ No newline at end of file
+
+  res := make([]*model.Deletion, len(resp.Deletions))
+  for i, deletion := range resp.Deletions {
+    res[i] = model.DeletionFromProto(deletion)
+  }
+  return res, nil
+}
+
+func (g *Gateway) UpdateDeletion(ctx context.Context, deletion *model.Deletion) error {
+  _, err := g.client.UpdateDeletion(ctx, &api.UpdateDeletionRequest{
+    Id: deletion.Id,
+    Status: deletion.Status,
+    MessagesDeleted: int32(deletion.MessagesDeleted),
+    FilesDeleted: int32(deletion.FilesDeleted),
+  })
+  return err
+}
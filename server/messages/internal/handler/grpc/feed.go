@@ -0,0 +1,78 @@
+package grpc
+
+import (
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * Feed streams events of user applied on this node till
+ * client goes away. Too slow client gets ResourceExhausted,
+ * it may resume after last received event
+ */
+func (h *Handler) Feed(req *api.FeedRequest, stream api.Messages_FeedServer) error {
+  ctx := stream.Context()
+  if req.UserId == 0 {
+    return status.Errorf(codes.InvalidArgument, "wrong user id")
+  }
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return err
+  }
+
+  sub, backlog := h.ctrl.Subscribe(usermodel.UserId(req.UserId), req.LastEventId)
+  defer sub.Close()
+
+  for _, event := range backlog {
+    if err := stream.Send(model.EventToProto(event)); err != nil {
+      return err
+    }
+  }
+
+  for {
+    select {
+    case <-ctx.Done():
+      return nil
+    case event, ok := <-sub.C:
+      if !ok {
+        if sub.Lagged() {
+          return status.Errorf(codes.ResourceExhausted, "feed consumer is too slow")
+        }
+        return nil
+      }
+      if err := stream.Send(model.EventToProto(event)); err != nil {
+        return err
+      }
+    }
+  }
+}
+
+/**
+ * WatchMessages streams ordered changes of user, or of all
+ * users for service callers. Send blocks while client reads
+ * slowly, fsm does not wait for it, see Watch of controller
+ */
+func (h *Handler) WatchMessages(req *api.WatchMessagesRequest, stream api.Messages_WatchMessagesServer) error {
+  ctx := stream.Context()
+  var err error
+  if req.UserId == 0 {
+    err = auth.RequireService(ctx)
+  } else {
+    err = auth.Authorize(ctx, usermodel.UserId(req.UserId))
+  }
+  if err != nil {
+    return err
+  }
+
+  err = h.ctrl.Watch(ctx, usermodel.UserId(req.UserId), req.StartIndex, func(event *model.Event) error {
+    return stream.Send(model.EventToProto(event))
+  })
+  if err != nil && status.Code(err) == codes.Unknown {
+    return status.Error(codes.Internal, err.Error())
+  }
+  return err
+}
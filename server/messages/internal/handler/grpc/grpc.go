@@ -1,9 +1,16 @@
 package grpc
 
 import (
+  "errors"
   "context"
 
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
   "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/internal/feed"
+  messages "github.com/bd878/gallery/server/messages/internal/controller/distributed"
   "github.com/bd878/gallery/server/messages/pkg/model"
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
 )
@@ -21,15 +28,32 @@ type Controller interface {
     error,
   )
   GetServers(ctx context.Context) ([]*api.Server, error)
+  GetUsage(ctx context.Context, userId usermodel.UserId) (*model.Usage, *model.Quota, error)
+  ListUsage(ctx context.Context, limit, offset int32) ([]*model.Usage, error)
+  Quota(ctx context.Context, userId usermodel.UserId) (*model.Quota, error)
+  SetQuota(ctx context.Context, userId usermodel.UserId, quota *model.Quota) error
+  Subscribe(userId usermodel.UserId, lastId uint64) (*feed.Subscription, []*model.Event)
+  Watch(ctx context.Context, userId usermodel.UserId, startIndex uint64, send func(*model.Event) error) error
+  Sync(ctx context.Context, userId usermodel.UserId, since uint64, limit int32) (*model.SyncResult, error)
+  UpdateMessage(ctx context.Context, update *model.MessageUpdate) (*model.Message, error)
+  ListVersions(ctx context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, []*model.MessageVersion, error)
+  RestoreVersion(ctx context.Context, userId usermodel.UserId, id model.MessageId, seq uint64) (*model.Message, error)
+  PruneVersions(ctx context.Context, userId usermodel.UserId, id model.MessageId, keep int32) (*model.PruneResult, error)
+  DeleteMessage(ctx context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, error)
+  ListTrash(ctx context.Context, userId usermodel.UserId, limit, offset int32) (*model.MessagesList, error)
+  RestoreMessage(ctx context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, error)
+  EmptyTrash(ctx context.Context, userId usermodel.UserId) (*model.PurgeResult, error)
 }
 
 type Handler struct {
   api.UnimplementedMessagesServer
   ctrl Controller
+  keyring Keyring
 }
 
-func New(ctrl Controller) *Handler {
-  h := &Handler{ctrl: ctrl}
+// keyring is nil when node runs without membership
+func New(ctrl Controller, keyring Keyring) *Handler {
+  h := &Handler{ctrl: ctrl, keyring: keyring}
 
   return h
 }
@@ -38,17 +62,60 @@ func (h *Handler) SaveMessage(ctx context.Context, req *api.SaveMessageRequest)
   *api.SaveMessageResponse,
   error,
 ) {
-  msg, err := h.ctrl.SaveMessage(ctx, model.MessageFromProto(req.Message))
+  if req.Message == nil {
+    return nil, status.Errorf(codes.InvalidArgument, "message required")
+  }
+  if err := auth.Authorize(ctx, usermodel.UserId(req.Message.UserId)); err != nil {
+    return nil, err
+  }
+
+  msg := model.MessageFromProto(req.Message)
+  if err := authorizeFiles(ctx, msg.Attachments); err != nil {
+    return nil, err
+  }
+
+  msg, err := h.ctrl.SaveMessage(ctx, msg)
+  if errors.Is(err, messages.ErrOverQuota) {
+    // ResourceExhausted is of rate limiter
+    return nil, status.Error(codes.FailedPrecondition, err.Error())
+  }
+  if errors.Is(err, messages.ErrIdempotencyConflict) {
+    return nil, status.Error(codes.AlreadyExists, err.Error())
+  }
   if err != nil {
     return &api.SaveMessageResponse{Message: req.Message}, err
   }
   return &api.SaveMessageResponse{Message: model.MessageToProto(msg)}, nil
 }
 
+/**
+ * Files are written by http gateway, so only service callers
+ * name them. User naming file of another one would get it
+ * counted and deleted on every node
+ */
+func authorizeFiles(ctx context.Context, attachments []model.Attachment) error {
+  if len(attachments) == 0 {
+    return nil
+  }
+  if err := auth.RequireService(ctx); err != nil {
+    return err
+  }
+  for _, attachment := range attachments {
+    if attachment.FileSize < 0 {
+      return status.Error(codes.InvalidArgument, "negative file size")
+    }
+  }
+  return nil
+}
+
 func (h *Handler) ReadUserMessages(ctx context.Context, req *api.ReadUserMessagesRequest) (
   *api.ReadUserMessagesResponse,
   error,
 ) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
   var res *model.MessagesList
   var err error
 
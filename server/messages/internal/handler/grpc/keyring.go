@@ -0,0 +1,72 @@
+package grpc
+
+import (
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  membership "github.com/bd878/gallery/server/internal/discovery/serf"
+)
+
+type Keyring interface {
+  ListKeys() (*membership.Keys, error)
+  InstallKey(key string) (*membership.Keys, error)
+  UseKey(key string) (*membership.Keys, error)
+  RemoveKey(key string) (*membership.Keys, error)
+}
+
+func (h *Handler) ListKeys(ctx context.Context, _ *api.ListKeysRequest) (*api.KeysResponse, error) {
+  if err := h.checkKeyring(ctx); err != nil {
+    return nil, err
+  }
+  return keysResponse(h.keyring.ListKeys())
+}
+
+func (h *Handler) InstallKey(ctx context.Context, req *api.KeyRequest) (*api.KeysResponse, error) {
+  if err := h.checkKeyring(ctx); err != nil {
+    return nil, err
+  }
+  return keysResponse(h.keyring.InstallKey(req.Key))
+}
+
+func (h *Handler) UseKey(ctx context.Context, req *api.KeyRequest) (*api.KeysResponse, error) {
+  if err := h.checkKeyring(ctx); err != nil {
+    return nil, err
+  }
+  return keysResponse(h.keyring.UseKey(req.Key))
+}
+
+func (h *Handler) RemoveKey(ctx context.Context, req *api.KeyRequest) (*api.KeysResponse, error) {
+  if err := h.checkKeyring(ctx); err != nil {
+    return nil, err
+  }
+  return keysResponse(h.keyring.RemoveKey(req.Key))
+}
+
+func (h *Handler) checkKeyring(ctx context.Context) error {
+  if err := auth.RequireService(ctx); err != nil {
+    return err
+  }
+  if h.keyring == nil {
+    return status.Errorf(codes.Unimplemented, "node runs without membership")
+  }
+  return nil
+}
+
+func keysResponse(keys *membership.Keys, err error) (*api.KeysResponse, error) {
+  if err != nil {
+    return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+  }
+
+  res := &api.KeysResponse{
+    Keys: make(map[string]int32, len(keys.Keys)),
+    NumNodes: int32(keys.NumNodes),
+  }
+  for key, count := range keys.Keys {
+    res.Keys[key] = int32(count)
+  }
+  return res, nil
+}
@@ -0,0 +1,40 @@
+package grpc
+
+import (
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const (
+  defaultSyncLimit = 100
+  maxSyncLimit = 1000
+)
+
+func (h *Handler) SyncMessages(ctx context.Context, req *api.SyncRequest) (*api.SyncResponse, error) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  limit := req.Limit
+  switch {
+  case limit < 0:
+    return nil, status.Errorf(codes.InvalidArgument, "negative limit")
+  case limit == 0:
+    limit = defaultSyncLimit
+  case limit > maxSyncLimit:
+    limit = maxSyncLimit
+  }
+
+  res, err := h.ctrl.Sync(ctx, usermodel.UserId(req.UserId), req.Since, limit)
+  if err != nil {
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+  return model.SyncResultToProto(res), nil
+}
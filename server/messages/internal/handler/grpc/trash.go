@@ -0,0 +1,88 @@
+package grpc
+
+import (
+  "errors"
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+func (h *Handler) DeleteMessage(ctx context.Context, req *api.DeleteMessageRequest) (
+  *api.SaveMessageResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  msg, err := h.ctrl.DeleteMessage(ctx, usermodel.UserId(req.UserId), model.MessageId(req.Id))
+  if err != nil {
+    return nil, trashError(err)
+  }
+  return &api.SaveMessageResponse{Message: model.MessageToProto(msg)}, nil
+}
+
+func (h *Handler) ListTrash(ctx context.Context, req *api.ListTrashRequest) (
+  *api.ReadUserMessagesResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  res, err := h.ctrl.ListTrash(ctx, usermodel.UserId(req.UserId), req.Limit, req.Offset)
+  if err != nil {
+    return nil, trashError(err)
+  }
+  return &api.ReadUserMessagesResponse{
+    Messages: model.MapMessagesToProto(model.MessageToProto, res.Messages),
+    IsLastPage: res.IsLastPage,
+  }, nil
+}
+
+func (h *Handler) RestoreMessage(ctx context.Context, req *api.RestoreMessageRequest) (
+  *api.SaveMessageResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  msg, err := h.ctrl.RestoreMessage(ctx, usermodel.UserId(req.UserId), model.MessageId(req.Id))
+  if err != nil {
+    return nil, trashError(err)
+  }
+  return &api.SaveMessageResponse{Message: model.MessageToProto(msg)}, nil
+}
+
+func (h *Handler) EmptyTrash(ctx context.Context, req *api.EmptyTrashRequest) (
+  *api.EmptyTrashResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  res, err := h.ctrl.EmptyTrash(ctx, usermodel.UserId(req.UserId))
+  if err != nil {
+    return nil, trashError(err)
+  }
+  return &api.EmptyTrashResponse{
+    MessagesDeleted: int32(res.MessagesDeleted),
+    FilesDeleted: int32(res.FilesDeleted),
+  }, nil
+}
+
+func trashError(err error) error {
+  if errors.Is(err, repository.ErrNotFound) {
+    return status.Error(codes.NotFound, "message not found")
+  }
+  return status.Error(codes.Internal, err.Error())
+}
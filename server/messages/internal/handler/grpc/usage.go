@@ -0,0 +1,84 @@
+package grpc
+
+import (
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+func (h *Handler) GetUsage(ctx context.Context, req *api.GetUsageRequest) (*api.UsageResponse, error) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+  return h.usageResponse(ctx, usermodel.UserId(req.UserId))
+}
+
+// ListUsage is admin call, service callers only
+func (h *Handler) ListUsage(ctx context.Context, req *api.ListUsageRequest) (*api.ListUsageResponse, error) {
+  if err := auth.RequireService(ctx); err != nil {
+    return nil, err
+  }
+  if req.Limit <= 0 || req.Offset < 0 {
+    return nil, status.Errorf(codes.InvalidArgument, "wrong limit or offset")
+  }
+
+  usages, err := h.ctrl.ListUsage(ctx, req.Limit, req.Offset)
+  if err != nil {
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+
+  res := &api.ListUsageResponse{Usages: make([]*api.UsageResponse, 0, len(usages))}
+  for _, usage := range usages {
+    quota, err := h.ctrl.Quota(ctx, usermodel.UserId(usage.UserId))
+    if err != nil {
+      return nil, status.Error(codes.Internal, err.Error())
+    }
+    if quota == nil {
+      quota = &model.Quota{}
+    }
+    res.Usages = append(res.Usages, &api.UsageResponse{
+      Usage: model.UsageToProto(usage),
+      Quota: model.QuotaToProto(quota),
+    })
+  }
+  return res, nil
+}
+
+// SetQuota is admin call, service callers only
+func (h *Handler) SetQuota(ctx context.Context, req *api.SetQuotaRequest) (*api.UsageResponse, error) {
+  if err := auth.RequireService(ctx); err != nil {
+    return nil, err
+  }
+  if req.UserId == 0 {
+    return nil, status.Errorf(codes.InvalidArgument, "wrong user id")
+  }
+
+  var quota *model.Quota
+  if !req.Reset_ {
+    quota = model.QuotaFromProto(req.Quota)
+    if quota.MaxBytes < 0 || quota.MaxFiles < 0 {
+      return nil, status.Errorf(codes.InvalidArgument, "negative quota")
+    }
+  }
+  if err := h.ctrl.SetQuota(ctx, usermodel.UserId(req.UserId), quota); err != nil {
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+  return h.usageResponse(ctx, usermodel.UserId(req.UserId))
+}
+
+func (h *Handler) usageResponse(ctx context.Context, userId usermodel.UserId) (*api.UsageResponse, error) {
+  usage, quota, err := h.ctrl.GetUsage(ctx, userId)
+  if err != nil {
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+  return &api.UsageResponse{
+    Usage: model.UsageToProto(usage),
+    Quota: model.QuotaToProto(quota),
+  }, nil
+}
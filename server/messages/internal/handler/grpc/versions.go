@@ -0,0 +1,113 @@
+package grpc
+
+import (
+  "errors"
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/messages/internal/auth"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  messages "github.com/bd878/gallery/server/messages/internal/controller/distributed"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+func (h *Handler) UpdateMessage(ctx context.Context, req *api.UpdateMessageRequest) (
+  *api.SaveMessageResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+  if !req.SetValue && !req.SetFile {
+    return nil, status.Errorf(codes.InvalidArgument, "nothing to update")
+  }
+
+  update := model.MessageUpdateFromProto(req)
+  if update.SetFile {
+    if err := authorizeFiles(ctx, update.Attachments); err != nil {
+      return nil, err
+    }
+  }
+
+  msg, err := h.ctrl.UpdateMessage(ctx, update)
+  if err != nil {
+    return nil, versionError(err)
+  }
+  return &api.SaveMessageResponse{Message: model.MessageToProto(msg)}, nil
+}
+
+func (h *Handler) ListVersions(ctx context.Context, req *api.ListVersionsRequest) (
+  *api.ListVersionsResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  msg, versions, err := h.ctrl.ListVersions(ctx, usermodel.UserId(req.UserId), model.MessageId(req.Id))
+  if err != nil {
+    return nil, versionError(err)
+  }
+
+  res := &api.ListVersionsResponse{
+    Message: model.MessageToProto(msg),
+    Versions: make([]*api.MessageVersion, len(versions)),
+  }
+  for i, version := range versions {
+    res.Versions[i] = model.MessageVersionToProto(version)
+  }
+  return res, nil
+}
+
+func (h *Handler) RestoreVersion(ctx context.Context, req *api.RestoreVersionRequest) (
+  *api.SaveMessageResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+
+  msg, err := h.ctrl.RestoreVersion(ctx, usermodel.UserId(req.UserId), model.MessageId(req.Id), req.Seq)
+  if err != nil {
+    return nil, versionError(err)
+  }
+  return &api.SaveMessageResponse{Message: model.MessageToProto(msg)}, nil
+}
+
+func (h *Handler) PruneVersions(ctx context.Context, req *api.PruneVersionsRequest) (
+  *api.PruneVersionsResponse,
+  error,
+) {
+  if err := auth.Authorize(ctx, usermodel.UserId(req.UserId)); err != nil {
+    return nil, err
+  }
+  if req.Keep < 0 {
+    return nil, status.Errorf(codes.InvalidArgument, "negative keep")
+  }
+
+  res, err := h.ctrl.PruneVersions(ctx, usermodel.UserId(req.UserId), model.MessageId(req.Id), req.Keep)
+  if err != nil {
+    return nil, versionError(err)
+  }
+  return &api.PruneVersionsResponse{
+    VersionsDeleted: int32(res.VersionsDeleted),
+    FilesDeleted: int32(res.FilesDeleted),
+  }, nil
+}
+
+func versionError(err error) error {
+  switch {
+  case errors.Is(err, repository.ErrNotFound):
+    return status.Error(codes.NotFound, "message or version not found")
+  case errors.Is(err, messages.ErrEmptyMessage):
+    return status.Error(codes.InvalidArgument, err.Error())
+  case errors.Is(err, messages.ErrOverQuota):
+    return status.Error(codes.FailedPrecondition, err.Error())
+  default:
+    return status.Error(codes.Internal, err.Error())
+  }
+}
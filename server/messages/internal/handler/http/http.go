@@ -2,24 +2,33 @@ package http
 
 import (
   "log"
+  "errors"
   "net/http"
   "strconv"
-  "strings"
   "os"
   "io"
   "fmt"
   "context"
   "mime"
+  "mime/multipart"
   "path/filepath"
   "encoding/json"
 
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
   "github.com/bd878/gallery/server/messages/pkg/model"
   "github.com/bd878/gallery/server/utils"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
 )
 
 const selectNoLimit int = -1
 
+const maxClientIdLen = 128
+
+const maxAttachments = 20
+
 type userGateway interface {
   Auth(ctx context.Context, token string) (*usermodel.User, error)
 }
@@ -36,20 +45,43 @@ type Controller interface {
     *model.MessagesList,
     error,
   )
+  GetUsage(ctx context.Context, userId usermodel.UserId) (*model.Usage, *model.Quota, error)
+  ListUsage(ctx context.Context, limit, offset int32) ([]*model.UserUsage, error)
+  SetQuota(ctx context.Context, userId usermodel.UserId, quota *model.Quota) (*model.Usage, *model.Quota, error)
+  Feed(ctx context.Context, userId usermodel.UserId, lastId uint64, handle func(*model.Event) error) error
+  Sync(ctx context.Context, userId usermodel.UserId, since uint64, limit int32) (*model.SyncResult, error)
+  UpdateMessage(ctx context.Context, update *model.MessageUpdate) (*model.Message, error)
+  ListVersions(ctx context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, []*model.MessageVersion, error)
+  RestoreVersion(ctx context.Context, userId usermodel.UserId, id model.MessageId, seq uint64) (*model.Message, error)
+  PruneVersions(ctx context.Context, userId usermodel.UserId, id model.MessageId, keep int32) (*model.PruneResult, error)
+  DeleteMessage(ctx context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, error)
+  ListTrash(ctx context.Context, userId usermodel.UserId, limit, offset int32) (*model.MessagesList, error)
+  RestoreMessage(ctx context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, error)
+  EmptyTrash(ctx context.Context, userId usermodel.UserId) (*model.PurgeResult, error)
+}
+
+type Config struct {
+  DataPath string
+  Limiter *ratelimit.Limiter
+  // take client ip from X-Forwarded-For
+  TrustProxy bool
+  // websocket origins besides own host
+  AllowedOrigins []string
 }
 
 type Handler struct {
   ctrl Controller
   userGateway userGateway
-  dataPath string
+  cfg Config
+  clientIP ratelimit.KeyFunc
 }
 
 func New(
   ctrl Controller,
   userGateway userGateway,
-  dataPath string,
+  cfg Config,
 ) *Handler {
-  return &Handler{ctrl, userGateway, dataPath}
+  return &Handler{ctrl, userGateway, cfg, ratelimit.ClientIP(cfg.TrustProxy)}
 }
 
 func (h *Handler) CheckAuth(
@@ -63,7 +95,6 @@ func (h *Handler) CheckAuth(
       return
     }
 
-    log.Println("cookie value =", cookie.Value)
     user, err := h.userGateway.Auth(context.Background(), cookie.Value)
     if err != nil {
       log.Println(err) // TODO: return invalid token response instead
@@ -77,10 +108,10 @@ func (h *Handler) CheckAuth(
       return
     }
 
-    log.Println("request for user id, name, token =", user.Id, user.Name, user.Token)
+    log.Println("request for user id, name =", user.Id, user.Name)
 
     req = req.WithContext(
-      context.WithValue(context.Background(), userContextKey{}, user),
+      context.WithValue(req.Context(), userContextKey{}, user),
     )
 
     next(w, req)
@@ -89,11 +120,28 @@ func (h *Handler) CheckAuth(
 
 type userContextKey struct {}
 
+/**
+ * Limit throttles route of class. Wrapped around CheckAuth
+ * it keys on client ip, inside it on the user id
+ */
+func (h *Handler) Limit(
+  class ratelimit.Class,
+  next func (w http.ResponseWriter, req *http.Request),
+) func (w http.ResponseWriter, req *http.Request) {
+  return h.cfg.Limiter.Handler(class, h.limitKey, next)
+}
+
+// limitKey is user id, client ip when there is no user yet
+func (h *Handler) limitKey(req *http.Request) string {
+  if user, ok := req.Context().Value(userContextKey{}).(*usermodel.User); ok {
+    return fmt.Sprintf("user:%d", user.Id)
+  }
+  return h.clientIP(req)
+}
+
 func (h *Handler) SendMessage(w http.ResponseWriter, req *http.Request) {
   var err error
-  if err = req.ParseMultipartForm(1); err != nil {
-    log.Println(err)
-    w.WriteHeader(http.StatusInternalServerError)
+  if !parseMultipart(w, req) {
     return
   }
 
@@ -103,38 +151,24 @@ func (h *Handler) SendMessage(w http.ResponseWriter, req *http.Request) {
     return
   }
 
-  var fileName string
-  var fileId string
-  if _, ok := req.MultipartForm.File["file"]; ok {
-    f, fh, err := req.FormFile("file")
-    if err != nil {
-      log.Println(err)
-      w.WriteHeader(http.StatusInternalServerError)
-      return
-    }
-
-    fileName = filepath.Base(fh.Filename)
-    fileId = strings.ToLower(utils.RandomString(10) + filepath.Ext(fh.Filename))
+  // retry with same key returns message saved first
+  clientId := req.Header.Get("Idempotency-Key")
+  if clientId == "" {
+    clientId = req.PostFormValue("client_id")
+  }
+  if len(clientId) > maxClientIdLen {
+    writeBadRequest(w, fmt.Sprintf("client id longer than %d", maxClientIdLen))
+    return
+  }
 
-    ff, err := os.OpenFile(
-      filepath.Join(h.dataPath, fileId),
-      os.O_WRONLY|os.O_CREATE, 0666,
-    )
-    if err != nil {
-      log.Println(err)
-      w.WriteHeader(http.StatusInternalServerError)
-      return
-    }
-    if _, err := io.Copy(ff, f); err != nil {
-      log.Println(err)
-      w.WriteHeader(http.StatusInternalServerError)
-      return
-    }
+  attachments, ok := h.saveUploads(w, req, user)
+  if !ok {
+    return
   }
 
   value := req.PostFormValue("message")
 
-  if fileName == "" && value == "" {
+  if len(attachments) == 0 && value == "" {
     if err := json.NewEncoder(w).Encode(model.ServerResponse{
       Status: "ok",
       Description: "empty fields",
@@ -149,14 +183,47 @@ func (h *Handler) SendMessage(w http.ResponseWriter, req *http.Request) {
   if msg, err = h.ctrl.SaveMessage(context.Background(), &model.Message{
     UserId: int(user.Id),
     Value: value,
-    FileName: fileName,
-    FileId: model.FileId(fileId),
+    Attachments: attachments,
+    ClientId: clientId,
   }); err != nil {
+    h.removeUploads(attachments)
+    if seconds, ok := ratelimit.RetryAfterOf(err); ok {
+      ratelimit.TooManyRequests(w, seconds)
+      return
+    }
+    if status.Code(err) == codes.FailedPrecondition {
+      // concurrent upload took the room left
+      usage, quota, err := h.ctrl.GetUsage(context.Background(), user.Id)
+      if err != nil {
+        log.Println(err)
+        w.WriteHeader(http.StatusInternalServerError)
+        return
+      }
+      writeOverQuota(w, usage, quota)
+      return
+    }
+    if status.Code(err) == codes.AlreadyExists {
+      w.WriteHeader(http.StatusConflict)
+      if err := json.NewEncoder(w).Encode(model.ServerResponse{
+        Status: "ok",
+        Description: "client id reused with other message",
+      }); err != nil {
+        log.Println(err)
+      }
+      return
+    }
     log.Println(err)
     w.WriteHeader(http.StatusInternalServerError)
     return
   }
 
+  // retried upload, files of first one are kept
+  for _, attachment := range attachments {
+    if !model.HasFile(msg.Attachments, attachment.FileId) {
+      h.removeUploads([]model.Attachment{attachment})
+    }
+  }
+
   if err := json.NewEncoder(w).Encode(model.NewMessageServerResponse{
     ServerResponse: model.ServerResponse{
       Status: "ok",
@@ -170,6 +237,121 @@ func (h *Handler) SendMessage(w http.ResponseWriter, req *http.Request) {
   }
 }
 
+// parseMultipart parses form, not ok means response is written
+func parseMultipart(w http.ResponseWriter, req *http.Request) bool {
+  err := req.ParseMultipartForm(1)
+  var tooLarge *http.MaxBytesError
+  switch {
+  case err == nil:
+    return true
+  case errors.As(err, &tooLarge):
+    http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+    return false
+  default:
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return false
+  }
+}
+
+/**
+ * saveUploads stores "file" parts of form, in order, after
+ * rate and quota checks. Not ok means response is written.
+ * Route takes upload token for the first file before the
+ * body is read, the rest take theirs here
+ */
+func (h *Handler) saveUploads(w http.ResponseWriter, req *http.Request, user *usermodel.User) (
+  []model.Attachment,
+  bool,
+) {
+  files := req.MultipartForm.File["file"]
+  if len(files) == 0 {
+    return nil, true
+  }
+  if len(files) > maxAttachments {
+    writeBadRequest(w, fmt.Sprintf("more than %d files", maxAttachments))
+    return nil, false
+  }
+
+  // refuse before files are copied to data dir, sizes are
+  // of parts already read, saved ones are counted after all
+  var size int64
+  for _, fh := range files {
+    size += fh.Size
+  }
+  usage, quota, err := h.ctrl.GetUsage(context.Background(), user.Id)
+  if err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return nil, false
+  }
+  if !quota.Allows(usage, size, int64(len(files))) {
+    writeOverQuota(w, usage, quota)
+    return nil, false
+  }
+
+  for range files[1:] {
+    if !h.cfg.Limiter.Check(w, req, ratelimit.Upload, h.limitKey) {
+      return nil, false
+    }
+  }
+
+  res := make([]model.Attachment, 0, len(files))
+  for _, fh := range files {
+    fileId, size, err := saveFile(fh, h.cfg.DataPath)
+    if err != nil {
+      log.Println(err)
+      h.removeUploads(res)
+      w.WriteHeader(http.StatusInternalServerError)
+      return nil, false
+    }
+    res = append(res, model.Attachment{
+      FileId: model.FileId(fileId),
+      FileName: filepath.Base(fh.Filename),
+      FileSize: size,
+      MimeType: mimeType(fh),
+    })
+  }
+  return res, true
+}
+
+// saveFile returns id and size of the file written to dir
+func saveFile(fh *multipart.FileHeader, dir string) (string, int64, error) {
+  f, err := fh.Open()
+  if err != nil {
+    return "", 0, err
+  }
+  defer f.Close()
+  return utils.SaveFile(f, dir, fh.Filename)
+}
+
+// mimeType is sniffed from file content, part header and name are of client
+func mimeType(fh *multipart.FileHeader) string {
+  f, err := fh.Open()
+  if err != nil {
+    return "application/octet-stream"
+  }
+  defer f.Close()
+
+  // DetectContentType looks at 512 bytes at most
+  buf := make([]byte, 512)
+  n, _ := io.ReadFull(f, buf)
+  mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+  if err != nil {
+    return "application/octet-stream"
+  }
+  return mediaType
+}
+
+// removeUploads drops saved files of request that failed
+func (h *Handler) removeUploads(attachments []model.Attachment) {
+  for _, attachment := range attachments {
+    if err := os.Remove(filepath.Join(h.cfg.DataPath, string(attachment.FileId))); err != nil {
+      log.Println(err)
+    }
+  }
+}
+
 func (h *Handler) ReadMessages(w http.ResponseWriter, req *http.Request) {
   var limitInt, offsetInt, orderInt int
   var ascending bool
@@ -237,7 +419,8 @@ func (h *Handler) ReadMessages(w http.ResponseWriter, req *http.Request) {
       ascending = true
     }
   } else {
-    ascending = true
+    // user preference, ascending unless set otherwise
+    ascending = user.Profile == nil || user.Profile.SortOrder != usermodel.SortDesc
   }
 
   res, err := h.ctrl.ReadUserMessages(
@@ -280,7 +463,7 @@ func (h *Handler) ReadFile(w http.ResponseWriter, req *http.Request) {
     return
   }
 
-  ff, err := os.Open(filepath.Join(h.dataPath, filename))
+  ff, err := os.Open(filepath.Join(h.cfg.DataPath, filename))
   if err != nil {
     log.Println(err)
     w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,197 @@
+package http
+
+import (
+  "io"
+  "fmt"
+  "log"
+  "sync"
+  "time"
+  "context"
+  "strconv"
+  "net/url"
+  "net/http"
+  "encoding/json"
+
+  "golang.org/x/net/websocket"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+)
+
+// comment line keeping idle proxies from closing stream
+const heartbeatInterval = 25*time.Second
+
+/**
+ * Stream sends live events of user messages as Server-Sent Events.
+ * Event id is raft log index, browser resumes with Last-Event-ID,
+ * last_event_id query param does the same for first connect
+ */
+func (h *Handler) Stream(w http.ResponseWriter, req *http.Request) {
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  lastId, ok := getLastEventId(w, req)
+  if !ok {
+    return
+  }
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    log.Println("streaming is not supported")
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.Header().Set("Connection", "keep-alive")
+  // nginx would buffer the stream otherwise
+  w.Header().Set("X-Accel-Buffering", "no")
+  w.WriteHeader(http.StatusOK)
+
+  var mu sync.Mutex
+  write := func(f func() error) error {
+    mu.Lock()
+    defer mu.Unlock()
+    if err := f(); err != nil {
+      return err
+    }
+    flusher.Flush()
+    return nil
+  }
+
+  // heartbeat is stopped and waited for before return,
+  // w must not be written once handler is done
+  ctx, cancel := context.WithCancel(req.Context())
+  var wg sync.WaitGroup
+  defer wg.Wait()
+  defer cancel()
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    ticker := time.NewTicker(heartbeatInterval)
+    defer ticker.Stop()
+    for {
+      select {
+      case <-ctx.Done():
+        return
+      case <-ticker.C:
+        if err := write(func() error {
+          _, err := io.WriteString(w, ": ping\n\n")
+          return err
+        }); err != nil {
+          cancel()
+          return
+        }
+      }
+    }
+  }()
+
+  if err := write(func() error {
+    _, err := io.WriteString(w, "retry: 3000\n\n")
+    return err
+  }); err != nil {
+    return
+  }
+
+  err := h.ctrl.Feed(ctx, user.Id, lastId, func(event *model.Event) error {
+    return write(func() error {
+      return writeEvent(w, event)
+    })
+  })
+  if err != nil {
+    log.Println("feed of user", user.Id, "closed:", err)
+  }
+}
+
+func writeEvent(w io.Writer, event *model.Event) error {
+  data, err := json.Marshal(event)
+  if err != nil {
+    return err
+  }
+  if event.Id != 0 {
+    if _, err := fmt.Fprintf(w, "id: %d\n", event.Id); err != nil {
+      return err
+    }
+  }
+  _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+  return err
+}
+
+/**
+ * WebSocket sends the same events as Stream, one json
+ * text frame each. Resume with last_event_id query param
+ */
+func (h *Handler) WebSocket(w http.ResponseWriter, req *http.Request) {
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  lastId, ok := getLastEventId(w, req)
+  if !ok {
+    return
+  }
+
+  websocket.Server{
+    Handshake: h.checkOrigin,
+    Handler: func(ws *websocket.Conn) {
+      defer ws.Close()
+
+      ctx, cancel := context.WithCancel(req.Context())
+      defer cancel()
+      // client frames are ignored, reading detects close
+      go func() {
+        defer cancel()
+        var frame []byte
+        for websocket.Message.Receive(ws, &frame) == nil {
+        }
+      }()
+
+      err := h.ctrl.Feed(ctx, user.Id, lastId, func(event *model.Event) error {
+        return websocket.JSON.Send(ws, event)
+      })
+      if err != nil {
+        log.Println("feed of user", user.Id, "closed:", err)
+      }
+    },
+  }.ServeHTTP(w, req)
+}
+
+/**
+ * Browsers send cookies on cross-site websocket handshakes,
+ * csrf check skips GET requests, so origin is checked here
+ */
+func (h *Handler) checkOrigin(cfg *websocket.Config, req *http.Request) error {
+  origin := req.Header.Get("Origin")
+  if origin == "" {
+    return nil
+  }
+  u, err := url.Parse(origin)
+  if err != nil {
+    return err
+  }
+  if u.Host == req.Host {
+    return nil
+  }
+  for _, allowed := range h.cfg.AllowedOrigins {
+    if origin == allowed {
+      return nil
+    }
+  }
+  return fmt.Errorf("origin %q is not allowed", origin)
+}
+
+func getLastEventId(w http.ResponseWriter, req *http.Request) (uint64, bool) {
+  value := req.Header.Get("Last-Event-ID")
+  if value == "" {
+    value = req.URL.Query().Get("last_event_id")
+  }
+  if value == "" {
+    return 0, true
+  }
+  lastId, err := strconv.ParseUint(value, 10, 64)
+  if err != nil {
+    writeBadRequest(w, "wrong last event id")
+    return 0, false
+  }
+  return lastId, true
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+  "log"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+)
+
+/**
+ * Sync returns changes of user messages after since token:
+ * changed messages, tombstones of deleted ones and token
+ * for next call. Resync asks to drop local copy and sync
+ * from zero, hasmore to call again at once
+ */
+func (h *Handler) Sync(w http.ResponseWriter, req *http.Request) {
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+
+  values := req.URL.Query()
+  var since uint64
+  if values.Has("since") {
+    var err error
+    since, err = strconv.ParseUint(values.Get("since"), 10, 64)
+    if err != nil {
+      writeBadRequest(w, "wrong \"since\" param")
+      return
+    }
+  }
+  limit, ok := getIntQuery(w, values.Get("limit"), "limit", 0)
+  if !ok {
+    return
+  }
+  if limit < 0 {
+    writeBadRequest(w, "wrong \"limit\" param")
+    return
+  }
+
+  res, err := h.ctrl.Sync(req.Context(), user.Id, since, int32(limit))
+  if err != nil {
+    log.Println("failed to sync: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  description := ""
+  if res.Resync {
+    description = "resync required"
+  }
+  if err := json.NewEncoder(w).Encode(model.SyncServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: description,
+    },
+    SyncResult: *res,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+  "io"
+  "log"
+  "mime"
+  "net/url"
+  "net/http"
+  "encoding/json"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+)
+
+// DeleteMessage moves posted message "id" to trash
+func (h *Handler) DeleteMessage(w http.ResponseWriter, req *http.Request) {
+  if !parseTrashForm(w, req) {
+    return
+  }
+
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  id, ok := getMessageId(w, req.PostFormValue("id"))
+  if !ok {
+    return
+  }
+
+  msg, err := h.ctrl.DeleteMessage(req.Context(), user.Id, id)
+  if err != nil {
+    writeTrashError(w, err)
+    return
+  }
+
+  writeMessage(w, msg, "moved to trash")
+}
+
+// Trash lists trashed messages, recently deleted first
+func (h *Handler) Trash(w http.ResponseWriter, req *http.Request) {
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  values := req.URL.Query()
+  limit, ok := getIntQuery(w, values.Get("limit"), "limit", selectNoLimit)
+  if !ok {
+    return
+  }
+  offset, ok := getIntQuery(w, values.Get("offset"), "offset", 0)
+  if !ok {
+    return
+  }
+  if offset < 0 {
+    writeBadRequest(w, "wrong \"offset\" param")
+    return
+  }
+
+  res, err := h.ctrl.ListTrash(req.Context(), user.Id, int32(limit), int32(offset))
+  if err != nil {
+    writeTrashError(w, err)
+    return
+  }
+
+  if res.Messages == nil {
+    res.Messages = make([]*model.Message, 0)
+  }
+  if err := json.NewEncoder(w).Encode(model.MessagesListServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    Messages: res.Messages,
+    IsLastPage: res.IsLastPage,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+// RestoreMessage brings posted message "id" back from trash
+func (h *Handler) RestoreMessage(w http.ResponseWriter, req *http.Request) {
+  if !parseTrashForm(w, req) {
+    return
+  }
+
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  id, ok := getMessageId(w, req.PostFormValue("id"))
+  if !ok {
+    return
+  }
+
+  msg, err := h.ctrl.RestoreMessage(req.Context(), user.Id, id)
+  if err != nil {
+    writeTrashError(w, err)
+    return
+  }
+
+  writeMessage(w, msg, "restored")
+}
+
+// EmptyTrash purges all trashed messages and their files at once
+func (h *Handler) EmptyTrash(w http.ResponseWriter, req *http.Request) {
+  if !parseTrashForm(w, req) {
+    return
+  }
+
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+
+  res, err := h.ctrl.EmptyTrash(req.Context(), user.Id)
+  if err != nil {
+    writeTrashError(w, err)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.PurgeServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "trash emptied",
+    },
+    PurgeResult: *res,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+// same as net/http limit of url-encoded bodies
+const maxFormSize = 10 << 20
+
+/**
+ * parseTrashForm lets POST and DELETE through and parses
+ * form body. net/http leaves url-encoded body of DELETE
+ * unparsed, it is read here
+ */
+func parseTrashForm(w http.ResponseWriter, req *http.Request) bool {
+  if req.Method != http.MethodPost && req.Method != http.MethodDelete {
+    w.WriteHeader(http.StatusMethodNotAllowed)
+    return false
+  }
+  if err := req.ParseForm(); err != nil {
+    writeBadRequest(w, "wrong form")
+    return false
+  }
+
+  contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+  if req.Method != http.MethodDelete || contentType != "application/x-www-form-urlencoded" ||
+    len(req.PostForm) > 0 {
+    return true
+  }
+  body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxFormSize))
+  if err != nil {
+    writeBadRequest(w, "wrong form")
+    return false
+  }
+  values, err := url.ParseQuery(string(body))
+  if err != nil {
+    writeBadRequest(w, "wrong form")
+    return false
+  }
+  req.PostForm = values
+  return true
+}
+
+func writeTrashError(w http.ResponseWriter, err error) {
+  if status.Code(err) != codes.NotFound {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+  w.WriteHeader(http.StatusNotFound)
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "message not found",
+  }); err != nil {
+    log.Println(err)
+  }
+}
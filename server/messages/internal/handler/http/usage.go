@@ -0,0 +1,204 @@
+package http
+
+import (
+  "log"
+  "context"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+)
+
+const defaultUsageLimit = 50
+
+// CheckAdmin lets through authenticated users of admin role
+func (h *Handler) CheckAdmin(
+  next func (w http.ResponseWriter, req *http.Request),
+) func (w http.ResponseWriter, req *http.Request) {
+  return h.CheckAuth(func(w http.ResponseWriter, req *http.Request) {
+    user, ok := getUser(w, req)
+    if !ok {
+      return
+    }
+    if user.Role != usermodel.RoleAdmin {
+      w.WriteHeader(http.StatusForbidden)
+      if err := json.NewEncoder(w).Encode(model.ServerResponse{
+        Status: "ok",
+        Description: "admin required",
+      }); err != nil {
+        log.Println(err)
+      }
+      return
+    }
+    next(w, req)
+  })
+}
+
+// Usage reports storage used by current user and its quota
+func (h *Handler) Usage(w http.ResponseWriter, req *http.Request) {
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  h.writeUsage(w, user.Id)
+}
+
+/**
+ * AdminUsage lists usage of all users, biggest first,
+ * or of one user, when user_id is given
+ */
+func (h *Handler) AdminUsage(w http.ResponseWriter, req *http.Request) {
+  values := req.URL.Query()
+
+  if values.Has("user_id") {
+    userId, ok := getIntQuery(w, values.Get("user_id"), "user_id", 0)
+    if !ok {
+      return
+    }
+    h.writeUsage(w, usermodel.UserId(userId))
+    return
+  }
+
+  limit, ok := getIntQuery(w, values.Get("limit"), "limit", defaultUsageLimit)
+  if !ok {
+    return
+  }
+  offset, ok := getIntQuery(w, values.Get("offset"), "offset", 0)
+  if !ok {
+    return
+  }
+
+  usages, err := h.ctrl.ListUsage(context.Background(), int32(limit), int32(offset))
+  if err != nil {
+    log.Println("failed to list usage: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.UsageListServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    Usages: usages,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+/**
+ * AdminSetQuota sets user_id quota of max_bytes and max_files,
+ * zero is unlimited. Reset drops user quota, default applies
+ */
+func (h *Handler) AdminSetQuota(w http.ResponseWriter, req *http.Request) {
+  if req.Method != http.MethodPost {
+    w.WriteHeader(http.StatusMethodNotAllowed)
+    return
+  }
+
+  userId, ok := getIntQuery(w, req.PostFormValue("user_id"), "user_id", 0)
+  if !ok {
+    return
+  }
+  if userId <= 0 {
+    writeBadRequest(w, "wrong \"user_id\" param")
+    return
+  }
+
+  var quota *model.Quota
+  if req.PostFormValue("reset") != "1" {
+    maxBytes, ok := getIntQuery(w, req.PostFormValue("max_bytes"), "max_bytes", 0)
+    if !ok {
+      return
+    }
+    maxFiles, ok := getIntQuery(w, req.PostFormValue("max_files"), "max_files", 0)
+    if !ok {
+      return
+    }
+    if maxBytes < 0 || maxFiles < 0 {
+      writeBadRequest(w, "negative quota")
+      return
+    }
+    quota = &model.Quota{MaxBytes: int64(maxBytes), MaxFiles: int64(maxFiles)}
+  }
+
+  usage, quota, err := h.ctrl.SetQuota(context.Background(), usermodel.UserId(userId), quota)
+  if err != nil {
+    log.Println("failed to set quota: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if user, ok := req.Context().Value(userContextKey{}).(*usermodel.User); ok {
+    log.Println("admin", user.Name, "set quota of user", userId, *quota)
+  }
+
+  if err := json.NewEncoder(w).Encode(model.UsageServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "updated",
+    },
+    UserUsage: model.UserUsage{Usage: *usage, Quota: *quota},
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+func (h *Handler) writeUsage(w http.ResponseWriter, userId usermodel.UserId) {
+  usage, quota, err := h.ctrl.GetUsage(context.Background(), userId)
+  if err != nil {
+    log.Println("failed to get usage: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.UsageServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    UserUsage: model.UserUsage{Usage: *usage, Quota: *quota},
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+func writeOverQuota(w http.ResponseWriter, usage *model.Usage, quota *model.Quota) {
+  w.WriteHeader(http.StatusRequestEntityTooLarge)
+  if err := json.NewEncoder(w).Encode(model.UsageServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "over quota",
+    },
+    UserUsage: model.UserUsage{Usage: *usage, Quota: *quota},
+  }); err != nil {
+    log.Println(err)
+  }
+}
+
+func writeBadRequest(w http.ResponseWriter, description string) {
+  w.WriteHeader(http.StatusBadRequest)
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: description,
+  }); err != nil {
+    log.Println(err)
+  }
+}
+
+// getIntQuery parses value of param name, empty value is def
+func getIntQuery(w http.ResponseWriter, value, name string, def int) (int, bool) {
+  if value == "" {
+    return def, true
+  }
+  res, err := strconv.Atoi(value)
+  if err != nil {
+    writeBadRequest(w, "wrong \"" + name + "\" param")
+    return 0, false
+  }
+  return res, true
+}
@@ -0,0 +1,224 @@
+package http
+
+import (
+  "log"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+)
+
+/**
+ * UpdateMessage changes text ("message" field), replaces files
+ * ("file" parts) or drops them (remove_file=1) of message "id".
+ * Earlier state is kept in history
+ */
+func (h *Handler) UpdateMessage(w http.ResponseWriter, req *http.Request) {
+  if !parseMultipart(w, req) {
+    return
+  }
+
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  id, ok := getMessageId(w, req.PostFormValue("id"))
+  if !ok {
+    return
+  }
+
+  update := &model.MessageUpdate{Id: id, UserId: int(user.Id)}
+  if _, has := req.MultipartForm.Value["message"]; has {
+    update.SetValue = true
+    update.Value = req.PostFormValue("message")
+  }
+
+  attachments, ok := h.saveUploads(w, req, user)
+  if !ok {
+    return
+  }
+  switch {
+  case len(attachments) > 0:
+    update.SetFile = true
+    update.Attachments = attachments
+  case req.PostFormValue("remove_file") == "1":
+    update.SetFile = true
+  }
+
+  if !update.SetValue && !update.SetFile {
+    writeBadRequest(w, "nothing to update")
+    return
+  }
+
+  msg, err := h.ctrl.UpdateMessage(req.Context(), update)
+  if err != nil {
+    h.removeUploads(attachments)
+    h.writeVersionError(w, req, user, err)
+    return
+  }
+
+  writeMessage(w, msg, "updated")
+}
+
+// History returns message "id" and its versions, newest first
+func (h *Handler) History(w http.ResponseWriter, req *http.Request) {
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  id, ok := getMessageId(w, req.URL.Query().Get("id"))
+  if !ok {
+    return
+  }
+
+  msg, versions, err := h.ctrl.ListVersions(req.Context(), user.Id, id)
+  if err != nil {
+    h.writeVersionError(w, req, user, err)
+    return
+  }
+
+  if versions == nil {
+    versions = make([]*model.MessageVersion, 0)
+  }
+  if err := json.NewEncoder(w).Encode(model.HistoryServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    Message: *msg,
+    Versions: versions,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+// RestoreVersion makes posted "version" (its seq) current state of message "id"
+func (h *Handler) RestoreVersion(w http.ResponseWriter, req *http.Request) {
+  if req.Method != http.MethodPost {
+    w.WriteHeader(http.StatusMethodNotAllowed)
+    return
+  }
+
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  id, ok := getMessageId(w, req.PostFormValue("id"))
+  if !ok {
+    return
+  }
+  seq, err := strconv.ParseUint(req.PostFormValue("version"), 10, 64)
+  if err != nil {
+    writeBadRequest(w, "wrong \"version\" param")
+    return
+  }
+
+  msg, err := h.ctrl.RestoreVersion(req.Context(), user.Id, id, seq)
+  if err != nil {
+    h.writeVersionError(w, req, user, err)
+    return
+  }
+
+  writeMessage(w, msg, "restored")
+}
+
+// PruneHistory drops versions of posted message "id" but "keep" newest ones
+func (h *Handler) PruneHistory(w http.ResponseWriter, req *http.Request) {
+  if req.Method != http.MethodPost {
+    w.WriteHeader(http.StatusMethodNotAllowed)
+    return
+  }
+
+  user, ok := getUser(w, req)
+  if !ok {
+    return
+  }
+  id, ok := getMessageId(w, req.PostFormValue("id"))
+  if !ok {
+    return
+  }
+  keep, ok := getIntQuery(w, req.PostFormValue("keep"), "keep", 0)
+  if !ok {
+    return
+  }
+  if keep < 0 {
+    writeBadRequest(w, "wrong \"keep\" param")
+    return
+  }
+
+  res, err := h.ctrl.PruneVersions(req.Context(), user.Id, id, int32(keep))
+  if err != nil {
+    h.writeVersionError(w, req, user, err)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.PruneServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "pruned",
+    },
+    PruneResult: *res,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+func (h *Handler) writeVersionError(w http.ResponseWriter, req *http.Request, user *usermodel.User, err error) {
+  if seconds, ok := ratelimit.RetryAfterOf(err); ok {
+    ratelimit.TooManyRequests(w, seconds)
+    return
+  }
+  switch status.Code(err) {
+  case codes.NotFound:
+    w.WriteHeader(http.StatusNotFound)
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "message or version not found",
+    }); err != nil {
+      log.Println(err)
+    }
+  case codes.InvalidArgument:
+    writeBadRequest(w, status.Convert(err).Message())
+  case codes.FailedPrecondition:
+    usage, quota, err := h.ctrl.GetUsage(req.Context(), user.Id)
+    if err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+      return
+    }
+    writeOverQuota(w, usage, quota)
+  default:
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+func writeMessage(w http.ResponseWriter, msg *model.Message, description string) {
+  if err := json.NewEncoder(w).Encode(model.NewMessageServerResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: description,
+    },
+    Message: *msg,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
+
+// getMessageId parses required positive message id
+func getMessageId(w http.ResponseWriter, value string) (model.MessageId, bool) {
+  id, err := strconv.Atoi(value)
+  if err != nil || id <= 0 {
+    writeBadRequest(w, "wrong \"id\" param")
+    return model.NullMsgId, false
+  }
+  return model.MessageId(id), true
+}
@@ -48,10 +48,9 @@ func (p *Picker) Pick(info balancer.PickInfo) (
   defer p.mu.RUnlock()
 
   var result balancer.PickResult
-  if strings.Contains(info.FullMethodName, "SaveMessage") ||
-    len(p.followers) == 0 {
-      result.SubConn = p.leader
-  } else if strings.Contains(info.FullMethodName, "ReadUserMessages") {
+  if isWrite(info.FullMethodName) || len(p.followers) == 0 {
+    result.SubConn = p.leader
+  } else {
     result.SubConn = p.nextFollower()
   }
   if result.SubConn == nil {
@@ -60,6 +59,19 @@ func (p *Picker) Pick(info balancer.PickInfo) (
   return result, nil
 }
 
+// writes go through raft, only leader applies them
+var writeMethods = []string{"SaveMessage", "SetQuota", "UpdateMessage", "RestoreVersion", "PruneVersions",
+  "DeleteMessage", "RestoreMessage", "EmptyTrash"}
+
+func isWrite(method string) bool {
+  for _, name := range writeMethods {
+    if strings.HasSuffix(method, "/" + name) {
+      return true
+    }
+  }
+  return false
+}
+
 func (p *Picker) nextFollower() balancer.SubConn {
   cur := atomic.AddUint64(&p.current, uint64(1))
   len := uint64(len(p.followers))
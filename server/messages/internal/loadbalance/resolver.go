@@ -7,7 +7,6 @@ import (
   "log"
 
   "google.golang.org/grpc"
-  "google.golang.org/grpc/credentials/insecure"
   "google.golang.org/grpc/attributes"
   "google.golang.org/grpc/serviceconfig"
   "google.golang.org/grpc/resolver"
@@ -27,14 +26,14 @@ var _ resolver.Builder = (*Resolver)(nil)
 func (r *Resolver) Build(
   t resolver.Target,
   cc resolver.ClientConn,
-  _ resolver.BuildOptions,
+  opts resolver.BuildOptions,
 ) (resolver.Resolver, error) {
   var err error
 
   r.clientConn = cc
   r.resolverConn, err = grpc.Dial(
     t.Endpoint(),
-    grpc.WithTransportCredentials(insecure.NewCredentials()),
+    grpc.WithTransportCredentials(dialCreds(opts)),
   )
   r.serviceConfig = r.clientConn.ParseServiceConfig(
     fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
@@ -0,0 +1,122 @@
+package loadbalance
+
+import (
+  "sync"
+  "time"
+  "strings"
+  "context"
+  "log"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/credentials"
+  "google.golang.org/grpc/credentials/insecure"
+  "google.golang.org/grpc/serviceconfig"
+  "google.golang.org/grpc/resolver"
+
+  "github.com/bd878/gallery/server/api"
+)
+
+const UsersName = "users"
+
+/**
+ * Resolves users service replicas. Target endpoint
+ * lists seed addresses, comma separated. Servers are asked
+ * one by one, so a resolve succeeds while any is up
+ */
+type UsersResolver struct {
+  mu sync.Mutex
+  clientConn resolver.ClientConn
+  serviceConfig *serviceconfig.ParseResult
+  seeds []string
+  known []string
+  creds credentials.TransportCredentials
+}
+
+type usersBuilder struct{}
+
+var _ resolver.Builder = (*usersBuilder)(nil)
+
+func (usersBuilder) Build(
+  t resolver.Target,
+  cc resolver.ClientConn,
+  opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+  r := &UsersResolver{
+    clientConn: cc,
+    seeds: strings.Split(t.Endpoint(), ","),
+    creds: dialCreds(opts),
+  }
+  r.serviceConfig = cc.ParseServiceConfig(
+    `{"loadBalancingConfig":[{"round_robin":{}}]}`,
+  )
+  r.ResolveNow(resolver.ResolveNowOptions{})
+  return r, nil
+}
+
+func (usersBuilder) Scheme() string {
+  return UsersName
+}
+
+func init() {
+  resolver.Register(usersBuilder{})
+}
+
+var _ resolver.Resolver = (*UsersResolver)(nil)
+
+func (r *UsersResolver) ResolveNow(resolver.ResolveNowOptions) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var err error
+  for _, addr := range append(r.known, r.seeds...) {
+    var servers []*api.UserServer
+    servers, err = r.getServers(addr)
+    if err != nil {
+      log.Println("cannot get users servers from", addr, err)
+      continue
+    }
+
+    known := make([]string, 0, len(servers))
+    addrs := make([]resolver.Address, 0, len(servers))
+    for _, server := range servers {
+      known = append(known, server.RpcAddr)
+      addrs = append(addrs, resolver.Address{Addr: server.RpcAddr})
+    }
+    r.known = known
+
+    r.clientConn.UpdateState(resolver.State{
+      Addresses: addrs,
+      ServiceConfig: r.serviceConfig,
+    })
+    return
+  }
+  // lets client conn retry resolving with backoff
+  r.clientConn.ReportError(err)
+}
+
+func (r *UsersResolver) getServers(addr string) ([]*api.UserServer, error) {
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+
+  conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(r.creds))
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+
+  res, err := api.NewUserServiceClient(conn).GetServers(ctx, &api.GetUserServersRequest{})
+  if err != nil {
+    return nil, err
+  }
+  return res.Servers, nil
+}
+
+func (r *UsersResolver) Close() {}
+
+// dialCreds resolves servers with credentials of the client conn
+func dialCreds(opts resolver.BuildOptions) credentials.TransportCredentials {
+  if opts.DialCreds == nil {
+    return insecure.NewCredentials()
+  }
+  return opts.DialCreds.Clone()
+}
@@ -0,0 +1,104 @@
+package purger
+
+import (
+  "log"
+  "time"
+  "context"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+type Controller interface {
+  IsLeader() bool
+  DeleteUserMessages(ctx context.Context, userId usermodel.UserId, limit int32) (*model.PurgeResult, error)
+}
+
+type userGateway interface {
+  ListDeletions(ctx context.Context) ([]*usermodel.Deletion, error)
+  UpdateDeletion(ctx context.Context, deletion *usermodel.Deletion) error
+}
+
+type Config struct {
+  Interval  time.Duration
+  BatchSize int32
+}
+
+/**
+ * Purger consumes account deletions recorded by users service.
+ * Runs on every node, but only the leader purges, so
+ * a new leader picks up deletions the old one left unfinished
+ */
+type Purger struct {
+  cfg         Config
+  ctrl        Controller
+  userGateway userGateway
+}
+
+func New(ctrl Controller, userGateway userGateway, cfg Config) *Purger {
+  return &Purger{cfg, ctrl, userGateway}
+}
+
+func (p *Purger) Run(ctx context.Context) {
+  ticker := time.NewTicker(p.cfg.Interval)
+  defer ticker.Stop()
+
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      if !p.ctrl.IsLeader() {
+        continue
+      }
+      if err := p.Purge(ctx); err != nil {
+        log.Println("purge failed:", err)
+      }
+    }
+  }
+}
+
+func (p *Purger) Purge(ctx context.Context) error {
+  deletions, err := p.userGateway.ListDeletions(ctx)
+  if err != nil {
+    return err
+  }
+
+  for _, deletion := range deletions {
+    if err := p.purgeUser(ctx, deletion); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// reports progress after every batch, users service sums it up
+func (p *Purger) purgeUser(ctx context.Context, deletion *usermodel.Deletion) error {
+  log.Println("purge messages of user", deletion.UserId)
+
+  for {
+    res, err := p.ctrl.DeleteUserMessages(ctx, deletion.UserId, p.cfg.BatchSize)
+    if err != nil {
+      return err
+    }
+
+    status := usermodel.DeletionPurging
+    if res.MessagesDeleted == 0 {
+      status = usermodel.DeletionDone
+    }
+
+    if err := p.userGateway.UpdateDeletion(ctx, &usermodel.Deletion{
+      Id: deletion.Id,
+      Status: status,
+      MessagesDeleted: res.MessagesDeleted,
+      FilesDeleted: res.FilesDeleted,
+    }); err != nil {
+      return err
+    }
+
+    if status == usermodel.DeletionDone {
+      log.Println("purged user", deletion.UserId)
+      return nil
+    }
+  }
+}
@@ -0,0 +1,69 @@
+package purger_test
+
+import (
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/purger"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+func TestPurge(t *testing.T) {
+  ctrl := &controller{messages: map[usermodel.UserId]int{1: 5, 2: 3}}
+  gateway := &gateway{deletions: map[string]*usermodel.Deletion{
+    "a": {Id: "a", UserId: 1, Status: usermodel.DeletionPending},
+  }}
+
+  p := purger.New(ctrl, gateway, purger.Config{BatchSize: 2})
+  require.NoError(t, p.Purge(context.Background()))
+
+  require.Equal(t, usermodel.DeletionDone, gateway.deletions["a"].Status)
+  require.Equal(t, 5, gateway.deletions["a"].MessagesDeleted)
+  require.Equal(t, 5, gateway.deletions["a"].FilesDeleted)
+  require.Equal(t, 0, ctrl.messages[1])
+  require.Equal(t, 3, ctrl.messages[2])
+}
+
+type controller struct {
+  messages map[usermodel.UserId]int
+}
+
+func (c *controller) IsLeader() bool {
+  return true
+}
+
+func (c *controller) DeleteUserMessages(_ context.Context, userId usermodel.UserId, limit int32) (
+  *model.PurgeResult, error,
+) {
+  n := c.messages[userId]
+  if n > int(limit) {
+    n = int(limit)
+  }
+  c.messages[userId] -= n
+  return &model.PurgeResult{MessagesDeleted: n, FilesDeleted: n}, nil
+}
+
+type gateway struct {
+  deletions map[string]*usermodel.Deletion
+}
+
+func (g *gateway) ListDeletions(_ context.Context) ([]*usermodel.Deletion, error) {
+  var res []*usermodel.Deletion
+  for _, deletion := range g.deletions {
+    if deletion.Status != usermodel.DeletionDone {
+      res = append(res, &usermodel.Deletion{Id: deletion.Id, UserId: deletion.UserId})
+    }
+  }
+  return res, nil
+}
+
+func (g *gateway) UpdateDeletion(_ context.Context, update *usermodel.Deletion) error {
+  deletion := g.deletions[update.Id]
+  deletion.Status = update.Status
+  deletion.MessagesDeleted += update.MessagesDeleted
+  deletion.FilesDeleted += update.FilesDeleted
+  return nil
+}
@@ -1,91 +1,112 @@
 package memory
 
 import (
+  "sort"
   "context"
-  "errors"
-  "math"
+  "sync"
 
   "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
 )
 
 type Repository struct {
+  mu sync.RWMutex
   messages map[usermodel.UserId][]*model.Message
+  quotas map[usermodel.UserId]*model.Quota
+  tombstones map[model.MessageId]*model.Tombstone
+  floors map[usermodel.UserId]uint64
+  keys map[idempotencyKey]*model.IdempotencyKey
+  versions map[model.MessageId][]*model.MessageVersion
+  lastId model.MessageId
+  idBase uint64
 }
 
 func New() *Repository {
   return &Repository{
     messages: make(map[usermodel.UserId][]*model.Message, 0),
+    quotas: make(map[usermodel.UserId]*model.Quota, 0),
+    tombstones: make(map[model.MessageId]*model.Tombstone, 0),
+    floors: make(map[usermodel.UserId]uint64, 0),
+    keys: make(map[idempotencyKey]*model.IdempotencyKey, 0),
+    versions: make(map[model.MessageId][]*model.MessageVersion, 0),
   }
 }
 
+type idempotencyKey struct {
+  userId int
+  key string
+}
+
 func (r *Repository) Put(_ context.Context, msg *model.Message) (model.MessageId, error) {
-  msg.Id = model.MessageId(len(r.messages[usermodel.UserId(msg.UserId)]) + 1)
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  msg.NormalizeAttachments()
+  if msg.Id == model.NullMsgId {
+    r.lastId += 1
+    msg.Id = r.lastId
+  } else if msg.Id > r.lastId {
+    r.lastId = msg.Id
+  }
   r.messages[usermodel.UserId(msg.UserId)] = append(r.messages[usermodel.UserId(msg.UserId)], msg)
-  return msg.Id
+  return msg.Id, nil
 }
 
-func (r *Repository) FindByIndexTerm(_ contex.Context, logIndex, logTerm uint64) (*model.Message, error) {
-  for _, msg := range msgs {
-    if msg.LogIndex == logIndex && msg.LogTerm == logTerm {
-      return msg, nil
+func (r *Repository) FindByIndexTerm(_ context.Context, logIndex, logTerm uint64) (*model.Message, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  for _, msgs := range r.messages {
+    for _, msg := range msgs {
+      if msg.LogIndex == logIndex && msg.LogTerm == logTerm {
+        return msg, nil
+      }
     }
   }
-  return false, nil
+  return nil, repository.ErrNotFound
 }
 
 func (r *Repository) Get(_ context.Context, userId usermodel.UserId, limit, offset int32, ascending bool) (*model.MessagesList, error) {
-  var isLastPage bool
+  r.mu.RLock()
+  defer r.mu.RUnlock()
 
-  msgs := r.messages[userId]
-  if offset >= len(msgs) {
-    return [], nil
+  msgs := live(r.messages[userId])
+  total := int32(len(msgs))
+  if offset >= total {
+    return &model.MessagesList{IsLastPage: true}, nil
   }
 
-  threshold := int32(math.Min(
-    float32(len(msgs)),
-    float32(offset + limit),
-  )
-
-  if limit+offset >= len(msgs) {
-    isLastPage = true
+  threshold := total
+  if limit >= 0 && offset + limit < total {
+    threshold = offset + limit
   }
 
-  result := make([]*model.Message, threshold - offset)
-
-  var j int32
-  if ascending == false {
-    for i := len(msgs)-offset; i > len(msgs)-offset-threshold; i-- {
-      result[j] = msgs[i]
-      j += 1
-    }
-  } else {
-    for i := offset; i < threshold; i++ {
-      result[j] = msgs[i]
-      j += 1
+  result := make([]*model.Message, 0, threshold - offset)
+  for i := offset; i < threshold; i++ {
+    if ascending {
+      result = append(result, msgs[i])
+    } else {
+      result = append(result, msgs[total-1-i])
     }
   }
 
   return &model.MessagesList{
     Messages: result,
-    IsLastPage: isLastPage,
+    IsLastPage: threshold == total,
   }, nil
 }
 
 func (r *Repository) GetOne(_ context.Context, userId usermodel.UserId, id model.MessageId) (*model.Message, error) {
-  var zero model.Message
+  r.mu.RLock()
+  defer r.mu.RUnlock()
 
-  msgs, ok := r.messages[userId]
-  if !ok {
-    return zero, errors.New("no such user")
-  }
-
-  for _, msg := range msgs {
-    if msg.Id == id {
-      return &msg, nil
+  for _, msg := range r.messages[userId] {
+    if msg.Id == id && msg.DeletedAt == "" {
+      return msg, nil
     }
   }
-  return &zero, errors.New("no such message")
+  return &model.Message{}, repository.ErrNotFound
 }
 
 func (r *Repository) PutBatch(ctx context.Context, msgs [](*model.Message)) error {
@@ -96,6 +117,9 @@ func (r *Repository) PutBatch(ctx context.Context, msgs [](*model.Message)) erro
 }
 
 func (r *Repository) GetBatch(_ context.Context) ([]*model.Message, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
   var msgs []*model.Message
   for _, userMsgs := range r.messages {
     msgs = append(msgs, userMsgs...)
@@ -103,9 +127,496 @@ func (r *Repository) GetBatch(_ context.Context) ([]*model.Message, error) {
   return msgs, nil
 }
 
+func (r *Repository) DeleteUserMessages(
+  _ context.Context,
+  userId usermodel.UserId,
+  logIndex uint64,
+  limit int32,
+  deleteTime string,
+) ([]*model.Message, []model.FileId, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var deleted, kept []*model.Message
+  var files []model.FileId
+  for _, msg := range r.messages[userId] {
+    if int32(len(deleted)) < limit && msg.LogIndex < logIndex {
+      deleted = append(deleted, msg)
+      files = append(files, fileIds(r.versionFiles(msg))...)
+      delete(r.versions, msg.Id)
+      r.tombstones[msg.Id] = &model.Tombstone{
+        Id: msg.Id,
+        UserId: msg.UserId,
+        Seq: logIndex,
+        DeleteTime: deleteTime,
+      }
+    } else {
+      kept = append(kept, msg)
+    }
+  }
+
+  if len(kept) == 0 {
+    delete(r.messages, userId)
+  } else {
+    r.messages[userId] = kept
+  }
+  return deleted, files, nil
+}
+
 func (r *Repository) Truncate(_ context.Context) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
   for userId, _ := range r.messages {
     delete(r.messages, userId)
   }
+  for userId, _ := range r.quotas {
+    delete(r.quotas, userId)
+  }
+  r.tombstones = make(map[model.MessageId]*model.Tombstone, 0)
+  r.floors = make(map[usermodel.UserId]uint64, 0)
+  r.keys = make(map[idempotencyKey]*model.IdempotencyKey, 0)
+  r.versions = make(map[model.MessageId][]*model.MessageVersion, 0)
+  return nil
+}
+
+func (r *Repository) IdBase(_ context.Context) (uint64, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  return r.idBase, nil
+}
+
+func (r *Repository) SetIdBase(_ context.Context, base uint64) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  r.idBase = base
+  return nil
+}
+
+func (r *Repository) GetUsage(_ context.Context, userId usermodel.UserId) (*model.Usage, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  return r.usage(userId), nil
+}
+
+func (r *Repository) usage(userId usermodel.UserId) *model.Usage {
+  usage := &model.Usage{UserId: int(userId)}
+  for _, msg := range r.messages[userId] {
+    usage.Messages += 1
+    usage.Bytes += model.FilesSize(msg.Attachments)
+    usage.Files += int64(len(msg.Attachments))
+    for _, attachment := range r.versionFiles(msg) {
+      usage.Bytes += attachment.FileSize
+      usage.Files += 1
+    }
+  }
+  return usage
+}
+
+func (r *Repository) ListUsage(_ context.Context, limit, offset int32) ([]*model.Usage, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var res []*model.Usage
+  for userId, _ := range r.messages {
+    res = append(res, r.usage(userId))
+  }
+  sort.Slice(res, func(i, j int) bool {
+    if res[i].Bytes != res[j].Bytes {
+      return res[i].Bytes > res[j].Bytes
+    }
+    return res[i].UserId < res[j].UserId
+  })
+
+  if int(offset) >= len(res) {
+    return nil, nil
+  }
+  res = res[offset:]
+  if int(limit) < len(res) {
+    res = res[:limit]
+  }
+  return res, nil
+}
+
+func (r *Repository) GetQuota(_ context.Context, userId usermodel.UserId) (*model.Quota, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  quota, ok := r.quotas[userId]
+  if !ok {
+    return nil, repository.ErrNotFound
+  }
+  res := *quota
+  return &res, nil
+}
+
+func (r *Repository) GetQuotas(_ context.Context) ([]*model.UserQuota, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var res []*model.UserQuota
+  for userId, quota := range r.quotas {
+    res = append(res, &model.UserQuota{UserId: int(userId), Quota: *quota})
+  }
+  return res, nil
+}
+
+func (r *Repository) SetQuota(_ context.Context, userId usermodel.UserId, quota *model.Quota) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  if quota == nil {
+    delete(r.quotas, userId)
+    return nil
+  }
+  res := *quota
+  r.quotas[userId] = &res
+  return nil
+}
+
+func (r *Repository) Changes(_ context.Context, userId usermodel.UserId, since uint64, limit int32) (
+  []*model.Message,
+  []*model.Tombstone,
+  error,
+) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var msgs []*model.Message
+  for id, userMsgs := range r.messages {
+    if userId != 0 && id != userId {
+      continue
+    }
+    for _, msg := range userMsgs {
+      if msg.Seq > since && msg.DeletedAt == "" {
+        msgs = append(msgs, msg)
+      }
+    }
+  }
+  sort.Slice(msgs, func(i, j int) bool {
+    return msgs[i].Seq < msgs[j].Seq
+  })
+  if int32(len(msgs)) > limit {
+    msgs = msgs[:limit]
+  }
+
+  var tombstones []*model.Tombstone
+  for _, tombstone := range r.tombstones {
+    if (userId == 0 || usermodel.UserId(tombstone.UserId) == userId) && tombstone.Seq > since {
+      res := *tombstone
+      tombstones = append(tombstones, &res)
+    }
+  }
+  sort.Slice(tombstones, func(i, j int) bool {
+    if tombstones[i].Seq != tombstones[j].Seq {
+      return tombstones[i].Seq < tombstones[j].Seq
+    }
+    return tombstones[i].Id < tombstones[j].Id
+  })
+  if int32(len(tombstones)) > limit {
+    tombstones = tombstones[:limit]
+  }
+  return msgs, tombstones, nil
+}
+
+func (r *Repository) SyncFloor(_ context.Context, userId usermodel.UserId) (uint64, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  if userId != 0 {
+    return r.floors[userId], nil
+  }
+  var res uint64
+  for _, seq := range r.floors {
+    res = max(res, seq)
+  }
+  return res, nil
+}
+
+func (r *Repository) CompactTombstones(_ context.Context, before string) (int, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  n := 0
+  for id, tombstone := range r.tombstones {
+    if tombstone.DeleteTime >= before {
+      continue
+    }
+    userId := usermodel.UserId(tombstone.UserId)
+    if tombstone.Seq > r.floors[userId] {
+      r.floors[userId] = tombstone.Seq
+    }
+    delete(r.tombstones, id)
+    n += 1
+  }
+  return n, nil
+}
+
+func (r *Repository) GetSyncState(_ context.Context) ([]*model.Tombstone, []*model.SyncFloor, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var tombstones []*model.Tombstone
+  for _, tombstone := range r.tombstones {
+    res := *tombstone
+    tombstones = append(tombstones, &res)
+  }
+  var floors []*model.SyncFloor
+  for userId, seq := range r.floors {
+    floors = append(floors, &model.SyncFloor{UserId: int(userId), Seq: seq})
+  }
+  return tombstones, floors, nil
+}
+
+func (r *Repository) PutSyncState(_ context.Context, tombstones []*model.Tombstone, floors []*model.SyncFloor) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  for _, tombstone := range tombstones {
+    res := *tombstone
+    r.tombstones[tombstone.Id] = &res
+  }
+  for _, floor := range floors {
+    r.floors[usermodel.UserId(floor.UserId)] = floor.Seq
+  }
+  return nil
+}
+
+func (r *Repository) GetIdempotencyKey(_ context.Context, userId usermodel.UserId, key, now string) (
+  *model.IdempotencyKey,
+  error,
+) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  res, ok := r.keys[idempotencyKey{int(userId), key}]
+  if !ok || res.Expires <= now {
+    return nil, repository.ErrNotFound
+  }
+  k := *res
+  return &k, nil
+}
+
+func (r *Repository) PutWithIdempotencyKey(ctx context.Context, msg *model.Message, key *model.IdempotencyKey) (
+  model.MessageId,
+  error,
+) {
+  id, err := r.Put(ctx, msg)
+  if err != nil {
+    return model.NullMsgId, err
+  }
+  key.MessageId = id
+  return id, r.PutIdempotencyKey(ctx, key)
+}
+
+func (r *Repository) PutIdempotencyKey(_ context.Context, key *model.IdempotencyKey) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  res := *key
+  r.keys[idempotencyKey{key.UserId, key.Key}] = &res
   return nil
 }
+
+func (r *Repository) DeleteExpiredIdempotencyKeys(_ context.Context, now string) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  for k, key := range r.keys {
+    if key.Expires <= now {
+      delete(r.keys, k)
+    }
+  }
+  return nil
+}
+
+func (r *Repository) GetIdempotencyKeys(_ context.Context) ([]*model.IdempotencyKey, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var res []*model.IdempotencyKey
+  for _, key := range r.keys {
+    k := *key
+    res = append(res, &k)
+  }
+  return res, nil
+}
+
+func (r *Repository) UpdateMessage(_ context.Context, msg *model.Message, prev *model.MessageVersion) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  msgs := r.messages[usermodel.UserId(msg.UserId)]
+  for i, current := range msgs {
+    if current.Id == msg.Id {
+      res := *msg
+      msgs[i] = &res
+      r.putVersion(prev)
+      return nil
+    }
+  }
+  return repository.ErrNotFound
+}
+
+func (r *Repository) GetVersions(_ context.Context, userId usermodel.UserId, id model.MessageId) ([]*model.MessageVersion, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var res []*model.MessageVersion
+  for _, version := range r.versions[id] {
+    if usermodel.UserId(version.UserId) == userId {
+      v := *version
+      res = append(res, &v)
+    }
+  }
+  sort.Slice(res, func(i, j int) bool {
+    return res[i].Seq > res[j].Seq
+  })
+  return res, nil
+}
+
+func (r *Repository) GetVersion(ctx context.Context, userId usermodel.UserId, id model.MessageId, seq uint64) (*model.MessageVersion, error) {
+  versions, err := r.GetVersions(ctx, userId, id)
+  if err != nil {
+    return nil, err
+  }
+  for _, version := range versions {
+    if version.Seq == seq {
+      return version, nil
+    }
+  }
+  return nil, repository.ErrNotFound
+}
+
+func (r *Repository) PruneVersions(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  keep int32,
+  logIndex uint64,
+) (int, []model.FileId, error) {
+  versions, err := r.GetVersions(ctx, userId, id)
+  if err != nil {
+    return 0, nil, err
+  }
+
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var current *model.Message
+  for _, msg := range r.messages[userId] {
+    if msg.Id == id {
+      current = msg
+    }
+  }
+
+  // versions go newest first, state that replaced
+  // each one is the one before, message for the first
+  var kept, pruned []*model.MessageVersion
+  var next uint64
+  if current != nil {
+    next = current.Seq
+  }
+  for _, version := range versions {
+    replaced := current != nil && next < logIndex
+    next = version.Seq
+    if !replaced || keep > 0 {
+      if replaced {
+        keep -= 1
+      }
+      kept = append(kept, version)
+      continue
+    }
+    pruned = append(pruned, version)
+  }
+  if len(pruned) == 0 {
+    return 0, nil, nil
+  }
+  r.versions[id] = kept
+
+  var freed []model.FileId
+  seen := make(map[model.FileId]bool)
+  for _, version := range pruned {
+    for _, attachment := range version.Attachments {
+      if seen[attachment.FileId] {
+        continue
+      }
+      seen[attachment.FileId] = true
+      if current != nil && model.HasFile(current.Attachments, attachment.FileId) {
+        continue
+      }
+      kept := false
+      for _, v := range r.versions[id] {
+        kept = kept || model.HasFile(v.Attachments, attachment.FileId)
+      }
+      if !kept {
+        freed = append(freed, attachment.FileId)
+      }
+    }
+  }
+  return len(pruned), freed, nil
+}
+
+func (r *Repository) GetAllVersions(_ context.Context) ([]*model.MessageVersion, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var res []*model.MessageVersion
+  for _, versions := range r.versions {
+    for _, version := range versions {
+      v := *version
+      res = append(res, &v)
+    }
+  }
+  return res, nil
+}
+
+func (r *Repository) PutVersions(_ context.Context, versions []*model.MessageVersion) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  for _, version := range versions {
+    r.putVersion(version)
+  }
+  return nil
+}
+
+func (r *Repository) putVersion(version *model.MessageVersion) {
+  v := *version
+  v.NormalizeAttachments()
+  versions := r.versions[v.MessageId]
+  for i, old := range versions {
+    if old.Seq == v.Seq {
+      versions[i] = &v
+      return
+    }
+  }
+  r.versions[v.MessageId] = append(versions, &v)
+}
+
+// versionFiles are files of msg versions other than msg ones, each once
+func (r *Repository) versionFiles(msg *model.Message) []model.Attachment {
+  var res []model.Attachment
+  seen := make(map[model.FileId]bool)
+  for _, version := range r.versions[msg.Id] {
+    for _, attachment := range version.Attachments {
+      if seen[attachment.FileId] || model.HasFile(msg.Attachments, attachment.FileId) {
+        continue
+      }
+      seen[attachment.FileId] = true
+      res = append(res, attachment)
+    }
+  }
+  return res
+}
+
+func fileIds(attachments []model.Attachment) []model.FileId {
+  var res []model.FileId
+  for _, attachment := range attachments {
+    res = append(res, attachment.FileId)
+  }
+  return res
+}
@@ -0,0 +1,152 @@
+package memory
+
+import (
+  "sort"
+  "context"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+func (r *Repository) TrashMessage(
+  _ context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  seq uint64,
+  deleteTime string,
+) (*model.Message, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  for i, msg := range r.messages[userId] {
+    if msg.Id != id || msg.DeletedAt != "" || msg.Seq >= seq {
+      continue
+    }
+    res := *msg
+    res.DeletedAt = deleteTime
+    res.Seq = seq
+    r.messages[userId][i] = &res
+    r.tombstones[id] = &model.Tombstone{
+      Id: id,
+      UserId: int(userId),
+      Seq: seq,
+      DeleteTime: deleteTime,
+    }
+    return &res, nil
+  }
+  return nil, repository.ErrNotFound
+}
+
+func (r *Repository) RestoreMessage(
+  _ context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  seq uint64,
+) (*model.Message, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  for i, msg := range r.messages[userId] {
+    if msg.Id != id || msg.DeletedAt == "" || msg.Seq >= seq {
+      continue
+    }
+    res := *msg
+    res.DeletedAt = ""
+    res.Seq = seq
+    r.messages[userId][i] = &res
+    delete(r.tombstones, id)
+    return &res, nil
+  }
+  return nil, repository.ErrNotFound
+}
+
+func (r *Repository) GetTrash(_ context.Context, userId usermodel.UserId, limit, offset int32) (*model.MessagesList, error) {
+  r.mu.RLock()
+  defer r.mu.RUnlock()
+
+  var trash []*model.Message
+  for _, msg := range r.messages[userId] {
+    if msg.DeletedAt != "" {
+      trash = append(trash, msg)
+    }
+  }
+  sort.Slice(trash, func(i, j int) bool {
+    if trash[i].DeletedAt != trash[j].DeletedAt {
+      return trash[i].DeletedAt > trash[j].DeletedAt
+    }
+    return trash[i].Id > trash[j].Id
+  })
+
+  if int(offset) >= len(trash) {
+    return &model.MessagesList{IsLastPage: true}, nil
+  }
+  trash = trash[offset:]
+  if limit >= 0 && int(limit) < len(trash) {
+    return &model.MessagesList{Messages: trash[:limit]}, nil
+  }
+  return &model.MessagesList{Messages: trash, IsLastPage: true}, nil
+}
+
+func (r *Repository) PurgeTrash(
+  _ context.Context,
+  userId usermodel.UserId,
+  before string,
+  logIndex uint64,
+  limit int32,
+) ([]*model.Message, []model.FileId, error) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  var purged []*model.Message
+  for id, msgs := range r.messages {
+    if userId != 0 && id != userId {
+      continue
+    }
+    for _, msg := range msgs {
+      if msg.DeletedAt != "" && msg.DeletedAt <= before && msg.Seq < logIndex {
+        purged = append(purged, msg)
+      }
+    }
+  }
+  sort.Slice(purged, func(i, j int) bool {
+    if purged[i].DeletedAt != purged[j].DeletedAt {
+      return purged[i].DeletedAt < purged[j].DeletedAt
+    }
+    return purged[i].Id < purged[j].Id
+  })
+  if int32(len(purged)) > limit {
+    purged = purged[:limit]
+  }
+
+  var files []model.FileId
+  for _, msg := range purged {
+    files = append(files, fileIds(r.versionFiles(msg))...)
+    delete(r.versions, msg.Id)
+
+    userId := usermodel.UserId(msg.UserId)
+    var kept []*model.Message
+    for _, m := range r.messages[userId] {
+      if m.Id != msg.Id {
+        kept = append(kept, m)
+      }
+    }
+    if len(kept) == 0 {
+      delete(r.messages, userId)
+    } else {
+      r.messages[userId] = kept
+    }
+  }
+  return purged, files, nil
+}
+
+// live are msgs not in trash
+func live(msgs []*model.Message) []*model.Message {
+  var res []*model.Message
+  for _, msg := range msgs {
+    if msg.DeletedAt == "" {
+      res = append(res, msg)
+    }
+  }
+  return res
+}
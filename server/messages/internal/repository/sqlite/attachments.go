@@ -0,0 +1,165 @@
+package repository
+
+import (
+  "strings"
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+)
+
+const attachmentColumns = "file_id, file_name, file_size, mime_type"
+
+// ids per query, sqlite limits query params
+const attachmentsBatch = 500
+
+// querier is db or tx
+type querier interface {
+  QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func putAttachments(ctx context.Context, tx *sql.Tx, id model.MessageId, attachments []model.Attachment) error {
+  for i, attachment := range attachments {
+    if _, err := tx.ExecContext(ctx,
+      "INSERT INTO attachments(message_id, position, " + attachmentColumns + ") VALUES (?,?,?,?,?,?)",
+      int(id), i, attachment.FileId, attachment.FileName, attachment.FileSize, attachment.MimeType,
+    ); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func deleteAttachments(ctx context.Context, tx *sql.Tx, id model.MessageId) error {
+  _, err := tx.ExecContext(ctx, "DELETE FROM attachments WHERE message_id = ?", int(id))
+  return err
+}
+
+// loadAttachments reads attachments of msgs in order, fills single file fields too
+func loadAttachments(ctx context.Context, q querier, msgs []*model.Message) error {
+  byId := make(map[model.MessageId]*model.Message, len(msgs))
+  ids := make([]any, 0, len(msgs))
+  for _, msg := range msgs {
+    msg.Attachments = nil
+    byId[msg.Id] = msg
+    ids = append(ids, int(msg.Id))
+  }
+
+  if err := eachBatch(ids, func(batch []any) error {
+    rows, err := q.QueryContext(ctx,
+      "SELECT message_id, " + attachmentColumns + " FROM attachments " +
+      "WHERE message_id IN (" + params(len(batch)) + ") ORDER BY message_id, position",
+      batch...,
+    )
+    if err != nil {
+      return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+      var id model.MessageId
+      var attachment model.Attachment
+      if err := rows.Scan(&id, &attachment.FileId, &attachment.FileName, &attachment.FileSize, &attachment.MimeType); err != nil {
+        return err
+      }
+      msg := byId[id]
+      msg.Attachments = append(msg.Attachments, attachment)
+    }
+    return rows.Err()
+  }); err != nil {
+    return err
+  }
+
+  for _, msg := range msgs {
+    msg.NormalizeAttachments()
+  }
+  return nil
+}
+
+func putVersionAttachments(ctx context.Context, tx *sql.Tx, version *model.MessageVersion) error {
+  if _, err := tx.ExecContext(ctx,
+    "DELETE FROM version_attachments WHERE message_id = ? AND seq = ?",
+    int(version.MessageId), version.Seq,
+  ); err != nil {
+    return err
+  }
+  for i, attachment := range version.Attachments {
+    if _, err := tx.ExecContext(ctx,
+      "INSERT INTO version_attachments(message_id, seq, position, " + attachmentColumns + ") VALUES (?,?,?,?,?,?,?)",
+      int(version.MessageId), version.Seq, i,
+      attachment.FileId, attachment.FileName, attachment.FileSize, attachment.MimeType,
+    ); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+type versionKey struct {
+  id model.MessageId
+  seq uint64
+}
+
+// loadVersionAttachments reads attachments of versions in order
+func loadVersionAttachments(ctx context.Context, q querier, versions []*model.MessageVersion) error {
+  byKey := make(map[versionKey]*model.MessageVersion, len(versions))
+  seen := make(map[model.MessageId]bool)
+  var ids []any
+  for _, version := range versions {
+    version.Attachments = nil
+    byKey[versionKey{version.MessageId, version.Seq}] = version
+    if !seen[version.MessageId] {
+      seen[version.MessageId] = true
+      ids = append(ids, int(version.MessageId))
+    }
+  }
+
+  if err := eachBatch(ids, func(batch []any) error {
+    rows, err := q.QueryContext(ctx,
+      "SELECT message_id, seq, " + attachmentColumns + " FROM version_attachments " +
+      "WHERE message_id IN (" + params(len(batch)) + ") ORDER BY message_id, seq, position",
+      batch...,
+    )
+    if err != nil {
+      return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+      var key versionKey
+      var attachment model.Attachment
+      if err := rows.Scan(&key.id, &key.seq, &attachment.FileId, &attachment.FileName, &attachment.FileSize, &attachment.MimeType); err != nil {
+        return err
+      }
+      if version, ok := byKey[key]; ok {
+        version.Attachments = append(version.Attachments, attachment)
+      }
+    }
+    return rows.Err()
+  }); err != nil {
+    return err
+  }
+
+  for _, version := range versions {
+    version.NormalizeAttachments()
+  }
+  return nil
+}
+
+func eachBatch(ids []any, fn func([]any) error) error {
+  for len(ids) > 0 {
+    n := len(ids)
+    if n > attachmentsBatch {
+      n = attachmentsBatch
+    }
+    if err := fn(ids[:n]); err != nil {
+      return err
+    }
+    ids = ids[n:]
+  }
+  return nil
+}
+
+func params(n int) string {
+  return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+  "errors"
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+// GetIdempotencyKey returns user key not expired at now
+func (r *Repository) GetIdempotencyKey(
+  ctx context.Context,
+  userId usermodel.UserId,
+  key string,
+  now string,
+) (
+  *model.IdempotencyKey,
+  error,
+) {
+  res := &model.IdempotencyKey{UserId: int(userId), Key: key}
+  err := r.db.QueryRowContext(ctx,
+    "SELECT message_id, expires, hash FROM idempotency_keys WHERE user_id = ? AND key = ? AND expires > ?",
+    int(userId), key, now,
+  ).Scan(&res.MessageId, &res.Expires, &res.Hash)
+  if errors.Is(err, sql.ErrNoRows) {
+    return nil, repository.ErrNotFound
+  }
+  if err != nil {
+    return nil, err
+  }
+  return res, nil
+}
+
+func (r *Repository) PutIdempotencyKey(ctx context.Context, key *model.IdempotencyKey) error {
+  return putIdempotencyKey(ctx, r.db, key)
+}
+
+/**
+ * PutWithIdempotencyKey saves message and key pointing
+ * to it in one transaction, so either both are kept or none
+ */
+func (r *Repository) PutWithIdempotencyKey(ctx context.Context, msg *model.Message, key *model.IdempotencyKey) (
+  model.MessageId,
+  error,
+) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return model.NullMsgId, err
+  }
+  defer tx.Rollback()
+
+  id, err := put(ctx, tx, msg)
+  if err != nil {
+    return model.NullMsgId, err
+  }
+  key.MessageId = id
+  if err := putIdempotencyKey(ctx, tx, key); err != nil {
+    return model.NullMsgId, err
+  }
+  return id, tx.Commit()
+}
+
+// execer is db or tx
+type execer interface {
+  ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func putIdempotencyKey(ctx context.Context, db execer, key *model.IdempotencyKey) error {
+  _, err := db.ExecContext(ctx,
+    "INSERT OR REPLACE INTO idempotency_keys(user_id, key, message_id, expires, hash) VALUES (?,?,?,?,?)",
+    key.UserId, key.Key, int(key.MessageId), key.Expires, key.Hash,
+  )
+  return err
+}
+
+// DeleteExpiredIdempotencyKeys drops keys expired at now
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context, now string) error {
+  _, err := r.db.ExecContext(ctx,
+    "DELETE FROM idempotency_keys WHERE expires <= ?",
+    now,
+  )
+  return err
+}
+
+// GetIdempotencyKeys returns all keys, for snapshot
+func (r *Repository) GetIdempotencyKeys(ctx context.Context) ([]*model.IdempotencyKey, error) {
+  rows, err := r.db.QueryContext(ctx,
+    "SELECT user_id, key, message_id, expires, hash FROM idempotency_keys",
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.IdempotencyKey
+  for rows.Next() {
+    var key model.IdempotencyKey
+    if err := rows.Scan(&key.UserId, &key.Key, &key.MessageId, &key.Expires, &key.Hash); err != nil {
+      return nil, err
+    }
+    res = append(res, &key)
+  }
+  return res, rows.Err()
+}
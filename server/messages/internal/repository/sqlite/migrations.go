@@ -0,0 +1,40 @@
+package repository
+
+import (
+  "log"
+  "embed"
+  "io/fs"
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/pkg/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// "msgs", tells messages database from other services ones
+const applicationId = 0x6d736773
+
+// Schema is messages database migrations, New applies pending ones
+var Schema = loadSchema()
+
+func loadSchema() *migrate.Schema {
+  sub, err := fs.Sub(migrations, "migrations")
+  if err != nil {
+    panic(err)
+  }
+  s, err := migrate.Load("messages", applicationId, sub)
+  if err != nil {
+    panic(err)
+  }
+  return s
+}
+
+func migrateUp(db *sql.DB) error {
+  applied, err := Schema.Up(context.Background(), db)
+  for _, m := range applied {
+    log.Printf("messages schema: applied %04d_%s\n", m.Version, m.Name)
+  }
+  return err
+}
@@ -0,0 +1,17 @@
+ALTER TABLE messages ADD COLUMN file_size INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS usage(
+  user_id INTEGER PRIMARY KEY,
+  bytes INTEGER NOT NULL DEFAULT 0,
+  files INTEGER NOT NULL DEFAULT 0,
+  messages INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS quotas(
+  user_id INTEGER PRIMARY KEY,
+  max_bytes INTEGER NOT NULL DEFAULT 0,
+  max_files INTEGER NOT NULL DEFAULT 0
+);
+INSERT OR REPLACE INTO usage(user_id, bytes, files, messages)
+  SELECT user_id, SUM(file_size),
+    SUM(CASE WHEN file_id IS NOT NULL AND file_id != '' THEN 1 ELSE 0 END),
+    COUNT(*)
+  FROM messages GROUP BY user_id;
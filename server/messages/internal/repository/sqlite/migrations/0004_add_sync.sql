@@ -0,0 +1,14 @@
+ALTER TABLE messages ADD COLUMN seq INTEGER NOT NULL DEFAULT 0;
+UPDATE messages SET seq = COALESCE(log_index, 0);
+CREATE INDEX IF NOT EXISTS messages_seq ON messages(user_id, seq);
+CREATE TABLE IF NOT EXISTS tombstones(
+  message_id INTEGER PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  deletetime TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS tombstones_seq ON tombstones(user_id, seq);
+CREATE TABLE IF NOT EXISTS sync_floors(
+  user_id INTEGER PRIMARY KEY,
+  seq INTEGER NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+  user_id INTEGER NOT NULL,
+  key TEXT NOT NULL,
+  message_id INTEGER NOT NULL,
+  expires TEXT NOT NULL,
+  PRIMARY KEY(user_id, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires ON idempotency_keys(expires);
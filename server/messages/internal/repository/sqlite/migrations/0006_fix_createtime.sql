@@ -0,0 +1,13 @@
+-- createtime was go time.String() of leader, like
+-- "2024-05-01 12:34:56.789012345 +0300 MSK m=+0.012345678",
+-- rewrite it to rfc3339 utc, millisecond precision
+UPDATE messages SET createtime = COALESCE(strftime('%Y-%m-%dT%H:%M:%fZ',
+  substr(createtime, 1, 19) ||
+  CASE WHEN substr(createtime, 20, 1) = '.'
+    THEN substr(substr(createtime, 20, instr(substr(createtime, 20), ' ') - 1), 1, 4)
+    ELSE ''
+  END ||
+  substr(substr(createtime, 20 + instr(substr(createtime, 20), ' ')), 1, 3) || ':' ||
+  substr(substr(createtime, 20 + instr(substr(createtime, 20), ' ')), 4, 2)
+), createtime)
+WHERE createtime GLOB '[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9] [0-9][0-9]:[0-9][0-9]:[0-9][0-9]*[+-][0-9][0-9][0-9][0-9]*';
@@ -0,0 +1,13 @@
+ALTER TABLE messages ADD COLUMN updatetime TEXT NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS message_versions(
+  message_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  message TEXT,
+  file TEXT,
+  file_id TEXT,
+  file_size INTEGER NOT NULL DEFAULT 0,
+  time TEXT NOT NULL DEFAULT '',
+  replacetime TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY(message_id, seq)
+);
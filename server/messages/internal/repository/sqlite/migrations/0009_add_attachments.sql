@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS attachments(
+  message_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  file_id TEXT NOT NULL,
+  file_name TEXT NOT NULL DEFAULT '',
+  file_size INTEGER NOT NULL DEFAULT 0,
+  mime_type TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY(message_id, position)
+);
+CREATE INDEX IF NOT EXISTS attachments_file ON attachments(message_id, file_id);
+CREATE TABLE IF NOT EXISTS version_attachments(
+  message_id INTEGER NOT NULL,
+  seq INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  file_id TEXT NOT NULL,
+  file_name TEXT NOT NULL DEFAULT '',
+  file_size INTEGER NOT NULL DEFAULT 0,
+  mime_type TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY(message_id, seq, position)
+);
+CREATE INDEX IF NOT EXISTS version_attachments_file ON version_attachments(message_id, file_id);
+-- single file of message becomes its first attachment,
+-- mime type is guessed from file id extension
+INSERT INTO attachments(message_id, position, file_id, file_name, file_size, mime_type)
+  SELECT id, 0, file_id, COALESCE(file, ''), file_size,
+    CASE lower(substr(file_id, instr(file_id, '.')))
+      WHEN '.jpg' THEN 'image/jpeg'
+      WHEN '.jpeg' THEN 'image/jpeg'
+      WHEN '.png' THEN 'image/png'
+      WHEN '.gif' THEN 'image/gif'
+      WHEN '.webp' THEN 'image/webp'
+      WHEN '.mp4' THEN 'video/mp4'
+      ELSE ''
+    END
+  FROM messages WHERE file_id IS NOT NULL AND file_id != '';
+INSERT INTO version_attachments(message_id, seq, position, file_id, file_name, file_size, mime_type)
+  SELECT message_id, seq, 0, file_id, COALESCE(file, ''), file_size, ''
+  FROM message_versions WHERE file_id IS NOT NULL AND file_id != '';
+ALTER TABLE messages DROP COLUMN file;
+ALTER TABLE messages DROP COLUMN file_id;
+ALTER TABLE messages DROP COLUMN file_size;
+ALTER TABLE message_versions DROP COLUMN file;
+ALTER TABLE message_versions DROP COLUMN file_id;
+ALTER TABLE message_versions DROP COLUMN file_size;
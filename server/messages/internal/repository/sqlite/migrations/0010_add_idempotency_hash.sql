@@ -0,0 +1,2 @@
+-- payload hash of message saved with the key, empty for older keys
+ALTER TABLE idempotency_keys ADD COLUMN hash TEXT NOT NULL DEFAULT '';
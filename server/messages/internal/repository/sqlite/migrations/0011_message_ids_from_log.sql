@@ -0,0 +1,45 @@
+-- message ids were rowids of each node, fsm takes log index now.
+-- Older rows keep their ids, rows from log get their index
+-- above the highest of them, fsm adds same base to new ones
+CREATE TABLE IF NOT EXISTS id_base(
+  id INTEGER PRIMARY KEY CHECK (id = 0),
+  base INTEGER NOT NULL
+);
+INSERT OR IGNORE INTO id_base(id, base)
+  SELECT 0, COALESCE(MAX(id), 0) FROM messages WHERE COALESCE(log_index, 0) = 0;
+CREATE TEMP TABLE message_ids AS
+  SELECT id AS old_id, log_index + (SELECT base FROM id_base) AS new_id
+  FROM messages WHERE log_index > 0;
+DELETE FROM message_ids WHERE old_id = new_id;
+-- ids are moved out of the way first, keys are checked per row
+UPDATE messages SET id = -id - 1000000000000
+  WHERE id IN (SELECT old_id FROM message_ids);
+UPDATE messages SET id = (SELECT new_id FROM message_ids WHERE old_id = -messages.id - 1000000000000)
+  WHERE id <= -1000000000000;
+UPDATE idempotency_keys SET message_id = (SELECT new_id FROM message_ids WHERE old_id = message_id)
+  WHERE message_id IN (SELECT old_id FROM message_ids);
+UPDATE message_versions SET message_id = -message_id - 1000000000000
+  WHERE message_id IN (SELECT old_id FROM message_ids);
+UPDATE message_versions SET message_id = (SELECT new_id FROM message_ids WHERE old_id = -message_id - 1000000000000)
+  WHERE message_id <= -1000000000000;
+UPDATE attachments SET message_id = -message_id - 1000000000000
+  WHERE message_id IN (SELECT old_id FROM message_ids);
+UPDATE attachments SET message_id = (SELECT new_id FROM message_ids WHERE old_id = -message_id - 1000000000000)
+  WHERE message_id <= -1000000000000;
+UPDATE version_attachments SET message_id = -message_id - 1000000000000
+  WHERE message_id IN (SELECT old_id FROM message_ids);
+UPDATE version_attachments SET message_id = (SELECT new_id FROM message_ids WHERE old_id = -message_id - 1000000000000)
+  WHERE message_id <= -1000000000000;
+-- synced clients hold old ids, floors above every seq make them
+-- resync, tombstones of old ids go away with it
+INSERT INTO sync_floors(user_id, seq)
+  SELECT user_id, (SELECT MAX(seq) FROM (
+      SELECT seq FROM messages UNION ALL
+      SELECT seq FROM tombstones UNION ALL
+      SELECT seq FROM sync_floors
+    )) + 1
+  FROM (SELECT user_id FROM messages UNION SELECT user_id FROM tombstones)
+  WHERE EXISTS (SELECT 1 FROM message_ids)
+  ON CONFLICT(user_id) DO UPDATE SET seq = MAX(seq, excluded.seq);
+DELETE FROM tombstones WHERE EXISTS (SELECT 1 FROM message_ids);
+DROP TABLE message_ids;
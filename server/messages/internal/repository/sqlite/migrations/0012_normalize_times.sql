@@ -0,0 +1,16 @@
+-- times were rfc3339 of seconds or nanoseconds, rewrite
+-- them to one fixed width layout, see model.TimeLayout
+UPDATE messages SET createtime = strftime('%Y-%m-%dT%H:%M:%fZ', createtime)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', createtime) IS NOT NULL;
+UPDATE messages SET updatetime = strftime('%Y-%m-%dT%H:%M:%fZ', updatetime)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', updatetime) IS NOT NULL;
+UPDATE messages SET deleted_at = strftime('%Y-%m-%dT%H:%M:%fZ', deleted_at)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', deleted_at) IS NOT NULL;
+UPDATE tombstones SET deletetime = strftime('%Y-%m-%dT%H:%M:%fZ', deletetime)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', deletetime) IS NOT NULL;
+UPDATE idempotency_keys SET expires = strftime('%Y-%m-%dT%H:%M:%fZ', expires)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', expires) IS NOT NULL;
+UPDATE message_versions SET time = strftime('%Y-%m-%dT%H:%M:%fZ', time)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', time) IS NOT NULL;
+UPDATE message_versions SET replacetime = strftime('%Y-%m-%dT%H:%M:%fZ', replacetime)
+  WHERE strftime('%Y-%m-%dT%H:%M:%fZ', replacetime) IS NOT NULL;
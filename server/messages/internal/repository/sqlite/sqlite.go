@@ -11,121 +11,242 @@ import (
   usermodel "github.com/bd878/gallery/server/users/pkg/model"
 )
 
+const messageColumns = "id, user_id, createtime, message, log_index, log_term, seq, updatetime, deleted_at"
+
 type Repository struct {
   db *sql.DB
+}
 
-  insertSt *sql.Stmt
+// Open returns database without migrating it
+func Open(dbfilepath string) (*sql.DB, error) {
+  return sql.Open("sqlite3", "file:" + dbfilepath)
 }
 
+// New opens database and applies pending migrations
 func New(dbfilepath string) (*Repository, error) {
-  db, err := sql.Open("sqlite3", "file:" + dbfilepath)
+  db, err := Open(dbfilepath)
   if err != nil {
     return nil, err
   }
-
-  insertSt, err := db.Prepare(
-    "INSERT INTO messages(" +
-      "user_id, " +
-      "createtime, " +
-      "message, " +
-      "file, " +
-      "file_id, " +
-      "log_index, " +
-      "log_term" +
-    ") VALUES (?,?,?,?,?,?,?)",
-  )
-  if err != nil {
+  if err := migrateUp(db); err != nil {
+    db.Close()
     return nil, err
   }
 
   return &Repository{
     db: db,
-
-    insertSt: insertSt,
   }, nil
 }
 
+type scanner interface {
+  Scan(dest ...any) error
+}
+
+func scanMessage(row scanner) (*model.Message, error) {
+  var msg model.Message
+  var logIndexCol sql.NullInt64
+  var logTermCol sql.NullInt64
+  var deletedAtCol sql.NullString
+  if err := row.Scan(
+    &msg.Id,
+    &msg.UserId,
+    &msg.CreateTime,
+    &msg.Value,
+    &logIndexCol,
+    &logTermCol,
+    &msg.Seq,
+    &msg.UpdateTime,
+    &deletedAtCol,
+  ); err != nil {
+    return nil, err
+  }
+  if logIndexCol.Valid {
+    msg.LogIndex = uint64(logIndexCol.Int64)
+  }
+  if logTermCol.Valid {
+    msg.LogTerm = uint64(logTermCol.Int64)
+  }
+  msg.DeletedAt = deletedAtCol.String
+  return &msg, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]*model.Message, error) {
+  var res []*model.Message
+  for rows.Next() {
+    msg, err := scanMessage(rows)
+    if err != nil {
+      return nil, err
+    }
+    res = append(res, msg)
+  }
+  return res, rows.Err()
+}
+
+// queryMessages returns messages of query with their attachments
+func queryMessages(ctx context.Context, q querier, query string, args ...any) ([]*model.Message, error) {
+  rows, err := q.QueryContext(ctx, query, args...)
+  if err != nil {
+    return nil, err
+  }
+  res, err := scanMessages(rows)
+  rows.Close()
+  if err != nil {
+    return nil, err
+  }
+  if err := loadAttachments(ctx, q, res); err != nil {
+    return nil, err
+  }
+  return res, nil
+}
+
+/**
+ * Put saves message and counts it in user usage,
+ * in one transaction, so usage always matches messages
+ */
 func (r *Repository) Put(ctx context.Context, msg *model.Message) (model.MessageId, error) {
-  res, err := r.insertSt.ExecContext(ctx,
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return model.NullMsgId, err
+  }
+  defer tx.Rollback()
+
+  id, err := put(ctx, tx, msg)
+  if err != nil {
+    return model.NullMsgId, err
+  }
+  return id, tx.Commit()
+}
+
+/**
+ * put keeps id of message, restored or assigned by fsm,
+ * sqlite takes next rowid for null one
+ */
+func put(ctx context.Context, tx *sql.Tx, msg *model.Message) (model.MessageId, error) {
+  msg.NormalizeAttachments()
+
+  var id sql.NullInt64
+  if msg.Id != model.NullMsgId {
+    id = sql.NullInt64{Int64: int64(msg.Id), Valid: true}
+  }
+  var deletedAt sql.NullString
+  if msg.DeletedAt != "" {
+    deletedAt = sql.NullString{String: msg.DeletedAt, Valid: true}
+  }
+
+  res, err := tx.ExecContext(ctx,
+    "INSERT INTO messages(" +
+      "id, " +
+      "user_id, " +
+      "createtime, " +
+      "message, " +
+      "log_index, " +
+      "log_term, " +
+      "seq, " +
+      "updatetime, " +
+      "deleted_at" +
+    ") VALUES (?,?,?,?,?,?,?,?,?)",
+    id,
     msg.UserId,
     msg.CreateTime,
     msg.Value,
-    msg.FileName,
-    msg.FileId,
     msg.LogIndex,
     msg.LogTerm,
+    msg.Seq,
+    msg.UpdateTime,
+    deletedAt,
   )
   if err != nil {
     return model.NullMsgId, err
   }
-  id, _ := res.LastInsertId()
-  return model.MessageId(id), nil
+  lastId, _ := res.LastInsertId()
+
+  if err := putAttachments(ctx, tx, model.MessageId(lastId), msg.Attachments); err != nil {
+    return model.NullMsgId, err
+  }
+  if err := addUsage(ctx, tx, msg, 1); err != nil {
+    return model.NullMsgId, err
+  }
+  return model.MessageId(lastId), nil
 }
 
-func (r *Repository) Truncate(ctx context.Context) error {
-  _, err := r.db.ExecContext(ctx,
-    "DELETE FROM messages",
+// addUsage counts msg in (sign 1) or out (sign -1) of user usage
+func addUsage(ctx context.Context, tx *sql.Tx, msg *model.Message, sign int64) error {
+  files := int64(len(msg.Attachments))
+  _, err := tx.ExecContext(ctx,
+    "INSERT INTO usage(user_id, bytes, files, messages) VALUES (?,?,?,?) " +
+    "ON CONFLICT(user_id) DO UPDATE SET " +
+    "bytes = bytes + excluded.bytes, " +
+    "files = files + excluded.files, " +
+    "messages = messages + excluded.messages",
+    msg.UserId, sign*model.FilesSize(msg.Attachments), sign*files, sign,
   )
+  return err
+}
+
+func (r *Repository) Truncate(ctx context.Context) error {
+  tx, err := r.db.BeginTx(ctx, nil)
   if err != nil {
     return err
   }
-  return nil
+  defer tx.Rollback()
+
+  for _, table := range []string{"messages", "usage", "quotas", "tombstones", "sync_floors", "idempotency_keys", "message_versions", "attachments", "version_attachments"} {
+    if _, err := tx.ExecContext(ctx, "DELETE FROM " + table); err != nil {
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+// IdBase is added to log index to make message id
+func (r *Repository) IdBase(ctx context.Context) (uint64, error) {
+  var base uint64
+  err := r.db.QueryRowContext(ctx,
+    "SELECT COALESCE(MAX(base), 0) FROM id_base",
+  ).Scan(&base)
+  return base, err
+}
+
+func (r *Repository) SetIdBase(ctx context.Context, base uint64) error {
+  _, err := r.db.ExecContext(ctx,
+    "INSERT OR REPLACE INTO id_base(id, base) VALUES (0, ?)",
+    base,
+  )
+  return err
 }
 
 func (r *Repository) FindByIndexTerm(ctx context.Context, logIndex, logTerm uint64) (*model.Message, error) {
   row := r.db.QueryRowContext(ctx,
-    "SELECT id, user_id, createtime, message, file, file_id, log_index, log_term " +
+    "SELECT " + messageColumns + " " +
     "FROM messages WHERE log_index = ? AND log_term = ?",
     logIndex, logTerm,
   )
 
-  var msg model.Message
-  var fileCol sql.NullString
-  var fileIdCol sql.NullString
-  var logIndexCol sql.NullInt64
-  var logTermCol sql.NullInt64
-  if err := row.Scan(
-    &msg.Id,
-    &msg.UserId,
-    &msg.CreateTime,
-    &msg.Value,
-    &fileCol,
-    &fileIdCol,
-    &logIndexCol,
-    &logTermCol,
-  ); err != nil {
+  msg, err := scanMessage(row)
+  if err != nil {
     if errors.Is(err, sql.ErrNoRows) {
       return nil, repository.ErrNotFound
     }
     return nil, err
   }
-  if fileCol.Valid {
-    msg.FileName = fileCol.String
-  }
-  if fileIdCol.Valid {
-    msg.FileId = model.FileId(fileIdCol.String)
-  }
-  if logIndexCol.Valid {
-    msg.LogIndex = uint64(logIndexCol.Int64)
-  }
-  if logTermCol.Valid {
-    msg.LogTerm = uint64(logTermCol.Int64)
+  if err := loadAttachments(ctx, r.db, []*model.Message{msg}); err != nil {
+    return nil, err
   }
-  return &msg, nil
+  return msg, nil
 }
 
 const ascStmt = `
-SELECT id, user_id, createtime, message, file, file_id, log_index, log_term 
+SELECT ` + messageColumns + `
 FROM messages
-WHERE user_id = ?
+WHERE user_id = ? AND deleted_at IS NULL
 ORDER BY id ASC
 LIMIT ? OFFSET ?
 `
 
 const descStmt = `
-SELECT id, user_id, createtime, message, file, file_id, log_index, log_term 
+SELECT ` + messageColumns + `
 FROM messages
-WHERE user_id = ?
+WHERE user_id = ? AND deleted_at IS NULL
 ORDER BY id DESC
 LIMIT ? OFFSET ?
 `
@@ -154,77 +275,25 @@ func (r *Repository) Get(
     return nil, err
   }
 
-  defer rows.Close()
-
-  var res []*model.Message
-  for rows.Next() {
-    var id int
-    var userId int
-    var createtime string
-    var value string
-    var fileCol sql.NullString
-    var fileIdCol sql.NullString
-    var logIndexCol sql.NullInt64
-    var logTermCol sql.NullInt64
-    if err := rows.Scan(
-      &id,
-      &userId,
-      &createtime,
-      &value,
-      &fileCol,
-      &fileIdCol,
-      &logIndexCol,
-      &logTermCol,
-    ); err != nil {
-      return nil, err
-    }
-
-    var fileName string
-    if fileCol.Valid {
-      fileName = fileCol.String
-    }
-    var fileId string
-    if fileIdCol.Valid {
-      fileId = fileIdCol.String
-    }
-    var logIndex uint64
-    if logIndexCol.Valid {
-      logIndex = uint64(logIndexCol.Int64)
-    }
-    var logTerm uint64
-    if logTermCol.Valid {
-      logTerm = uint64(logTermCol.Int64)
-    }
-    res = append(res, &model.Message{
-      Id: model.MessageId(id),
-      UserId: userId,
-      CreateTime: createtime,
-      Value: value,
-      FileName: fileName,
-      FileId: model.FileId(fileId),
-      LogIndex: logIndex,
-      LogTerm: logTerm,
-    })
+  res, err := scanMessages(rows)
+  rows.Close()
+  if err != nil {
+    return nil, err
+  }
+  if err := loadAttachments(ctx, r.db, res); err != nil {
+    return nil, err
   }
 
   if int32(len(res)) < limit {
     isLastPage = true
   } else {
     row := r.db.QueryRowContext(ctx,
-      "SELECT COUNT(*) FROM messages WHERE user_id = ?",
+      "SELECT COUNT(*) FROM messages WHERE user_id = ? AND deleted_at IS NULL",
       int(userId),
     )
-    if err != nil {
-      isLastPage = false
-    } else {
-      var countMessages int32
-      if err := row.Scan(&countMessages); err != nil {
-        isLastPage = false
-      }
-
-      if countMessages <= offset + limit {
-        isLastPage = true
-      }
+    var countMessages int32
+    if err := row.Scan(&countMessages); err == nil && countMessages <= offset + limit {
+      isLastPage = true
     }
   }
 
@@ -243,124 +312,106 @@ func (r *Repository) GetOne(
   error,
 ) {
   row := r.db.QueryRowContext(ctx,
-    "SELECT id, user_id, createtime, message, file, file_id, log_index, log_term " +
-    "FROM messages WHERE user_id = ? AND id = ?",
+    "SELECT " + messageColumns + " " +
+    "FROM messages WHERE user_id = ? AND id = ? AND deleted_at IS NULL",
     int(userId), int(id),
   )
 
-  var msg model.Message
-  var fileCol sql.NullString
-  var fileIdCol sql.NullString
-  var logIndexCol sql.NullInt64
-  var logTermCol sql.NullInt64
-  if err := row.Scan(
-    &msg.Id,
-    &msg.UserId,
-    &msg.CreateTime,
-    &msg.Value,
-    &fileCol,
-    &fileIdCol,
-    &logIndexCol,
-    &logTermCol,
-  ); err != nil {
+  msg, err := scanMessage(row)
+  if err != nil {
     if errors.Is(err, sql.ErrNoRows) {
-      return &msg, repository.ErrNotFound
+      return &model.Message{}, repository.ErrNotFound
     }
-    return &msg, err
-  }
-  if fileCol.Valid {
-    msg.FileName = fileCol.String
-  }
-  if fileIdCol.Valid {
-    msg.FileId = model.FileId(fileIdCol.String)
+    return &model.Message{}, err
   }
-  if logIndexCol.Valid {
-    msg.LogIndex = uint64(logIndexCol.Int64)
-  }
-  if logTermCol.Valid {
-    msg.LogTerm = uint64(logTermCol.Int64)
+  if err := loadAttachments(ctx, r.db, []*model.Message{msg}); err != nil {
+    return &model.Message{}, err
   }
-  return &msg, nil
+  return msg, nil
 }
 
 func (r *Repository) PutBatch(ctx context.Context, batch []*model.Message) error {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
   for _, msg := range batch {
-    _, err := r.insertSt.ExecContext(ctx,
-      msg.UserId,
-      msg.CreateTime,
-      msg.Value,
-      msg.FileName,
-      msg.FileId,
-      msg.LogIndex,
-      msg.LogTerm,
-    )
-    if err != nil {
+    if _, err := put(ctx, tx, msg); err != nil {
       return err
     }
   }
-  return nil
+  return tx.Commit()
 }
 
 func (r *Repository) GetBatch(ctx context.Context) ([]*model.Message, error) {
-  rows, err := r.db.QueryContext(ctx,
-    "SELECT id, user_id, createtime, message, file, file_id, log_index, log_term " +
-    "FROM messages",
+  return queryMessages(ctx, r.db,
+    "SELECT " + messageColumns + " FROM messages",
   )
+}
+
+/**
+ * Deletes up to limit oldest user messages, appended to log
+ * before logIndex, returns deleted ones. Called from fsm,
+ * so every replica deletes the same batch, log replay included.
+ * Deleted ones leave tombstones of logIndex seq
+ */
+func (r *Repository) DeleteUserMessages(
+  ctx context.Context,
+  userId usermodel.UserId,
+  logIndex uint64,
+  limit int32,
+  deleteTime string,
+) (
+  []*model.Message,
+  []model.FileId,
+  error,
+) {
+  tx, err := r.db.BeginTx(ctx, nil)
   if err != nil {
-    return nil, err
+    return nil, nil, err
   }
-  defer rows.Close()
+  defer tx.Rollback()
 
-  var res []*model.Message
-  for rows.Next() {
-    var id int
-    var userId int
-    var value string
-    var createtime string
-    var fileCol sql.NullString
-    var fileIdCol sql.NullString
-    var logIndexCol sql.NullInt64
-    var logTermCol sql.NullInt64
-    if err := rows.Scan(
-      &id,
-      &userId,
-      &createtime,
-      &value,
-      &fileCol,
-      &fileIdCol,
-      &logIndexCol,
-      &logTermCol,
+  res, err := queryMessages(ctx, tx,
+    "SELECT " + messageColumns + " " +
+    "FROM messages WHERE user_id = ? AND (log_index IS NULL OR log_index < ?) " +
+    "ORDER BY id ASC LIMIT ?",
+    int(userId), logIndex, limit,
+  )
+  if err != nil {
+    return nil, nil, err
+  }
+
+  var files []model.FileId
+  for _, msg := range res {
+    if _, err := tx.ExecContext(ctx,
+      "DELETE FROM messages WHERE id = ?",
+      int(msg.Id),
     ); err != nil {
-      return nil, err
+      return nil, nil, err
     }
-
-    var fileName string
-    if fileCol.Valid {
-      fileName = fileCol.String
+    if err := deleteAttachments(ctx, tx, msg.Id); err != nil {
+      return nil, nil, err
     }
-    var fileId string
-    if fileIdCol.Valid {
-      fileId = fileIdCol.String
+    if err := addUsage(ctx, tx, msg, -1); err != nil {
+      return nil, nil, err
     }
-    var logIndex uint64
-    if logIndexCol.Valid {
-      logIndex = uint64(logIndexCol.Int64)
+    freed, err := deleteVersions(ctx, tx, msg)
+    if err != nil {
+      return nil, nil, err
     }
-    var logTerm uint64
-    if logTermCol.Valid {
-      logTerm = uint64(logTermCol.Int64)
+    files = append(files, freed...)
+    if err := putTombstone(ctx, tx, &model.Tombstone{
+      Id: msg.Id,
+      UserId: msg.UserId,
+      Seq: logIndex,
+      DeleteTime: deleteTime,
+    }); err != nil {
+      return nil, nil, err
     }
-    res = append(res, &model.Message{
-      Id: model.MessageId(id),
-      UserId: userId,
-      CreateTime: createtime,
-      Value: value,
-      FileName: fileName,
-      FileId: model.FileId(fileId),
-      LogIndex: logIndex,
-      LogTerm: logTerm,
-    })
   }
 
-  return res, nil
-}
\ No newline at end of file
+  return res, files, tx.Commit()
+}
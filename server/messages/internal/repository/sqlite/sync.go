@@ -0,0 +1,177 @@
+package repository
+
+import (
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * Changes returns up to limit messages and up to limit
+ * tombstones of user changed after since, in seq order.
+ * Zero userId returns changes of all users
+ */
+func (r *Repository) Changes(
+  ctx context.Context,
+  userId usermodel.UserId,
+  since uint64,
+  limit int32,
+) (
+  []*model.Message,
+  []*model.Tombstone,
+  error,
+) {
+  msgs, err := queryMessages(ctx, r.db,
+    "SELECT " + messageColumns + " " +
+    "FROM messages WHERE (? = 0 OR user_id = ?) AND seq > ? AND deleted_at IS NULL " +
+    "ORDER BY seq ASC LIMIT ?",
+    int(userId), int(userId), since, limit,
+  )
+  if err != nil {
+    return nil, nil, err
+  }
+
+  rows, err := r.db.QueryContext(ctx,
+    "SELECT message_id, user_id, seq, deletetime " +
+    "FROM tombstones WHERE (? = 0 OR user_id = ?) AND seq > ? " +
+    "ORDER BY seq ASC, message_id ASC LIMIT ?",
+    int(userId), int(userId), since, limit,
+  )
+  if err != nil {
+    return nil, nil, err
+  }
+  defer rows.Close()
+
+  tombstones, err := scanTombstones(rows)
+  if err != nil {
+    return nil, nil, err
+  }
+  return msgs, tombstones, nil
+}
+
+// SyncFloor is seq user tombstones are compacted up to, highest of all for zero userId
+func (r *Repository) SyncFloor(ctx context.Context, userId usermodel.UserId) (uint64, error) {
+  var seq uint64
+  err := r.db.QueryRowContext(ctx,
+    "SELECT COALESCE(MAX(seq), 0) FROM sync_floors WHERE (? = 0 OR user_id = ?)",
+    int(userId), int(userId),
+  ).Scan(&seq)
+  return seq, err
+}
+
+/**
+ * CompactTombstones drops tombstones deleted before
+ * the time and raises floors of their users
+ */
+func (r *Repository) CompactTombstones(ctx context.Context, before string) (int, error) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return 0, err
+  }
+  defer tx.Rollback()
+
+  if _, err := tx.ExecContext(ctx,
+    "INSERT INTO sync_floors(user_id, seq) " +
+    "SELECT user_id, MAX(seq) FROM tombstones WHERE deletetime < ? GROUP BY user_id " +
+    "ON CONFLICT(user_id) DO UPDATE SET seq = MAX(seq, excluded.seq)",
+    before,
+  ); err != nil {
+    return 0, err
+  }
+
+  res, err := tx.ExecContext(ctx,
+    "DELETE FROM tombstones WHERE deletetime < ?",
+    before,
+  )
+  if err != nil {
+    return 0, err
+  }
+  n, _ := res.RowsAffected()
+  return int(n), tx.Commit()
+}
+
+// GetSyncState returns all tombstones and floors, for snapshot
+func (r *Repository) GetSyncState(ctx context.Context) ([]*model.Tombstone, []*model.SyncFloor, error) {
+  rows, err := r.db.QueryContext(ctx,
+    "SELECT message_id, user_id, seq, deletetime FROM tombstones",
+  )
+  if err != nil {
+    return nil, nil, err
+  }
+  tombstones, err := scanTombstones(rows)
+  rows.Close()
+  if err != nil {
+    return nil, nil, err
+  }
+
+  rows, err = r.db.QueryContext(ctx, "SELECT user_id, seq FROM sync_floors")
+  if err != nil {
+    return nil, nil, err
+  }
+  defer rows.Close()
+
+  var floors []*model.SyncFloor
+  for rows.Next() {
+    var floor model.SyncFloor
+    if err := rows.Scan(&floor.UserId, &floor.Seq); err != nil {
+      return nil, nil, err
+    }
+    floors = append(floors, &floor)
+  }
+  return tombstones, floors, rows.Err()
+}
+
+// PutSyncState saves tombstones and floors of restored snapshot
+func (r *Repository) PutSyncState(
+  ctx context.Context,
+  tombstones []*model.Tombstone,
+  floors []*model.SyncFloor,
+) error {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for _, tombstone := range tombstones {
+    if err := putTombstone(ctx, tx, tombstone); err != nil {
+      return err
+    }
+  }
+  for _, floor := range floors {
+    if _, err := tx.ExecContext(ctx,
+      "INSERT OR REPLACE INTO sync_floors(user_id, seq) VALUES (?,?)",
+      floor.UserId, floor.Seq,
+    ); err != nil {
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+func putTombstone(ctx context.Context, tx *sql.Tx, tombstone *model.Tombstone) error {
+  _, err := tx.ExecContext(ctx,
+    "INSERT OR REPLACE INTO tombstones(message_id, user_id, seq, deletetime) VALUES (?,?,?,?)",
+    int(tombstone.Id), tombstone.UserId, tombstone.Seq, tombstone.DeleteTime,
+  )
+  return err
+}
+
+func scanTombstones(rows *sql.Rows) ([]*model.Tombstone, error) {
+  var res []*model.Tombstone
+  for rows.Next() {
+    var tombstone model.Tombstone
+    if err := rows.Scan(
+      &tombstone.Id,
+      &tombstone.UserId,
+      &tombstone.Seq,
+      &tombstone.DeleteTime,
+    ); err != nil {
+      return nil, err
+    }
+    res = append(res, &tombstone)
+  }
+  return res, rows.Err()
+}
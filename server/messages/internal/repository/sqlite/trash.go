@@ -0,0 +1,210 @@
+package repository
+
+import (
+  "errors"
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * TrashMessage marks user message deleted at deleteTime
+ * and leaves tombstone of seq for delta sync. Message
+ * changed at seq or later is not found, so replayed
+ * record never trashes restored message again.
+ * Trashed message still counts in usage till purged
+ */
+func (r *Repository) TrashMessage(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  seq uint64,
+  deleteTime string,
+) (
+  *model.Message,
+  error,
+) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return nil, err
+  }
+  defer tx.Rollback()
+
+  res, err := tx.ExecContext(ctx,
+    "UPDATE messages SET deleted_at = ?, seq = ? " +
+    "WHERE user_id = ? AND id = ? AND deleted_at IS NULL AND seq < ?",
+    deleteTime, seq, int(userId), int(id), seq,
+  )
+  if err != nil {
+    return nil, err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return nil, repository.ErrNotFound
+  }
+
+  if err := putTombstone(ctx, tx, &model.Tombstone{
+    Id: id,
+    UserId: int(userId),
+    Seq: seq,
+    DeleteTime: deleteTime,
+  }); err != nil {
+    return nil, err
+  }
+
+  msg, err := getMessage(ctx, tx, userId, id)
+  if err != nil {
+    return nil, err
+  }
+  return msg, tx.Commit()
+}
+
+// RestoreMessage brings trashed message back, its tombstone is dropped
+func (r *Repository) RestoreMessage(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  seq uint64,
+) (
+  *model.Message,
+  error,
+) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return nil, err
+  }
+  defer tx.Rollback()
+
+  res, err := tx.ExecContext(ctx,
+    "UPDATE messages SET deleted_at = NULL, seq = ? " +
+    "WHERE user_id = ? AND id = ? AND deleted_at IS NOT NULL AND seq < ?",
+    seq, int(userId), int(id), seq,
+  )
+  if err != nil {
+    return nil, err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return nil, repository.ErrNotFound
+  }
+
+  if _, err := tx.ExecContext(ctx,
+    "DELETE FROM tombstones WHERE message_id = ?",
+    int(id),
+  ); err != nil {
+    return nil, err
+  }
+
+  msg, err := getMessage(ctx, tx, userId, id)
+  if err != nil {
+    return nil, err
+  }
+  return msg, tx.Commit()
+}
+
+// GetTrash returns trashed user messages, recently deleted first
+func (r *Repository) GetTrash(
+  ctx context.Context,
+  userId usermodel.UserId,
+  limit int32,
+  offset int32,
+) (
+  *model.MessagesList,
+  error,
+) {
+  // one more tells there is next page
+  fetch := limit
+  if limit >= 0 {
+    fetch = limit + 1
+  }
+  res, err := queryMessages(ctx, r.db,
+    "SELECT " + messageColumns + " FROM messages " +
+    "WHERE user_id = ? AND deleted_at IS NOT NULL " +
+    "ORDER BY deleted_at DESC, id DESC LIMIT ? OFFSET ?",
+    int(userId), fetch, offset,
+  )
+  if err != nil {
+    return nil, err
+  }
+
+  isLastPage := limit < 0 || int32(len(res)) <= limit
+  if !isLastPage {
+    res = res[:limit]
+  }
+  return &model.MessagesList{
+    Messages: res,
+    IsLastPage: isLastPage,
+  }, nil
+}
+
+/**
+ * PurgeTrash deletes up to limit messages trashed at before
+ * or earlier and before logIndex, of user or of all users
+ * for zero userId. Returns deleted messages and files of
+ * their versions, out of usage. Tombstones are left from trash
+ */
+func (r *Repository) PurgeTrash(
+  ctx context.Context,
+  userId usermodel.UserId,
+  before string,
+  logIndex uint64,
+  limit int32,
+) (
+  []*model.Message,
+  []model.FileId,
+  error,
+) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return nil, nil, err
+  }
+  defer tx.Rollback()
+
+  res, err := queryMessages(ctx, tx,
+    "SELECT " + messageColumns + " FROM messages " +
+    "WHERE deleted_at IS NOT NULL AND deleted_at <= ? AND seq < ? AND (? = 0 OR user_id = ?) " +
+    "ORDER BY deleted_at ASC, id ASC LIMIT ?",
+    before, logIndex, int(userId), int(userId), limit,
+  )
+  if err != nil {
+    return nil, nil, err
+  }
+
+  var files []model.FileId
+  for _, msg := range res {
+    if _, err := tx.ExecContext(ctx,
+      "DELETE FROM messages WHERE id = ?",
+      int(msg.Id),
+    ); err != nil {
+      return nil, nil, err
+    }
+    if err := deleteAttachments(ctx, tx, msg.Id); err != nil {
+      return nil, nil, err
+    }
+    if err := addUsage(ctx, tx, msg, -1); err != nil {
+      return nil, nil, err
+    }
+    freed, err := deleteVersions(ctx, tx, msg)
+    if err != nil {
+      return nil, nil, err
+    }
+    files = append(files, freed...)
+  }
+  return res, files, tx.Commit()
+}
+
+// getMessage returns user message, trashed one too
+func getMessage(ctx context.Context, tx *sql.Tx, userId usermodel.UserId, id model.MessageId) (*model.Message, error) {
+  msg, err := scanMessage(tx.QueryRowContext(ctx,
+    "SELECT " + messageColumns + " FROM messages WHERE user_id = ? AND id = ?",
+    int(userId), int(id),
+  ))
+  if errors.Is(err, sql.ErrNoRows) {
+    return nil, repository.ErrNotFound
+  }
+  if err != nil {
+    return nil, err
+  }
+  return msg, loadAttachments(ctx, tx, []*model.Message{msg})
+}
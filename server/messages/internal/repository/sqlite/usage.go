@@ -0,0 +1,102 @@
+package repository
+
+import (
+  "context"
+  "errors"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+// GetUsage returns zero usage for user without messages
+func (r *Repository) GetUsage(ctx context.Context, userId usermodel.UserId) (*model.Usage, error) {
+  usage := &model.Usage{UserId: int(userId)}
+  err := r.db.QueryRowContext(ctx,
+    "SELECT bytes, files, messages FROM usage WHERE user_id = ?",
+    int(userId),
+  ).Scan(&usage.Bytes, &usage.Files, &usage.Messages)
+  if err != nil && !errors.Is(err, sql.ErrNoRows) {
+    return nil, err
+  }
+  return usage, nil
+}
+
+// ListUsage orders users by bytes used, largest first
+func (r *Repository) ListUsage(ctx context.Context, limit, offset int32) ([]*model.Usage, error) {
+  rows, err := r.db.QueryContext(ctx,
+    "SELECT user_id, bytes, files, messages FROM usage " +
+    "WHERE messages > 0 ORDER BY bytes DESC, user_id ASC LIMIT ? OFFSET ?",
+    limit, offset,
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.Usage
+  for rows.Next() {
+    var usage model.Usage
+    if err := rows.Scan(&usage.UserId, &usage.Bytes, &usage.Files, &usage.Messages); err != nil {
+      return nil, err
+    }
+    res = append(res, &usage)
+  }
+  return res, rows.Err()
+}
+
+// GetQuota returns repository.ErrNotFound when user has no own quota
+func (r *Repository) GetQuota(ctx context.Context, userId usermodel.UserId) (*model.Quota, error) {
+  var quota model.Quota
+  err := r.db.QueryRowContext(ctx,
+    "SELECT max_bytes, max_files FROM quotas WHERE user_id = ?",
+    int(userId),
+  ).Scan(&quota.MaxBytes, &quota.MaxFiles)
+  if errors.Is(err, sql.ErrNoRows) {
+    return nil, repository.ErrNotFound
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &quota, nil
+}
+
+func (r *Repository) GetQuotas(ctx context.Context) ([]*model.UserQuota, error) {
+  rows, err := r.db.QueryContext(ctx,
+    "SELECT user_id, max_bytes, max_files FROM quotas",
+  )
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.UserQuota
+  for rows.Next() {
+    var quota model.UserQuota
+    if err := rows.Scan(&quota.UserId, &quota.MaxBytes, &quota.MaxFiles); err != nil {
+      return nil, err
+    }
+    res = append(res, &quota)
+  }
+  return res, rows.Err()
+}
+
+// SetQuota sets user quota, nil quota drops it
+func (r *Repository) SetQuota(ctx context.Context, userId usermodel.UserId, quota *model.Quota) error {
+  if quota == nil {
+    _, err := r.db.ExecContext(ctx,
+      "DELETE FROM quotas WHERE user_id = ?",
+      int(userId),
+    )
+    return err
+  }
+
+  _, err := r.db.ExecContext(ctx,
+    "INSERT INTO quotas(user_id, max_bytes, max_files) VALUES (?,?,?) " +
+    "ON CONFLICT(user_id) DO UPDATE SET " +
+    "max_bytes = excluded.max_bytes, max_files = excluded.max_files",
+    int(userId), quota.MaxBytes, quota.MaxFiles,
+  )
+  return err
+}
@@ -0,0 +1,357 @@
+package repository
+
+import (
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/messages/pkg/model"
+  "github.com/bd878/gallery/server/messages/internal/repository"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const versionColumns = "message_id, user_id, seq, message, time, replacetime"
+
+/**
+ * UpdateMessage replaces message content and keeps prev
+ * state as version, in one transaction. Usage counts every
+ * file message keeps, current or in versions, once
+ */
+func (r *Repository) UpdateMessage(ctx context.Context, msg *model.Message, prev *model.MessageVersion) error {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  if err := putVersion(ctx, tx, prev); err != nil {
+    return err
+  }
+
+  res, err := tx.ExecContext(ctx,
+    "UPDATE messages SET message = ?, seq = ?, updatetime = ? " +
+    "WHERE id = ? AND user_id = ?",
+    msg.Value, msg.Seq, msg.UpdateTime,
+    int(msg.Id), msg.UserId,
+  )
+  if err != nil {
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrNotFound
+  }
+
+  if err := deleteAttachments(ctx, tx, msg.Id); err != nil {
+    return err
+  }
+  if err := putAttachments(ctx, tx, msg.Id, msg.Attachments); err != nil {
+    return err
+  }
+
+  for _, attachment := range distinctFiles(msg.Attachments) {
+    kept, err := versionKeeps(ctx, tx, msg.Id, attachment.FileId)
+    if err != nil {
+      return err
+    }
+    if !kept {
+      if err := addFileUsage(ctx, tx, msg.UserId, attachment.FileSize, 1); err != nil {
+        return err
+      }
+    }
+  }
+  return tx.Commit()
+}
+
+// GetVersions returns versions of user message, newest first
+func (r *Repository) GetVersions(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+) (
+  []*model.MessageVersion,
+  error,
+) {
+  return queryVersions(ctx, r.db,
+    "SELECT " + versionColumns + " FROM message_versions " +
+    "WHERE user_id = ? AND message_id = ? ORDER BY seq DESC",
+    int(userId), int(id),
+  )
+}
+
+func (r *Repository) GetVersion(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  seq uint64,
+) (
+  *model.MessageVersion,
+  error,
+) {
+  res, err := queryVersions(ctx, r.db,
+    "SELECT " + versionColumns + " FROM message_versions " +
+    "WHERE user_id = ? AND message_id = ? AND seq = ?",
+    int(userId), int(id), seq,
+  )
+  if err != nil {
+    return nil, err
+  }
+  if len(res) == 0 {
+    return nil, repository.ErrNotFound
+  }
+  return res[0], nil
+}
+
+/**
+ * PruneVersions drops versions of user message but keep
+ * newest ones, of versions replaced before logIndex only,
+ * so replayed prune spares later ones. Returns number
+ * dropped and files nothing refers to any more, they are out of usage
+ */
+func (r *Repository) PruneVersions(
+  ctx context.Context,
+  userId usermodel.UserId,
+  id model.MessageId,
+  keep int32,
+  logIndex uint64,
+) (
+  int,
+  []model.FileId,
+  error,
+) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return 0, nil, err
+  }
+  defer tx.Rollback()
+
+  pruned, err := queryVersions(ctx, tx,
+    "SELECT " + versionColumns + " FROM message_versions v " +
+    "WHERE user_id = ? AND message_id = ? AND " + replacedBefore + " " +
+    "ORDER BY seq DESC LIMIT -1 OFFSET ?",
+    int(userId), int(id), logIndex, keep,
+  )
+  if err != nil {
+    return 0, nil, err
+  }
+
+  for _, version := range pruned {
+    if err := deleteVersion(ctx, tx, version.MessageId, version.Seq); err != nil {
+      return 0, nil, err
+    }
+  }
+
+  var freed []model.FileId
+  for _, attachment := range distinctFiles(versionsFiles(pruned)) {
+    kept, err := messageKeeps(ctx, tx, id, attachment.FileId)
+    if err != nil {
+      return 0, nil, err
+    }
+    if kept {
+      continue
+    }
+    if err := addFileUsage(ctx, tx, int(userId), attachment.FileSize, -1); err != nil {
+      return 0, nil, err
+    }
+    freed = append(freed, attachment.FileId)
+  }
+  return len(pruned), freed, tx.Commit()
+}
+
+/**
+ * replacedBefore tells version v was replaced before log index,
+ * state that replaced it is next version or message itself
+ */
+const replacedBefore = "COALESCE(" +
+  "(SELECT MIN(n.seq) FROM message_versions n WHERE n.message_id = v.message_id AND n.seq > v.seq), " +
+  "(SELECT m.seq FROM messages m WHERE m.id = v.message_id)) < ?"
+
+// GetAllVersions returns versions of all messages, for snapshot
+func (r *Repository) GetAllVersions(ctx context.Context) ([]*model.MessageVersion, error) {
+  return queryVersions(ctx, r.db,
+    "SELECT " + versionColumns + " FROM message_versions",
+  )
+}
+
+/**
+ * PutVersions saves versions of restored snapshot, after
+ * messages. Files message does not keep yet count in usage
+ */
+func (r *Repository) PutVersions(ctx context.Context, versions []*model.MessageVersion) error {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for _, version := range versions {
+    version.NormalizeAttachments()
+    for _, attachment := range distinctFiles(version.Attachments) {
+      kept, err := messageKeeps(ctx, tx, version.MessageId, attachment.FileId)
+      if err != nil {
+        return err
+      }
+      if !kept {
+        if err := addFileUsage(ctx, tx, version.UserId, attachment.FileSize, 1); err != nil {
+          return err
+        }
+      }
+    }
+    if err := putVersion(ctx, tx, version); err != nil {
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+/**
+ * deleteVersions drops versions of deleted msg, returns
+ * their files other than msg ones, out of usage
+ */
+func deleteVersions(ctx context.Context, tx *sql.Tx, msg *model.Message) ([]model.FileId, error) {
+  versions, err := queryVersions(ctx, tx,
+    "SELECT " + versionColumns + " FROM message_versions WHERE message_id = ?",
+    int(msg.Id),
+  )
+  if err != nil {
+    return nil, err
+  }
+
+  for _, version := range versions {
+    if err := deleteVersion(ctx, tx, version.MessageId, version.Seq); err != nil {
+      return nil, err
+    }
+  }
+
+  var freed []model.FileId
+  for _, attachment := range distinctFiles(versionsFiles(versions)) {
+    if model.HasFile(msg.Attachments, attachment.FileId) {
+      continue
+    }
+    if err := addFileUsage(ctx, tx, msg.UserId, attachment.FileSize, -1); err != nil {
+      return nil, err
+    }
+    freed = append(freed, attachment.FileId)
+  }
+  return freed, nil
+}
+
+func deleteVersion(ctx context.Context, tx *sql.Tx, id model.MessageId, seq uint64) error {
+  if _, err := tx.ExecContext(ctx,
+    "DELETE FROM message_versions WHERE message_id = ? AND seq = ?",
+    int(id), seq,
+  ); err != nil {
+    return err
+  }
+  _, err := tx.ExecContext(ctx,
+    "DELETE FROM version_attachments WHERE message_id = ? AND seq = ?",
+    int(id), seq,
+  )
+  return err
+}
+
+// versionsFiles are attachments of all versions
+func versionsFiles(versions []*model.MessageVersion) []model.Attachment {
+  var res []model.Attachment
+  for _, version := range versions {
+    res = append(res, version.Attachments...)
+  }
+  return res
+}
+
+// distinctFiles returns one attachment of each file
+func distinctFiles(attachments []model.Attachment) []model.Attachment {
+  seen := make(map[model.FileId]bool)
+  var res []model.Attachment
+  for _, attachment := range attachments {
+    if attachment.FileId == "" || seen[attachment.FileId] {
+      continue
+    }
+    seen[attachment.FileId] = true
+    res = append(res, attachment)
+  }
+  return res
+}
+
+// messageKeeps tells file is attached to message or to one of its versions
+func messageKeeps(ctx context.Context, tx *sql.Tx, id model.MessageId, fileId model.FileId) (bool, error) {
+  var current int
+  err := tx.QueryRowContext(ctx,
+    "SELECT COUNT(*) FROM attachments WHERE message_id = ? AND file_id = ?",
+    int(id), fileId,
+  ).Scan(&current)
+  if err != nil || current > 0 {
+    return current > 0, err
+  }
+  return versionKeeps(ctx, tx, id, fileId)
+}
+
+func versionKeeps(ctx context.Context, tx *sql.Tx, id model.MessageId, fileId model.FileId) (bool, error) {
+  var n int
+  err := tx.QueryRowContext(ctx,
+    "SELECT COUNT(*) FROM version_attachments WHERE message_id = ? AND file_id = ?",
+    int(id), fileId,
+  ).Scan(&n)
+  return n > 0, err
+}
+
+// addFileUsage counts file of size in (sign 1) or out (sign -1)
+func addFileUsage(ctx context.Context, tx *sql.Tx, userId int, size int64, sign int64) error {
+  _, err := tx.ExecContext(ctx,
+    "INSERT INTO usage(user_id, bytes, files, messages) VALUES (?,?,?,0) " +
+    "ON CONFLICT(user_id) DO UPDATE SET " +
+    "bytes = bytes + excluded.bytes, " +
+    "files = files + excluded.files",
+    userId, sign*size, sign,
+  )
+  return err
+}
+
+func putVersion(ctx context.Context, tx *sql.Tx, version *model.MessageVersion) error {
+  if _, err := tx.ExecContext(ctx,
+    "INSERT OR REPLACE INTO message_versions(" + versionColumns + ") VALUES (?,?,?,?,?,?)",
+    int(version.MessageId),
+    version.UserId,
+    version.Seq,
+    version.Value,
+    version.Time,
+    version.ReplaceTime,
+  ); err != nil {
+    return err
+  }
+  return putVersionAttachments(ctx, tx, version)
+}
+
+// queryVersions returns versions of query with their attachments
+func queryVersions(ctx context.Context, q querier, query string, args ...any) ([]*model.MessageVersion, error) {
+  rows, err := q.QueryContext(ctx, query, args...)
+  if err != nil {
+    return nil, err
+  }
+  res, err := scanVersions(rows)
+  rows.Close()
+  if err != nil {
+    return nil, err
+  }
+  if err := loadVersionAttachments(ctx, q, res); err != nil {
+    return nil, err
+  }
+  return res, nil
+}
+
+func scanVersions(rows *sql.Rows) ([]*model.MessageVersion, error) {
+  var res []*model.MessageVersion
+  for rows.Next() {
+    var version model.MessageVersion
+    if err := rows.Scan(
+      &version.MessageId,
+      &version.UserId,
+      &version.Seq,
+      &version.Value,
+      &version.Time,
+      &version.ReplaceTime,
+    ); err != nil {
+      return nil, err
+    }
+    res = append(res, &version)
+  }
+  return res, rows.Err()
+}
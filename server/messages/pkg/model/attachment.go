@@ -0,0 +1,54 @@
+package model
+
+/**
+ * NormalizeAttachments makes single file of message saved
+ * before attachments its only attachment, then fills single
+ * file fields from first attachment
+ */
+func (m *Message) NormalizeAttachments() {
+  m.Attachments = withSingleFile(m.Attachments, m.FileId, m.FileName, m.FileSize)
+  m.FileName, m.FileId, m.FileSize = firstFile(m.Attachments)
+}
+
+func (v *MessageVersion) NormalizeAttachments() {
+  v.Attachments = withSingleFile(v.Attachments, v.FileId, v.FileName, v.FileSize)
+  v.FileName, v.FileId, v.FileSize = firstFile(v.Attachments)
+}
+
+func (u *MessageUpdate) NormalizeAttachments() {
+  u.Attachments = withSingleFile(u.Attachments, u.FileId, u.FileName, u.FileSize)
+  u.FileName, u.FileId, u.FileSize = firstFile(u.Attachments)
+}
+
+// FilesSize is bytes of all attachments
+func FilesSize(attachments []Attachment) int64 {
+  var res int64
+  for _, attachment := range attachments {
+    res += attachment.FileSize
+  }
+  return res
+}
+
+// HasFile tells one of attachments is file
+func HasFile(attachments []Attachment, fileId FileId) bool {
+  for _, attachment := range attachments {
+    if attachment.FileId == fileId {
+      return true
+    }
+  }
+  return false
+}
+
+func withSingleFile(attachments []Attachment, fileId FileId, fileName string, fileSize int64) []Attachment {
+  if len(attachments) > 0 || fileId == "" {
+    return attachments
+  }
+  return []Attachment{{FileId: fileId, FileName: fileName, FileSize: fileSize}}
+}
+
+func firstFile(attachments []Attachment) (string, FileId, int64) {
+  if len(attachments) == 0 {
+    return "", "", 0
+  }
+  return attachments[0].FileName, attachments[0].FileId, attachments[0].FileSize
+}
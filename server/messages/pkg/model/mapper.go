@@ -3,14 +3,22 @@ package model
 import "github.com/bd878/gallery/server/api"
 
 func MessageFromProto(proto *api.Message) *Message {
-  return &Message{
+  msg := &Message{
     Id:          MessageId(proto.Id),
     CreateTime:  proto.CreateTime,
     UserId:      int(proto.UserId),
     Value:       string(proto.Value),
     FileName:    proto.FileName,
     FileId:      FileId(proto.FileId),
+    FileSize:    proto.FileSize,
+    Seq:         proto.Seq,
+    ClientId:    proto.ClientId,
+    UpdateTime:  proto.UpdateTime,
+    DeletedAt:   proto.DeletedAt,
+    Attachments: MapAttachmentsFromProto(proto.Attachments),
   }
+  msg.NormalizeAttachments()
+  return msg
 }
 
 func MessageToProto(msg *Message) *api.Message {
@@ -21,6 +29,12 @@ func MessageToProto(msg *Message) *api.Message {
     Value:       []byte(msg.Value),
     FileName:    msg.FileName,
     FileId:      string(msg.FileId),
+    FileSize:    msg.FileSize,
+    Seq:         msg.Seq,
+    ClientId:    msg.ClientId,
+    UpdateTime:  msg.UpdateTime,
+    DeletedAt:   msg.DeletedAt,
+    Attachments: MapAttachmentsToProto(msg.Attachments),
   }
 }
 
@@ -38,4 +52,202 @@ func MapMessagesFromProto(mapper (func(*api.Message) *Message), msgs []*api.Mess
     res[i] = mapper(msg)
   }
   return res
-}
\ No newline at end of file
+}
+func UsageFromProto(proto *api.Usage) *Usage {
+  return &Usage{
+    UserId:      int(proto.UserId),
+    Bytes:       proto.Bytes,
+    Files:       proto.Files,
+    Messages:    proto.Messages,
+  }
+}
+
+func UsageToProto(usage *Usage) *api.Usage {
+  return &api.Usage{
+    UserId:      uint32(usage.UserId),
+    Bytes:       usage.Bytes,
+    Files:       usage.Files,
+    Messages:    usage.Messages,
+  }
+}
+
+func QuotaFromProto(proto *api.Quota) *Quota {
+  if proto == nil {
+    return &Quota{}
+  }
+  return &Quota{
+    MaxBytes:    proto.MaxBytes,
+    MaxFiles:    proto.MaxFiles,
+  }
+}
+
+func QuotaToProto(quota *Quota) *api.Quota {
+  return &api.Quota{
+    MaxBytes:    quota.MaxBytes,
+    MaxFiles:    quota.MaxFiles,
+  }
+}
+
+func EventFromProto(proto *api.MessageEvent) *Event {
+  event := &Event{
+    Id:          proto.Id,
+    Type:        proto.Type,
+    UserId:      int(proto.UserId),
+  }
+  if proto.Message != nil {
+    event.Message = MessageFromProto(proto.Message)
+  }
+  for _, id := range proto.MessageIds {
+    event.MessageIds = append(event.MessageIds, MessageId(id))
+  }
+  return event
+}
+
+func EventToProto(event *Event) *api.MessageEvent {
+  proto := &api.MessageEvent{
+    Id:          event.Id,
+    Type:        event.Type,
+    UserId:      uint32(event.UserId),
+  }
+  if event.Message != nil {
+    proto.Message = MessageToProto(event.Message)
+  }
+  for _, id := range event.MessageIds {
+    proto.MessageIds = append(proto.MessageIds, uint32(id))
+  }
+  return proto
+}
+
+func MessageUpdateFromProto(proto *api.UpdateMessageRequest) *MessageUpdate {
+  update := &MessageUpdate{
+    Id:          MessageId(proto.Id),
+    UserId:      int(proto.UserId),
+    SetValue:    proto.SetValue,
+    Value:       string(proto.Value),
+    SetFile:     proto.SetFile,
+    FileName:    proto.FileName,
+    FileId:      FileId(proto.FileId),
+    FileSize:    proto.FileSize,
+    Attachments: MapAttachmentsFromProto(proto.Attachments),
+  }
+  update.NormalizeAttachments()
+  return update
+}
+
+func MessageUpdateToProto(update *MessageUpdate) *api.UpdateMessageRequest {
+  return &api.UpdateMessageRequest{
+    Id:          uint32(update.Id),
+    UserId:      uint32(update.UserId),
+    SetValue:    update.SetValue,
+    Value:       []byte(update.Value),
+    SetFile:     update.SetFile,
+    FileName:    update.FileName,
+    FileId:      string(update.FileId),
+    FileSize:    update.FileSize,
+    Attachments: MapAttachmentsToProto(update.Attachments),
+  }
+}
+
+func MessageVersionFromProto(proto *api.MessageVersion) *MessageVersion {
+  version := &MessageVersion{
+    MessageId:   MessageId(proto.MessageId),
+    UserId:      int(proto.UserId),
+    Seq:         proto.Seq,
+    Value:       string(proto.Value),
+    FileName:    proto.FileName,
+    FileId:      FileId(proto.FileId),
+    FileSize:    proto.FileSize,
+    Time:        proto.Time,
+    ReplaceTime: proto.ReplaceTime,
+    Attachments: MapAttachmentsFromProto(proto.Attachments),
+  }
+  version.NormalizeAttachments()
+  return version
+}
+
+func MessageVersionToProto(version *MessageVersion) *api.MessageVersion {
+  return &api.MessageVersion{
+    MessageId:   uint32(version.MessageId),
+    UserId:      uint32(version.UserId),
+    Seq:         version.Seq,
+    Value:       []byte(version.Value),
+    FileName:    version.FileName,
+    FileId:      string(version.FileId),
+    FileSize:    version.FileSize,
+    Time:        version.Time,
+    ReplaceTime: version.ReplaceTime,
+    Attachments: MapAttachmentsToProto(version.Attachments),
+  }
+}
+
+func TombstoneFromProto(proto *api.Tombstone) *Tombstone {
+  return &Tombstone{
+    Id:          MessageId(proto.Id),
+    UserId:      int(proto.UserId),
+    Seq:         proto.Seq,
+    DeleteTime:  proto.DeleteTime,
+  }
+}
+
+func TombstoneToProto(tombstone *Tombstone) *api.Tombstone {
+  return &api.Tombstone{
+    Id:          uint32(tombstone.Id),
+    UserId:      uint32(tombstone.UserId),
+    Seq:         tombstone.Seq,
+    DeleteTime:  tombstone.DeleteTime,
+  }
+}
+
+func SyncResultFromProto(proto *api.SyncResponse) *SyncResult {
+  res := &SyncResult{
+    Messages:    MapMessagesFromProto(MessageFromProto, proto.Messages),
+    Tombstones:  make([]*Tombstone, len(proto.Tombstones)),
+    Token:       proto.Token,
+    Resync:      proto.Resync,
+    HasMore:     proto.HasMore,
+  }
+  for i, tombstone := range proto.Tombstones {
+    res.Tombstones[i] = TombstoneFromProto(tombstone)
+  }
+  return res
+}
+
+func SyncResultToProto(res *SyncResult) *api.SyncResponse {
+  proto := &api.SyncResponse{
+    Messages:    MapMessagesToProto(MessageToProto, res.Messages),
+    Tombstones:  make([]*api.Tombstone, len(res.Tombstones)),
+    Token:       res.Token,
+    Resync:      res.Resync,
+    HasMore:     res.HasMore,
+  }
+  for i, tombstone := range res.Tombstones {
+    proto.Tombstones[i] = TombstoneToProto(tombstone)
+  }
+  return proto
+}
+
+func MapAttachmentsFromProto(protos []*api.Attachment) []Attachment {
+  var res []Attachment
+  for _, proto := range protos {
+    res = append(res, Attachment{
+      FileId:      FileId(proto.FileId),
+      FileName:    proto.FileName,
+      FileSize:    proto.FileSize,
+      MimeType:    proto.MimeType,
+    })
+  }
+  return res
+}
+
+func MapAttachmentsToProto(attachments []Attachment) []*api.Attachment {
+  var res []*api.Attachment
+  for _, attachment := range attachments {
+    res = append(res, &api.Attachment{
+      FileId:      string(attachment.FileId),
+      FileName:    attachment.FileName,
+      FileSize:    attachment.FileSize,
+      MimeType:    attachment.MimeType,
+    })
+  }
+  return res
+}
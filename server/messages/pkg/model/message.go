@@ -1,7 +1,19 @@
 package model
 
+import "time"
+
 type MessageId int
 
+/**
+ * TimeLayout is rfc3339 utc with milliseconds. Every stored
+ * time has it, fixed width lets repositories compare them as strings
+ */
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+func FormatTime(t time.Time) string {
+  return t.UTC().Format(TimeLayout)
+}
+
 type FileId string
 
 // This message handler passes to repository
@@ -10,10 +22,173 @@ type Message struct {
   CreateTime string  `json:"createtime"`
   UserId int         `json:"userid"`
   Value string       `json:"value"`
+  // first attachment, for clients before attachments
   FileName string    `json:"filename"`
   FileId FileId      `json:"fileid"`
+  FileSize int64     `json:"filesize,omitempty"`
+  Attachments []Attachment `json:"attachments,omitempty"`
   LogIndex uint64    `json:"logindex,omitempty"`
   LogTerm uint64     `json:"logterm,omitempty"`
+  // log index of last change, see Sync
+  Seq uint64         `json:"seq,omitempty"`
+  // client key of save request, retry with it returns same message
+  ClientId string    `json:"clientid,omitempty"`
+  // leader time of last update, empty for never updated
+  UpdateTime string  `json:"updatetime,omitempty"`
+  // leader time message went to trash, empty for live one
+  DeletedAt string   `json:"deletedat,omitempty"`
+}
+
+/**
+ * File of message. Message keeps attachments
+ * in the order they were sent
+ */
+type Attachment struct {
+  FileId FileId      `json:"fileid"`
+  FileName string    `json:"filename"`
+  FileSize int64     `json:"filesize"`
+  MimeType string    `json:"mimetype,omitempty"`
+}
+
+/**
+ * Change of message text or attachments, parts not set
+ * are kept. Set file replaces attachments, none removes
+ * them. Single file fields are of clients before attachments
+ */
+type MessageUpdate struct {
+  Id MessageId       `json:"id"`
+  UserId int         `json:"userid"`
+  SetValue bool      `json:"setvalue,omitempty"`
+  Value string       `json:"value,omitempty"`
+  SetFile bool       `json:"setfile,omitempty"`
+  FileName string    `json:"filename,omitempty"`
+  FileId FileId      `json:"fileid,omitempty"`
+  FileSize int64     `json:"filesize,omitempty"`
+  Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+/**
+ * Earlier state of message, kept on every change till
+ * pruned. Seq is log index the state was made at, it
+ * names the version. Time is when state was made,
+ * ReplaceTime when next one took its place
+ */
+type MessageVersion struct {
+  MessageId MessageId  `json:"messageid"`
+  UserId int           `json:"userid"`
+  Seq uint64           `json:"seq"`
+  Value string         `json:"value"`
+  FileName string      `json:"filename"`
+  FileId FileId        `json:"fileid"`
+  FileSize int64       `json:"filesize,omitempty"`
+  Attachments []Attachment `json:"attachments,omitempty"`
+  Time string          `json:"time"`
+  ReplaceTime string   `json:"replacetime"`
+}
+
+// Result of pruning message versions, applied through raft
+type PruneResult struct {
+  VersionsDeleted int `json:"versionsdeleted"`
+  FilesDeleted int    `json:"filesdeleted"`
+}
+
+/**
+ * Saved message of client key, replicated so retried
+ * save finds it on any leader till expires
+ */
+type IdempotencyKey struct {
+  UserId int           `json:"userid"`
+  Key string           `json:"key"`
+  MessageId MessageId  `json:"messageid"`
+  Expires string       `json:"expires"`
+  // payload hash, retry with other payload is refused
+  Hash string          `json:"hash,omitempty"`
+}
+
+// Left of deleted message for delta sync, till compacted
+type Tombstone struct {
+  Id MessageId       `json:"id"`
+  UserId int         `json:"userid"`
+  Seq uint64         `json:"seq"`
+  DeleteTime string  `json:"deletetime"`
+}
+
+/**
+ * Tombstones of user up to Seq are compacted,
+ * clients synced before it must resync
+ */
+type SyncFloor struct {
+  UserId int  `json:"userid"`
+  Seq uint64  `json:"seq"`
+}
+
+/**
+ * Changes after client token. Token is the one to ask next
+ * time, Resync tells changes are lost and client must start
+ * over with zero token, HasMore asks for next page at once
+ */
+type SyncResult struct {
+  Messages []*Message      `json:"messages"`
+  Tombstones []*Tombstone  `json:"tombstones"`
+  Token uint64             `json:"token"`
+  Resync bool              `json:"resync"`
+  HasMore bool             `json:"hasmore"`
+}
+
+// Result of one purge step, applied through raft
+type PurgeResult struct {
+  MessagesDeleted int `json:"messagesdeleted"`
+  FilesDeleted int    `json:"filesdeleted"`
+}
+
+// Storage used by user, kept by fsm along with messages
+type Usage struct {
+  UserId int     `json:"userid"`
+  Bytes int64    `json:"bytes"`
+  Files int64    `json:"files"`
+  Messages int64 `json:"messages"`
+}
+
+// Per-user limits, zero is unlimited
+type Quota struct {
+  MaxBytes int64 `json:"maxbytes"`
+  MaxFiles int64 `json:"maxfiles"`
+}
+
+// Allows reports whether usage grows by files of size bytes in total
+func (q *Quota) Allows(usage *Usage, size int64, files int64) bool {
+  if q == nil {
+    return true
+  }
+  if q.MaxBytes > 0 && usage.Bytes + size > q.MaxBytes {
+    return false
+  }
+  if files > 0 && q.MaxFiles > 0 && usage.Files + files > q.MaxFiles {
+    return false
+  }
+  return true
+}
+
+type UserQuota struct {
+  UserId int   `json:"userid"`
+  Quota
+}
+
+const (
+  EventCreated = "created"
+  EventUpdated = "updated"
+  EventDeleted = "deleted"
+  // events after client last id are gone, reload messages
+  EventResync = "resync"
+)
+
+// Change of user messages, id is raft log index of it
+type Event struct {
+  Id uint64               `json:"id"`
+  Type string             `json:"type"`
+  UserId int              `json:"userid"`
+  Message *Message        `json:"message,omitempty"`
+  MessageIds []MessageId  `json:"messageids,omitempty"`
 }
 
 type MessagesList struct {
@@ -32,10 +207,48 @@ type NewMessageServerResponse struct {
   Message Message `json:"message"`
 }
 
+// Current message and its versions, newest first
+type HistoryServerResponse struct {
+  ServerResponse
+  Message Message                `json:"message"`
+  Versions []*MessageVersion     `json:"versions"`
+}
+
+type PruneServerResponse struct {
+  ServerResponse
+  PruneResult
+}
+
+type PurgeServerResponse struct {
+  ServerResponse
+  PurgeResult
+}
+
+type SyncServerResponse struct {
+  ServerResponse
+  SyncResult
+}
+
 type MessagesListServerResponse struct {
   ServerResponse
   Messages   []*Message `json:"messages"`
   IsLastPage bool       `json:"islastpage"`
 }
 
+// Usage along with effective quota, user own or default
+type UserUsage struct {
+  Usage Usage `json:"usage"`
+  Quota Quota `json:"quota"`
+}
+
+type UsageServerResponse struct {
+  ServerResponse
+  UserUsage
+}
+
+type UsageListServerResponse struct {
+  ServerResponse
+  Usages []*UserUsage `json:"usages"`
+}
+
 const NullMsgId = MessageId(0)
@@ -0,0 +1,75 @@
+package cookie
+
+import (
+  "time"
+  "errors"
+  "strings"
+  "net/http"
+)
+
+var ErrSameSiteNone = errors.New("SameSite=None requires Secure")
+
+// Attributes shared by cookies a service sets.
+// SameSite is "lax", "strict", "none" or empty for browser default
+type Config struct {
+  Domain string `json:"domain"`
+  Path string `json:"path"`
+  HttpOnly bool `json:"httpOnly"`
+  Secure bool `json:"secure"`
+  SameSite string `json:"sameSite"`
+}
+
+func (c Config) Validate() error {
+  sameSite, err := parseSameSite(c.SameSite)
+  if err != nil {
+    return err
+  }
+  if sameSite == http.SameSiteNoneMode && !c.Secure {
+    return ErrSameSiteNone
+  }
+  return nil
+}
+
+// New makes cookie with configured attributes,
+// zero expires makes session cookie
+func (c Config) New(name, value string, expires time.Time) *http.Cookie {
+  sameSite, _ := parseSameSite(c.SameSite)
+
+  path := c.Path
+  if path == "" {
+    path = "/"
+  }
+
+  return &http.Cookie{
+    Name: name,
+    Value: value,
+    Domain: c.Domain,
+    Path: path,
+    Expires: expires,
+    HttpOnly: c.HttpOnly,
+    Secure: c.Secure,
+    SameSite: sameSite,
+  }
+}
+
+// Expire makes cookie that removes the named one
+func (c Config) Expire(name string) *http.Cookie {
+  cookie := c.New(name, "", time.Time{})
+  cookie.MaxAge = -1
+  return cookie
+}
+
+func parseSameSite(value string) (http.SameSite, error) {
+  switch strings.ToLower(value) {
+  case "":
+    return http.SameSiteDefaultMode, nil
+  case "lax":
+    return http.SameSiteLaxMode, nil
+  case "strict":
+    return http.SameSiteStrictMode, nil
+  case "none":
+    return http.SameSiteNoneMode, nil
+  default:
+    return 0, errors.New("unknown SameSite " + value)
+  }
+}
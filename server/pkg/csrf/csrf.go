@@ -0,0 +1,159 @@
+package csrf
+
+import (
+  "log"
+  "errors"
+  "time"
+  "net/url"
+  "net/http"
+  "crypto/subtle"
+
+  "github.com/bd878/gallery/server/pkg/cookie"
+  "github.com/bd878/gallery/server/utils"
+)
+
+const (
+  CookieName = "csrf"
+  HeaderName = "X-CSRF-Token"
+  FormField = "csrf_token"
+)
+
+// Cookie is readable by scripts, it must be
+// sent back in HeaderName header or FormField field.
+// Request Host is always allowed origin
+type Config struct {
+  Cookie cookie.Config
+  AllowedOrigins []string
+}
+
+/**
+ * Double-submit token check. Every state-changing
+ * request needs an allowed origin and token matching
+ * csrf cookie. Safe requests get the cookie set when missing
+ */
+type CSRF struct {
+  cookie  cookie.Config
+  origins map[string]struct{}
+}
+
+func New(cfg Config) *CSRF {
+  origins := make(map[string]struct{}, len(cfg.AllowedOrigins))
+  for _, origin := range cfg.AllowedOrigins {
+    origins[origin] = struct{}{}
+  }
+
+  cookieCfg := cfg.Cookie
+  cookieCfg.HttpOnly = false
+
+  return &CSRF{cookieCfg, origins}
+}
+
+func (c *CSRF) Protect(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    if isSafe(req.Method) {
+      if _, err := req.Cookie(CookieName); err != nil {
+        if err := c.setToken(w); err != nil {
+          log.Println("failed to set csrf token: ", err)
+          w.WriteHeader(http.StatusInternalServerError)
+          return
+        }
+      }
+      next.ServeHTTP(w, req)
+      return
+    }
+
+    if !c.allowedOrigin(req) {
+      log.Println("csrf: origin not allowed", req.Header.Get("Origin"), req.URL.Path)
+      http.Error(w, "origin not allowed", http.StatusForbidden)
+      return
+    }
+
+    valid, err := validToken(req)
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+      http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+      return
+    }
+    if !valid {
+      log.Println("csrf: bad token", req.URL.Path)
+      http.Error(w, "bad csrf token", http.StatusForbidden)
+      return
+    }
+
+    next.ServeHTTP(w, req)
+  })
+}
+
+func (c *CSRF) setToken(w http.ResponseWriter) error {
+  token, err := utils.RandomToken(32)
+  if err != nil {
+    return err
+  }
+  http.SetCookie(w, c.cookie.New(CookieName, token, time.Time{}))
+  return nil
+}
+
+/**
+ * Origin header is checked, or Referer when browser
+ * did not send Origin. Requests with neither are
+ * not from a browser page and pass to token check
+ */
+func (c *CSRF) allowedOrigin(req *http.Request) bool {
+  origin := req.Header.Get("Origin")
+  if origin == "" {
+    referer := req.Header.Get("Referer")
+    if referer == "" {
+      return true
+    }
+    u, err := url.Parse(referer)
+    if err != nil {
+      return false
+    }
+    origin = u.Scheme + "://" + u.Host
+  }
+
+  if _, ok := c.origins[origin]; ok {
+    return true
+  }
+
+  u, err := url.Parse(origin)
+  if err != nil || u.Host == "" {
+    return false
+  }
+  return u.Host == req.Host
+}
+
+/**
+ * Body is parsed only when token is not in header, servers
+ * cap its size before. Returns error of parse, if any
+ */
+func validToken(req *http.Request) (bool, error) {
+  cookie, err := req.Cookie(CookieName)
+  if err != nil || cookie.Value == "" {
+    return false, nil
+  }
+
+  token := req.Header.Get(HeaderName)
+  if token == "" {
+    if err := req.ParseForm(); err != nil {
+      return false, err
+    }
+    // multipart bodies are parsed too, so handlers
+    // calling ParseMultipartForm later get the parsed form
+    if err := req.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+      return false, err
+    }
+    token = req.PostFormValue(FormField)
+  }
+
+  return subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) == 1, nil
+}
+
+func isSafe(method string) bool {
+  switch method {
+  case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+    return true
+  default:
+    return false
+  }
+}
@@ -0,0 +1,91 @@
+package csrf_test
+
+import (
+  "strings"
+  "testing"
+  "net/url"
+  "net/http"
+  "net/http/httptest"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/pkg/cookie"
+  "github.com/bd878/gallery/server/pkg/csrf"
+)
+
+func TestProtect(t *testing.T) {
+  protect := csrf.New(csrf.Config{
+    Cookie: cookie.Config{HttpOnly: true, SameSite: "strict"},
+    AllowedOrigins: []string{"https://gallery.example.com"},
+  }).Protect
+
+  h := protect(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    w.WriteHeader(http.StatusOK)
+  }))
+
+  // safe request issues token
+  w := httptest.NewRecorder()
+  h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://api.example.com/users/v1/auth", nil))
+  require.Equal(t, http.StatusOK, w.Code)
+  cookies := w.Result().Cookies()
+  require.Len(t, cookies, 1)
+  token := cookies[0]
+  require.Equal(t, csrf.CookieName, token.Name)
+  require.False(t, token.HttpOnly)
+  require.Equal(t, http.SameSiteStrictMode, token.SameSite)
+
+  post := func(origin, header string, form url.Values) int {
+    req := httptest.NewRequest(http.MethodPost, "http://api.example.com/users/v1/login",
+      strings.NewReader(form.Encode()))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.AddCookie(token)
+    if origin != "" {
+      req.Header.Set("Origin", origin)
+    }
+    if header != "" {
+      req.Header.Set(csrf.HeaderName, header)
+    }
+    w := httptest.NewRecorder()
+    h.ServeHTTP(w, req)
+    return w.Code
+  }
+
+  require.Equal(t, http.StatusOK, post("https://gallery.example.com", token.Value, nil))
+  require.Equal(t, http.StatusOK, post("http://api.example.com", "", url.Values{csrf.FormField: {token.Value}}))
+  require.Equal(t, http.StatusOK, post("", token.Value, nil))
+
+  require.Equal(t, http.StatusForbidden, post("https://evil.example.com", token.Value, nil))
+  require.Equal(t, http.StatusForbidden, post("null", token.Value, nil))
+  require.Equal(t, http.StatusForbidden, post("https://gallery.example.com", "", nil))
+  require.Equal(t, http.StatusForbidden, post("https://gallery.example.com", "forged", nil))
+
+  // no cookie, no match
+  req := httptest.NewRequest(http.MethodPost, "http://api.example.com/users/v1/login", nil)
+  req.Header.Set(csrf.HeaderName, token.Value)
+  w = httptest.NewRecorder()
+  h.ServeHTTP(w, req)
+  require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestProtectLargeBody(t *testing.T) {
+  protect := csrf.New(csrf.Config{}).Protect
+  h := protect(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    w.WriteHeader(http.StatusOK)
+  }))
+  limit := func(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+      req.Body = http.MaxBytesReader(w, req.Body, 64)
+      next.ServeHTTP(w, req)
+    })
+  }
+
+  // token in form of capped body is not read past the cap
+  form := url.Values{csrf.FormField: {"token"}, "message": {strings.Repeat("a", 128)}}
+  req := httptest.NewRequest(http.MethodPost, "http://api.example.com/messages/v1/send",
+    strings.NewReader(form.Encode()))
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  req.AddCookie(&http.Cookie{Name: csrf.CookieName, Value: "token"})
+  w := httptest.NewRecorder()
+  limit(h).ServeHTTP(w, req)
+  require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
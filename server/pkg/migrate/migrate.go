@@ -0,0 +1,253 @@
+package migrate
+
+import (
+  "io"
+  "fmt"
+  "sort"
+  "errors"
+  "regexp"
+  "strconv"
+  "context"
+  "io/fs"
+  "database/sql"
+)
+
+var (
+  ErrNewerSchema = errors.New("database schema is newer than this build")
+  ErrForeignSchema = errors.New("database belongs to another schema")
+  ErrUnversioned = errors.New("database set up by hand scripts, baseline it first")
+)
+
+// Migration is one step, Version is its number in file name
+type Migration struct {
+  Version int
+  Name string
+  SQL string
+}
+
+/**
+ * Schema is ordered migrations of one service. Applied
+ * version is kept in PRAGMA user_version, ApplicationId
+ * in PRAGMA application_id tells whose database it is
+ */
+type Schema struct {
+  Name string
+  ApplicationId int32
+  Migrations []Migration
+}
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+/**
+ * Load reads NNNN_name.sql files of fsys root,
+ * versions go from 1 without gaps
+ */
+func Load(name string, applicationId int32, fsys fs.FS) (*Schema, error) {
+  entries, err := fs.ReadDir(fsys, ".")
+  if err != nil {
+    return nil, err
+  }
+
+  s := &Schema{Name: name, ApplicationId: applicationId}
+  for _, entry := range entries {
+    match := fileName.FindStringSubmatch(entry.Name())
+    if entry.IsDir() || match == nil {
+      continue
+    }
+    version, _ := strconv.Atoi(match[1])
+    b, err := fs.ReadFile(fsys, entry.Name())
+    if err != nil {
+      return nil, err
+    }
+    s.Migrations = append(s.Migrations, Migration{
+      Version: version,
+      Name: match[2],
+      SQL: string(b),
+    })
+  }
+
+  sort.Slice(s.Migrations, func(i, j int) bool {
+    return s.Migrations[i].Version < s.Migrations[j].Version
+  })
+  for i, m := range s.Migrations {
+    if m.Version != i + 1 {
+      return nil, fmt.Errorf("%s migrations: expected version %d, got %d", name, i + 1, m.Version)
+    }
+  }
+  return s, nil
+}
+
+// Latest is version of last migration
+func (s *Schema) Latest() int {
+  return len(s.Migrations)
+}
+
+type Status struct {
+  Version int
+  Latest int
+  Pending []Migration
+}
+
+/**
+ * Status returns applied version and pending migrations.
+ * Error tells database can not be migrated by this schema
+ */
+func (s *Schema) Status(ctx context.Context, db *sql.DB) (*Status, error) {
+  version, appId, err := readHeader(ctx, db)
+  if err != nil {
+    return nil, err
+  }
+
+  status := &Status{Version: version, Latest: s.Latest()}
+  switch {
+  case appId != 0 && appId != s.ApplicationId:
+    return status, fmt.Errorf("%w: application id %#x", ErrForeignSchema, appId)
+  case appId == 0 && version > 0:
+    return status, ErrUnversioned
+  case version > status.Latest:
+    return status, fmt.Errorf("%w: %s version %d, latest known %d", ErrNewerSchema, s.Name, version, status.Latest)
+  }
+  status.Pending = s.Migrations[version:]
+  return status, nil
+}
+
+/**
+ * Up applies pending migrations, each in own transaction
+ * together with its version, and returns applied ones.
+ * It refuses newer, foreign and hand set up databases
+ */
+func (s *Schema) Up(ctx context.Context, db *sql.DB) ([]Migration, error) {
+  status, err := s.Status(ctx, db)
+  if err != nil {
+    return nil, err
+  }
+
+  var applied []Migration
+  for _, m := range status.Pending {
+    ok, err := s.apply(ctx, db, m)
+    if err != nil {
+      return applied, fmt.Errorf("%s migration %04d_%s: %w", s.Name, m.Version, m.Name, err)
+    }
+    if ok {
+      applied = append(applied, m)
+    }
+  }
+  return applied, nil
+}
+
+// apply skips migration applied by other process meanwhile
+func (s *Schema) apply(ctx context.Context, db *sql.DB, m Migration) (bool, error) {
+  tx, err := db.BeginTx(ctx, nil)
+  if err != nil {
+    return false, err
+  }
+  defer tx.Rollback()
+
+  var version int
+  if err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+    return false, err
+  }
+  if version >= m.Version {
+    return false, nil
+  }
+
+  if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+    return false, err
+  }
+  if err := writeHeader(ctx, tx, m.Version, s.ApplicationId); err != nil {
+    return false, err
+  }
+  return true, tx.Commit()
+}
+
+/**
+ * Baseline marks database set up by hand scripts as
+ * migrated up to version, without running anything
+ */
+func (s *Schema) Baseline(ctx context.Context, db *sql.DB, version int) error {
+  if version < 1 || version > s.Latest() {
+    return fmt.Errorf("%s baseline version must be 1..%d", s.Name, s.Latest())
+  }
+  _, appId, err := readHeader(ctx, db)
+  if err != nil {
+    return err
+  }
+  if appId != 0 && appId != s.ApplicationId {
+    return fmt.Errorf("%w: application id %#x", ErrForeignSchema, appId)
+  }
+
+  tx, err := db.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  if err := writeHeader(ctx, tx, version, s.ApplicationId); err != nil {
+    return err
+  }
+  return tx.Commit()
+}
+
+/**
+ * Command runs migrate subcommand of service binary:
+ * status (default), dry-run, up or baseline N
+ */
+func (s *Schema) Command(ctx context.Context, db *sql.DB, w io.Writer, args []string) error {
+  command := "status"
+  if len(args) > 0 {
+    command = args[0]
+  }
+
+  switch command {
+  case "status", "dry-run":
+    status, err := s.Status(ctx, db)
+    if status != nil {
+      fmt.Fprintf(w, "%s schema version %d, latest %d\n", s.Name, status.Version, status.Latest)
+      for _, m := range status.Pending {
+        fmt.Fprintf(w, "pending %04d_%s\n", m.Version, m.Name)
+        if command == "dry-run" {
+          fmt.Fprintln(w, m.SQL)
+        }
+      }
+    }
+    return err
+  case "up":
+    applied, err := s.Up(ctx, db)
+    for _, m := range applied {
+      fmt.Fprintf(w, "applied %04d_%s\n", m.Version, m.Name)
+    }
+    return err
+  case "baseline":
+    if len(args) != 2 {
+      return errors.New("usage: migrate baseline version")
+    }
+    version, err := strconv.Atoi(args[1])
+    if err != nil {
+      return err
+    }
+    if err := s.Baseline(ctx, db, version); err != nil {
+      return err
+    }
+    fmt.Fprintf(w, "%s schema marked at version %d\n", s.Name, version)
+    return nil
+  default:
+    return fmt.Errorf("unknown migrate command %q, expected status, dry-run, up or baseline", command)
+  }
+}
+
+func readHeader(ctx context.Context, db *sql.DB) (version int, appId int32, err error) {
+  if err = db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+    return
+  }
+  err = db.QueryRowContext(ctx, "PRAGMA application_id").Scan(&appId)
+  return
+}
+
+// pragmas take no parameters, both values are ints
+func writeHeader(ctx context.Context, tx *sql.Tx, version int, appId int32) error {
+  if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+    return err
+  }
+  _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA application_id = %d", appId))
+  return err
+}
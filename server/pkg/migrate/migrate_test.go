@@ -0,0 +1,116 @@
+package migrate_test
+
+import (
+  "bytes"
+  "context"
+  "testing"
+  "testing/fstest"
+  "path/filepath"
+  "database/sql"
+
+  _ "github.com/mattn/go-sqlite3"
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/pkg/migrate"
+)
+
+var files = fstest.MapFS{
+  "0001_items.sql": {Data: []byte("CREATE TABLE items(id INTEGER PRIMARY KEY);")},
+  "0002_add_name.sql": {Data: []byte("ALTER TABLE items ADD COLUMN name TEXT;")},
+  "README": {Data: []byte("not a migration")},
+}
+
+func setup(t *testing.T, fsys fstest.MapFS) (*migrate.Schema, *sql.DB) {
+  t.Helper()
+  s, err := migrate.Load("items", 0x6974656d, fsys)
+  require.NoError(t, err)
+  db, err := sql.Open("sqlite3", "file:" + filepath.Join(t.TempDir(), "items.db"))
+  require.NoError(t, err)
+  t.Cleanup(func() { db.Close() })
+  return s, db
+}
+
+func TestUp(t *testing.T) {
+  ctx := context.Background()
+  s, db := setup(t, files)
+
+  status, err := s.Status(ctx, db)
+  require.NoError(t, err)
+  require.Equal(t, 0, status.Version)
+  require.Len(t, status.Pending, 2)
+
+  applied, err := s.Up(ctx, db)
+  require.NoError(t, err)
+  require.Len(t, applied, 2)
+  _, err = db.Exec("INSERT INTO items(name) VALUES ('one')")
+  require.NoError(t, err)
+
+  applied, err = s.Up(ctx, db)
+  require.NoError(t, err)
+  require.Empty(t, applied)
+
+  // newer build adds a step, older one refuses the database
+  newer := fstest.MapFS{"0003_add_size.sql": {Data: []byte("ALTER TABLE items ADD COLUMN size INTEGER;")}}
+  for name, file := range files {
+    newer[name] = file
+  }
+  s3, err := migrate.Load("items", 0x6974656d, newer)
+  require.NoError(t, err)
+  _, err = s3.Up(ctx, db)
+  require.NoError(t, err)
+
+  _, err = s.Up(ctx, db)
+  require.ErrorIs(t, err, migrate.ErrNewerSchema)
+}
+
+func TestFailedStep(t *testing.T) {
+  ctx := context.Background()
+  s, db := setup(t, fstest.MapFS{
+    "0001_items.sql": files["0001_items.sql"],
+    "0002_broken.sql": {Data: []byte("ALTER TABLE items ADD COLUMN name TEXT; ALTER TABLE nothing ADD COLUMN x;")},
+  })
+
+  applied, err := s.Up(ctx, db)
+  require.Error(t, err)
+  require.Len(t, applied, 1)
+
+  status, err := s.Status(ctx, db)
+  require.NoError(t, err)
+  require.Equal(t, 1, status.Version)
+
+  // first statement of broken step is rolled back too
+  _, err = db.Exec("INSERT INTO items(name) VALUES ('one')")
+  require.Error(t, err)
+}
+
+func TestForeignAndUnversioned(t *testing.T) {
+  ctx := context.Background()
+  s, db := setup(t, files)
+
+  other, err := migrate.Load("other", 1, files)
+  require.NoError(t, err)
+  _, err = other.Up(ctx, db)
+  require.NoError(t, err)
+  _, err = s.Up(ctx, db)
+  require.ErrorIs(t, err, migrate.ErrForeignSchema)
+
+  // hand scripts set user_version, not application_id
+  _, db = setup(t, files)
+  _, err = db.Exec("PRAGMA user_version=1; CREATE TABLE items(id INTEGER PRIMARY KEY, name TEXT);")
+  require.NoError(t, err)
+  _, err = s.Up(ctx, db)
+  require.ErrorIs(t, err, migrate.ErrUnversioned)
+
+  var out bytes.Buffer
+  require.NoError(t, s.Command(ctx, db, &out, []string{"baseline", "2"}))
+  require.NoError(t, s.Command(ctx, db, &out, []string{"status"}))
+  require.Contains(t, out.String(), "items schema version 2, latest 2")
+}
+
+func TestLoadGap(t *testing.T) {
+  _, err := migrate.Load("items", 1, fstest.MapFS{
+    "0001_items.sql": files["0001_items.sql"],
+    "0003_add_size.sql": {Data: []byte("SELECT 1;")},
+  })
+  require.Error(t, err)
+}
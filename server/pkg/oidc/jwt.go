@@ -0,0 +1,212 @@
+package oidc
+
+import (
+  "fmt"
+  "time"
+  "bytes"
+  "errors"
+  "context"
+  "strings"
+  "math/big"
+  "crypto"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/json"
+  "encoding/base64"
+)
+
+type jwtHeader struct {
+  Alg string `json:"alg"`
+  Kid string `json:"kid"`
+}
+
+// JSON Web Key, only RSA keys are used
+type JWK struct {
+  Kty string `json:"kty"`
+  Kid string `json:"kid"`
+  Use string `json:"use,omitempty"`
+  Alg string `json:"alg,omitempty"`
+  N string `json:"n"`
+  E string `json:"e"`
+}
+
+type JWKS struct {
+  Keys []JWK `json:"keys"`
+}
+
+/**
+ * Verify checks id token signature, issuer, audience,
+ * expiry and nonce. Only RS256 is accepted, the algorithm
+ * every provider must support
+ */
+func (c *Client) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+  parts := strings.Split(rawIDToken, ".")
+  if len(parts) != 3 {
+    return nil, fmt.Errorf("%w: malformed", ErrTokenInvalid)
+  }
+
+  var header jwtHeader
+  if err := decodeSegment(parts[0], &header); err != nil {
+    return nil, fmt.Errorf("%w: header: %v", ErrTokenInvalid, err)
+  }
+  if header.Alg != "RS256" {
+    return nil, fmt.Errorf("%w: unsupported alg %q", ErrTokenInvalid, header.Alg)
+  }
+
+  signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil {
+    return nil, fmt.Errorf("%w: signature: %v", ErrTokenInvalid, err)
+  }
+
+  key, err := c.key(ctx, header.Kid)
+  if err != nil {
+    return nil, err
+  }
+  digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+  if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+    return nil, fmt.Errorf("%w: bad signature", ErrTokenInvalid)
+  }
+
+  var claims Claims
+  if err := decodeSegment(parts[1], &claims); err != nil {
+    return nil, fmt.Errorf("%w: claims: %v", ErrTokenInvalid, err)
+  }
+  if err := c.checkClaims(&claims, nonce); err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrTokenInvalid, err)
+  }
+  return &claims, nil
+}
+
+func (c *Client) checkClaims(claims *Claims, nonce string) error {
+  now := c.now()
+
+  switch {
+  case strings.TrimSuffix(claims.Issuer, "/") != c.cfg.Issuer:
+    return fmt.Errorf("issuer %q", claims.Issuer)
+  case claims.Subject == "":
+    return errors.New("no subject")
+  case !claims.Audience.Contains(c.cfg.ClientID):
+    return errors.New("audience mismatch")
+  case len(claims.Audience) > 1 && claims.AuthorizedParty != c.cfg.ClientID:
+    return errors.New("authorized party mismatch")
+  case claims.AuthorizedParty != "" && claims.AuthorizedParty != c.cfg.ClientID:
+    return errors.New("authorized party mismatch")
+  case now.Add(-leeway).After(time.Unix(claims.Expiry, 0)):
+    return errors.New("expired")
+  case time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+    return errors.New("issued in future")
+  case claims.Nonce != nonce:
+    return errors.New("nonce mismatch")
+  }
+  return nil
+}
+
+// key finds signing key, keys are refetched once on unknown kid
+func (c *Client) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+
+  if key, ok := c.lookupKey(kid); ok {
+    return key, nil
+  }
+
+  metadata, err := c.discover(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  var jwks JWKS
+  if err := c.getJSON(ctx, metadata.JWKSURI, &jwks); err != nil {
+    return nil, err
+  }
+
+  keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+  for _, jwk := range jwks.Keys {
+    if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+      continue
+    }
+    key, err := jwk.publicKey()
+    if err != nil {
+      return nil, err
+    }
+    keys[jwk.Kid] = key
+  }
+  c.keys = keys
+
+  if key, ok := c.lookupKey(kid); ok {
+    return key, nil
+  }
+  return nil, fmt.Errorf("%w: unknown key %q", ErrTokenInvalid, kid)
+}
+
+// Empty kid is allowed while provider has one key
+func (c *Client) lookupKey(kid string) (*rsa.PublicKey, bool) {
+  if kid == "" && len(c.keys) == 1 {
+    for _, key := range c.keys {
+      return key, true
+    }
+  }
+  key, ok := c.keys[kid]
+  return key, ok
+}
+
+func (k JWK) publicKey() (*rsa.PublicKey, error) {
+  n, err := base64.RawURLEncoding.DecodeString(k.N)
+  if err != nil {
+    return nil, fmt.Errorf("jwk %q modulus: %w", k.Kid, err)
+  }
+  e, err := base64.RawURLEncoding.DecodeString(k.E)
+  if err != nil {
+    return nil, fmt.Errorf("jwk %q exponent: %w", k.Kid, err)
+  }
+  exponent := new(big.Int).SetBytes(e)
+  if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31 {
+    return nil, fmt.Errorf("jwk %q exponent out of range", k.Kid)
+  }
+  return &rsa.PublicKey{
+    N: new(big.Int).SetBytes(n),
+    E: int(exponent.Int64()),
+  }, nil
+}
+
+// NewJWK describes RSA public key
+func NewJWK(kid string, key *rsa.PublicKey) JWK {
+  return JWK{
+    Kty: "RSA",
+    Kid: kid,
+    Use: "sig",
+    Alg: "RS256",
+    N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+    E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+  }
+}
+
+// Sign makes RS256 token of claims, used by test provider
+func Sign(key *rsa.PrivateKey, kid string, claims any) (string, error) {
+  header, err := json.Marshal(jwtHeader{Alg: "RS256", Kid: kid})
+  if err != nil {
+    return "", err
+  }
+  payload, err := json.Marshal(claims)
+  if err != nil {
+    return "", err
+  }
+
+  signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+    base64.RawURLEncoding.EncodeToString(payload)
+  digest := sha256.Sum256([]byte(signed))
+  signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+  if err != nil {
+    return "", err
+  }
+  return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func decodeSegment(segment string, v any) error {
+  b, err := base64.RawURLEncoding.DecodeString(segment)
+  if err != nil {
+    return err
+  }
+  dec := json.NewDecoder(bytes.NewReader(b))
+  return dec.Decode(v)
+}
@@ -0,0 +1,260 @@
+package oidc
+
+import (
+  "fmt"
+  "sync"
+  "time"
+  "errors"
+  "strings"
+  "context"
+  "net/url"
+  "net/http"
+  "crypto/rsa"
+  "encoding/json"
+)
+
+var ErrTokenInvalid = errors.New("id token invalid")
+
+const (
+  // clock skew tolerated on token times
+  leeway = time.Minute
+  discoveryPath = "/.well-known/openid-configuration"
+)
+
+// Relying party registration at the provider
+type Config struct {
+  Issuer string
+  ClientID string
+  // empty for public clients, PKCE protects the code then
+  ClientSecret string
+  RedirectURL string
+  // "openid" is always requested
+  Scopes []string
+  HTTPClient *http.Client
+}
+
+// Provider endpoints, as published in discovery document
+type Metadata struct {
+  Issuer string `json:"issuer"`
+  AuthorizationEndpoint string `json:"authorization_endpoint"`
+  TokenEndpoint string `json:"token_endpoint"`
+  JWKSURI string `json:"jwks_uri"`
+  UserinfoEndpoint string `json:"userinfo_endpoint,omitempty"`
+  CodeChallengeMethods []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// Verified id token claims
+type Claims struct {
+  Issuer string `json:"iss"`
+  Subject string `json:"sub"`
+  Audience Audience `json:"aud"`
+  AuthorizedParty string `json:"azp,omitempty"`
+  Expiry int64 `json:"exp"`
+  IssuedAt int64 `json:"iat"`
+  Nonce string `json:"nonce,omitempty"`
+  Email string `json:"email,omitempty"`
+  EmailVerified bool `json:"email_verified,omitempty"`
+  Name string `json:"name,omitempty"`
+  PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// Audience is a string or an array of them in json
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+  var single string
+  if err := json.Unmarshal(b, &single); err == nil {
+    *a = Audience{single}
+    return nil
+  }
+  var list []string
+  if err := json.Unmarshal(b, &list); err != nil {
+    return err
+  }
+  *a = Audience(list)
+  return nil
+}
+
+func (a Audience) Contains(aud string) bool {
+  for _, v := range a {
+    if v == aud {
+      return true
+    }
+  }
+  return false
+}
+
+/**
+ * Client is OpenID Connect relying party using
+ * authorization code flow with PKCE. Provider is discovered
+ * on first use, signing keys are refetched on unknown key id
+ */
+type Client struct {
+  cfg Config
+
+  mu sync.Mutex
+  metadata *Metadata
+  keys map[string]*rsa.PublicKey
+  now func() time.Time
+}
+
+func New(cfg Config) *Client {
+  if cfg.HTTPClient == nil {
+    cfg.HTTPClient = &http.Client{Timeout: 10*time.Second}
+  }
+  cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+  return &Client{cfg: cfg, now: time.Now}
+}
+
+func (c *Client) Issuer() string {
+  return c.cfg.Issuer
+}
+
+func (c *Client) Discover(ctx context.Context) (*Metadata, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.discover(ctx)
+}
+
+func (c *Client) discover(ctx context.Context) (*Metadata, error) {
+  if c.metadata != nil {
+    return c.metadata, nil
+  }
+
+  var metadata Metadata
+  if err := c.getJSON(ctx, c.cfg.Issuer + discoveryPath, &metadata); err != nil {
+    return nil, err
+  }
+  if strings.TrimSuffix(metadata.Issuer, "/") != c.cfg.Issuer {
+    return nil, fmt.Errorf("discovery issuer %q does not match %q", metadata.Issuer, c.cfg.Issuer)
+  }
+  if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+    return nil, errors.New("discovery document misses endpoints")
+  }
+  if len(metadata.CodeChallengeMethods) != 0 && !contains(metadata.CodeChallengeMethods, "S256") {
+    return nil, errors.New("provider does not support S256 code challenge")
+  }
+
+  c.metadata = &metadata
+  return c.metadata, nil
+}
+
+// AuthCodeURL returns provider login page address
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+  metadata, err := c.Discover(ctx)
+  if err != nil {
+    return "", err
+  }
+
+  scopes := []string{"openid"}
+  for _, scope := range c.cfg.Scopes {
+    if scope != "openid" {
+      scopes = append(scopes, scope)
+    }
+  }
+
+  params := url.Values{}
+  params.Set("response_type", "code")
+  params.Set("client_id", c.cfg.ClientID)
+  params.Set("redirect_uri", c.cfg.RedirectURL)
+  params.Set("scope", strings.Join(scopes, " "))
+  params.Set("state", state)
+  params.Set("nonce", nonce)
+  params.Set("code_challenge", challenge)
+  params.Set("code_challenge_method", "S256")
+
+  sep := "?"
+  if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+    sep = "&"
+  }
+  return metadata.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+type tokenResponse struct {
+  AccessToken string `json:"access_token"`
+  TokenType string `json:"token_type"`
+  IDToken string `json:"id_token"`
+  Error string `json:"error"`
+  ErrorDescription string `json:"error_description"`
+}
+
+/**
+ * Exchange redeems authorization code and returns
+ * claims of the verified id token
+ */
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+  metadata, err := c.Discover(ctx)
+  if err != nil {
+    return nil, err
+  }
+
+  form := url.Values{}
+  form.Set("grant_type", "authorization_code")
+  form.Set("code", code)
+  form.Set("redirect_uri", c.cfg.RedirectURL)
+  form.Set("code_verifier", verifier)
+  if c.cfg.ClientSecret == "" {
+    form.Set("client_id", c.cfg.ClientID)
+  }
+
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint,
+    strings.NewReader(form.Encode()))
+  if err != nil {
+    return nil, err
+  }
+  req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+  req.Header.Set("Accept", "application/json")
+  if c.cfg.ClientSecret != "" {
+    req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+  }
+
+  resp, err := c.cfg.HTTPClient.Do(req)
+  if err != nil {
+    return nil, err
+  }
+  defer resp.Body.Close()
+
+  var token tokenResponse
+  if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+    return nil, fmt.Errorf("token response: %w", err)
+  }
+  if token.Error != "" {
+    return nil, fmt.Errorf("token endpoint: %s %s", token.Error, token.ErrorDescription)
+  }
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("token endpoint: status %d", resp.StatusCode)
+  }
+  if token.IDToken == "" {
+    return nil, fmt.Errorf("%w: no id token in response", ErrTokenInvalid)
+  }
+
+  return c.Verify(ctx, token.IDToken, nonce)
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v any) error {
+  req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Accept", "application/json")
+
+  resp, err := c.cfg.HTTPClient.Do(req)
+  if err != nil {
+    return err
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode != http.StatusOK {
+    return fmt.Errorf("get %s: status %d", url, resp.StatusCode)
+  }
+  return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func contains(list []string, value string) bool {
+  for _, v := range list {
+    if v == value {
+      return true
+    }
+  }
+  return false
+}
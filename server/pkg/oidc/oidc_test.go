@@ -0,0 +1,119 @@
+package oidc_test
+
+import (
+  "time"
+  "context"
+  "testing"
+  "net/url"
+  "net/http"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/pkg/oidc"
+  "github.com/bd878/gallery/server/pkg/oidc/oidctest"
+)
+
+const redirectURL = "http://app.test/users/v1/oidc/callback"
+
+// authorize follows provider login, returns code and state
+func authorize(t *testing.T, authURL string) (string, string) {
+  client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+    return http.ErrUseLastResponse
+  }}
+  resp, err := client.Get(authURL)
+  require.NoError(t, err)
+  resp.Body.Close()
+  require.Equal(t, http.StatusFound, resp.StatusCode)
+
+  location, err := url.Parse(resp.Header.Get("Location"))
+  require.NoError(t, err)
+  return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestCodeFlow(t *testing.T) {
+  ctx := context.Background()
+  provider, err := oidctest.New("gallery", "secret")
+  require.NoError(t, err)
+  defer provider.Close()
+
+  client := oidc.New(oidc.Config{
+    Issuer: provider.Issuer(),
+    ClientID: "gallery",
+    ClientSecret: "secret",
+    RedirectURL: redirectURL,
+    Scopes: []string{"email"},
+  })
+
+  provider.SetUser(oidc.Claims{Subject: "42", Email: "alice@example.com", EmailVerified: true})
+
+  verifier, err := oidc.NewVerifier()
+  require.NoError(t, err)
+  authURL, err := client.AuthCodeURL(ctx, "state1", "nonce1", oidc.Challenge(verifier))
+  require.NoError(t, err)
+
+  code, state := authorize(t, authURL)
+  require.Equal(t, "state1", state)
+
+  _, err = client.Exchange(ctx, code, "wrong verifier", "nonce1")
+  require.Error(t, err, "code is bound to challenge")
+
+  code, _ = authorize(t, authURL)
+  claims, err := client.Exchange(ctx, code, verifier, "nonce1")
+  require.NoError(t, err)
+  require.Equal(t, "42", claims.Subject)
+  require.Equal(t, "alice@example.com", claims.Email)
+
+  _, err = client.Exchange(ctx, code, verifier, "nonce1")
+  require.Error(t, err, "code is used once")
+
+  code, _ = authorize(t, authURL)
+  _, err = client.Exchange(ctx, code, verifier, "other nonce")
+  require.ErrorIs(t, err, oidc.ErrTokenInvalid)
+}
+
+func TestVerify(t *testing.T) {
+  ctx := context.Background()
+  provider, err := oidctest.New("gallery", "")
+  require.NoError(t, err)
+  defer provider.Close()
+
+  client := oidc.New(oidc.Config{
+    Issuer: provider.Issuer(),
+    ClientID: "gallery",
+    RedirectURL: redirectURL,
+  })
+
+  now := time.Now()
+  valid := oidc.Claims{
+    Issuer: provider.Issuer(),
+    Subject: "42",
+    Audience: oidc.Audience{"gallery"},
+    IssuedAt: now.Unix(),
+    Expiry: now.Add(time.Hour).Unix(),
+    Nonce: "nonce",
+  }
+
+  token, err := provider.SignToken(valid)
+  require.NoError(t, err)
+  _, err = client.Verify(ctx, token, "nonce")
+  require.NoError(t, err)
+
+  for name, claims := range map[string]oidc.Claims{
+    "issuer": func(c oidc.Claims) oidc.Claims { c.Issuer = "http://evil.test"; return c }(valid),
+    "audience": func(c oidc.Claims) oidc.Claims { c.Audience = oidc.Audience{"other"}; return c }(valid),
+    "azp": func(c oidc.Claims) oidc.Claims { c.Audience = oidc.Audience{"gallery", "other"}; return c }(valid),
+    "expired": func(c oidc.Claims) oidc.Claims { c.Expiry = now.Add(-time.Hour).Unix(); return c }(valid),
+    "nonce": func(c oidc.Claims) oidc.Claims { c.Nonce = "replayed"; return c }(valid),
+  } {
+    token, err := provider.SignToken(claims)
+    require.NoError(t, err)
+    _, err = client.Verify(ctx, token, "nonce")
+    require.ErrorIs(t, err, oidc.ErrTokenInvalid, name)
+  }
+
+  // unsigned and tampered tokens
+  _, err = client.Verify(ctx, "eyJhbGciOiJub25lIn0.eyJzdWIiOiI0MiJ9.", "nonce")
+  require.ErrorIs(t, err, oidc.ErrTokenInvalid)
+  _, err = client.Verify(ctx, token[:len(token)-4] + "AAAA", "nonce")
+  require.ErrorIs(t, err, oidc.ErrTokenInvalid)
+}
@@ -0,0 +1,198 @@
+package oidctest
+
+import (
+  "sync"
+  "time"
+  "net/url"
+  "net/http"
+  "net/http/httptest"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/subtle"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/pkg/oidc"
+)
+
+const keyId = "test-key"
+
+/**
+ * Provider is in-process OpenID Connect provider for tests.
+ * Authorization endpoint logs User in without a page and
+ * redirects back with a code right away
+ */
+type Provider struct {
+  *httptest.Server
+  ClientID string
+  ClientSecret string
+
+  mu sync.Mutex
+  // next user to log in, Subject is required
+  user oidc.Claims
+  codes map[string]grant
+  key *rsa.PrivateKey
+}
+
+type grant struct {
+  claims oidc.Claims
+  challenge string
+  redirectURI string
+}
+
+func New(clientID, clientSecret string) (*Provider, error) {
+  key, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    return nil, err
+  }
+
+  p := &Provider{
+    ClientID: clientID,
+    ClientSecret: clientSecret,
+    codes: make(map[string]grant),
+    key: key,
+  }
+
+  mux := http.NewServeMux()
+  mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+  mux.HandleFunc("/jwks", p.jwks)
+  mux.HandleFunc("/authorize", p.authorize)
+  mux.HandleFunc("/token", p.token)
+  p.Server = httptest.NewServer(mux)
+  return p, nil
+}
+
+// Issuer is provider address, as relying party is configured with
+func (p *Provider) Issuer() string {
+  return p.Server.URL
+}
+
+// SetUser chooses who logs in next
+func (p *Provider) SetUser(claims oidc.Claims) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.user = claims
+}
+
+// SignToken signs arbitrary claims with provider key
+func (p *Provider) SignToken(claims any) (string, error) {
+  return oidc.Sign(p.key, keyId, claims)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+  writeJSON(w, http.StatusOK, oidc.Metadata{
+    Issuer: p.Issuer(),
+    AuthorizationEndpoint: p.Issuer() + "/authorize",
+    TokenEndpoint: p.Issuer() + "/token",
+    JWKSURI: p.Issuer() + "/jwks",
+    CodeChallengeMethods: []string{"S256"},
+  })
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, _ *http.Request) {
+  writeJSON(w, http.StatusOK, oidc.JWKS{
+    Keys: []oidc.JWK{oidc.NewJWK(keyId, &p.key.PublicKey)},
+  })
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, req *http.Request) {
+  query := req.URL.Query()
+  if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" ||
+    query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+    http.Error(w, "invalid_request", http.StatusBadRequest)
+    return
+  }
+
+  code, err := oidc.NewState()
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+
+  p.mu.Lock()
+  claims := p.user
+  claims.Nonce = query.Get("nonce")
+  p.codes[code] = grant{
+    claims: claims,
+    challenge: query.Get("code_challenge"),
+    redirectURI: query.Get("redirect_uri"),
+  }
+  p.mu.Unlock()
+
+  redirect, err := url.Parse(query.Get("redirect_uri"))
+  if err != nil {
+    http.Error(w, "invalid_request", http.StatusBadRequest)
+    return
+  }
+  params := redirect.Query()
+  params.Set("code", code)
+  params.Set("state", query.Get("state"))
+  redirect.RawQuery = params.Encode()
+  http.Redirect(w, req, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, req *http.Request) {
+  if err := req.ParseForm(); err != nil {
+    tokenError(w, "invalid_request")
+    return
+  }
+
+  clientID, clientSecret, ok := req.BasicAuth()
+  if ok {
+    clientID, _ = url.QueryUnescape(clientID)
+    clientSecret, _ = url.QueryUnescape(clientSecret)
+  } else {
+    clientID = req.PostForm.Get("client_id")
+  }
+  if clientID != p.ClientID ||
+    subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) != 1 {
+    tokenError(w, "invalid_client")
+    return
+  }
+
+  code := req.PostForm.Get("code")
+  p.mu.Lock()
+  g, ok := p.codes[code]
+  delete(p.codes, code)
+  p.mu.Unlock()
+
+  switch {
+  case req.PostForm.Get("grant_type") != "authorization_code":
+    tokenError(w, "unsupported_grant_type")
+    return
+  case !ok || g.redirectURI != req.PostForm.Get("redirect_uri"):
+    tokenError(w, "invalid_grant")
+    return
+  case oidc.Challenge(req.PostForm.Get("code_verifier")) != g.challenge:
+    tokenError(w, "invalid_grant")
+    return
+  }
+
+  now := time.Now()
+  claims := g.claims
+  claims.Issuer = p.Issuer()
+  claims.Audience = oidc.Audience{p.ClientID}
+  claims.IssuedAt = now.Unix()
+  claims.Expiry = now.Add(time.Hour).Unix()
+
+  idToken, err := p.SignToken(claims)
+  if err != nil {
+    tokenError(w, "server_error")
+    return
+  }
+  writeJSON(w, http.StatusOK, map[string]any{
+    "access_token": code + ".access",
+    "token_type": "Bearer",
+    "expires_in": 3600,
+    "id_token": idToken,
+  })
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+  writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,31 @@
+package oidc
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+)
+
+// NewVerifier returns PKCE code verifier, 43 characters
+func NewVerifier() (string, error) {
+  return randomString(32)
+}
+
+// Challenge derives S256 code challenge from verifier
+func Challenge(verifier string) string {
+  sum := sha256.Sum256([]byte(verifier))
+  return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewState returns random value for state and nonce parameters
+func NewState() (string, error) {
+  return randomString(16)
+}
+
+func randomString(n int) (string, error) {
+  b := make([]byte, n)
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
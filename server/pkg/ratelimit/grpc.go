@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+  "net"
+  "time"
+  "context"
+  "strconv"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/peer"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+  "google.golang.org/grpc/metadata"
+  "google.golang.org/protobuf/types/known/durationpb"
+  "google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+const retryAfterKey = "retry-after"
+
+// GRPCKeyFunc names caller of request, req is nil for streams
+type GRPCKeyFunc func(ctx context.Context, req any) string
+
+// ClassFunc tells class of full method name
+type ClassFunc func(method string) Class
+
+// PeerIP keys calls by remote host, port differs per connection
+func PeerIP(ctx context.Context) string {
+  p, ok := peer.FromContext(ctx)
+  if !ok {
+    return "ip:unknown"
+  }
+  host, _, err := net.SplitHostPort(p.Addr.String())
+  if err != nil {
+    return "ip:" + p.Addr.String()
+  }
+  return "ip:" + host
+}
+
+/**
+ * UnaryInterceptor rejects calls over limit with ResourceExhausted,
+ * retry-after header and RetryInfo detail hold time to wait. Put it after
+ * authentication, so keys may rely on caller identity
+ */
+func (l *Limiter) UnaryInterceptor(class ClassFunc, key GRPCKeyFunc) grpc.UnaryServerInterceptor {
+  return func(
+    ctx context.Context,
+    req any,
+    info *grpc.UnaryServerInfo,
+    handler grpc.UnaryHandler,
+  ) (any, error) {
+    if err := l.checkCall(ctx, info.FullMethod, class, key(ctx, req)); err != nil {
+      return nil, err
+    }
+    return handler(ctx, req)
+  }
+}
+
+// StreamInterceptor limits opening of streams, not messages in them
+func (l *Limiter) StreamInterceptor(class ClassFunc, key GRPCKeyFunc) grpc.StreamServerInterceptor {
+  return func(
+    srv any,
+    stream grpc.ServerStream,
+    info *grpc.StreamServerInfo,
+    handler grpc.StreamHandler,
+  ) error {
+    if err := l.checkCall(stream.Context(), info.FullMethod, class, key(stream.Context(), nil)); err != nil {
+      return err
+    }
+    return handler(srv, stream)
+  }
+}
+
+func (l *Limiter) checkCall(ctx context.Context, method string, class ClassFunc, key string) error {
+  ok, wait := l.Allow(method, class(method), key)
+  if ok {
+    return nil
+  }
+  seconds := RetryAfter(wait)
+  _ = grpc.SetHeader(ctx, metadata.Pairs(retryAfterKey, strconv.Itoa(seconds)))
+  st := status.Newf(codes.ResourceExhausted, "rate limit exceeded, retry after %ds", seconds)
+  if detailed, err := st.WithDetails(&errdetails.RetryInfo{
+    RetryDelay: durationpb.New(time.Duration(seconds) * time.Second),
+  }); err == nil {
+    st = detailed
+  }
+  return st.Err()
+}
+
+/**
+ * RetryAfterOf returns seconds to wait of call limiter
+ * rejected, false for any other error
+ */
+func RetryAfterOf(err error) (int, bool) {
+  st, ok := status.FromError(err)
+  if !ok || st.Code() != codes.ResourceExhausted {
+    return 0, false
+  }
+  for _, detail := range st.Details() {
+    if info, ok := detail.(*errdetails.RetryInfo); ok {
+      return RetryAfter(info.RetryDelay.AsDuration()), true
+    }
+  }
+  return 0, false
+}
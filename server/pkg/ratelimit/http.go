@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+  "log"
+  "net"
+  "strconv"
+  "strings"
+  "net/http"
+  "encoding/json"
+)
+
+// KeyFunc names caller of request
+type KeyFunc func(req *http.Request) string
+
+/**
+ * ClientIP keys requests by client address. Behind proxy
+ * X-Real-IP and the last X-Forwarded-For entry are set by
+ * the proxy, earlier entries are client supplied
+ */
+func ClientIP(trustProxy bool) KeyFunc {
+  return func(req *http.Request) string {
+    if trustProxy {
+      if ip := req.Header.Get("X-Real-IP"); ip != "" {
+        return "ip:" + strings.TrimSpace(ip)
+      }
+      if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+        hops := strings.Split(fwd, ",")
+        return "ip:" + strings.TrimSpace(hops[len(hops)-1])
+      }
+    }
+
+    host, _, err := net.SplitHostPort(req.RemoteAddr)
+    if err != nil {
+      return "ip:" + req.RemoteAddr
+    }
+    return "ip:" + host
+  }
+}
+
+// Handler limits requests of class, route is request path
+func (l *Limiter) Handler(class Class, key KeyFunc, next http.HandlerFunc) http.HandlerFunc {
+  return func(w http.ResponseWriter, req *http.Request) {
+    if !l.Check(w, req, class, key) {
+      return
+    }
+    next(w, req)
+  }
+}
+
+/**
+ * Check takes token for request, when there is none it responds
+ * 429 Too Many Requests with Retry-After and returns false
+ */
+func (l *Limiter) Check(w http.ResponseWriter, req *http.Request, class Class, key KeyFunc) bool {
+  k := key(req)
+  ok, wait := l.Allow(req.URL.Path, class, k)
+  if ok {
+    return true
+  }
+
+  log.Println("rate limit exceeded", k, req.URL.Path)
+  TooManyRequests(w, RetryAfter(wait))
+  return false
+}
+
+// TooManyRequests responds 429 with Retry-After of seconds
+func TooManyRequests(w http.ResponseWriter, seconds int) {
+  w.Header().Set("Retry-After", strconv.Itoa(seconds))
+  w.WriteHeader(http.StatusTooManyRequests)
+  if err := json.NewEncoder(w).Encode(struct {
+    Status string      `json:"status"`
+    Description string `json:"description"`
+  }{
+    Status: "ok",
+    Description: "too many requests",
+  }); err != nil {
+    log.Println(err)
+  }
+}
@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+  "math"
+  "sync"
+  "time"
+)
+
+// Class groups routes sharing one limit
+type Class string
+
+const (
+  Read Class = "read"
+  Write Class = "write"
+  Upload Class = "upload"
+  // coarse limit of client ip, taken before caller is authenticated
+  IP Class = "ip"
+)
+
+// Rate tokens per second refill a bucket of Burst tokens,
+// zero Rate disables the limit
+type Limit struct {
+  Rate float64 `json:"rate"`
+  Burst int `json:"burst"`
+}
+
+func (l Limit) disabled() bool {
+  return l.Rate <= 0
+}
+
+/**
+ * Limits of each class, Routes override them for
+ * single http path or grpc full method name,
+ * overridden route gets its own buckets
+ */
+type Config struct {
+  Read Limit `json:"read"`
+  Write Limit `json:"write"`
+  Upload Limit `json:"upload"`
+  IP Limit `json:"ip"`
+  Routes map[string]Limit `json:"routes"`
+}
+
+// idle buckets are refilled and dropped after that long
+const sweepInterval = time.Minute
+
+/**
+ * Limiter keeps token bucket per caller key,
+ * e.g. user id, api key or client ip, and limit
+ */
+type Limiter struct {
+  mu sync.Mutex
+  cfg Config
+  buckets map[string]*bucket
+  lastSweep time.Time
+}
+
+type bucket struct {
+  limit Limit
+  tokens float64
+  updated time.Time
+}
+
+func New(cfg Config) *Limiter {
+  return &Limiter{
+    cfg: cfg,
+    buckets: make(map[string]*bucket),
+    lastSweep: time.Now(),
+  }
+}
+
+/**
+ * Allow takes one token of key bucket for route of class.
+ * When bucket is empty it returns false and the time
+ * till next token
+ */
+func (l *Limiter) Allow(route string, class Class, key string) (bool, time.Duration) {
+  limit, name := l.limit(route, class)
+  if limit.disabled() {
+    return true, 0
+  }
+
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  now := time.Now()
+  l.sweep(now)
+
+  id := name + "|" + key
+  b, ok := l.buckets[id]
+  if !ok {
+    b = &bucket{limit: limit, tokens: float64(burst(limit)), updated: now}
+    l.buckets[id] = b
+  }
+  b.refill(now)
+
+  if b.tokens < 1 {
+    wait := (1 - b.tokens) / limit.Rate
+    return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+  }
+  b.tokens -= 1
+  return true, 0
+}
+
+// limit of route and name of its buckets, ip one is not overridden
+func (l *Limiter) limit(route string, class Class) (Limit, string) {
+  if class == IP {
+    return l.cfg.IP, string(IP)
+  }
+  if limit, ok := l.cfg.Routes[route]; ok {
+    return limit, route
+  }
+  switch class {
+  case Write:
+    return l.cfg.Write, string(class)
+  case Upload:
+    return l.cfg.Upload, string(class)
+  default:
+    return l.cfg.Read, string(Read)
+  }
+}
+
+func (l *Limiter) sweep(now time.Time) {
+  if now.Sub(l.lastSweep) < sweepInterval {
+    return
+  }
+  l.lastSweep = now
+  for id, b := range l.buckets {
+    b.refill(now)
+    if b.tokens >= float64(burst(b.limit)) {
+      delete(l.buckets, id)
+    }
+  }
+}
+
+func (b *bucket) refill(now time.Time) {
+  b.tokens = math.Min(
+    float64(burst(b.limit)),
+    b.tokens + now.Sub(b.updated).Seconds() * b.limit.Rate,
+  )
+  b.updated = now
+}
+
+// at least one request passes
+func burst(limit Limit) int {
+  if limit.Burst < 1 {
+    return 1
+  }
+  return limit.Burst
+}
+
+// RetryAfter in whole seconds, as Retry-After header wants
+func RetryAfter(wait time.Duration) int {
+  return int(math.Ceil(wait.Seconds()))
+}
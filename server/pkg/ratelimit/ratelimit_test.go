@@ -0,0 +1,130 @@
+package ratelimit_test
+
+import (
+  "net"
+  "time"
+  "context"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+
+  "github.com/stretchr/testify/require"
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/peer"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+)
+
+func TestAllow(t *testing.T) {
+  l := ratelimit.New(ratelimit.Config{
+    Read: ratelimit.Limit{Rate: 20, Burst: 2},
+    Write: ratelimit.Limit{Rate: 1, Burst: 1},
+    Routes: map[string]ratelimit.Limit{
+      "/export": {Rate: 1, Burst: 1},
+    },
+  })
+
+  ok, _ := l.Allow("/read", ratelimit.Read, "user:1")
+  require.True(t, ok)
+  ok, _ = l.Allow("/read", ratelimit.Read, "user:1")
+  require.True(t, ok)
+  ok, wait := l.Allow("/read", ratelimit.Read, "user:1")
+  require.False(t, ok)
+  require.True(t, wait > 0 && wait <= 50*time.Millisecond)
+
+  // keys and classes have own buckets
+  ok, _ = l.Allow("/read", ratelimit.Read, "user:2")
+  require.True(t, ok)
+  ok, _ = l.Allow("/send", ratelimit.Write, "user:1")
+  require.True(t, ok)
+  ok, wait = l.Allow("/send", ratelimit.Write, "user:1")
+  require.False(t, ok)
+  require.Equal(t, 1, ratelimit.RetryAfter(wait))
+
+  // overridden route does not share read bucket
+  ok, _ = l.Allow("/export", ratelimit.Read, "user:1")
+  require.True(t, ok)
+  ok, _ = l.Allow("/export", ratelimit.Read, "user:1")
+  require.False(t, ok)
+
+  // no upload limit configured
+  for i := 0; i < 10; i++ {
+    ok, _ = l.Allow("/upload", ratelimit.Upload, "user:1")
+    require.True(t, ok)
+  }
+
+  // no ip limit configured, overridden route does not apply to it
+  for i := 0; i < 10; i++ {
+    ok, _ = l.Allow("/export", ratelimit.IP, "ip:192.0.2.1")
+    require.True(t, ok)
+  }
+
+  time.Sleep(60 * time.Millisecond)
+  ok, _ = l.Allow("/read", ratelimit.Read, "user:1")
+  require.True(t, ok)
+}
+
+func TestHandler(t *testing.T) {
+  l := ratelimit.New(ratelimit.Config{Write: ratelimit.Limit{Rate: 0.5, Burst: 1}})
+  h := l.Handler(ratelimit.Write, ratelimit.ClientIP(true), func(w http.ResponseWriter, _ *http.Request) {
+    w.WriteHeader(http.StatusOK)
+  })
+
+  send := func(ip string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(http.MethodPost, "/messages/v1/send", nil)
+    req.Header.Set("X-Forwarded-For", "10.0.0.1, " + ip)
+    w := httptest.NewRecorder()
+    h(w, req)
+    return w
+  }
+
+  require.Equal(t, http.StatusOK, send("192.0.2.1").Code)
+  w := send("192.0.2.1")
+  require.Equal(t, http.StatusTooManyRequests, w.Code)
+  require.Equal(t, "2", w.Header().Get("Retry-After"))
+  require.Equal(t, http.StatusOK, send("192.0.2.2").Code)
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+  l := ratelimit.New(ratelimit.Config{Write: ratelimit.Limit{Rate: 1, Burst: 1}})
+  interceptor := l.UnaryInterceptor(
+    func(method string) ratelimit.Class {
+      if method == "/Save" {
+        return ratelimit.Write
+      }
+      return ratelimit.Read
+    },
+    func(context.Context, any) string { return "user:1" },
+  )
+
+  call := func(method string) error {
+    _, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+      func(context.Context, any) (any, error) { return nil, nil })
+    return err
+  }
+
+  require.NoError(t, call("/Save"))
+  err := call("/Save")
+  require.Equal(t, codes.ResourceExhausted, status.Code(err))
+  seconds, ok := ratelimit.RetryAfterOf(err)
+  require.True(t, ok)
+  require.Equal(t, 1, seconds)
+  require.NoError(t, call("/Read"))
+
+  // other exhausted resources are not throttling
+  _, ok = ratelimit.RetryAfterOf(status.Error(codes.ResourceExhausted, "too slow"))
+  require.False(t, ok)
+}
+
+func TestPeerIP(t *testing.T) {
+  key := func(port int) string {
+    return ratelimit.PeerIP(peer.NewContext(context.Background(), &peer.Peer{
+      Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: port},
+    }))
+  }
+  // new connection does not get new bucket
+  require.Equal(t, "ip:192.0.2.1", key(50001))
+  require.Equal(t, key(50001), key(50002))
+}
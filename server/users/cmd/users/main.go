@@ -13,15 +13,32 @@ import (
   "log"
   "fmt"
   "os/signal"
+  "time"
+  "crypto/tls"
 
   "google.golang.org/grpc"
+  "github.com/hashicorp/raft"
+  "github.com/soheilhy/cmux"
+  hclog "github.com/hashicorp/go-hclog"
 
   "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/pkg/cookie"
+  "github.com/bd878/gallery/server/pkg/csrf"
+  "github.com/bd878/gallery/server/pkg/oidc"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/internal/tlsconfig"
+  "github.com/bd878/gallery/server/internal/streamlayer"
+  membership "github.com/bd878/gallery/server/internal/discovery/serf"
+  usermodel "github.com/bd878/gallery/server/users/pkg/model"
   config "github.com/bd878/gallery/server/users/config"
   httphandler "github.com/bd878/gallery/server/users/internal/handler/http"
   grpchandler "github.com/bd878/gallery/server/users/internal/handler/grpc"
   controller "github.com/bd878/gallery/server/users/internal/controller/users"
   sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+  distributed "github.com/bd878/gallery/server/users/internal/repository/distributed"
+  smtpnotifier "github.com/bd878/gallery/server/users/internal/notifier/smtp"
+  filenotifier "github.com/bd878/gallery/server/users/internal/notifier/file"
 )
 
 var (
@@ -35,6 +52,12 @@ func main() {
 
   serverCfg := loadConfig()
 
+  // users -config path migrate [status|dry-run|up|baseline N]
+  if flag.Arg(0) == "migrate" {
+    runMigrate(serverCfg.DBPath, flag.Args()[1:])
+    return
+  }
+
   if serverCfg.Debug {
     if *interactive {
       log.SetOutput(os.Stdout)
@@ -48,22 +71,45 @@ func main() {
   go trackConfig(c)
   defer close(c)
 
+  rpcAddr := serverCfg.Cluster.RpcAddr
+  if rpcAddr == "" {
+    rpcAddr = fmt.Sprintf("0.0.0.0:%d", serverCfg.GrpcPort)
+  }
+  l, err := net.Listen("tcp4", rpcAddr)
+  if err != nil {
+    panic(err)
+  }
+  defer l.Close()
+  mux := cmux.New(l)
+
+  repo := setupRaft(serverCfg, mux)
+  ctrl := controller.New(repo, newNotifier(serverCfg), controllerConfig(serverCfg))
+  setupMembership(serverCfg, rpcAddr, repo)
+
+  go func() {
+    if err := repo.WaitForLeader(time.Minute); err != nil {
+      log.Println(err)
+      return
+    }
+    grantAdmins(ctrl, serverCfg)
+  }()
+  go pruneAudit(ctrl, repo, serverCfg)
+
   var wg sync.WaitGroup
   wg.Add(2)
 
-  go func() { httpRun(serverCfg); wg.Done() }()
-  go func() { grpcRun(serverCfg); wg.Done() }()
+  go func() { httpRun(serverCfg, ctrl); wg.Done() }()
+  go func() { grpcRun(serverCfg, ctrl, repo, mux); wg.Done() }()
 
   wg.Wait()
 }
 
-func httpRun(cfg *config.Config) {
-  mem, err := sqlite.New(cfg.DBPath)
-  if err != nil {
-    panic(err)
-  }
-  ctrl := controller.New(mem)
-  h := httphandler.New(ctrl, httphandler.Config{Domainname:cfg.Domainname})
+func httpRun(cfg *config.Config, ctrl *controller.Controller) {
+  h := httphandler.New(ctrl, httphandler.Config{
+    Cookie: cookieConfig(cfg),
+    TrustProxy: cfg.TrustProxy,
+    OIDCLanding: cfg.OIDC.Landing,
+  })
 
   netCfg := net.ListenConfig{}
   l, err := netCfg.Listen(context.Background(), "tcp4", fmt.Sprintf(":%d", cfg.HttpPort))
@@ -72,39 +118,201 @@ func httpRun(cfg *config.Config) {
   }
   defer l.Close()
 
-  http.Handle("/users/v1/signup", http.HandlerFunc(h.Register))
-  http.Handle("/users/v1/login", http.HandlerFunc(h.Authenticate))
-  http.Handle("/users/v1/auth", http.HandlerFunc(h.Auth))
-  http.Handle("/users/v1/status", http.HandlerFunc(h.ReportStatus))
+  limiter := ratelimit.New(cfg.RateLimit)
+  clientIP := ratelimit.ClientIP(cfg.TrustProxy)
+  limit := func(class ratelimit.Class, next http.HandlerFunc) http.Handler {
+    return limiter.Handler(class, clientIP, next)
+  }
+
+  http.Handle("/users/v1/signup", limit(ratelimit.Write, h.Register))
+  http.Handle("/users/v1/login", limit(ratelimit.Write, h.Authenticate))
+  http.Handle("/users/v1/auth", limit(ratelimit.Read, h.Auth))
+  http.Handle("/users/v1/logout", limit(ratelimit.Write, h.Logout))
+  http.Handle("/users/v1/change_password", limit(ratelimit.Write, h.ChangePassword))
+  http.Handle("/users/v1/reset_request", limit(ratelimit.Write, h.RequestPasswordReset))
+  http.Handle("/users/v1/reset_password", limit(ratelimit.Write, h.ResetPassword))
+  http.Handle("/users/v1/delete", limit(ratelimit.Write, h.Delete))
+  http.Handle("/users/v1/deletion", limit(ratelimit.Read, h.DeletionStatus))
+  http.Handle("/users/v1/status", limit(ratelimit.Read, h.ReportStatus))
+  http.Handle("/users/v1/profile", limit(ratelimit.Read, h.Profile))
+  http.Handle("/users/v1/avatar", limit(ratelimit.Read, h.Avatar))
+  http.Handle("/users/v1/upload_avatar", limit(ratelimit.Upload, h.UploadAvatar))
+  http.Handle("/users/v1/invites", limit(ratelimit.Read, h.ListInvites))
+  http.Handle("/users/v1/invite", limit(ratelimit.Write, h.CreateInvite))
+  http.Handle("/users/v1/revoke_invite", limit(ratelimit.Write, h.RevokeInvite))
+  http.Handle("/users/v1/oidc/login", limit(ratelimit.Write, h.OIDCLogin))
+  http.Handle("/users/v1/oidc/link", limit(ratelimit.Write, h.OIDCLink))
+  http.Handle("/users/v1/oidc/callback", limit(ratelimit.Write, h.OIDCCallback))
+
+  http.Handle("/users/v1/admin/users", limit(ratelimit.Read, h.CheckAdmin(h.ListUsers)))
+  http.Handle("/users/v1/admin/disable", limit(ratelimit.Write, h.CheckAdmin(h.DisableUser)))
+  http.Handle("/users/v1/admin/enable", limit(ratelimit.Write, h.CheckAdmin(h.EnableUser)))
+  http.Handle("/users/v1/admin/logout", limit(ratelimit.Write, h.CheckAdmin(h.LogoutUser)))
+  http.Handle("/users/v1/admin/reset_password", limit(ratelimit.Write, h.CheckAdmin(h.ResetUserPassword)))
+  http.Handle("/users/v1/admin/unlock", limit(ratelimit.Write, h.CheckAdmin(h.UnlockUser)))
+  http.Handle("/users/v1/admin/audit", limit(ratelimit.Read, h.CheckAdmin(h.ListAudit)))
+
+  protect := csrf.New(csrf.Config{
+    Cookie: cookieConfig(cfg),
+    AllowedOrigins: cfg.AllowedOrigins,
+  }).Protect
 
   log.Println("http server is listening on =", l.Addr())
-  if err := http.Serve(l, nil); err != nil {
+  if err := http.Serve(l, limitBody(protect(http.DefaultServeMux))); err != nil {
     panic(err)
   }
   log.Println("http server exited")
 }
 
-func grpcRun(cfg *config.Config) {
-  mem, err := sqlite.New(cfg.DBPath)
+func grpcRun(cfg *config.Config, ctrl *controller.Controller, repo *distributed.Repository, mux cmux.CMux) {
+  serverTLS, _ := tlsConfigs(cfg)
+  srv := grpc.NewServer(
+    grpc.Creds(grpcutil.Credentials(serverTLS)),
+    grpc.UnaryInterceptor(grpchandler.AuditInterceptor),
+    grpc.MaxRecvMsgSize(distributed.MaxCommandSize),
+  )
+  api.RegisterUserServiceServer(srv, grpchandler.New(ctrl, repo, cfg.ServiceToken))
+  api.RegisterUserRaftServer(srv, grpchandler.NewRaft(repo, cfg.ServiceToken))
+
+  grpcLn := mux.Match(cmux.Any())
+  go func() {
+    if err := srv.Serve(grpcLn); err != nil {
+      log.Println("grpc server exited", err)
+    }
+  }()
+
+  log.Println("grpc server is listening on =", grpcLn.Addr())
+  if err := mux.Serve(); err != nil {
+    panic(err)
+  }
+  log.Println("grpc server exited")
+}
+
+// forms are small, avatar upload has room for the file
+const maxFormSize = 1 << 20
+
+// limitBody caps request bodies before csrf check parses forms
+func limitBody(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    size := int64(maxFormSize)
+    if req.URL.Path == "/users/v1/upload_avatar" {
+      size += httphandler.MaxAvatarSize
+    }
+    req.Body = http.MaxBytesReader(w, req.Body, size)
+    next.ServeHTTP(w, req)
+  })
+}
+
+/**
+ * Replicated repository, raft shares gRPC port,
+ * its connections start with streamlayer.RaftRPC byte
+ */
+func setupRaft(cfg *config.Config, mux cmux.CMux) *distributed.Repository {
+  local, err := sqlite.New(cfg.DBPath)
   if err != nil {
     panic(err)
   }
-  ctrl := controller.New(mem)
-  h := grpchandler.New(ctrl)
-  netCfg := net.ListenConfig{}
-  l, err := netCfg.Listen(context.Background(), "tcp4", fmt.Sprintf(":%d", cfg.GrpcPort))
+
+  raftLogLevel := hclog.Error.String()
+  switch cfg.Cluster.RaftLogLevel {
+  case "debug":
+    raftLogLevel = hclog.Debug.String()
+  case "info":
+    raftLogLevel = hclog.Info.String()
+  }
+
+  nodeName := cfg.Cluster.NodeName
+  if nodeName == "" {
+    nodeName = fmt.Sprintf("users.%d", cfg.GrpcPort)
+  }
+
+  serverTLS, peerTLS := tlsConfigs(cfg)
+  repo, err := distributed.New(local, distributed.Config{
+    Raft: raft.Config{
+      LocalID: raft.ServerID(nodeName),
+      LogLevel: raftLogLevel,
+    },
+    StreamLayer: streamlayer.New(mux.Match(streamlayer.Match), serverTLS, peerTLS),
+    Bootstrap: cfg.Cluster.RaftBootstrap,
+    DataDir: cfg.Cluster.DataPath,
+    Servers: cfg.Cluster.RaftServers,
+    Credentials: grpcutil.Credentials(peerTLS),
+    ServiceToken: cfg.ServiceToken,
+  })
   if err != nil {
     panic(err)
   }
-  defer l.Close()
+  return repo
+}
 
-  srv := grpc.NewServer()
-  api.RegisterUserServiceServer(srv, h)
-  log.Println("grpc server is listening on =", l.Addr())
-  if err := srv.Serve(l); err != nil {
+func setupMembership(cfg *config.Config, rpcAddr string, repo *distributed.Repository) {
+  if cfg.Cluster.SerfAddr == "" {
+    return
+  }
+
+  nodeName := cfg.Cluster.NodeName
+  if nodeName == "" {
+    nodeName = fmt.Sprintf("users.%d", cfg.GrpcPort)
+  }
+
+  key, err := membership.ParseKey(cfg.Cluster.SerfEncryptKey)
+  if err != nil {
     panic(err)
   }
-  log.Println("grpc server exited")
+
+  _, err = membership.New(
+    membership.Config{
+      NodeName: nodeName,
+      BindAddr: cfg.Cluster.SerfAddr,
+      Tags: map[string]string{
+        "raft_addr": rpcAddr,
+      },
+      SerfJoinAddrs: cfg.Cluster.SerfJoinAddrs,
+      EncryptKey: key,
+    },
+    repo,
+  )
+  if err != nil {
+    panic(err)
+  }
+}
+
+// tlsConfigs of accepted and dialed node connections, nil when tls is off
+func tlsConfigs(cfg *config.Config) (*tls.Config, *tls.Config) {
+  tlsCfg := tlsconfig.Config{
+    CAFile: cfg.TLS.CAFile,
+    CertFile: cfg.TLS.CertFile,
+    KeyFile: cfg.TLS.KeyFile,
+    ServerName: cfg.TLS.ServerName,
+    AllowedPeers: cfg.TLS.AllowedPeers,
+  }
+  if !tlsCfg.Enabled() {
+    return nil, nil
+  }
+
+  serverTLS, err := tlsCfg.Server()
+  if err != nil {
+    panic(err)
+  }
+  peerTLS, err := tlsCfg.Client()
+  if err != nil {
+    panic(err)
+  }
+  return serverTLS, peerTLS
+}
+
+func runMigrate(dbPath string, args []string) {
+  db, err := sqlite.Open(dbPath)
+  if err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+  }
+  defer db.Close()
+
+  if err := sqlite.Schema.Command(context.Background(), db, os.Stdout, args); err != nil {
+    fmt.Fprintln(os.Stderr, err)
+    os.Exit(1)
+  }
 }
 
 func loadConfig() *config.Config {
@@ -122,6 +330,127 @@ func loadConfig() *config.Config {
   return &cfg
 }
 
+func cookieConfig(cfg *config.Config) cookie.Config {
+  res := cfg.Cookie
+  if res == (cookie.Config{}) {
+    res.HttpOnly = true
+    res.SameSite = "lax"
+  }
+  if res.Domain == "" {
+    res.Domain = cfg.Domainname
+  }
+  if err := res.Validate(); err != nil {
+    panic(err)
+  }
+  return res
+}
+
+func grantAdmins(ctrl *controller.Controller, cfg *config.Config) {
+  for _, name := range cfg.Admins {
+    if err := ctrl.SetRole(context.Background(), name, usermodel.RoleAdmin); err != nil {
+      log.Println("cannot grant admin role to", name, err)
+    }
+  }
+}
+
+// pruneAudit drops expired audit events, only the leader
+// writes, followers receive the prune through raft
+func pruneAudit(ctrl *controller.Controller, repo *distributed.Repository, cfg *config.Config) {
+  if cfg.Audit.RetentionDays == 0 || cfg.Audit.PruneIntervalSec == 0 {
+    return
+  }
+
+  ticker := time.NewTicker(time.Duration(cfg.Audit.PruneIntervalSec)*time.Second)
+  defer ticker.Stop()
+  for range ticker.C {
+    if !repo.IsLeader() {
+      continue
+    }
+    n, err := ctrl.PruneAudit(context.Background())
+    if err != nil {
+      log.Println("cannot prune audit log", err)
+      continue
+    }
+    if n > 0 {
+      log.Println("pruned audit events", n)
+    }
+  }
+}
+
+func controllerConfig(cfg *config.Config) controller.Config {
+  lockout := controller.DefaultLockoutConfig
+  if cfg.Lockout.AccountAttempts != 0 {
+    lockout.AccountAttempts = cfg.Lockout.AccountAttempts
+  }
+  if cfg.Lockout.IPAttempts != 0 {
+    lockout.IPAttempts = cfg.Lockout.IPAttempts
+  }
+  if cfg.Lockout.BaseDelaySec != 0 {
+    lockout.BaseDelay = time.Duration(cfg.Lockout.BaseDelaySec)*time.Second
+  }
+  if cfg.Lockout.MaxDelaySec != 0 {
+    lockout.MaxDelay = time.Duration(cfg.Lockout.MaxDelaySec)*time.Second
+  }
+  if cfg.Lockout.WindowSec != 0 {
+    lockout.Window = time.Duration(cfg.Lockout.WindowSec)*time.Second
+  }
+
+  resetTokenTTL := time.Hour
+  if cfg.ResetTokenTTLSec != 0 {
+    resetTokenTTL = time.Duration(cfg.ResetTokenTTLSec)*time.Second
+  }
+
+  registration := controller.DefaultRegistrationConfig
+  switch cfg.Registration.Policy {
+  case "":
+  case controller.PolicyOpen, controller.PolicyInvite, controller.PolicyClosed:
+    registration.Policy = cfg.Registration.Policy
+  default:
+    panic(fmt.Sprintf("unknown registration policy %q", cfg.Registration.Policy))
+  }
+  registration.InviteQuota = cfg.Registration.InviteQuota
+  if cfg.Registration.InviteTTLSec != 0 {
+    registration.InviteTTL = time.Duration(cfg.Registration.InviteTTLSec)*time.Second
+  }
+
+  var identityProvider controller.IdentityProvider
+  if cfg.OIDC.Issuer != "" {
+    identityProvider = oidc.New(oidc.Config{
+      Issuer: cfg.OIDC.Issuer,
+      ClientID: cfg.OIDC.ClientID,
+      ClientSecret: cfg.OIDC.ClientSecret,
+      RedirectURL: cfg.OIDC.RedirectURL,
+      Scopes: cfg.OIDC.Scopes,
+    })
+  }
+
+  return controller.Config{
+    Lockout: lockout,
+    ResetTokenTTL: resetTokenTTL,
+    Registration: registration,
+    OIDC: controller.OIDCConfig{
+      Provider: identityProvider,
+      AutoProvision: cfg.OIDC.AutoProvision,
+    },
+    AuditRetention: time.Duration(cfg.Audit.RetentionDays)*24*time.Hour,
+  }
+}
+
+func newNotifier(cfg *config.Config) controller.Notifier {
+  switch cfg.Notifier.Type {
+  case "smtp":
+    return smtpnotifier.New(smtpnotifier.Config{
+      Addr: cfg.Notifier.SMTP.Addr,
+      From: cfg.Notifier.SMTP.From,
+      Username: cfg.Notifier.SMTP.Username,
+      Password: cfg.Notifier.SMTP.Password,
+      ResetURL: cfg.Notifier.ResetURL,
+    })
+  default:
+    return filenotifier.New(cfg.Notifier.File)
+  }
+}
+
 func trackConfig(c chan os.Signal) {
   signal.Notify(c, syscall.SIGHUP)
 
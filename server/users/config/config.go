@@ -1,5 +1,10 @@
 package config
 
+import (
+  "github.com/bd878/gallery/server/pkg/cookie"
+  "github.com/bd878/gallery/server/pkg/ratelimit"
+)
+
 type Config struct {
   HttpPort int `json:"httpport"`
   GrpcPort int `json:"grpcport"`
@@ -7,4 +12,114 @@ type Config struct {
   LogFile string `json:"logFile"`
   DBPath string `json:"dbPath"`
   Domainname string `json:"domainname"`
+  // shared by users nodes and messages servers, authenticates
+  // their calls to service-only grpc methods, empty refuses them
+  ServiceToken string `json:"serviceToken"`
+  // take client ip from X-Real-IP, set only when every request comes
+  // through nginx, otherwise clients spoof it and dodge lockout
+  TrustProxy bool `json:"trustProxy"`
+  Lockout LockoutConfig `json:"lockout"`
+  Notifier NotifierConfig `json:"notifier"`
+  ResetTokenTTLSec int `json:"resetTokenTtlSec"`
+  // user names granted admin role on start
+  Admins []string `json:"admins"`
+  Registration RegistrationConfig `json:"registration"`
+  // cookie domain defaults to Domainname
+  Cookie cookie.Config `json:"cookie"`
+  // origins besides own host allowed to make
+  // state-changing requests
+  AllowedOrigins []string `json:"allowedOrigins"`
+  Cluster ClusterConfig `json:"cluster"`
+  TLS TLSConfig `json:"tls"`
+  OIDC OIDCConfig `json:"oidc"`
+  Audit AuditConfig `json:"audit"`
+  // per client ip limits of http routes, zero rate is unlimited
+  RateLimit ratelimit.Config `json:"rateLimit"`
+}
+
+// RetentionDays 0 keeps audit events forever,
+// the leader prunes every PruneIntervalSec
+type AuditConfig struct {
+  RetentionDays int `json:"retentionDays"`
+  PruneIntervalSec int `json:"pruneIntervalSec"`
+}
+
+/**
+ * OpenID Connect login, disabled while Issuer is empty.
+ * RedirectURL is /users/v1/oidc/callback as the provider
+ * reaches it, Landing is where the callback sends the browser
+ */
+type OIDCConfig struct {
+  Issuer string `json:"issuer"`
+  ClientID string `json:"clientId"`
+  ClientSecret string `json:"clientSecret"`
+  RedirectURL string `json:"redirectUrl"`
+  Scopes []string `json:"scopes"`
+  AutoProvision bool `json:"autoProvision"`
+  Landing string `json:"landing"`
+}
+
+/**
+ * Users service replicas. RpcAddr serves both gRPC and raft,
+ * defaults to 0.0.0.0:grpcport. Empty SerfAddr runs
+ * without membership, servers join by RaftServers only
+ */
+type ClusterConfig struct {
+  NodeName string `json:"nodeName"`
+  RpcAddr string `json:"rpcAddr"`
+  SerfAddr string `json:"serfAddr"`
+  SerfJoinAddrs []string `json:"serfJoinAddrs"`
+  RaftBootstrap bool `json:"raftBootstrap"`
+  RaftServers []string `json:"raftServers"`
+  RaftLogLevel string `json:"raftLogLevel"`
+  DataPath string `json:"dataPath"`
+  // base64 gossip key, shared by all users nodes
+  SerfEncryptKey string `json:"serfEncryptKey"`
+}
+
+/**
+ * Mutual tls for raft and gRPC, disabled while files are
+ * empty. ServerName is in every users server certificate,
+ * AllowedPeers lists names of users and messages nodes
+ */
+type TLSConfig struct {
+  CAFile string `json:"caFile"`
+  CertFile string `json:"certFile"`
+  KeyFile string `json:"keyFile"`
+  ServerName string `json:"serverName"`
+  AllowedPeers []string `json:"allowedPeers"`
+}
+
+// Policy is "open", "invite" or "closed", empty means open.
+// InviteQuota limits invites a non-admin user may create,
+// 0 leaves invites to admins only
+type RegistrationConfig struct {
+  Policy string `json:"policy"`
+  InviteQuota int `json:"inviteQuota"`
+  InviteTTLSec int `json:"inviteTtlSec"`
+}
+
+// Zero values fall back to controller defaults
+type LockoutConfig struct {
+  AccountAttempts int `json:"accountAttempts"`
+  IPAttempts int `json:"ipAttempts"`
+  BaseDelaySec int `json:"baseDelaySec"`
+  MaxDelaySec int `json:"maxDelaySec"`
+  WindowSec int `json:"windowSec"`
+}
+
+// Type is "smtp" or "file", file notifier writes
+// to File, or to the log when File is empty
+type NotifierConfig struct {
+  Type string `json:"type"`
+  File string `json:"file"`
+  ResetURL string `json:"resetUrl"`
+  SMTP SMTPConfig `json:"smtp"`
+}
+
+type SMTPConfig struct {
+  Addr string `json:"addr"`
+  From string `json:"from"`
+  Username string `json:"username"`
+  Password string `json:"password"`
 }
@@ -5,3 +5,20 @@ import "errors"
 var ErrTokenInvalid = errors.New("token invalid")
 var ErrTokenExpired = errors.New("token expired")
 var ErrNotFound = errors.New("not found")
+var ErrWrongPassword = errors.New("wrong name or password")
+var ErrLocked = errors.New("too many failed attempts, locked")
+var ErrNoDeletion = errors.New("no such deletion")
+var ErrResetInvalid = errors.New("reset token invalid or expired")
+var ErrDisabled = errors.New("user disabled")
+var ErrForbidden = errors.New("admin role required")
+var ErrRegistrationClosed = errors.New("registration closed")
+var ErrInviteInvalid = errors.New("invite invalid, used or expired")
+var ErrInviteQuota = errors.New("invite quota exceeded")
+var ErrNameExists = errors.New("name exists")
+var ErrBadEmail = errors.New("bad email")
+var ErrBadProfile = errors.New("bad profile")
+var ErrOIDCDisabled = errors.New("oidc login disabled")
+var ErrOIDCState = errors.New("oidc state mismatch")
+var ErrOIDCToken = errors.New("oidc identity token invalid")
+var ErrIdentityUnknown = errors.New("no account linked to identity")
+var ErrIdentityLinked = errors.New("identity linked to another account")
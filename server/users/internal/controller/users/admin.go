@@ -0,0 +1,93 @@
+package users
+
+import (
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  "github.com/bd878/gallery/server/users/internal/controller"
+)
+
+// Authorize returns session user if it has admin role
+func (c *Controller) Authorize(ctx context.Context, token string) (*model.User, error) {
+  user, err := c.Get(ctx, &model.User{Token: token})
+  if err != nil {
+    return nil, err
+  }
+  if user.Role != model.RoleAdmin {
+    return nil, controller.ErrForbidden
+  }
+  return user, nil
+}
+
+// ListUsers returns users without passwords and tokens
+func (c *Controller) ListUsers(ctx context.Context, query string, limit, offset int32) ([]*model.User, error) {
+  users, err := c.repo.List(ctx, query, limit, offset)
+  if err != nil {
+    return nil, err
+  }
+
+  res := make([]*model.User, len(users))
+  for i, user := range users {
+    res[i] = &model.User{
+      Id: user.Id,
+      Name: user.Name,
+      Email: user.Email,
+      Role: user.Role,
+      Disabled: user.Disabled,
+    }
+  }
+  return res, nil
+}
+
+// Disable logs user out as well, so open sessions end now
+func (c *Controller) Disable(ctx context.Context, id model.UserId) error {
+  err := mapNoUser(c.repo.SetDisabled(ctx, id, true))
+  if err == nil {
+    err = c.Logout(ctx, id)
+  }
+  c.auditUser(ctx, model.AuditAdminDisable, &model.User{Id: id}, err)
+  return err
+}
+
+func (c *Controller) Enable(ctx context.Context, id model.UserId) error {
+  err := mapNoUser(c.repo.SetDisabled(ctx, id, false))
+  c.auditUser(ctx, model.AuditAdminEnable, &model.User{Id: id}, err)
+  return err
+}
+
+func (c *Controller) Logout(ctx context.Context, id model.UserId) error {
+  return mapNoUser(c.repo.Logout(ctx, id))
+}
+
+// AdminLogout ends sessions of user on admin request
+func (c *Controller) AdminLogout(ctx context.Context, id model.UserId) error {
+  err := c.Logout(ctx, id)
+  c.auditUser(ctx, model.AuditAdminLogout, &model.User{Id: id}, err)
+  return err
+}
+
+func (c *Controller) SetRole(ctx context.Context, name, role string) error {
+  err := mapNoUser(c.repo.SetRole(ctx, name, role))
+  c.audit(ctx, &model.AuditEvent{
+    Event: model.AuditAdminSetRole,
+    Name: name,
+    Success: err == nil,
+    Details: map[string]string{"role": role},
+  })
+  return err
+}
+
+// AdminResetPassword sets password chosen by admin, user is logged out
+func (c *Controller) AdminResetPassword(ctx context.Context, id model.UserId, password string) error {
+  _, err := c.setPassword(ctx, id, password)
+  c.auditUser(ctx, model.AuditAdminResetPassword, &model.User{Id: id}, err)
+  return err
+}
+
+func mapNoUser(err error) error {
+  if err == repository.ErrNoUser {
+    return controller.ErrNotFound
+  }
+  return err
+}
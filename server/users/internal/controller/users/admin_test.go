@@ -0,0 +1,52 @@
+package users_test
+
+import (
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestAdmin(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{Lockout: users.DefaultLockoutConfig})
+
+  ctx := context.Background()
+  adminToken, expires := users.NewToken()
+  require.NoError(t, ctrl.Add(ctx, &model.User{Name: "root", Password: "root", Token: adminToken, Expires: expires}))
+  userToken, expires := users.NewToken()
+  require.NoError(t, ctrl.Add(ctx, &model.User{Name: "alice", Password: "secret", Token: userToken, Expires: expires}))
+
+  _, err = ctrl.Authorize(ctx, adminToken)
+  require.ErrorIs(t, err, controller.ErrForbidden)
+
+  require.NoError(t, ctrl.SetRole(ctx, "root", model.RoleAdmin))
+  _, err = ctrl.Authorize(ctx, adminToken)
+  require.NoError(t, err)
+
+  list, err := ctrl.ListUsers(ctx, "ali", 10, 0)
+  require.NoError(t, err)
+  require.Len(t, list, 1)
+  require.Equal(t, "alice", list[0].Name)
+  require.Empty(t, list[0].Password)
+
+  require.NoError(t, ctrl.Disable(ctx, list[0].Id))
+
+  _, err = ctrl.Get(ctx, &model.User{Token: userToken})
+  require.Error(t, err)
+  _, err = ctrl.Login(ctx, &model.User{Name: "alice", Password: "secret"}, "")
+  require.ErrorIs(t, err, controller.ErrDisabled)
+
+  require.NoError(t, ctrl.Enable(ctx, list[0].Id))
+  _, err = ctrl.Login(ctx, &model.User{Name: "alice", Password: "secret"}, "")
+  require.NoError(t, err)
+
+  require.ErrorIs(t, ctrl.Disable(ctx, 100), controller.ErrNotFound)
+}
@@ -0,0 +1,77 @@
+package users
+
+import (
+  "log"
+  "time"
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const maxAuditLimit = 1000
+
+type auditKey int
+
+const (
+  clientKey auditKey = iota
+  actorKey
+)
+
+// WithClient records client address for audit log
+func WithClient(ctx context.Context, ip string) context.Context {
+  return context.WithValue(ctx, clientKey, ip)
+}
+
+// WithActor records admin acting in request for audit log
+func WithActor(ctx context.Context, actor *model.User) context.Context {
+  return context.WithValue(ctx, actorKey, actor)
+}
+
+/**
+ * audit appends event, client and actor are taken from ctx.
+ * Failure to write is logged, it does not fail the
+ * action audited
+ */
+func (c *Controller) audit(ctx context.Context, event *model.AuditEvent) {
+  if ip, ok := ctx.Value(clientKey).(string); ok && event.IP == "" {
+    event.IP = ip
+  }
+  if actor, ok := ctx.Value(actorKey).(*model.User); ok && actor != nil {
+    event.ActorId = actor.Id
+  }
+  if err := c.repo.AddAudit(ctx, event); err != nil {
+    log.Println("failed to write audit event", event.Event, err)
+  }
+}
+
+func (c *Controller) auditUser(ctx context.Context, event string, user *model.User, err error) {
+  e := &model.AuditEvent{
+    Event: event,
+    UserId: user.Id,
+    Name: user.Name,
+    Success: err == nil,
+  }
+  if err != nil {
+    e.Details = map[string]string{"error": err.Error()}
+  }
+  c.audit(ctx, e)
+}
+
+// ListAudit queries audit log, limit is capped
+func (c *Controller) ListAudit(ctx context.Context, filter *model.AuditFilter) ([]*model.AuditEvent, error) {
+  if filter.Limit <= 0 || filter.Limit > maxAuditLimit {
+    filter.Limit = maxAuditLimit
+  }
+  if filter.Offset < 0 {
+    filter.Offset = 0
+  }
+  return c.repo.ListAudit(ctx, filter)
+}
+
+// PruneAudit drops events past retention, run on leader only
+func (c *Controller) PruneAudit(ctx context.Context) (int64, error) {
+  if c.cfg.AuditRetention <= 0 {
+    return 0, nil
+  }
+  return c.repo.PruneAudit(ctx, time.Now().Add(-c.cfg.AuditRetention))
+}
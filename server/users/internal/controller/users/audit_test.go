@@ -0,0 +1,86 @@
+package users_test
+
+import (
+  "time"
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestAudit(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{
+    Lockout: users.DefaultLockoutConfig,
+    Registration: users.DefaultRegistrationConfig,
+    AuditRetention: 24*time.Hour,
+  })
+  ctx := users.WithClient(context.Background(), "10.0.0.1")
+
+  alice, err := ctrl.SignUp(ctx, "alice", "secret", "", "")
+  require.NoError(t, err)
+  admin, err := ctrl.SignUp(ctx, "admin", "secret", "", "")
+  require.NoError(t, err)
+
+  _, _, err = ctrl.Authenticate(ctx, "alice", "guess", "10.0.0.2")
+  require.ErrorIs(t, err, controller.ErrWrongPassword)
+  _, _, err = ctrl.Authenticate(ctx, "alice", "secret", "10.0.0.2")
+  require.NoError(t, err)
+  require.NoError(t, ctrl.LogoutToken(ctx, alice.Token))
+  require.NoError(t, ctrl.Disable(users.WithActor(ctx, admin), alice.Id))
+
+  events, err := ctrl.ListAudit(ctx, &model.AuditFilter{UserId: alice.Id})
+  require.NoError(t, err)
+  var names []string
+  for _, e := range events {
+    names = append(names, e.Event)
+  }
+  // failed login is recorded by name, user is unknown to it
+  require.Equal(t, []string{
+    model.AuditAdminDisable,
+    model.AuditLogout,
+    model.AuditLogin,
+    model.AuditSignup,
+  }, names)
+  require.Equal(t, admin.Id, events[0].ActorId)
+  require.Equal(t, "10.0.0.1", events[0].IP)
+  require.Equal(t, "10.0.0.2", events[2].IP)
+
+  logins, err := ctrl.ListAudit(ctx, &model.AuditFilter{Events: []string{model.AuditLogin}})
+  require.NoError(t, err)
+  require.Len(t, logins, 2)
+  require.True(t, logins[0].Success)
+  require.False(t, logins[1].Success)
+  require.Equal(t, "alice", logins[1].Name)
+  require.NotEmpty(t, logins[1].Details["error"])
+
+  page, err := ctrl.ListAudit(ctx, &model.AuditFilter{Limit: 2, Offset: 1})
+  require.NoError(t, err)
+  require.Len(t, page, 2)
+  require.Equal(t, model.AuditLogout, page[0].Event)
+
+  // events older than retention are pruned
+  old := time.Now().Add(-48*time.Hour)
+  require.NoError(t, repo.At(old).AddAudit(ctx, &model.AuditEvent{
+    Event: model.AuditLogin,
+    Name: "bob",
+  }))
+  before, err := ctrl.ListAudit(ctx, &model.AuditFilter{Until: time.Now().Add(-time.Hour)})
+  require.NoError(t, err)
+  require.Len(t, before, 1)
+
+  n, err := ctrl.PruneAudit(ctx)
+  require.NoError(t, err)
+  require.Equal(t, int64(1), n)
+
+  all, err := ctrl.ListAudit(ctx, &model.AuditFilter{})
+  require.NoError(t, err)
+  require.Len(t, all, 6)
+}
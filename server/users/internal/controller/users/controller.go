@@ -11,17 +11,66 @@ import (
 
 type Repository interface {
   Add(context.Context, *model.User) error
+  AddFirst(context.Context, *model.User) error
   Has(context.Context, *model.User) (bool, error)
   Refresh(context.Context, *model.User) error
   Get(context.Context, *model.User) (*model.User, error)
+  GetAttempts(context.Context, string) (*model.LoginAttempts, error)
+  AddFailure(context.Context, string, time.Time, time.Duration) (*model.LoginAttempts, error)
+  LockUntil(context.Context, string, time.Time) error
+  ResetAttempts(context.Context, string) error
+  ForgiveFailure(context.Context, string) error
+  Delete(context.Context, *model.Deletion) error
+  GetDeletion(context.Context, string) (*model.Deletion, error)
+  ListDeletions(context.Context) ([]*model.Deletion, error)
+  UpdateDeletion(context.Context, *model.Deletion) error
+  UpdatePassword(context.Context, *model.User) error
+  AddPasswordReset(context.Context, *model.PasswordReset) error
+  UsePasswordReset(context.Context, string, time.Time) (model.UserId, error)
+  List(context.Context, string, int32, int32) ([]*model.User, error)
+  SetDisabled(context.Context, model.UserId, bool) error
+  SetRole(context.Context, string, string) error
+  Logout(context.Context, model.UserId) error
+  GetByIds(context.Context, []model.UserId) ([]*model.User, error)
+  UpdateProfile(context.Context, model.UserId, *model.Profile) error
+  SetAvatar(context.Context, model.UserId, string, []byte) error
+  GetAvatar(context.Context, string) ([]byte, error)
+  AddInvited(context.Context, *model.User, string, time.Time) error
+  AddInvite(context.Context, *model.Invite, time.Time, int) error
+  GetInvite(context.Context, string) (*model.Invite, error)
+  ListInvites(context.Context, model.UserId) ([]*model.Invite, error)
+  CountInviteSeats(context.Context, model.UserId) (int, error)
+  RevokeInvite(context.Context, string, model.UserId) error
+  GetIdentity(context.Context, string, string) (*model.Identity, error)
+  AddIdentity(context.Context, *model.Identity) error
+  AddWithIdentity(context.Context, *model.User, *model.Identity, string, time.Time) error
+  AddAudit(context.Context, *model.AuditEvent) error
+  ListAudit(context.Context, *model.AuditFilter) ([]*model.AuditEvent, error)
+  PruneAudit(context.Context, time.Time) (int64, error)
+}
+
+// Delivers password reset tokens to users
+type Notifier interface {
+  NotifyPasswordReset(ctx context.Context, user *model.User, token string) error
+}
+
+type Config struct {
+  Lockout LockoutConfig
+  ResetTokenTTL time.Duration
+  Registration RegistrationConfig
+  OIDC OIDCConfig
+  // audit events older than that are pruned, 0 keeps them
+  AuditRetention time.Duration
 }
 
 type Controller struct {
-  repo Repository
+  repo     Repository
+  notifier Notifier
+  cfg      Config
 }
 
-func New(repo Repository) *Controller {
-  return &Controller{repo}
+func New(repo Repository, notifier Notifier, cfg Config) *Controller {
+  return &Controller{repo, notifier, cfg}
 }
 
 func (c *Controller) Add(ctx context.Context, user *model.User) error {
@@ -50,6 +99,10 @@ func (c *Controller) Get(ctx context.Context, user *model.User) (*model.User, er
     return nil, err
   }
 
+  if result.Disabled {
+    return nil, controller.ErrDisabled
+  }
+
   if result.Expires == "" {
     return nil, controller.ErrTokenExpired
   }
@@ -68,6 +121,10 @@ func (c *Controller) Get(ctx context.Context, user *model.User) (*model.User, er
     Name: result.Name,
     Token: result.Token,
     Expires: result.Expires,
+    Email: result.Email,
+    Role: result.Role,
+    InvitedBy: result.InvitedBy,
+    Profile: result.Profile,
   }, nil
 }
 
@@ -0,0 +1,61 @@
+package users
+
+import (
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/utils"
+)
+
+/**
+ * Deletes account right away, so it can no longer log in.
+ * Messages service picks pending deletion up
+ * and purges user messages and files
+ */
+func (c *Controller) Delete(ctx context.Context, user *model.User) (*model.Deletion, error) {
+  deletion := &model.Deletion{
+    Id: utils.RandomString(16),
+    UserId: user.Id,
+    Status: model.DeletionPending,
+  }
+
+  err := c.repo.Delete(ctx, deletion)
+  if err == repository.ErrNoUser {
+    err = controller.ErrNotFound
+  }
+  c.auditUser(ctx, model.AuditAccountDelete, user, err)
+  if err != nil {
+    return nil, err
+  }
+
+  if err := c.repo.ResetAttempts(ctx, accountKey(user.Name)); err != nil {
+    return nil, err
+  }
+
+  return c.GetDeletion(ctx, deletion.Id)
+}
+
+func (c *Controller) GetDeletion(ctx context.Context, id string) (*model.Deletion, error) {
+  deletion, err := c.repo.GetDeletion(ctx, id)
+  if err == repository.ErrNoDeletion {
+    return nil, controller.ErrNoDeletion
+  }
+  return deletion, err
+}
+
+func (c *Controller) ListDeletions(ctx context.Context) ([]*model.Deletion, error) {
+  return c.repo.ListDeletions(ctx)
+}
+
+func (c *Controller) UpdateDeletion(ctx context.Context, deletion *model.Deletion) (*model.Deletion, error) {
+  err := c.repo.UpdateDeletion(ctx, deletion)
+  if err == repository.ErrNoDeletion {
+    return nil, controller.ErrNoDeletion
+  }
+  if err != nil {
+    return nil, err
+  }
+  return c.GetDeletion(ctx, deletion.Id)
+}
@@ -0,0 +1,135 @@
+package users
+
+import (
+  "time"
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/utils"
+)
+
+const (
+  PolicyOpen = "open"
+  PolicyInvite = "invite"
+  PolicyClosed = "closed"
+)
+
+type RegistrationConfig struct {
+  Policy string
+  // signups non-admin user may invite, 0 leaves invites to admins
+  InviteQuota int
+  InviteTTL time.Duration
+}
+
+var DefaultRegistrationConfig = RegistrationConfig{
+  Policy: PolicyOpen,
+  InviteQuota: 0,
+  InviteTTL: 7*24*time.Hour,
+}
+
+/**
+ * Register adds user according to registration policy.
+ * Invite code is required on invite policy, except for
+ * the very first account, and optional on open one,
+ * inviter is recorded whenever code is given
+ */
+func (c *Controller) Register(ctx context.Context, user *model.User, code string) error {
+  switch c.cfg.Registration.Policy {
+  case PolicyClosed:
+    return controller.ErrRegistrationClosed
+
+  case PolicyInvite:
+    if code == "" {
+      // first account, to be granted admin, needs no invite,
+      // only one of concurrent signups gets in
+      err := c.repo.AddFirst(ctx, user)
+      if err == repository.ErrUsersExist {
+        return controller.ErrInviteInvalid
+      }
+      return err
+    }
+  }
+
+  if code == "" {
+    return c.repo.Add(ctx, user)
+  }
+
+  err := c.repo.AddInvited(ctx, user, code, time.Now())
+  if err == repository.ErrNoInvite {
+    return controller.ErrInviteInvalid
+  }
+  return err
+}
+
+/**
+ * CreateInvite makes invite code admitting maxUses signups.
+ * Admins are not limited, other users share InviteQuota
+ * seats among all their invites
+ */
+func (c *Controller) CreateInvite(ctx context.Context, user *model.User, maxUses int, ttl time.Duration) (
+  *model.Invite, error,
+) {
+  if c.cfg.Registration.Policy == PolicyClosed {
+    return nil, controller.ErrRegistrationClosed
+  }
+
+  if maxUses < 1 {
+    maxUses = 1
+  }
+  if ttl <= 0 {
+    ttl = c.cfg.Registration.InviteTTL
+  }
+
+  // seats are counted along with insert, concurrent invites do not oversubscribe
+  quota := 0
+  if user.Role != model.RoleAdmin {
+    if c.cfg.Registration.InviteQuota == 0 {
+      return nil, controller.ErrForbidden
+    }
+    quota = c.cfg.Registration.InviteQuota
+  }
+
+  code, err := utils.RandomToken(12)
+  if err != nil {
+    return nil, err
+  }
+
+  invite := &model.Invite{
+    Code: code,
+    CreatedBy: user.Id,
+    MaxUses: maxUses,
+  }
+  err = c.repo.AddInvite(ctx, invite, time.Now().Add(ttl), quota)
+  if err == repository.ErrInviteQuota {
+    return nil, controller.ErrInviteQuota
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  return c.repo.GetInvite(ctx, code)
+}
+
+// ListInvites returns invites made by user, admins see all
+func (c *Controller) ListInvites(ctx context.Context, user *model.User) ([]*model.Invite, error) {
+  return c.repo.ListInvites(ctx, inviteOwner(user))
+}
+
+// RevokeInvite stops further signups with the code, already invited users stay
+func (c *Controller) RevokeInvite(ctx context.Context, user *model.User, code string) error {
+  err := c.repo.RevokeInvite(ctx, code, inviteOwner(user))
+  if err == repository.ErrNoInvite {
+    return controller.ErrInviteInvalid
+  }
+  return err
+}
+
+// inviteOwner is 0 for admins, so any invite matches
+func inviteOwner(user *model.User) model.UserId {
+  if user.Role == model.RoleAdmin {
+    return 0
+  }
+  return user.Id
+}
@@ -0,0 +1,165 @@
+package users_test
+
+import (
+  "fmt"
+  "time"
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestInviteRegistration(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{
+    Lockout: users.DefaultLockoutConfig,
+    Registration: users.RegistrationConfig{
+      Policy: users.PolicyInvite,
+      InviteQuota: 2,
+      InviteTTL: time.Hour,
+    },
+  })
+
+  ctx := context.Background()
+  newUser := func(name string) *model.User {
+    token, expires := users.NewToken()
+    return &model.User{Name: name, Password: "secret", Token: token, Expires: expires}
+  }
+
+  require.ErrorIs(t, ctrl.Register(ctx, newUser("nobody"), "guess"), controller.ErrInviteInvalid)
+
+  // first account needs no invite
+  require.NoError(t, ctrl.Register(ctx, newUser("root"), ""))
+  require.ErrorIs(t, ctrl.Register(ctx, newUser("nobody"), ""), controller.ErrInviteInvalid)
+  require.NoError(t, ctrl.SetRole(ctx, "root", model.RoleAdmin))
+  admin, err := ctrl.Get(ctx, &model.User{Name: "root"})
+  require.NoError(t, err)
+
+  invite, err := ctrl.CreateInvite(ctx, admin, 2, 0)
+  require.NoError(t, err)
+  require.Equal(t, 2, invite.MaxUses)
+
+  require.NoError(t, ctrl.Register(ctx, newUser("alice"), invite.Code))
+  require.NoError(t, ctrl.Register(ctx, newUser("bob"), invite.Code))
+  require.ErrorIs(t, ctrl.Register(ctx, newUser("carol"), invite.Code), controller.ErrInviteInvalid)
+
+  alice, err := ctrl.Get(ctx, &model.User{Name: "alice"})
+  require.NoError(t, err)
+  require.Equal(t, admin.Id, alice.InvitedBy)
+
+  // regular users share quota among invites
+  _, err = ctrl.CreateInvite(ctx, alice, 3, 0)
+  require.ErrorIs(t, err, controller.ErrInviteQuota)
+  own, err := ctrl.CreateInvite(ctx, alice, 1, 0)
+  require.NoError(t, err)
+  spare, err := ctrl.CreateInvite(ctx, alice, 1, 0)
+  require.NoError(t, err)
+  _, err = ctrl.CreateInvite(ctx, alice, 1, 0)
+  require.ErrorIs(t, err, controller.ErrInviteQuota)
+
+  // revoking unused invite returns its seat
+  bob, err := ctrl.Get(ctx, &model.User{Name: "bob"})
+  require.NoError(t, err)
+  require.ErrorIs(t, ctrl.RevokeInvite(ctx, bob, spare.Code), controller.ErrInviteInvalid)
+  require.NoError(t, ctrl.RevokeInvite(ctx, alice, spare.Code))
+  require.ErrorIs(t, ctrl.Register(ctx, newUser("carol"), spare.Code), controller.ErrInviteInvalid)
+  _, err = ctrl.CreateInvite(ctx, alice, 1, 0)
+  require.NoError(t, err)
+
+  require.NoError(t, ctrl.Register(ctx, newUser("carol"), own.Code))
+  carol, err := ctrl.Get(ctx, &model.User{Name: "carol"})
+  require.NoError(t, err)
+  require.Equal(t, alice.Id, carol.InvitedBy)
+
+  list, err := ctrl.ListInvites(ctx, alice)
+  require.NoError(t, err)
+  require.Len(t, list, 3)
+  list, err = ctrl.ListInvites(ctx, admin)
+  require.NoError(t, err)
+  require.Len(t, list, 4)
+
+  // expired invite
+  expired, err := ctrl.CreateInvite(ctx, admin, 1, time.Nanosecond)
+  require.NoError(t, err)
+  time.Sleep(time.Second)
+  require.ErrorIs(t, ctrl.Register(ctx, newUser("dave"), expired.Code), controller.ErrInviteInvalid)
+}
+
+func TestClosedRegistration(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{
+    Registration: users.RegistrationConfig{Policy: users.PolicyClosed},
+  })
+
+  token, expires := users.NewToken()
+  err = ctrl.Register(context.Background(), &model.User{Name: "alice", Password: "secret",
+    Token: token, Expires: expires}, "")
+  require.ErrorIs(t, err, controller.ErrRegistrationClosed)
+}
+
+func TestInviteRaces(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{
+    Lockout: users.DefaultLockoutConfig,
+    Registration: users.RegistrationConfig{
+      Policy: users.PolicyInvite,
+      InviteQuota: 3,
+      InviteTTL: time.Hour,
+    },
+  })
+
+  ctx := context.Background()
+  const n = 8
+
+  // only one of concurrent signups is the first account
+  errs := make(chan error, n)
+  for i := 0; i < n; i++ {
+    go func(i int) {
+      token, expires := users.NewToken()
+      errs <- ctrl.Register(ctx, &model.User{
+        Name: fmt.Sprintf("user%d", i), Password: "secret", Token: token, Expires: expires,
+      }, "")
+    }(i)
+  }
+  added := 0
+  for i := 0; i < n; i++ {
+    if err := <-errs; err == nil {
+      added += 1
+    } else {
+      require.ErrorIs(t, err, controller.ErrInviteInvalid)
+    }
+  }
+  require.Equal(t, 1, added)
+
+  list, err := repo.List(ctx, "", n, 0)
+  require.NoError(t, err)
+  require.Len(t, list, 1)
+
+  // concurrent invites do not take more seats than quota
+  for i := 0; i < n; i++ {
+    go func() {
+      _, err := ctrl.CreateInvite(ctx, list[0], 1, 0)
+      errs <- err
+    }()
+  }
+  created := 0
+  for i := 0; i < n; i++ {
+    if err := <-errs; err == nil {
+      created += 1
+    } else {
+      require.ErrorIs(t, err, controller.ErrInviteQuota)
+    }
+  }
+  require.Equal(t, 3, created)
+}
@@ -0,0 +1,246 @@
+package users
+
+import (
+  "log"
+  "time"
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+)
+
+type LockoutConfig struct {
+  // failures allowed before the first lock
+  AccountAttempts int
+  IPAttempts int
+  // first lock duration, doubled on every next failure
+  BaseDelay time.Duration
+  MaxDelay time.Duration
+  // failures older than window are forgotten
+  Window time.Duration
+}
+
+var DefaultLockoutConfig = LockoutConfig{
+  AccountAttempts: 5,
+  IPAttempts: 20,
+  BaseDelay: 30*time.Second,
+  MaxDelay: time.Hour,
+  Window: 24*time.Hour,
+}
+
+func accountKey(name string) string {
+  return "user:" + name
+}
+
+func ipKey(ip string) string {
+  return "ip:" + ip
+}
+
+/**
+ * Verifies name and password pair. Password is not even
+ * checked while either the account or the client ip is locked,
+ * so lockout does not leak whether a guess was correct.
+ * Attempt counts as failure before password is checked,
+ * so concurrent guesses cannot slip past the limit
+ */
+func (c *Controller) Login(ctx context.Context, user *model.User, ip string) (*model.Lockout, error) {
+  now := time.Now()
+  counted, lockout, err := c.takeAttempt(ctx, user.Name, ip, now)
+  if err != nil {
+    return nil, err
+  }
+  if lockout.Locked {
+    c.forgive(ctx, counted)
+    return lockout, controller.ErrLocked
+  }
+
+  exists := false
+  if user.Password != "" {
+    exists, err = c.repo.Has(ctx, user)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  if !exists {
+    lockout, err = c.lock(ctx, counted, ip, now)
+    if err != nil {
+      return nil, err
+    }
+    return lockout, controller.ErrWrongPassword
+  }
+
+  if err := c.repo.ResetAttempts(ctx, accountKey(user.Name)); err != nil {
+    return nil, err
+  }
+  c.forgive(ctx, counted)
+
+  // told only to those who know the password
+  result, err := c.repo.Get(ctx, &model.User{Name: user.Name})
+  if err != nil {
+    return nil, err
+  }
+  if result.Disabled {
+    return &model.Lockout{}, controller.ErrDisabled
+  }
+  return &model.Lockout{}, nil
+}
+
+func (c *Controller) GetLockout(ctx context.Context, name, ip string) (*model.Lockout, error) {
+  var until time.Time
+
+  for _, key := range lockoutKeys(name, ip) {
+    attempts, err := c.repo.GetAttempts(ctx, key)
+    if err != nil {
+      return nil, err
+    }
+    if attempts.LockedUntil.After(until) {
+      until = attempts.LockedUntil
+    }
+  }
+
+  return lockoutFromTime(until), nil
+}
+
+// Unlock forgets failed attempts for account name, client ip, or both
+func (c *Controller) Unlock(ctx context.Context, name, ip string) error {
+  var err error
+  for _, key := range lockoutKeys(name, ip) {
+    if err = c.repo.ResetAttempts(ctx, key); err != nil {
+      break
+    }
+  }
+
+  event := &model.AuditEvent{
+    Event: model.AuditAdminUnlock,
+    Name: name,
+    Success: err == nil,
+  }
+  if ip != "" {
+    event.Details = map[string]string{"ip": ip}
+  }
+  c.audit(ctx, event)
+  return err
+}
+
+/**
+ * takeAttempt counts a failure on every key up front, in one
+ * statement each. Attempt is refused when a key is locked, or
+ * when failures counted by concurrent attempts already spent
+ * what is allowed. Returns keys the attempt counted on
+ */
+func (c *Controller) takeAttempt(ctx context.Context, name, ip string, now time.Time) (
+  map[string]int, *model.Lockout, error,
+) {
+  var until time.Time
+
+  counted := make(map[string]int)
+  refused := false
+  for _, key := range lockoutKeys(name, ip) {
+    attempts, err := c.repo.AddFailure(ctx, key, now, c.cfg.Lockout.Window)
+    if err != nil {
+      return nil, nil, err
+    }
+    if attempts.LockedUntil.After(now) {
+      if attempts.LockedUntil.After(until) {
+        until = attempts.LockedUntil
+      }
+      continue
+    }
+    counted[key] = attempts.Failures
+    if lockoutDelay(attempts.Failures - 1, c.free(key, ip), c.cfg.Lockout) > 0 {
+      refused = true
+    }
+  }
+
+  if refused && !until.After(now) {
+    // lock is about to be set by attempt that spent the last one
+    until = now.Add(c.cfg.Lockout.BaseDelay)
+  }
+  return counted, lockoutFromTime(until), nil
+}
+
+// lock sets lock on keys of failed attempt which spent what is allowed
+func (c *Controller) lock(ctx context.Context, counted map[string]int, ip string, now time.Time) (
+  *model.Lockout, error,
+) {
+  var until time.Time
+
+  for key, failures := range counted {
+    delay := lockoutDelay(failures, c.free(key, ip), c.cfg.Lockout)
+    if delay == 0 {
+      continue
+    }
+
+    lockedUntil := now.Add(delay)
+    if err := c.repo.LockUntil(ctx, key, lockedUntil); err != nil {
+      return nil, err
+    }
+    if lockedUntil.After(until) {
+      until = lockedUntil
+    }
+  }
+
+  return lockoutFromTime(until), nil
+}
+
+// forgive takes back failures counted by attempt that was not a failed one
+func (c *Controller) forgive(ctx context.Context, counted map[string]int) {
+  for key := range counted {
+    if err := c.repo.ForgiveFailure(ctx, key); err != nil {
+      log.Println(err)
+    }
+  }
+}
+
+// free is number of failures allowed on key before the first lock
+func (c *Controller) free(key, ip string) int {
+  if key == ipKey(ip) {
+    return c.cfg.Lockout.IPAttempts
+  }
+  return c.cfg.Lockout.AccountAttempts
+}
+
+func lockoutKeys(name, ip string) []string {
+  var keys []string
+  if name != "" {
+    keys = append(keys, accountKey(name))
+  }
+  if ip != "" {
+    keys = append(keys, ipKey(ip))
+  }
+  return keys
+}
+
+// base delay after free attempts are spent, doubled for each next failure
+func lockoutDelay(failures, free int, cfg LockoutConfig) time.Duration {
+  if failures < free {
+    return 0
+  }
+
+  shift := failures - free
+  if shift > 30 {
+    return cfg.MaxDelay
+  }
+
+  delay := cfg.BaseDelay << shift
+  if delay > cfg.MaxDelay || delay <= 0 {
+    return cfg.MaxDelay
+  }
+  return delay
+}
+
+func lockoutFromTime(until time.Time) *model.Lockout {
+  now := time.Now()
+  if !until.After(now) {
+    return &model.Lockout{}
+  }
+
+  until = until.UTC()
+  untilRaw, _ := until.MarshalText()
+  return &model.Lockout{
+    Locked: true,
+    Until: string(untilRaw),
+    RetryAfter: int(until.Sub(now).Seconds()) + 1,
+  }
+}
@@ -0,0 +1,132 @@
+package users_test
+
+import (
+  "time"
+  "errors"
+  "context"
+  "testing"
+  "path/filepath"
+
+  "github.com/stretchr/testify/require"
+
+  _ "github.com/mattn/go-sqlite3"
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+// setupDB returns fresh database path, sqlite.New migrates it
+func setupDB(t *testing.T) string {
+  return filepath.Join(t.TempDir(), "users.db")
+}
+
+func TestLockoutSharedDB(t *testing.T) {
+  dbpath := setupDB(t)
+
+  cfg := users.Config{Lockout: users.LockoutConfig{
+    AccountAttempts: 3,
+    IPAttempts: 10,
+    BaseDelay: time.Minute,
+    MaxDelay: time.Hour,
+    Window: time.Hour,
+  }}
+
+  // http and grpc processes open the same file
+  var ctrls []*users.Controller
+  for i := 0; i < 2; i++ {
+    repo, err := sqlite.New(dbpath)
+    require.NoError(t, err)
+    ctrls = append(ctrls, users.New(repo, nil, cfg))
+  }
+
+  ctx := context.Background()
+  err := ctrls[0].Add(ctx, &model.User{Name: "alice", Password: "secret"})
+  require.NoError(t, err)
+
+  lockout, err := ctrls[0].Login(ctx, &model.User{Name: "alice", Password: "secret"}, "10.0.0.1")
+  require.NoError(t, err)
+  require.False(t, lockout.Locked)
+
+  for i := 0; i < 3; i++ {
+    _, err = ctrls[i%2].Login(ctx, &model.User{Name: "alice", Password: "guess"}, "10.0.0.1")
+    require.ErrorIs(t, err, controller.ErrWrongPassword)
+  }
+
+  // right password is not even checked while locked
+  for _, ctrl := range ctrls {
+    lockout, err = ctrl.Login(ctx, &model.User{Name: "alice", Password: "secret"}, "10.0.0.2")
+    require.ErrorIs(t, err, controller.ErrLocked)
+    require.True(t, lockout.Locked)
+    require.Greater(t, lockout.RetryAfter, 0)
+  }
+
+  require.NoError(t, ctrls[1].Unlock(ctx, "alice", ""))
+
+  lockout, err = ctrls[0].Login(ctx, &model.User{Name: "alice", Password: "secret"}, "10.0.0.1")
+  require.NoError(t, err)
+  require.False(t, lockout.Locked)
+}
+
+func TestLockoutPerIP(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, nil, users.Config{Lockout: users.LockoutConfig{
+    AccountAttempts: 10,
+    IPAttempts: 2,
+    BaseDelay: time.Minute,
+    MaxDelay: time.Hour,
+    Window: time.Hour,
+  }})
+
+  ctx := context.Background()
+  for _, name := range []string{"bob", "carol"} {
+    _, err = ctrl.Login(ctx, &model.User{Name: name, Password: "guess"}, "10.0.0.3")
+    require.ErrorIs(t, err, controller.ErrWrongPassword)
+  }
+
+  _, err = ctrl.Login(ctx, &model.User{Name: "dave", Password: "guess"}, "10.0.0.3")
+  require.ErrorIs(t, err, controller.ErrLocked)
+
+  _, err = ctrl.Login(ctx, &model.User{Name: "dave", Password: "guess"}, "10.0.0.4")
+  require.ErrorIs(t, err, controller.ErrWrongPassword)
+}
+
+func TestLockoutConcurrent(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, nil, users.Config{Lockout: users.LockoutConfig{
+    AccountAttempts: 3,
+    IPAttempts: 100,
+    BaseDelay: time.Minute,
+    MaxDelay: time.Hour,
+    Window: time.Hour,
+  }})
+
+  ctx := context.Background()
+  require.NoError(t, ctrl.Add(ctx, &model.User{Name: "alice", Password: "secret"}))
+
+  // guesses racing each other get no more than allowed
+  errs := make(chan error, 10)
+  for i := 0; i < cap(errs); i++ {
+    go func() {
+      _, err := ctrl.Login(ctx, &model.User{Name: "alice", Password: "guess"}, "10.0.0.1")
+      errs <- err
+    }()
+  }
+  wrong := 0
+  for i := 0; i < cap(errs); i++ {
+    err := <-errs
+    if errors.Is(err, controller.ErrWrongPassword) {
+      wrong += 1
+    } else {
+      require.ErrorIs(t, err, controller.ErrLocked)
+    }
+  }
+  require.Equal(t, 3, wrong)
+
+  _, err = ctrl.Login(ctx, &model.User{Name: "alice", Password: "secret"}, "10.0.0.2")
+  require.ErrorIs(t, err, controller.ErrLocked)
+}
@@ -0,0 +1,287 @@
+package users
+
+import (
+  "time"
+  "errors"
+  "regexp"
+  "context"
+  "strings"
+  "crypto/subtle"
+
+  "github.com/bd878/gallery/server/pkg/oidc"
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/utils"
+)
+
+// IdentityProvider signs users in with external accounts
+type IdentityProvider interface {
+  Issuer() string
+  AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error)
+  // Exchange redeems code, returns verified id token claims
+  Exchange(ctx context.Context, code, verifier, nonce string) (*oidc.Claims, error)
+}
+
+/**
+ * Nil Provider disables OpenID Connect login. Unknown
+ * identities get an account on AutoProvision, following
+ * registration policy as Register does, and are
+ * rejected otherwise
+ */
+type OIDCConfig struct {
+  Provider IdentityProvider
+  AutoProvision bool
+}
+
+// Login in progress, kept by the client until callback
+type OIDCFlow struct {
+  State string
+  Nonce string
+  Verifier string
+  // link identity to logged in user instead of logging in
+  Link bool
+  // invite code new account is provisioned with
+  Invite string
+  // provider login page
+  URL string
+}
+
+/**
+ * StartOIDC begins authorization code flow with PKCE.
+ * Invite is kept in the flow, until account is provisioned
+ */
+func (c *Controller) StartOIDC(ctx context.Context, link bool, invite string) (*OIDCFlow, error) {
+  provider := c.cfg.OIDC.Provider
+  if provider == nil {
+    return nil, controller.ErrOIDCDisabled
+  }
+
+  flow := &OIDCFlow{Link: link, Invite: invite}
+  var err error
+  if flow.State, err = oidc.NewState(); err != nil {
+    return nil, err
+  }
+  if flow.Nonce, err = oidc.NewState(); err != nil {
+    return nil, err
+  }
+  if flow.Verifier, err = oidc.NewVerifier(); err != nil {
+    return nil, err
+  }
+
+  flow.URL, err = provider.AuthCodeURL(ctx, flow.State, flow.Nonce, oidc.Challenge(flow.Verifier))
+  if err != nil {
+    return nil, err
+  }
+  return flow, nil
+}
+
+/**
+ * FinishOIDC completes flow started by StartOIDC. Linking
+ * flow adds identity to current user, login flow starts
+ * session of the user identity is linked to
+ */
+func (c *Controller) FinishOIDC(ctx context.Context, flow *OIDCFlow, state, code string,
+  current *model.User,
+) (*model.User, error) {
+  user, event, err := c.finishOIDC(ctx, flow, state, code, current)
+  if err == controller.ErrOIDCDisabled {
+    return nil, err
+  }
+
+  e := &model.AuditEvent{
+    Event: event,
+    Success: err == nil,
+    Details: map[string]string{"method": "oidc"},
+  }
+  if user == nil {
+    user = current
+  }
+  if user != nil {
+    e.UserId, e.Name = user.Id, user.Name
+  }
+  if err != nil {
+    e.Details["error"] = err.Error()
+  }
+  c.audit(ctx, e)
+
+  if err != nil {
+    return nil, err
+  }
+  return user, nil
+}
+
+// finishOIDC returns audit event type along with the result
+func (c *Controller) finishOIDC(ctx context.Context, flow *OIDCFlow, state, code string,
+  current *model.User,
+) (*model.User, string, error) {
+  event := model.AuditLogin
+  if flow.Link {
+    event = model.AuditIdentityLink
+  }
+
+  provider := c.cfg.OIDC.Provider
+  if provider == nil {
+    return nil, event, controller.ErrOIDCDisabled
+  }
+  if flow.State == "" || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+    return nil, event, controller.ErrOIDCState
+  }
+
+  claims, err := provider.Exchange(ctx, code, flow.Verifier, flow.Nonce)
+  if errors.Is(err, oidc.ErrTokenInvalid) {
+    return nil, event, controller.ErrOIDCToken
+  }
+  if err != nil {
+    return nil, event, err
+  }
+
+  identity, err := c.repo.GetIdentity(ctx, provider.Issuer(), claims.Subject)
+  if err != nil && err != repository.ErrNoIdentity {
+    return nil, event, err
+  }
+
+  if flow.Link {
+    if current == nil {
+      return nil, event, controller.ErrNotFound
+    }
+    user, err := c.linkIdentity(ctx, current, identity, provider.Issuer(), claims)
+    return user, event, err
+  }
+
+  if identity != nil {
+    user, err := c.repo.Get(ctx, &model.User{Id: identity.UserId})
+    if err != nil {
+      return nil, event, mapNoUser(err)
+    }
+    if user.Disabled {
+      return user, event, controller.ErrDisabled
+    }
+    user, err = c.newSession(ctx, user.Name)
+    return user, event, err
+  }
+
+  if !c.cfg.OIDC.AutoProvision {
+    return nil, event, controller.ErrIdentityUnknown
+  }
+  user, err := c.provision(ctx, provider.Issuer(), claims, flow.Invite)
+  return user, model.AuditSignup, err
+}
+
+// linkIdentity is idempotent, identity of another user is not taken over
+func (c *Controller) linkIdentity(ctx context.Context, current *model.User, identity *model.Identity,
+  issuer string, claims *oidc.Claims,
+) (*model.User, error) {
+  if identity != nil {
+    if identity.UserId != current.Id {
+      return nil, controller.ErrIdentityLinked
+    }
+    return current, nil
+  }
+
+  err := c.repo.AddIdentity(ctx, &model.Identity{
+    Issuer: issuer,
+    Subject: claims.Subject,
+    UserId: current.Id,
+    Email: claims.Email,
+  })
+  if err != nil {
+    return nil, err
+  }
+  return current, nil
+}
+
+/**
+ * provision creates account for identity, password is random
+ * and unknown. Invite policy admits only invite holders,
+ * the invite is spent along with the account
+ */
+func (c *Controller) provision(ctx context.Context, issuer string, claims *oidc.Claims, code string) (
+  *model.User, error,
+) {
+  switch c.cfg.Registration.Policy {
+  case PolicyClosed:
+    return nil, controller.ErrRegistrationClosed
+  case PolicyInvite:
+    if code == "" {
+      return nil, controller.ErrInviteInvalid
+    }
+  }
+
+  name, err := c.freeName(ctx, claims)
+  if err != nil {
+    return nil, err
+  }
+  password, err := utils.RandomToken(24)
+  if err != nil {
+    return nil, err
+  }
+
+  token, expires := NewToken()
+  user := &model.User{
+    Name: name,
+    Password: password,
+    Token: token,
+    Expires: expires,
+    Email: verifiedEmail(claims),
+  }
+  identity := &model.Identity{
+    Issuer: issuer,
+    Subject: claims.Subject,
+    Email: claims.Email,
+  }
+  err = c.repo.AddWithIdentity(ctx, user, identity, code, time.Now())
+  if err == repository.ErrNoInvite {
+    return nil, controller.ErrInviteInvalid
+  }
+  if err != nil {
+    return nil, err
+  }
+  return c.Get(ctx, &model.User{Token: token})
+}
+
+var nameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+const maxNameLen = 32
+
+// freeName derives unused user name from preferred name or email
+func (c *Controller) freeName(ctx context.Context, claims *oidc.Claims) (string, error) {
+  base := claims.PreferredUsername
+  if base == "" {
+    base, _, _ = strings.Cut(claims.Email, "@")
+  }
+  base = nameUnsafe.ReplaceAllString(base, "")
+  if len(base) > maxNameLen {
+    base = base[:maxNameLen]
+  }
+  if base == "" {
+    base = "user"
+  }
+
+  candidates := []string{base}
+  for i := 0; i < 3; i++ {
+    candidates = append(candidates, base + "-" + utils.RandomString(4))
+  }
+
+  for _, name := range candidates {
+    exists, err := c.repo.Has(ctx, &model.User{Name: name})
+    if err != nil {
+      return "", err
+    }
+    if !exists {
+      return name, nil
+    }
+  }
+  return "", controller.ErrNameExists
+}
+
+func verifiedEmail(claims *oidc.Claims) string {
+  if !claims.EmailVerified {
+    return ""
+  }
+  email, err := normalizeEmail(claims.Email)
+  if err != nil {
+    return ""
+  }
+  return email
+}
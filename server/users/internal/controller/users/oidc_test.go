@@ -0,0 +1,146 @@
+package users_test
+
+import (
+  "context"
+  "testing"
+  "net/url"
+  "net/http"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/pkg/oidc"
+  "github.com/bd878/gallery/server/pkg/oidc/oidctest"
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+// loginAt follows provider login page, returns state and code of the callback
+func loginAt(t *testing.T, flow *users.OIDCFlow) (string, string) {
+  client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+    return http.ErrUseLastResponse
+  }}
+  resp, err := client.Get(flow.URL)
+  require.NoError(t, err)
+  resp.Body.Close()
+
+  location, err := url.Parse(resp.Header.Get("Location"))
+  require.NoError(t, err)
+  return location.Query().Get("state"), location.Query().Get("code")
+}
+
+func TestOIDC(t *testing.T) {
+  provider, err := oidctest.New("gallery", "secret")
+  require.NoError(t, err)
+  defer provider.Close()
+
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  cfg := users.Config{
+    Lockout: users.DefaultLockoutConfig,
+    Registration: users.DefaultRegistrationConfig,
+    OIDC: users.OIDCConfig{
+      Provider: oidc.New(oidc.Config{
+        Issuer: provider.Issuer(),
+        ClientID: "gallery",
+        ClientSecret: "secret",
+        RedirectURL: "http://app.test/users/v1/oidc/callback",
+      }),
+    },
+  }
+  ctrl := users.New(repo, &notifier{}, cfg)
+  ctx := context.Background()
+
+  login := func(ctrl *users.Controller, link bool, current *model.User, invite string) (*model.User, error) {
+    flow, err := ctrl.StartOIDC(ctx, link, invite)
+    require.NoError(t, err)
+    state, code := loginAt(t, flow)
+    return ctrl.FinishOIDC(ctx, flow, state, code, current)
+  }
+
+  provider.SetUser(oidc.Claims{
+    Subject: "sub-alice",
+    Email: "alice@corp.example",
+    EmailVerified: true,
+    PreferredUsername: "alice",
+  })
+
+  // unknown identity without provisioning
+  _, err = login(ctrl, false, nil, "")
+  require.ErrorIs(t, err, controller.ErrIdentityUnknown)
+
+  // state must come back unchanged
+  flow, err := ctrl.StartOIDC(ctx, false, "")
+  require.NoError(t, err)
+  _, code := loginAt(t, flow)
+  _, err = ctrl.FinishOIDC(ctx, flow, "forged", code, nil)
+  require.ErrorIs(t, err, controller.ErrOIDCState)
+
+  // linking to existing account
+  alice, err := ctrl.SignUp(ctx, "alice", "secret", "", "")
+  require.NoError(t, err)
+  linked, err := login(ctrl, true, alice, "")
+  require.NoError(t, err)
+  require.Equal(t, alice.Id, linked.Id)
+
+  user, err := login(ctrl, false, nil, "")
+  require.NoError(t, err)
+  require.Equal(t, alice.Id, user.Id)
+  require.NotEmpty(t, user.Token)
+
+  bob, err := ctrl.SignUp(ctx, "bob", "secret", "", "")
+  require.NoError(t, err)
+  _, err = login(ctrl, true, bob, "")
+  require.ErrorIs(t, err, controller.ErrIdentityLinked)
+
+  // auto-provisioning picks a free name
+  cfg.OIDC.AutoProvision = true
+  ctrl = users.New(repo, &notifier{}, cfg)
+
+  provider.SetUser(oidc.Claims{
+    Subject: "sub-other-alice",
+    Email: "alice@other.example",
+    EmailVerified: true,
+    PreferredUsername: "alice",
+  })
+  created, err := login(ctrl, false, nil, "")
+  require.NoError(t, err)
+  require.NotEqual(t, alice.Id, created.Id)
+  require.NotEqual(t, "alice", created.Name)
+  require.Equal(t, "alice@other.example", created.Email)
+
+  again, err := login(ctrl, false, nil, "")
+  require.NoError(t, err)
+  require.Equal(t, created.Id, again.Id)
+
+  cfg.Registration.Policy = users.PolicyClosed
+  ctrl = users.New(repo, &notifier{}, cfg)
+  provider.SetUser(oidc.Claims{Subject: "sub-carol", PreferredUsername: "carol"})
+  _, err = login(ctrl, false, nil, "")
+  require.ErrorIs(t, err, controller.ErrRegistrationClosed)
+
+  // invite policy provisions invite holders only
+  cfg.Registration.Policy = users.PolicyInvite
+  ctrl = users.New(repo, &notifier{}, cfg)
+  _, err = login(ctrl, false, nil, "")
+  require.ErrorIs(t, err, controller.ErrInviteInvalid)
+  _, err = login(ctrl, false, nil, "nosuchcode")
+  require.ErrorIs(t, err, controller.ErrInviteInvalid)
+
+  admin := &model.User{Id: alice.Id, Role: model.RoleAdmin}
+  invite, err := ctrl.CreateInvite(ctx, admin, 1, 0)
+  require.NoError(t, err)
+  carol, err := login(ctrl, false, nil, invite.Code)
+  require.NoError(t, err)
+  require.Equal(t, alice.Id, carol.InvitedBy)
+
+  provider.SetUser(oidc.Claims{Subject: "sub-dave", PreferredUsername: "dave"})
+  _, err = login(ctrl, false, nil, invite.Code)
+  require.ErrorIs(t, err, controller.ErrInviteInvalid)
+
+  ctrl = users.New(repo, &notifier{}, users.Config{})
+  _, err = ctrl.StartOIDC(ctx, false, "")
+  require.ErrorIs(t, err, controller.ErrOIDCDisabled)
+}
@@ -0,0 +1,147 @@
+package users
+
+import (
+  "log"
+  "time"
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/utils"
+)
+
+const tokenTTL = time.Hour * 24 * 5
+
+// NewToken returns fresh session token and its expiry time
+func NewToken() (token string, expires string) {
+  token = utils.RandomString(10)
+  expiresRaw, err := time.Now().Add(tokenTTL).MarshalText()
+  if err != nil {
+    log.Println("failed to marshal text: ", err)
+  }
+  expires = string(expiresRaw)
+  return
+}
+
+/**
+ * Changes password of logged in user. Current password
+ * is checked as on login, lockout included. Session token
+ * is replaced, so every other session is logged out
+ */
+func (c *Controller) ChangePassword(
+  ctx context.Context,
+  user *model.User,
+  password, newPassword, ip string,
+) (
+  *model.User,
+  *model.Lockout,
+  error,
+) {
+  lockout, err := c.Login(ctx, &model.User{Name: user.Name, Password: password}, ip)
+  if err != nil {
+    c.auditUser(ctx, model.AuditPasswordChange, user, err)
+    return nil, lockout, err
+  }
+
+  updated, err := c.setPassword(ctx, user.Id, newPassword)
+  c.auditUser(ctx, model.AuditPasswordChange, user, err)
+  if err != nil {
+    return nil, lockout, err
+  }
+  return updated, lockout, nil
+}
+
+/**
+ * Sends reset token to user. Unknown names and users
+ * without email are not reported, so the endpoint
+ * can not be used to look up accounts
+ */
+func (c *Controller) RequestPasswordReset(ctx context.Context, name string) error {
+  user, err := c.repo.Get(ctx, &model.User{Name: name})
+  if err == repository.ErrNoUser {
+    c.auditUser(ctx, model.AuditPasswordResetRequest, &model.User{Name: name}, controller.ErrNotFound)
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  if user.Email == "" {
+    log.Println("no email to send reset to, user:", user.Name)
+    c.auditUser(ctx, model.AuditPasswordResetRequest, user, controller.ErrBadEmail)
+    return nil
+  }
+
+  token, err := utils.RandomToken(32)
+  if err != nil {
+    return err
+  }
+
+  if err := c.repo.AddPasswordReset(ctx, &model.PasswordReset{
+    TokenHash: hashToken(token),
+    UserId: user.Id,
+    ExpiresAt: time.Now().Add(c.cfg.ResetTokenTTL),
+  }); err != nil {
+    return err
+  }
+
+  err = c.notifier.NotifyPasswordReset(ctx, user, token)
+  c.auditUser(ctx, model.AuditPasswordResetRequest, user, err)
+  return err
+}
+
+// ResetPassword uses reset token once and logs every session out
+func (c *Controller) ResetPassword(ctx context.Context, token, newPassword string) (*model.User, error) {
+  userId, err := c.repo.UsePasswordReset(ctx, hashToken(token), time.Now())
+  if err == repository.ErrNoReset {
+    c.auditUser(ctx, model.AuditPasswordReset, &model.User{}, controller.ErrResetInvalid)
+    return nil, controller.ErrResetInvalid
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  user, err := c.setPassword(ctx, userId, newPassword)
+  c.auditUser(ctx, model.AuditPasswordReset, &model.User{Id: userId}, err)
+  return user, err
+}
+
+func (c *Controller) setPassword(ctx context.Context, id model.UserId, password string) (*model.User, error) {
+  token, expires := NewToken()
+  err := c.repo.UpdatePassword(ctx, &model.User{
+    Id: id,
+    Password: password,
+    Token: token,
+    Expires: expires,
+  })
+  if err == repository.ErrNoUser {
+    return nil, controller.ErrNotFound
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  user, err := c.repo.Get(ctx, &model.User{Id: id})
+  if err != nil {
+    return nil, err
+  }
+
+  if err := c.repo.ResetAttempts(ctx, accountKey(user.Name)); err != nil {
+    return nil, err
+  }
+
+  return &model.User{
+    Id: user.Id,
+    Name: user.Name,
+    Token: user.Token,
+    Expires: user.Expires,
+    Email: user.Email,
+  }, nil
+}
+
+func hashToken(token string) string {
+  sum := sha256.Sum256([]byte(token))
+  return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,82 @@
+package users_test
+
+import (
+  "context"
+  "testing"
+  "time"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestPasswordReset(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  n := &notifier{}
+  ctrl := users.New(repo, n, users.Config{
+    Lockout: users.DefaultLockoutConfig,
+    ResetTokenTTL: time.Hour,
+  })
+
+  ctx := context.Background()
+  require.NoError(t, ctrl.Add(ctx, &model.User{
+    Name: "alice",
+    Password: "old",
+    Token: "session",
+    Email: "alice@example.com",
+  }))
+
+  require.NoError(t, ctrl.RequestPasswordReset(ctx, "nobody"))
+  require.Empty(t, n.tokens)
+
+  require.NoError(t, ctrl.RequestPasswordReset(ctx, "alice"))
+  require.Len(t, n.tokens, 1)
+
+  user, err := ctrl.ResetPassword(ctx, n.tokens[0], "new")
+  require.NoError(t, err)
+  require.NotEqual(t, "session", user.Token)
+
+  // single use
+  _, err = ctrl.ResetPassword(ctx, n.tokens[0], "newer")
+  require.ErrorIs(t, err, controller.ErrResetInvalid)
+
+  _, err = ctrl.Get(ctx, &model.User{Token: "session"})
+  require.ErrorIs(t, err, controller.ErrNotFound)
+
+  _, err = ctrl.Login(ctx, &model.User{Name: "alice", Password: "new"}, "")
+  require.NoError(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{Lockout: users.DefaultLockoutConfig})
+
+  ctx := context.Background()
+  require.NoError(t, ctrl.Add(ctx, &model.User{Name: "bob", Password: "old", Token: "session"}))
+  user := &model.User{Id: 1, Name: "bob"}
+  _, _, err = ctrl.ChangePassword(ctx, user, "wrong", "new", "")
+  require.ErrorIs(t, err, controller.ErrWrongPassword)
+
+  updated, _, err := ctrl.ChangePassword(ctx, user, "old", "new", "")
+  require.NoError(t, err)
+  require.NotEqual(t, "session", updated.Token)
+
+  _, err = ctrl.Login(ctx, &model.User{Name: "bob", Password: "new"}, "")
+  require.NoError(t, err)
+}
+
+type notifier struct {
+  tokens []string
+}
+
+func (n *notifier) NotifyPasswordReset(_ context.Context, _ *model.User, token string) error {
+  n.tokens = append(n.tokens, token)
+  return nil
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+  "time"
+  "regexp"
+  "context"
+  "unicode/utf8"
+  _ "time/tzdata"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/internal/repository"
+)
+
+const maxDisplayName = 64
+
+// language tag, like "en" or "pt-BR"
+var localeRe = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+func (c *Controller) GetProfile(ctx context.Context, user *model.User) (*model.Profile, error) {
+  result, err := c.repo.Get(ctx, &model.User{Id: user.Id})
+  if err != nil {
+    return nil, mapNoUser(err)
+  }
+  return result.Profile, nil
+}
+
+/**
+ * UpdateProfile replaces profile fields of session user,
+ * avatar is kept. Unknown time zone, malformed locale
+ * or sort order fail with ErrBadProfile
+ */
+func (c *Controller) UpdateProfile(ctx context.Context, user *model.User, profile *model.Profile) (
+  *model.User, error,
+) {
+  email, err := normalizeEmail(profile.Email)
+  if err != nil {
+    return nil, err
+  }
+  if err := validateProfile(profile); err != nil {
+    return nil, err
+  }
+
+  if err := mapNoUser(c.repo.UpdateProfile(ctx, user.Id, &model.Profile{
+    Email: email,
+    DisplayName: profile.DisplayName,
+    TimeZone: profile.TimeZone,
+    Locale: profile.Locale,
+    SortOrder: profile.SortOrder,
+  })); err != nil {
+    return nil, err
+  }
+  return c.Get(ctx, &model.User{Token: user.Token})
+}
+
+// SetAvatar points profile to uploaded image, previous one is removed
+func (c *Controller) SetAvatar(ctx context.Context, user *model.User, avatarId string, data []byte) (*model.Profile, error) {
+  if err := c.repo.SetAvatar(ctx, user.Id, avatarId, data); err != nil {
+    return nil, mapNoUser(err)
+  }
+  return c.GetProfile(ctx, user)
+}
+
+// Avatar returns image, only ones set as avatars are served
+func (c *Controller) Avatar(ctx context.Context, avatarId string) ([]byte, error) {
+  data, err := c.repo.GetAvatar(ctx, avatarId)
+  if err == repository.ErrNoAvatar {
+    return nil, controller.ErrNotFound
+  }
+  return data, err
+}
+
+func validateProfile(profile *model.Profile) error {
+  if utf8.RuneCountInString(profile.DisplayName) > maxDisplayName {
+    return controller.ErrBadProfile
+  }
+
+  if profile.TimeZone != "" {
+    if _, err := time.LoadLocation(profile.TimeZone); err != nil || profile.TimeZone == "Local" {
+      return controller.ErrBadProfile
+    }
+  }
+
+  if profile.Locale != "" && !localeRe.MatchString(profile.Locale) {
+    return controller.ErrBadProfile
+  }
+
+  switch profile.SortOrder {
+  case "", model.SortAsc, model.SortDesc:
+  default:
+    return controller.ErrBadProfile
+  }
+  return nil
+}
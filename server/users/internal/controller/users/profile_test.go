@@ -0,0 +1,67 @@
+package users_test
+
+import (
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestProfile(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{})
+  ctx := context.Background()
+
+  alice, err := ctrl.SignUp(ctx, "alice", "secret", "", "")
+  require.NoError(t, err)
+  require.Equal(t, &model.Profile{}, alice.Profile)
+
+  for _, bad := range []*model.Profile{
+    {TimeZone: "Mars/Olympus"},
+    {TimeZone: "Local"},
+    {Locale: "english please"},
+    {SortOrder: "random"},
+  } {
+    _, err = ctrl.UpdateProfile(ctx, alice, bad)
+    require.ErrorIs(t, err, controller.ErrBadProfile)
+  }
+
+  updated, err := ctrl.UpdateProfile(ctx, alice, &model.Profile{
+    DisplayName: "Alice",
+    TimeZone: "Europe/Moscow",
+    Locale: "ru-RU",
+    SortOrder: model.SortDesc,
+  })
+  require.NoError(t, err)
+  require.Equal(t, "Alice", updated.Profile.DisplayName)
+  require.Equal(t, "Europe/Moscow", updated.Profile.TimeZone)
+  require.Equal(t, model.SortDesc, updated.Profile.SortOrder)
+
+  // only current avatars are served, previous one is removed
+  _, err = ctrl.SetAvatar(ctx, alice, "first.png", []byte("first"))
+  require.NoError(t, err)
+  profile, err := ctrl.SetAvatar(ctx, alice, "second.png", []byte("second"))
+  require.NoError(t, err)
+  require.Equal(t, "second.png", profile.AvatarId)
+  require.Equal(t, "Alice", profile.DisplayName)
+
+  data, err := ctrl.Avatar(ctx, "second.png")
+  require.NoError(t, err)
+  require.Equal(t, []byte("second"), data)
+  _, err = ctrl.Avatar(ctx, "first.png")
+  require.ErrorIs(t, err, controller.ErrNotFound)
+  _, err = ctrl.Avatar(ctx, "")
+  require.ErrorIs(t, err, controller.ErrNotFound)
+
+  // others see name and avatar only
+  public, err := ctrl.GetUser(ctx, &model.User{Id: alice.Id})
+  require.NoError(t, err)
+  require.Equal(t, &model.Profile{DisplayName: "Alice", AvatarId: "second.png"}, public.Profile)
+}
@@ -0,0 +1,179 @@
+package users
+
+import (
+  "context"
+  "net/mail"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+)
+
+/**
+ * SignUp creates account and its first session.
+ * Email is optional, it is needed for password reset only
+ */
+func (c *Controller) SignUp(ctx context.Context, name, password, email, invite string) (*model.User, error) {
+  exists, err := c.repo.Has(ctx, &model.User{Name: name})
+  if err != nil {
+    return nil, err
+  }
+  if exists {
+    return nil, controller.ErrNameExists
+  }
+
+  email, err = normalizeEmail(email)
+  if err != nil {
+    return nil, err
+  }
+
+  token, expires := NewToken()
+  user := &model.User{
+    Name: name,
+    Password: password,
+    Token: token,
+    Expires: expires,
+    Email: email,
+  }
+  if err := c.Register(ctx, user, invite); err != nil {
+    c.auditUser(ctx, model.AuditSignup, user, err)
+    return nil, err
+  }
+
+  result, err := c.Get(ctx, &model.User{Token: token})
+  if err != nil {
+    return nil, err
+  }
+  c.auditUser(ctx, model.AuditSignup, result, nil)
+  return result, nil
+}
+
+/**
+ * Authenticate logs user in. Current session token is
+ * returned while valid, a new one is issued otherwise.
+ * Lockout is set on ErrLocked and ErrWrongPassword
+ */
+func (c *Controller) Authenticate(ctx context.Context, name, password, ip string) (
+  *model.User, *model.Lockout, error,
+) {
+  lockout, err := c.Login(ctx, &model.User{Name: name, Password: password}, ip)
+  if err != nil {
+    event := model.AuditLogin
+    if err == controller.ErrLocked {
+      event = model.AuditLoginLocked
+    }
+    c.audit(ctx, &model.AuditEvent{
+      Event: event,
+      Name: name,
+      IP: ip,
+      Details: map[string]string{"error": err.Error()},
+    })
+    return nil, lockout, err
+  }
+
+  user, err := c.Get(ctx, &model.User{Name: name})
+  if err == controller.ErrTokenExpired {
+    user, err = c.newSession(ctx, name)
+  }
+  if err != nil {
+    return nil, lockout, err
+  }
+
+  c.audit(ctx, &model.AuditEvent{
+    Event: model.AuditLogin,
+    UserId: user.Id,
+    Name: user.Name,
+    IP: ip,
+    Success: true,
+  })
+  return user, lockout, nil
+}
+
+// RefreshToken replaces valid session token with a new one
+func (c *Controller) RefreshToken(ctx context.Context, token string) (*model.User, error) {
+  user, err := c.Get(ctx, &model.User{Token: token})
+  if err != nil {
+    return nil, err
+  }
+
+  refreshed, err := c.newSession(ctx, user.Name)
+  c.auditUser(ctx, model.AuditRefresh, user, err)
+  return refreshed, err
+}
+
+// LogoutToken ends session the token belongs to
+func (c *Controller) LogoutToken(ctx context.Context, token string) error {
+  if token == "" {
+    return controller.ErrNotFound
+  }
+  user, err := c.repo.Get(ctx, &model.User{Token: token})
+  if err != nil {
+    return mapNoUser(err)
+  }
+
+  err = c.Logout(ctx, user.Id)
+  c.auditUser(ctx, model.AuditLogout, user, err)
+  return err
+}
+
+// GetUser finds user by id or name, session and password are not returned
+func (c *Controller) GetUser(ctx context.Context, user *model.User) (*model.User, error) {
+  if user.Id == 0 && user.Name == "" {
+    return nil, controller.ErrNotFound
+  }
+
+  result, err := c.repo.Get(ctx, &model.User{Id: user.Id, Name: user.Name})
+  if err != nil {
+    return nil, mapNoUser(err)
+  }
+  return publicUser(result), nil
+}
+
+// GetUsersByIds skips unknown ids
+func (c *Controller) GetUsersByIds(ctx context.Context, ids []model.UserId) ([]*model.User, error) {
+  users, err := c.repo.GetByIds(ctx, ids)
+  if err != nil {
+    return nil, err
+  }
+
+  res := make([]*model.User, len(users))
+  for i, user := range users {
+    res[i] = publicUser(user)
+  }
+  return res, nil
+}
+
+func (c *Controller) newSession(ctx context.Context, name string) (*model.User, error) {
+  token, expires := NewToken()
+  if err := c.repo.Refresh(ctx, &model.User{Name: name, Token: token, Expires: expires}); err != nil {
+    return nil, err
+  }
+  return c.Get(ctx, &model.User{Token: token})
+}
+
+func normalizeEmail(email string) (string, error) {
+  if email == "" {
+    return "", nil
+  }
+  addr, err := mail.ParseAddress(email)
+  if err != nil {
+    return "", controller.ErrBadEmail
+  }
+  return addr.Address, nil
+}
+
+// publicUser keeps what other users may see
+func publicUser(user *model.User) *model.User {
+  res := &model.User{
+    Id: user.Id,
+    Name: user.Name,
+    Role: user.Role,
+    Disabled: user.Disabled,
+  }
+  if user.Profile != nil {
+    res.Profile = &model.Profile{
+      DisplayName: user.Profile.DisplayName,
+      AvatarId: user.Profile.AvatarId,
+    }
+  }
+  return res
+}
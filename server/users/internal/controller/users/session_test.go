@@ -0,0 +1,74 @@
+package users_test
+
+import (
+  "context"
+  "testing"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  users "github.com/bd878/gallery/server/users/internal/controller/users"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestSession(t *testing.T) {
+  repo, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ctrl := users.New(repo, &notifier{}, users.Config{Lockout: users.DefaultLockoutConfig})
+  ctx := context.Background()
+
+  alice, err := ctrl.SignUp(ctx, "alice", "secret", "Alice <alice@example.com>", "")
+  require.NoError(t, err)
+  require.NotEmpty(t, alice.Token)
+  require.Equal(t, "alice@example.com", alice.Email)
+
+  _, err = ctrl.SignUp(ctx, "alice", "other", "", "")
+  require.ErrorIs(t, err, controller.ErrNameExists)
+  _, err = ctrl.SignUp(ctx, "bob", "secret", "not an email", "")
+  require.ErrorIs(t, err, controller.ErrBadEmail)
+  bob, err := ctrl.SignUp(ctx, "bob", "secret", "", "")
+  require.NoError(t, err)
+
+  // valid session is shared by logins
+  user, _, err := ctrl.Authenticate(ctx, "alice", "secret", "")
+  require.NoError(t, err)
+  require.Equal(t, alice.Token, user.Token)
+  _, lockout, err := ctrl.Authenticate(ctx, "alice", "wrong", "")
+  require.ErrorIs(t, err, controller.ErrWrongPassword)
+  require.False(t, lockout.Locked)
+
+  refreshed, err := ctrl.RefreshToken(ctx, alice.Token)
+  require.NoError(t, err)
+  require.NotEqual(t, alice.Token, refreshed.Token)
+  _, err = ctrl.Get(ctx, &model.User{Token: alice.Token})
+  require.ErrorIs(t, err, controller.ErrNotFound)
+
+  updated, err := ctrl.UpdateProfile(ctx, refreshed, &model.Profile{Email: "a@example.com"})
+  require.NoError(t, err)
+  require.Equal(t, "a@example.com", updated.Email)
+
+  found, err := ctrl.GetUser(ctx, &model.User{Name: "bob"})
+  require.NoError(t, err)
+  require.Equal(t, bob.Id, found.Id)
+  require.Empty(t, found.Token)
+  require.Empty(t, found.Password)
+
+  list, err := ctrl.GetUsersByIds(ctx, []model.UserId{bob.Id, alice.Id, 100})
+  require.NoError(t, err)
+  require.Len(t, list, 2)
+  require.Equal(t, alice.Id, list[0].Id)
+  require.Empty(t, list[0].Token)
+
+  require.NoError(t, ctrl.LogoutToken(ctx, refreshed.Token))
+  _, err = ctrl.Get(ctx, &model.User{Token: refreshed.Token})
+  require.Error(t, err)
+
+  // next login starts new session
+  user, _, err = ctrl.Authenticate(ctx, "alice", "secret", "")
+  require.NoError(t, err)
+  require.NotEmpty(t, user.Token)
+  _, err = ctrl.Get(ctx, &model.User{Token: user.Token})
+  require.NoError(t, err)
+}
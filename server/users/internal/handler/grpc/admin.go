@@ -0,0 +1,135 @@
+package grpc
+
+import (
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+  "google.golang.org/grpc/metadata"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/internal/controller/users"
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const defaultListLimit = 50
+
+// authorize checks admin session token from "token" metadata
+func (h *Handler) authorize(ctx context.Context) (*model.User, error) {
+  md, _ := metadata.FromIncomingContext(ctx)
+  tokens := md.Get("token")
+  if len(tokens) == 0 || tokens[0] == "" {
+    return nil, status.Errorf(codes.Unauthenticated, "no token")
+  }
+
+  admin, err := h.ctrl.Authorize(ctx, tokens[0])
+  switch err {
+  case nil:
+    return admin, nil
+  case controller.ErrForbidden, controller.ErrDisabled:
+    return nil, status.Error(codes.PermissionDenied, err.Error())
+  case controller.ErrTokenExpired, controller.ErrNotFound:
+    return nil, status.Error(codes.Unauthenticated, err.Error())
+  default:
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+}
+
+// requireService lets through service callers only
+func (h *Handler) requireService(ctx context.Context) error {
+  if !grpcutil.IsService(ctx, h.serviceToken) {
+    return status.Errorf(codes.Unauthenticated, "service credentials required")
+  }
+  return nil
+}
+
+// authorizeService lets through service callers, or admins otherwise
+func (h *Handler) authorizeService(ctx context.Context) error {
+  if grpcutil.IsService(ctx, h.serviceToken) {
+    return nil
+  }
+  _, err := h.authorize(ctx)
+  return err
+}
+
+func (h *Handler) ListUsers(ctx context.Context, req *api.ListUsersRequest) (*api.ListUsersResponse, error) {
+  if _, err := h.authorize(ctx); err != nil {
+    return nil, err
+  }
+
+  limit := req.Limit
+  if limit <= 0 {
+    limit = defaultListLimit
+  }
+  if req.Offset < 0 {
+    return nil, status.Errorf(codes.InvalidArgument, "negative offset")
+  }
+
+  users, err := h.ctrl.ListUsers(ctx, req.Query, limit, req.Offset)
+  if err != nil {
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+
+  res := make([]*api.User, len(users))
+  for i, user := range users {
+    res[i] = model.UserToProto(user)
+  }
+  return &api.ListUsersResponse{Users: res}, nil
+}
+
+func (h *Handler) DisableUser(ctx context.Context, req *api.DisableUserRequest) (*api.DisableUserResponse, error) {
+  if err := h.updateUser(ctx, req.Id, h.ctrl.Disable); err != nil {
+    return nil, err
+  }
+  return &api.DisableUserResponse{}, nil
+}
+
+func (h *Handler) EnableUser(ctx context.Context, req *api.EnableUserRequest) (*api.EnableUserResponse, error) {
+  if err := h.updateUser(ctx, req.Id, h.ctrl.Enable); err != nil {
+    return nil, err
+  }
+  return &api.EnableUserResponse{}, nil
+}
+
+func (h *Handler) LogoutUser(ctx context.Context, req *api.LogoutUserRequest) (*api.LogoutUserResponse, error) {
+  if err := h.updateUser(ctx, req.Id, h.ctrl.AdminLogout); err != nil {
+    return nil, err
+  }
+  return &api.LogoutUserResponse{}, nil
+}
+
+func (h *Handler) ResetUserPassword(ctx context.Context, req *api.ResetUserPasswordRequest) (*api.ResetUserPasswordResponse, error) {
+  if req.Password == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "empty password")
+  }
+  if err := h.updateUser(ctx, req.Id, func(ctx context.Context, id model.UserId) error {
+    return h.ctrl.AdminResetPassword(ctx, id, req.Password)
+  }); err != nil {
+    return nil, err
+  }
+  return &api.ResetUserPasswordResponse{}, nil
+}
+
+func (h *Handler) updateUser(
+  ctx context.Context,
+  id int32,
+  update func(context.Context, model.UserId) error,
+) error {
+  admin, err := h.authorize(ctx)
+  if err != nil {
+    return err
+  }
+  if id <= 0 {
+    return status.Errorf(codes.InvalidArgument, "wrong user id")
+  }
+
+  err = update(users.WithActor(ctx, admin), model.UserId(id))
+  if err == controller.ErrNotFound {
+    return status.Error(codes.NotFound, err.Error())
+  } else if err != nil {
+    return status.Error(codes.Internal, err.Error())
+  }
+  return nil
+}
@@ -12,13 +12,20 @@ import (
   "github.com/bd878/gallery/server/users/pkg/model"
 )
 
+// Cluster lists replicas of users service
+type Cluster interface {
+  GetServers(context.Context) ([]*api.UserServer, error)
+}
+
 type Handler struct {
   api.UnimplementedUserServiceServer
   ctrl *users.Controller
+  cluster Cluster
+  serviceToken string
 }
 
-func New(ctrl *users.Controller) *Handler {
-  return &Handler{ctrl: ctrl}
+func New(ctrl *users.Controller, cluster Cluster, serviceToken string) *Handler {
+  return &Handler{ctrl: ctrl, cluster: cluster, serviceToken: serviceToken}
 }
 
 func (h *Handler) Auth(ctx context.Context, req *api.AuthUserRequest) (*api.AuthUserResponse, error) {
@@ -28,8 +35,69 @@ func (h *Handler) Auth(ctx context.Context, req *api.AuthUserRequest) (*api.Auth
   u, err := h.ctrl.Get(ctx, &model.User{Token: req.Token})
   if err == controller.ErrTokenInvalid {
     return nil, status.Errorf(codes.InvalidArgument, "wrong token")
+  } else if err == controller.ErrDisabled {
+    return nil, status.Errorf(codes.PermissionDenied, err.Error())
+  } else if err == controller.ErrTokenExpired || err == controller.ErrNotFound {
+    return nil, status.Errorf(codes.Unauthenticated, err.Error())
   } else if err != nil {
     return nil, status.Errorf(codes.Internal, err.Error())
   }
   return &api.AuthUserResponse{User: model.UserToProto(u)}, nil
-}
\ No newline at end of file
+}
+
+func (h *Handler) Unlock(ctx context.Context, req *api.UnlockUserRequest) (*api.UnlockUserResponse, error) {
+  admin, err := h.authorize(ctx)
+  if err != nil {
+    return nil, err
+  }
+  if req == nil || (req.Name == "" && req.Ip == "") {
+    return nil, status.Errorf(codes.InvalidArgument, "name or ip required")
+  }
+  if err := h.ctrl.Unlock(users.WithActor(ctx, admin), req.Name, req.Ip); err != nil {
+    return nil, status.Errorf(codes.Internal, err.Error())
+  }
+  return &api.UnlockUserResponse{}, nil
+}
+
+func (h *Handler) ListDeletions(ctx context.Context, _ *api.ListDeletionsRequest) (*api.ListDeletionsResponse, error) {
+  if err := h.requireService(ctx); err != nil {
+    return nil, err
+  }
+  deletions, err := h.ctrl.ListDeletions(ctx)
+  if err != nil {
+    return nil, status.Errorf(codes.Internal, err.Error())
+  }
+
+  res := make([]*api.Deletion, len(deletions))
+  for i, deletion := range deletions {
+    res[i] = model.DeletionToProto(deletion)
+  }
+  return &api.ListDeletionsResponse{Deletions: res}, nil
+}
+
+func (h *Handler) UpdateDeletion(ctx context.Context, req *api.UpdateDeletionRequest) (*api.UpdateDeletionResponse, error) {
+  if err := h.requireService(ctx); err != nil {
+    return nil, err
+  }
+  if req == nil || req.Id == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "nil or empty deletion id")
+  }
+  switch req.Status {
+  case model.DeletionPending, model.DeletionPurging, model.DeletionDone:
+  default:
+    return nil, status.Errorf(codes.InvalidArgument, "unknown deletion status")
+  }
+
+  deletion, err := h.ctrl.UpdateDeletion(ctx, &model.Deletion{
+    Id: req.Id,
+    Status: req.Status,
+    MessagesDeleted: int(req.MessagesDeleted),
+    FilesDeleted: int(req.FilesDeleted),
+  })
+  if err == controller.ErrNoDeletion {
+    return nil, status.Errorf(codes.NotFound, err.Error())
+  } else if err != nil {
+    return nil, status.Errorf(codes.Internal, err.Error())
+  }
+  return &api.UpdateDeletionResponse{Deletion: model.DeletionToProto(deletion)}, nil
+}
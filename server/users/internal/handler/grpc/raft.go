@@ -0,0 +1,54 @@
+package grpc
+
+import (
+  "context"
+
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/status"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+)
+
+// Applier applies writes forwarded by followers
+type Applier interface {
+  ApplyCommand(context.Context, []byte) ([]byte, uint64, string, error)
+}
+
+// RaftHandler takes any write, only nodes holding service token call it
+type RaftHandler struct {
+  api.UnimplementedUserRaftServer
+  applier Applier
+  serviceToken string
+}
+
+func NewRaft(applier Applier, serviceToken string) *RaftHandler {
+  return &RaftHandler{applier: applier, serviceToken: serviceToken}
+}
+
+func (h *RaftHandler) Apply(ctx context.Context, req *api.ApplyUserCommandRequest) (*api.ApplyUserCommandResponse, error) {
+  if !grpcutil.IsService(ctx, h.serviceToken) {
+    return nil, status.Errorf(codes.Unauthenticated, "service credentials required")
+  }
+  if req == nil || len(req.Command) == 0 {
+    return nil, status.Errorf(codes.InvalidArgument, "nil or empty command")
+  }
+
+  result, index, applyErr, err := h.applier.ApplyCommand(ctx, req.Command)
+  if err != nil {
+    return nil, status.Error(codes.Unavailable, err.Error())
+  }
+  return &api.ApplyUserCommandResponse{
+    Result: result,
+    Index: index,
+    Error: applyErr,
+  }, nil
+}
+
+func (h *Handler) GetServers(ctx context.Context, _ *api.GetUserServersRequest) (*api.GetUserServersResponse, error) {
+  servers, err := h.cluster.GetServers(ctx)
+  if err != nil {
+    return nil, status.Error(codes.Internal, err.Error())
+  }
+  return &api.GetUserServersResponse{Servers: servers}, nil
+}
@@ -0,0 +1,183 @@
+package grpc
+
+import (
+  "net"
+  "strconv"
+  "context"
+
+  "google.golang.org/grpc"
+  "google.golang.org/grpc/codes"
+  "google.golang.org/grpc/peer"
+  "google.golang.org/grpc/status"
+  "google.golang.org/grpc/metadata"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/internal/controller/users"
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const maxUsersByIds = 500
+
+func (h *Handler) Register(ctx context.Context, req *api.RegisterRequest) (*api.RegisterResponse, error) {
+  if req == nil || req.Name == "" || req.Password == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "name and password required")
+  }
+
+  user, err := h.ctrl.SignUp(ctx, req.Name, req.Password, req.Email, req.Invite)
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.RegisterResponse{User: model.UserToProto(user)}, nil
+}
+
+func (h *Handler) Login(ctx context.Context, req *api.LoginRequest) (*api.LoginResponse, error) {
+  if req == nil || req.Name == "" || req.Password == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "name and password required")
+  }
+
+  user, lockout, err := h.ctrl.Authenticate(ctx, req.Name, req.Password, peerIP(ctx))
+  if err == controller.ErrLocked {
+    grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(lockout.RetryAfter)))
+    return nil, status.Error(codes.ResourceExhausted, err.Error())
+  }
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.LoginResponse{User: model.UserToProto(user)}, nil
+}
+
+func (h *Handler) Refresh(ctx context.Context, req *api.RefreshRequest) (*api.RefreshResponse, error) {
+  if req == nil || req.Token == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "nil or empty token")
+  }
+
+  user, err := h.ctrl.RefreshToken(ctx, req.Token)
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.RefreshResponse{User: model.UserToProto(user)}, nil
+}
+
+func (h *Handler) Logout(ctx context.Context, req *api.LogoutRequest) (*api.LogoutResponse, error) {
+  if req == nil || req.Token == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "nil or empty token")
+  }
+
+  if err := h.ctrl.LogoutToken(ctx, req.Token); err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.LogoutResponse{}, nil
+}
+
+func (h *Handler) GetUser(ctx context.Context, req *api.GetUserRequest) (*api.GetUserResponse, error) {
+  if err := h.authorizeService(ctx); err != nil {
+    return nil, err
+  }
+  if req == nil || (req.Id <= 0 && req.Name == "") {
+    return nil, status.Errorf(codes.InvalidArgument, "id or name required")
+  }
+
+  user, err := h.ctrl.GetUser(ctx, &model.User{Id: model.UserId(req.Id), Name: req.Name})
+  if err == controller.ErrNotFound {
+    return nil, status.Error(codes.NotFound, err.Error())
+  }
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.GetUserResponse{User: model.UserToProto(user)}, nil
+}
+
+func (h *Handler) GetUsersByIds(ctx context.Context, req *api.GetUsersByIdsRequest) (*api.GetUsersByIdsResponse, error) {
+  if err := h.authorizeService(ctx); err != nil {
+    return nil, err
+  }
+  if req == nil || len(req.Ids) > maxUsersByIds {
+    return nil, status.Errorf(codes.InvalidArgument, "at most %d ids", maxUsersByIds)
+  }
+
+  ids := make([]model.UserId, len(req.Ids))
+  for i, id := range req.Ids {
+    ids[i] = model.UserId(id)
+  }
+
+  users, err := h.ctrl.GetUsersByIds(ctx, ids)
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+
+  res := make([]*api.User, len(users))
+  for i, user := range users {
+    res[i] = model.UserToProto(user)
+  }
+  return &api.GetUsersByIdsResponse{Users: res}, nil
+}
+
+func (h *Handler) GetProfile(ctx context.Context, req *api.GetProfileRequest) (*api.GetProfileResponse, error) {
+  if req == nil || req.Token == "" {
+    return nil, status.Errorf(codes.InvalidArgument, "nil or empty token")
+  }
+
+  user, err := h.ctrl.Get(ctx, &model.User{Token: req.Token})
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.GetProfileResponse{Profile: model.ProfileToProto(user.Profile)}, nil
+}
+
+func (h *Handler) UpdateProfile(ctx context.Context, req *api.UpdateProfileRequest) (*api.UpdateProfileResponse, error) {
+  if req == nil || req.Token == "" || req.Profile == nil {
+    return nil, status.Errorf(codes.InvalidArgument, "token and profile required")
+  }
+
+  user, err := h.ctrl.Get(ctx, &model.User{Token: req.Token})
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+
+  user, err = h.ctrl.UpdateProfile(ctx, user, model.ProfileFromProto(req.Profile))
+  if err != nil {
+    return nil, errorStatus(err)
+  }
+  return &api.UpdateProfileResponse{User: model.UserToProto(user)}, nil
+}
+
+func errorStatus(err error) error {
+  switch err {
+  case controller.ErrNotFound, controller.ErrTokenExpired, controller.ErrWrongPassword:
+    return status.Error(codes.Unauthenticated, err.Error())
+  case controller.ErrDisabled, controller.ErrForbidden,
+    controller.ErrRegistrationClosed, controller.ErrInviteInvalid:
+    return status.Error(codes.PermissionDenied, err.Error())
+  case controller.ErrNameExists:
+    return status.Error(codes.AlreadyExists, err.Error())
+  case controller.ErrBadEmail, controller.ErrBadProfile:
+    return status.Error(codes.InvalidArgument, err.Error())
+  case controller.ErrLocked:
+    return status.Error(codes.ResourceExhausted, err.Error())
+  default:
+    return status.Error(codes.Internal, err.Error())
+  }
+}
+
+// AuditInterceptor passes client address to audit log
+func AuditInterceptor(
+  ctx context.Context,
+  req any,
+  _ *grpc.UnaryServerInfo,
+  handler grpc.UnaryHandler,
+) (any, error) {
+  return handler(users.WithClient(ctx, peerIP(ctx)), req)
+}
+
+func peerIP(ctx context.Context) string {
+  p, ok := peer.FromContext(ctx)
+  if !ok || p.Addr == nil {
+    return ""
+  }
+  host, _, err := net.SplitHostPort(p.Addr.String())
+  if err != nil {
+    return p.Addr.String()
+  }
+  return host
+}
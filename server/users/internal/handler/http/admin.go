@@ -0,0 +1,214 @@
+package http
+
+import (
+  "log"
+  "context"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/internal/controller/users"
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const defaultListLimit = 50
+
+func (h *Handler) CheckAdmin(
+  next func (w http.ResponseWriter, req *http.Request),
+) func (w http.ResponseWriter, req *http.Request) {
+  return func(w http.ResponseWriter, req *http.Request) {
+    cookie, err := req.Cookie("token")
+    if err != nil {
+      log.Println("bad cookie")
+      w.WriteHeader(http.StatusBadRequest)
+      return
+    }
+
+    admin, err := h.ctrl.Authorize(context.Background(), cookie.Value)
+    switch err {
+    case nil:
+
+    case controller.ErrForbidden:
+      w.WriteHeader(http.StatusForbidden)
+      if err := json.NewEncoder(w).Encode(model.ServerResponse{
+        Status: "ok",
+        Description: "admin required",
+      }); err != nil {
+        log.Println(err)
+      }
+      return
+
+    case controller.ErrTokenExpired, controller.ErrNotFound, controller.ErrDisabled:
+      w.WriteHeader(http.StatusUnauthorized)
+      if err := json.NewEncoder(w).Encode(model.ServerResponse{
+        Status: "ok",
+        Description: err.Error(),
+      }); err != nil {
+        log.Println(err)
+      }
+      return
+
+    default:
+      log.Println("failed to authorize admin: ", err)
+      w.WriteHeader(http.StatusInternalServerError)
+      return
+    }
+
+    log.Println("admin request from", admin.Name, req.URL.Path)
+    next(w, req.WithContext(users.WithActor(req.Context(), admin)))
+  }
+}
+
+func (h *Handler) ListUsers(w http.ResponseWriter, req *http.Request) {
+  values := req.URL.Query()
+
+  limit, ok := getIntQuery(w, values.Get("limit"), "limit", defaultListLimit)
+  if !ok {
+    return
+  }
+  offset, ok := getIntQuery(w, values.Get("offset"), "offset", 0)
+  if !ok {
+    return
+  }
+
+  users, err := h.ctrl.ListUsers(context.Background(), values.Get("q"), int32(limit), int32(offset))
+  if err != nil {
+    log.Println("failed to list users: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  res := make([]model.User, len(users))
+  for i, user := range users {
+    res[i] = *user
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerUsersResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    Users: res,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func (h *Handler) DisableUser(w http.ResponseWriter, req *http.Request) {
+  h.updateUser(w, req, "disabled", h.ctrl.Disable)
+}
+
+func (h *Handler) EnableUser(w http.ResponseWriter, req *http.Request) {
+  h.updateUser(w, req, "enabled", h.ctrl.Enable)
+}
+
+func (h *Handler) LogoutUser(w http.ResponseWriter, req *http.Request) {
+  h.updateUser(w, req, "logged out", h.ctrl.AdminLogout)
+}
+
+func (h *Handler) ResetUserPassword(w http.ResponseWriter, req *http.Request) {
+  password, ok := getTextField(w, req, "new_password")
+  if !ok {
+    return
+  }
+
+  h.updateUser(w, req, "password reset", func(ctx context.Context, id model.UserId) error {
+    return h.ctrl.AdminResetPassword(ctx, id, password)
+  })
+}
+
+func (h *Handler) UnlockUser(w http.ResponseWriter, req *http.Request) {
+  name := req.PostFormValue("name")
+  ip := req.PostFormValue("ip")
+  if name == "" && ip == "" {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "no name or ip",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+
+  if err := h.ctrl.Unlock(h.auditContext(req), name, ip); err != nil {
+    log.Println("failed to unlock: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "unlocked",
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func (h *Handler) updateUser(
+  w http.ResponseWriter,
+  req *http.Request,
+  description string,
+  update func(context.Context, model.UserId) error,
+) {
+  id, err := strconv.Atoi(req.PostFormValue("id"))
+  if err != nil {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "wrong \"id\" param",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+
+  err = update(h.auditContext(req), model.UserId(id))
+  if err == controller.ErrNotFound {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "no user",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+  if err != nil {
+    log.Println("failed to update user: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: description,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func getIntQuery(w http.ResponseWriter, raw, field string, def int) (int, bool) {
+  if raw == "" {
+    return def, true
+  }
+
+  value, err := strconv.Atoi(raw)
+  if err != nil || value < 0 {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "wrong \"" + field + "\" query param",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return 0, false
+  }
+  return value, true
+}
@@ -0,0 +1,94 @@
+package http
+
+import (
+  "log"
+  "time"
+  "strings"
+  "net/http"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * ListAudit queries audit log. Filters are user_id,
+ * event (comma separated), since and until (RFC3339),
+ * newest events come first
+ */
+func (h *Handler) ListAudit(w http.ResponseWriter, req *http.Request) {
+  values := req.URL.Query()
+
+  userId, ok := getIntQuery(w, values.Get("user_id"), "user_id", 0)
+  if !ok {
+    return
+  }
+  limit, ok := getIntQuery(w, values.Get("limit"), "limit", defaultListLimit)
+  if !ok {
+    return
+  }
+  offset, ok := getIntQuery(w, values.Get("offset"), "offset", 0)
+  if !ok {
+    return
+  }
+  since, ok := getTimeQuery(w, values.Get("since"), "since")
+  if !ok {
+    return
+  }
+  until, ok := getTimeQuery(w, values.Get("until"), "until")
+  if !ok {
+    return
+  }
+
+  filter := &model.AuditFilter{
+    UserId: model.UserId(userId),
+    Since: since,
+    Until: until,
+    Limit: int32(limit),
+    Offset: int32(offset),
+  }
+  if events := values.Get("event"); events != "" {
+    filter.Events = strings.Split(events, ",")
+  }
+
+  events, err := h.ctrl.ListAudit(req.Context(), filter)
+  if err != nil {
+    log.Println("failed to list audit events: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  res := make([]model.AuditEvent, len(events))
+  for i, event := range events {
+    res[i] = *event
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerAuditResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    Events: res,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func getTimeQuery(w http.ResponseWriter, raw, field string) (time.Time, bool) {
+  if raw == "" {
+    return time.Time{}, true
+  }
+
+  value, err := time.Parse(time.RFC3339, raw)
+  if err != nil {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "wrong \"" + field + "\" query param",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return time.Time{}, false
+  }
+  return value, true
+}
@@ -2,21 +2,28 @@ package http
 
 import (
   "log"
+  "net"
   "net/http"
   "io"
   "time"
+  "strings"
+  "strconv"
   "context"
   "encoding/json"
 
   "github.com/bd878/gallery/server/users/internal/controller"
   "github.com/bd878/gallery/server/users/internal/controller/users"
   "github.com/bd878/gallery/server/users/pkg/model"
-  "github.com/bd878/gallery/server/utils"
+  "github.com/bd878/gallery/server/pkg/cookie"
 )
 
 /* TODO: rewrite global config on singletone pattern */
 type Config struct {
-  Domainname string
+  // session cookie attributes
+  Cookie cookie.Config
+  TrustProxy bool
+  // page oidc callback redirects to, "/" by default
+  OIDCLanding string
 }
 
 type Handler struct {
@@ -30,9 +37,7 @@ func New(ctrl *users.Controller, cfg Config) *Handler {
 
 func (h *Handler) Authenticate(w http.ResponseWriter, req *http.Request) {
   var userName, password string 
-  var ok, exists bool
-  var user *model.User
-  var err error
+  var ok bool
 
   if userName, ok = getName(w, req); !ok {
     log.Println("cannot get name")
@@ -44,56 +49,52 @@ func (h *Handler) Authenticate(w http.ResponseWriter, req *http.Request) {
     return
   }
 
-  exists, err = h.ctrl.Has(context.Background(), &model.User{Name: userName, Password: password})
-  if err != nil {
-    log.Println("failed to check if user exists:", err)
-    w.WriteHeader(http.StatusInternalServerError)
+  user, lockout, err := h.ctrl.Authenticate(
+    h.auditContext(req),
+    userName,
+    password,
+    clientIP(req, h.cfg.TrustProxy),
+  )
+  switch err {
+  case controller.ErrLocked:
+    log.Println("login locked for user:", userName)
+    writeLocked(w, lockout)
     return
-  }
 
-  if !exists {
+  case controller.ErrDisabled:
     if err = json.NewEncoder(w).Encode(model.ServerResponse{
       Status: "ok",
-      Description: "no user,password pair",
+      Description: "user disabled",
     }); err != nil {
-      log.Println("failed to send no user,password pair:", err)
+      log.Println("failed to send user disabled:", err)
       w.WriteHeader(http.StatusInternalServerError)
-      return
     }
     return
-  }
-
-  user, err = h.ctrl.Get(context.Background(), &model.User{Name: userName})
-  switch err {
-  case controller.ErrTokenExpired:
-    log.Println("token expired")
-    err = refreshToken(h, w, userName)
-    if err != nil {
-      log.Println("Cannot refresh token: ", err)
-      w.WriteHeader(http.StatusInternalServerError)
-      return
-    }
 
-  case controller.ErrNotFound:
-    if err = json.NewEncoder(w).Encode(model.ServerResponse{
-      Status: "ok",
-      Description: "no user,password pair",
+  case controller.ErrWrongPassword:
+    if err = json.NewEncoder(w).Encode(model.ServerLoginResponse{
+      ServerResponse: model.ServerResponse{
+        Status: "ok",
+        Description: "no user,password pair",
+      },
+      Lockout: *lockout,
     }); err != nil {
-      log.Println("cannot respond no user: ", err)
+      log.Println("failed to send no user,password pair:", err)
       w.WriteHeader(http.StatusInternalServerError)
       return
     }
+    return
 
   case nil:
-    err = attachTokenToResponse(w, user.Token, h.cfg.Domainname, user.Expires)
-    if err != nil {
-      log.Println("Cannot attach token to response: ", err)
-      w.WriteHeader(http.StatusInternalServerError)
-      return
-    }
 
   default:
-    log.Println("Unknown error: ", err)
+    log.Println("failed to authenticate user:", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err = attachTokenToResponse(w, user.Token, user.Expires, h.cfg.Cookie); err != nil {
+    log.Println("Cannot attach token to response: ", err)
     w.WriteHeader(http.StatusInternalServerError)
     return
   }
@@ -116,7 +117,6 @@ func (h *Handler) Auth(w http.ResponseWriter, req *http.Request) {
     return
   }
 
-  log.Println("cookie value =", cookie.Value)
   user, err := h.ctrl.Get(context.Background(), &model.User{Token: cookie.Value})
   if err == controller.ErrTokenExpired {
     log.Println("token expired")
@@ -132,6 +132,16 @@ func (h *Handler) Auth(w http.ResponseWriter, req *http.Request) {
       return
     }
   }
+  if err == controller.ErrDisabled {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "user disabled",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
   // TODO: check for token expired error
   if err != nil {
     log.Println("failed to get user by token: ", err)
@@ -150,6 +160,8 @@ func (h *Handler) Auth(w http.ResponseWriter, req *http.Request) {
       Name: user.Name,
       Token: user.Token,
       Expires: user.Expires,
+      Role: user.Role,
+      Profile: user.Profile,
     },
   }); err != nil {
     log.Println("failed to send authorize response: ", err)
@@ -162,53 +174,379 @@ func (h *Handler) Register(w http.ResponseWriter, req *http.Request) {
   var userName, password string
   var ok bool
 
-  // user name
   if userName, ok = getName(w, req); !ok {
     return
   }
 
-  // already exists
-  exists, err := h.ctrl.Has(context.Background(), &model.User{Name: userName})
+  if password, ok = getPassword(w, req); !ok {
+    return
+  }
+
+  log.Println("register user", userName)
+  user, err := h.ctrl.SignUp(
+    h.auditContext(req),
+    userName,
+    password,
+    req.PostFormValue("email"),
+    req.PostFormValue("invite"),
+  )
+  switch err {
+  case controller.ErrNameExists, controller.ErrBadEmail:
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: err.Error(),
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+
+  case controller.ErrRegistrationClosed, controller.ErrInviteInvalid:
+    w.WriteHeader(http.StatusForbidden)
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: err.Error(),
+    }); err != nil {
+      log.Println(err)
+    }
+    return
+
+  case nil:
+
+  default:
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := attachTokenToResponse(w, user.Token, user.Expires, h.cfg.Cookie); err != nil {
+    log.Println("failed to attach token: ", err)
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "created",
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+// Logout ends current session, cookie is expired
+// Logout changes state, so it is POST only and passes csrf check
+func (h *Handler) Logout(w http.ResponseWriter, req *http.Request) {
+  if req.Method != http.MethodPost {
+    w.WriteHeader(http.StatusMethodNotAllowed)
+    return
+  }
+
+  cookie, err := req.Cookie("token")
   if err != nil {
+    log.Println("bad cookie")
+    w.WriteHeader(http.StatusBadRequest)
+    return
+  }
+
+  err = h.ctrl.LogoutToken(h.auditContext(req), cookie.Value)
+  if err != nil && err != controller.ErrNotFound {
+    log.Println("failed to logout: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  expireToken(w, h.cfg.Cookie)
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "logged out",
+  }); err != nil {
     log.Println(err)
     w.WriteHeader(http.StatusInternalServerError)
     return
   }
+}
+
+func (h *Handler) Delete(w http.ResponseWriter, req *http.Request) {
+  cookie, err := req.Cookie("token")
+  if err != nil {
+    log.Println("bad cookie")
+    w.WriteHeader(http.StatusBadRequest)
+    return
+  }
 
-  if exists {
+  user, err := h.ctrl.Get(context.Background(), &model.User{Token: cookie.Value})
+  switch err {
+  case controller.ErrTokenExpired, controller.ErrNotFound:
     if err := json.NewEncoder(w).Encode(model.ServerResponse{
       Status: "ok",
-      Description: "name exists",
+      Description: err.Error(),
     }); err != nil {
       log.Println(err)
       w.WriteHeader(http.StatusInternalServerError)
-      return
     }
     return
+
+  case nil:
+
+  default:
+    log.Println("failed to get user by token: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
   }
 
-  // password
-  if password, ok = getPassword(w, req); !ok {
+  // deletion is confirmed with password
+  password, ok := getPassword(w, req)
+  if !ok {
+    return
+  }
+
+  lockout, err := h.ctrl.Login(
+    h.auditContext(req),
+    &model.User{Name: user.Name, Password: password},
+    clientIP(req, h.cfg.TrustProxy),
+  )
+  switch err {
+  case controller.ErrLocked:
+    writeLocked(w, lockout)
+    return
+
+  case controller.ErrWrongPassword:
+    if err := json.NewEncoder(w).Encode(model.ServerLoginResponse{
+      ServerResponse: model.ServerResponse{
+        Status: "ok",
+        Description: "wrong password",
+      },
+      Lockout: *lockout,
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+
+  case nil:
+
+  default:
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  deletion, err := h.ctrl.Delete(h.auditContext(req), user)
+  if err != nil {
+    log.Println("failed to delete user: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
     return
   }
 
-  token, expires := createToken(w, h.cfg.Domainname)
+  expireToken(w, h.cfg.Cookie)
 
-  log.Println("user, password, token, expires=", userName, password, token, expires)
-  if err := h.ctrl.Add(context.Background(), &model.User{
-    Name: userName,
-    Password: password,
-    Token: token,
-    Expires: expires,
+  if err := json.NewEncoder(w).Encode(model.ServerDeletionResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "deleting",
+    },
+    Deletion: *deletion,
   }); err != nil {
     log.Println(err)
     w.WriteHeader(http.StatusInternalServerError)
     return
   }
+}
+
+func (h *Handler) ChangePassword(w http.ResponseWriter, req *http.Request) {
+  cookie, err := req.Cookie("token")
+  if err != nil {
+    log.Println("bad cookie")
+    w.WriteHeader(http.StatusBadRequest)
+    return
+  }
+
+  user, err := h.ctrl.Get(context.Background(), &model.User{Token: cookie.Value})
+  switch err {
+  case controller.ErrTokenExpired, controller.ErrNotFound:
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: err.Error(),
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+
+  case nil:
+
+  default:
+    log.Println("failed to get user by token: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  password, ok := getPassword(w, req)
+  if !ok {
+    return
+  }
+  newPassword, ok := getTextField(w, req, "new_password")
+  if !ok {
+    return
+  }
+
+  updated, lockout, err := h.ctrl.ChangePassword(
+    h.auditContext(req),
+    user,
+    password,
+    newPassword,
+    clientIP(req, h.cfg.TrustProxy),
+  )
+  switch err {
+  case controller.ErrLocked:
+    writeLocked(w, lockout)
+    return
+
+  case controller.ErrWrongPassword:
+    if err := json.NewEncoder(w).Encode(model.ServerLoginResponse{
+      ServerResponse: model.ServerResponse{
+        Status: "ok",
+        Description: "wrong password",
+      },
+      Lockout: *lockout,
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+
+  case nil:
+
+  default:
+    log.Println("failed to change password: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := attachTokenToResponse(w, updated.Token, updated.Expires, h.cfg.Cookie); err != nil {
+    log.Println("Cannot attach token to response: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
 
   if err := json.NewEncoder(w).Encode(model.ServerResponse{
     Status: "ok",
-    Description: "created",
+    Description: "password changed",
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+// Answers the same whether user exists or not
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, req *http.Request) {
+  userName, ok := getName(w, req)
+  if !ok {
+    return
+  }
+
+  if err := h.ctrl.RequestPasswordReset(h.auditContext(req), userName); err != nil {
+    log.Println("failed to request password reset: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "reset requested",
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func (h *Handler) ResetPassword(w http.ResponseWriter, req *http.Request) {
+  token, ok := getTextField(w, req, "token")
+  if !ok {
+    return
+  }
+  newPassword, ok := getTextField(w, req, "new_password")
+  if !ok {
+    return
+  }
+
+  user, err := h.ctrl.ResetPassword(h.auditContext(req), token, newPassword)
+  switch err {
+  case controller.ErrResetInvalid, controller.ErrNotFound:
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "reset token invalid",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+
+  case nil:
+
+  default:
+    log.Println("failed to reset password: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := attachTokenToResponse(w, user.Token, user.Expires, h.cfg.Cookie); err != nil {
+    log.Println("Cannot attach token to response: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "password reset",
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+// Deletion progress is looked up by id returned from Delete,
+// account cookie is gone by then
+func (h *Handler) DeletionStatus(w http.ResponseWriter, req *http.Request) {
+  id := req.URL.Query().Get("id")
+  if id == "" {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "empty deletion id",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+
+  deletion, err := h.ctrl.GetDeletion(context.Background(), id)
+  if err == controller.ErrNoDeletion {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "no deletion",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+  if err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerDeletionResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: deletion.Status,
+    },
+    Deletion: *deletion,
   }); err != nil {
     log.Println(err)
     w.WriteHeader(http.StatusInternalServerError)
@@ -225,6 +563,43 @@ func (h *Handler) ReportStatus(w http.ResponseWriter, _ *http.Request) {
   }
 }
 
+func writeLocked(w http.ResponseWriter, lockout *model.Lockout) {
+  w.Header().Set("Retry-After", strconv.Itoa(lockout.RetryAfter))
+  w.WriteHeader(http.StatusTooManyRequests)
+  if err := json.NewEncoder(w).Encode(model.ServerLoginResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "locked",
+    },
+    Lockout: *lockout,
+  }); err != nil {
+    log.Println("failed to send locked:", err)
+  }
+}
+
+/**
+ * Behind nginx RemoteAddr is the proxy itself.
+ * X-Real-IP and the last X-Forwarded-For entry are
+ * set by the proxy, earlier entries are client supplied
+ */
+func clientIP(req *http.Request, trustProxy bool) string {
+  if trustProxy {
+    if ip := req.Header.Get("X-Real-IP"); ip != "" {
+      return strings.TrimSpace(ip)
+    }
+    if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+      hops := strings.Split(fwd, ",")
+      return strings.TrimSpace(hops[len(hops)-1])
+    }
+  }
+
+  host, _, err := net.SplitHostPort(req.RemoteAddr)
+  if err != nil {
+    return req.RemoteAddr
+  }
+  return host
+}
+
 func getName(w http.ResponseWriter, req *http.Request) (name string, ok bool) {
   name, ok = getTextField(w, req, "name")
   return
@@ -252,37 +627,12 @@ func getTextField(w http.ResponseWriter, req *http.Request, field string) (value
   return
 }
 
-func refreshToken(h *Handler, w http.ResponseWriter, userName string) (err error) {
-  var token, expires string
-
-  token, expires = createToken(w, h.cfg.Domainname)
-
-  return h.ctrl.Refresh(context.Background(), &model.User{Name: userName, Token: token, Expires: expires})
+// auditContext carries client address to audit log
+func (h *Handler) auditContext(req *http.Request) context.Context {
+  return users.WithClient(req.Context(), clientIP(req, h.cfg.TrustProxy))
 }
 
-func createToken(w http.ResponseWriter, domain string) (token string, expires string) {
-  token = utils.RandomString(10)
-  expiresAt := time.Now().Add(time.Hour * 24 * 5)
-  expiresRaw, err := expiresAt.MarshalText()
-  if err != nil {
-    log.Println("failed to marshal text: ", err)
-  }
-
-  expires = string(expiresRaw)
-
-  http.SetCookie(w, &http.Cookie{
-    Name: "token",
-    Value: token,
-    Domain: domain,
-    Expires: expiresAt,
-    Path: "/",
-    HttpOnly: true,
-  })
-
-  return
-}
-
-func attachTokenToResponse(w http.ResponseWriter, token, domain string, expires string) (err error) {
+func attachTokenToResponse(w http.ResponseWriter, token, expires string, cfg cookie.Config) (err error) {
   var tokenExpiresTime time.Time
 
   err = tokenExpiresTime.UnmarshalText([]byte(expires))
@@ -290,15 +640,10 @@ func attachTokenToResponse(w http.ResponseWriter, token, domain string, expires
     return
   }
 
-  http.SetCookie(w, &http.Cookie{
-    Name: "token",
-    Value: token,
-    Domain: domain,
-    Expires: tokenExpiresTime,
-    Path: "/",
-    HttpOnly: true,
-  })
-
+  http.SetCookie(w, cfg.New("token", token, tokenExpiresTime))
   return
 }
 
+func expireToken(w http.ResponseWriter, cfg cookie.Config) {
+  http.SetCookie(w, cfg.Expire("token"))
+}
@@ -0,0 +1,181 @@
+package http
+
+import (
+  "log"
+  "time"
+  "context"
+  "strconv"
+  "net/http"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+func (h *Handler) CreateInvite(w http.ResponseWriter, req *http.Request) {
+  user, ok := h.sessionUser(w, req)
+  if !ok {
+    return
+  }
+
+  maxUses, ok := getIntForm(w, req, "max_uses", 1)
+  if !ok {
+    return
+  }
+  ttlSec, ok := getIntForm(w, req, "ttl_sec", 0)
+  if !ok {
+    return
+  }
+
+  invite, err := h.ctrl.CreateInvite(context.Background(), user, maxUses,
+    time.Duration(ttlSec)*time.Second)
+  switch err {
+  case controller.ErrForbidden, controller.ErrInviteQuota, controller.ErrRegistrationClosed:
+    w.WriteHeader(http.StatusForbidden)
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: err.Error(),
+    }); err != nil {
+      log.Println(err)
+    }
+    return
+
+  case nil:
+
+  default:
+    log.Println("failed to create invite: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerInviteResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: "created",
+    },
+    Invite: *invite,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func (h *Handler) ListInvites(w http.ResponseWriter, req *http.Request) {
+  user, ok := h.sessionUser(w, req)
+  if !ok {
+    return
+  }
+
+  invites, err := h.ctrl.ListInvites(context.Background(), user)
+  if err != nil {
+    log.Println("failed to list invites: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  res := make([]model.Invite, len(invites))
+  for i, invite := range invites {
+    res[i] = *invite
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerInvitesResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+    },
+    Invites: res,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+func (h *Handler) RevokeInvite(w http.ResponseWriter, req *http.Request) {
+  user, ok := h.sessionUser(w, req)
+  if !ok {
+    return
+  }
+
+  code, ok := getTextField(w, req, "code")
+  if !ok {
+    return
+  }
+
+  err := h.ctrl.RevokeInvite(context.Background(), user, code)
+  if err == controller.ErrInviteInvalid {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "no invite",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+  if err != nil {
+    log.Println("failed to revoke invite: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: "revoked",
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+}
+
+// sessionUser answers unauthorized itself when token cookie is bad
+func (h *Handler) sessionUser(w http.ResponseWriter, req *http.Request) (*model.User, bool) {
+  cookie, err := req.Cookie("token")
+  if err != nil {
+    log.Println("bad cookie")
+    w.WriteHeader(http.StatusBadRequest)
+    return nil, false
+  }
+
+  user, err := h.ctrl.Get(context.Background(), &model.User{Token: cookie.Value})
+  switch err {
+  case nil:
+    return user, true
+
+  case controller.ErrTokenExpired, controller.ErrNotFound, controller.ErrDisabled:
+    w.WriteHeader(http.StatusUnauthorized)
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: err.Error(),
+    }); err != nil {
+      log.Println(err)
+    }
+    return nil, false
+
+  default:
+    log.Println("failed to get user by token: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return nil, false
+  }
+}
+
+func getIntForm(w http.ResponseWriter, req *http.Request, field string, def int) (int, bool) {
+  raw := req.PostFormValue(field)
+  if raw == "" {
+    return def, true
+  }
+
+  value, err := strconv.Atoi(raw)
+  if err != nil || value < 0 {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "wrong \"" + field + "\" param",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return 0, false
+  }
+  return value, true
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+  "log"
+  "time"
+  "context"
+  "strings"
+  "net/http"
+  "encoding/json"
+  "encoding/base64"
+
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/internal/controller/users"
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+const (
+  oidcCookieName = "oidc"
+  // time user has to log in at the provider
+  oidcFlowTTL = 10*time.Minute
+)
+
+// OIDCLogin redirects to provider login page, invite lets new account in
+func (h *Handler) OIDCLogin(w http.ResponseWriter, req *http.Request) {
+  h.startOIDC(w, req, false, req.URL.Query().Get("invite"))
+}
+
+// OIDCLink adds provider account to logged in user
+func (h *Handler) OIDCLink(w http.ResponseWriter, req *http.Request) {
+  if _, ok := h.sessionUser(w, req); !ok {
+    return
+  }
+  h.startOIDC(w, req, true, "")
+}
+
+func (h *Handler) startOIDC(w http.ResponseWriter, req *http.Request, link bool, invite string) {
+  flow, err := h.ctrl.StartOIDC(context.Background(), link, invite)
+  switch err {
+  case controller.ErrOIDCDisabled:
+    writeOIDCError(w, http.StatusNotFound, err)
+    return
+
+  case nil:
+
+  default:
+    log.Println("failed to start oidc login:", err)
+    w.WriteHeader(http.StatusBadGateway)
+    return
+  }
+
+  http.SetCookie(w, h.oidcCookie(encodeFlow(flow), time.Now().Add(oidcFlowTTL)))
+  http.Redirect(w, req, flow.URL, http.StatusFound)
+}
+
+/**
+ * OIDCCallback is provider redirect target. Flow cookie
+ * is removed whatever the outcome, so a code is tried once
+ */
+func (h *Handler) OIDCCallback(w http.ResponseWriter, req *http.Request) {
+  flowCookie, err := req.Cookie(oidcCookieName)
+  if err != nil {
+    writeOIDCError(w, http.StatusBadRequest, controller.ErrOIDCState)
+    return
+  }
+  expired := h.oidcCookie("", time.Time{})
+  expired.MaxAge = -1
+  http.SetCookie(w, expired)
+
+  query := req.URL.Query()
+  if providerErr := query.Get("error"); providerErr != "" {
+    log.Println("oidc provider error:", providerErr, query.Get("error_description"))
+    writeOIDCError(w, http.StatusUnauthorized, controller.ErrOIDCToken)
+    return
+  }
+
+  flow, ok := decodeFlow(flowCookie.Value)
+  if !ok {
+    writeOIDCError(w, http.StatusBadRequest, controller.ErrOIDCState)
+    return
+  }
+
+  var current *model.User
+  if flow.Link {
+    if current, ok = h.sessionUser(w, req); !ok {
+      return
+    }
+  }
+
+  user, err := h.ctrl.FinishOIDC(h.auditContext(req), flow, query.Get("state"),
+    query.Get("code"), current)
+  switch err {
+  case controller.ErrOIDCState:
+    writeOIDCError(w, http.StatusBadRequest, err)
+    return
+
+  case controller.ErrOIDCToken, controller.ErrIdentityUnknown, controller.ErrDisabled:
+    writeOIDCError(w, http.StatusUnauthorized, err)
+    return
+
+  case controller.ErrIdentityLinked, controller.ErrRegistrationClosed, controller.ErrInviteInvalid:
+    writeOIDCError(w, http.StatusForbidden, err)
+    return
+
+  case nil:
+
+  default:
+    log.Println("failed to finish oidc login:", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  if !flow.Link {
+    if err := attachTokenToResponse(w, user.Token, user.Expires, h.cfg.Cookie); err != nil {
+      log.Println("Cannot attach token to response: ", err)
+      w.WriteHeader(http.StatusInternalServerError)
+      return
+    }
+  }
+
+  landing := h.cfg.OIDCLanding
+  if landing == "" {
+    landing = "/"
+  }
+  http.Redirect(w, req, landing, http.StatusFound)
+}
+
+/**
+ * Flow cookie returns with provider redirect,
+ * a cross-site navigation, strict cookies are not sent on it
+ */
+func (h *Handler) oidcCookie(value string, expires time.Time) *http.Cookie {
+  cookie := h.cfg.Cookie.New(oidcCookieName, value, expires)
+  cookie.HttpOnly = true
+  if cookie.SameSite != http.SameSiteNoneMode {
+    cookie.SameSite = http.SameSiteLaxMode
+  }
+  return cookie
+}
+
+// values are base64url, dot does not occur in them
+func encodeFlow(flow *users.OIDCFlow) string {
+  mode := "login"
+  if flow.Link {
+    mode = "link"
+  }
+  // invite comes from the query, encoded to stay one cookie-safe part
+  invite := base64.RawURLEncoding.EncodeToString([]byte(flow.Invite))
+  return strings.Join([]string{flow.State, flow.Nonce, flow.Verifier, mode, invite}, ".")
+}
+
+func decodeFlow(value string) (*users.OIDCFlow, bool) {
+  parts := strings.Split(value, ".")
+  if len(parts) != 5 {
+    return nil, false
+  }
+  invite, err := base64.RawURLEncoding.DecodeString(parts[4])
+  if err != nil {
+    return nil, false
+  }
+  return &users.OIDCFlow{
+    State: parts[0],
+    Nonce: parts[1],
+    Verifier: parts[2],
+    Link: parts[3] == "link",
+    Invite: string(invite),
+  }, true
+}
+
+func writeOIDCError(w http.ResponseWriter, status int, err error) {
+  w.WriteHeader(status)
+  if err := json.NewEncoder(w).Encode(model.ServerResponse{
+    Status: "ok",
+    Description: err.Error(),
+  }); err != nil {
+    log.Println(err)
+  }
+}
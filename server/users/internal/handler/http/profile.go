@@ -0,0 +1,203 @@
+package http
+
+import (
+  "io"
+  "log"
+  "mime"
+  "context"
+  "strings"
+  "net/http"
+  "path/filepath"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/users/internal/controller"
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/utils"
+)
+
+// MaxAvatarSize of avatar file, upload body has room for form around it
+const MaxAvatarSize = 5 << 20
+
+// avatarTypes are raster images served as avatars, by sniffed
+// content type and by extension of saved file
+var avatarTypes = map[string]string{
+  "image/png": ".png",
+  "image/jpeg": ".jpg",
+  "image/gif": ".gif",
+  "image/webp": ".webp",
+}
+
+// Profile returns session user profile on GET, updates it on POST
+func (h *Handler) Profile(w http.ResponseWriter, req *http.Request) {
+  user, ok := h.sessionUser(w, req)
+  if !ok {
+    return
+  }
+
+  if req.Method == http.MethodPost {
+    if user, ok = h.updateProfile(w, req, user); !ok {
+      return
+    }
+  }
+
+  writeProfile(w, user.Profile, "ok")
+}
+
+// Fields missing from the form are left as they are
+func (h *Handler) updateProfile(w http.ResponseWriter, req *http.Request, user *model.User) (*model.User, bool) {
+  if err := req.ParseForm(); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusBadRequest)
+    return nil, false
+  }
+
+  profile := *user.Profile
+  for field, value := range map[string]*string{
+    "email": &profile.Email,
+    "display_name": &profile.DisplayName,
+    "time_zone": &profile.TimeZone,
+    "locale": &profile.Locale,
+    "sort_order": &profile.SortOrder,
+  } {
+    if values, ok := req.PostForm[field]; ok {
+      *value = values[0]
+    }
+  }
+
+  updated, err := h.ctrl.UpdateProfile(context.Background(), user, &profile)
+  switch err {
+  case controller.ErrBadEmail, controller.ErrBadProfile:
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: err.Error(),
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return nil, false
+
+  case nil:
+    return updated, true
+
+  default:
+    log.Println("failed to update profile: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return nil, false
+  }
+}
+
+// UploadAvatar takes image from "file" multipart field
+func (h *Handler) UploadAvatar(w http.ResponseWriter, req *http.Request) {
+  user, ok := h.sessionUser(w, req)
+  if !ok {
+    return
+  }
+
+  if err := req.ParseMultipartForm(1); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusBadRequest)
+    return
+  }
+
+  f, fh, err := req.FormFile("file")
+  if err != nil {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "no file",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+  defer f.Close()
+
+  if fh.Size > MaxAvatarSize {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "file too large",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+
+  // type is told by content, not by name or part header,
+  // svg and html never get served from own origin
+  head := make([]byte, 512)
+  n, err := io.ReadFull(f, head)
+  if err != nil && err != io.ErrUnexpectedEOF {
+    log.Println(err)
+    w.WriteHeader(http.StatusBadRequest)
+    return
+  }
+  ext, ok := avatarTypes[http.DetectContentType(head[:n])]
+  if !ok {
+    if err := json.NewEncoder(w).Encode(model.ServerResponse{
+      Status: "ok",
+      Description: "not an image",
+    }); err != nil {
+      log.Println(err)
+      w.WriteHeader(http.StatusInternalServerError)
+    }
+    return
+  }
+
+  // image is replicated with the write, every node serves it
+  rest, err := io.ReadAll(f)
+  if err != nil {
+    log.Println("failed to read avatar: ", err)
+    w.WriteHeader(http.StatusBadRequest)
+    return
+  }
+  avatarId := strings.ToLower(utils.RandomString(10) + ext)
+
+  profile, err := h.ctrl.SetAvatar(context.Background(), user, avatarId, append(head[:n], rest...))
+  if err != nil {
+    log.Println("failed to set avatar: ", err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  writeProfile(w, profile, "avatar set")
+}
+
+func (h *Handler) Avatar(w http.ResponseWriter, req *http.Request) {
+  avatarId := req.URL.Query().Get("id")
+  data, err := h.ctrl.Avatar(context.Background(), avatarId)
+  if err == controller.ErrNotFound {
+    w.WriteHeader(http.StatusNotFound)
+    return
+  }
+  if err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+    return
+  }
+
+  contentType := mime.TypeByExtension(filepath.Ext(avatarId))
+  if _, ok := avatarTypes[contentType]; !ok {
+    contentType = "application/octet-stream"
+  }
+  w.Header().Set("Content-Type", contentType)
+  w.Header().Set("X-Content-Type-Options", "nosniff")
+
+  if _, err := w.Write(data); err != nil {
+    log.Println(err)
+  }
+}
+
+
+func writeProfile(w http.ResponseWriter, profile *model.Profile, description string) {
+  if err := json.NewEncoder(w).Encode(model.ServerProfileResponse{
+    ServerResponse: model.ServerResponse{
+      Status: "ok",
+      Description: description,
+    },
+    Profile: *profile,
+  }); err != nil {
+    log.Println(err)
+    w.WriteHeader(http.StatusInternalServerError)
+  }
+}
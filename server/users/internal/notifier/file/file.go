@@ -0,0 +1,47 @@
+package file
+
+import (
+  "os"
+  "fmt"
+  "log"
+  "sync"
+  "time"
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * Development notifier. Appends reset tokens to a file,
+ * or writes them to the log when no file is given
+ */
+type Notifier struct {
+  mu   sync.Mutex
+  path string
+}
+
+func New(path string) *Notifier {
+  return &Notifier{path: path}
+}
+
+func (n *Notifier) NotifyPasswordReset(_ context.Context, user *model.User, token string) error {
+  line := fmt.Sprintf("%s password reset user=%s email=%s token=%s\n",
+    time.Now().Format(time.RFC3339), user.Name, user.Email, token)
+
+  if n.path == "" {
+    log.Print(line)
+    return nil
+  }
+
+  n.mu.Lock()
+  defer n.mu.Unlock()
+
+  f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+
+  _, err = f.WriteString(line)
+  return err
+}
@@ -0,0 +1,77 @@
+package smtp
+
+import (
+  "fmt"
+  "net"
+  "bytes"
+  "errors"
+  "context"
+  "strings"
+  "net/url"
+  "net/mail"
+  netsmtp "net/smtp"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+var ErrBadAddress = errors.New("bad email address")
+
+type Config struct {
+  Addr     string
+  From     string
+  Username string
+  Password string
+  // page that reads token from query and asks for new password
+  ResetURL string
+}
+
+type Notifier struct {
+  cfg Config
+}
+
+func New(cfg Config) *Notifier {
+  return &Notifier{cfg}
+}
+
+func (n *Notifier) NotifyPasswordReset(_ context.Context, user *model.User, token string) error {
+  to, err := mail.ParseAddress(user.Email)
+  if err != nil {
+    return ErrBadAddress
+  }
+  from, err := mail.ParseAddress(n.cfg.From)
+  if err != nil {
+    return ErrBadAddress
+  }
+
+  var auth netsmtp.Auth
+  if n.cfg.Username != "" {
+    host, _, err := net.SplitHostPort(n.cfg.Addr)
+    if err != nil {
+      return err
+    }
+    auth = netsmtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+  }
+
+  return netsmtp.SendMail(
+    n.cfg.Addr,
+    auth,
+    from.Address,
+    []string{to.Address},
+    n.resetMessage(from, to, user.Name, token),
+  )
+}
+
+func (n *Notifier) resetMessage(from, to *mail.Address, name, token string) []byte {
+  link := n.cfg.ResetURL + "?token=" + url.QueryEscape(token)
+
+  var b bytes.Buffer
+  fmt.Fprintf(&b, "From: %s\r\n", from.String())
+  fmt.Fprintf(&b, "To: %s\r\n", to.String())
+  fmt.Fprintf(&b, "Subject: Password reset\r\n")
+  fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+  fmt.Fprintf(&b, "\r\n")
+  fmt.Fprintf(&b, "Hello, %s\r\n\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(name))
+  fmt.Fprintf(&b, "Follow the link to set a new password:\r\n%s\r\n\r\n", link)
+  fmt.Fprintf(&b, "If you did not ask for it, ignore this letter.\r\n")
+  return b.Bytes()
+}
@@ -0,0 +1,104 @@
+package smtp_test
+
+import (
+  "net"
+  "strings"
+  "context"
+  "testing"
+  "net/textproto"
+
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/notifier/smtp"
+)
+
+func TestNotifyPasswordReset(t *testing.T) {
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  require.NoError(t, err)
+  defer l.Close()
+
+  letters := make(chan letter, 1)
+  go serveSMTP(l, letters)
+
+  n := smtp.New(smtp.Config{
+    Addr: l.Addr().String(),
+    From: "gallery@galleryexample.com",
+    ResetURL: "http://galleryexample.com/reset",
+  })
+
+  err = n.NotifyPasswordReset(context.Background(), &model.User{
+    Name: "alice",
+    Email: "alice@example.com",
+  }, "abc123")
+  require.NoError(t, err)
+
+  got := <-letters
+  require.Equal(t, "<gallery@galleryexample.com>", got.from)
+  require.Equal(t, []string{"<alice@example.com>"}, got.to)
+  require.Contains(t, got.data, "Subject: Password reset")
+  require.Contains(t, got.data, "http://galleryexample.com/reset?token=abc123")
+}
+
+func TestNotifyBadAddress(t *testing.T) {
+  n := smtp.New(smtp.Config{Addr: "127.0.0.1:1", From: "gallery@galleryexample.com"})
+  err := n.NotifyPasswordReset(context.Background(), &model.User{
+    Name: "alice",
+    Email: "alice@example.com\r\nBcc: eve@example.com",
+  }, "abc123")
+  require.ErrorIs(t, err, smtp.ErrBadAddress)
+}
+
+type letter struct {
+  from string
+  to   []string
+  data string
+}
+
+// serveSMTP is a stand-in server, accepts one letter
+// without auth or tls
+func serveSMTP(l net.Listener, letters chan<- letter) {
+  conn, err := l.Accept()
+  if err != nil {
+    return
+  }
+  defer conn.Close()
+
+  c := textproto.NewConn(conn)
+  var got letter
+
+  c.PrintfLine("220 localhost ESMTP")
+  for {
+    line, err := c.ReadLine()
+    if err != nil {
+      return
+    }
+
+    cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+    switch cmd {
+    case "EHLO", "HELO":
+      c.PrintfLine("250 localhost")
+    case "MAIL":
+      got.from = strings.TrimPrefix(line, "MAIL FROM:")
+      got.from = strings.SplitN(got.from, " ", 2)[0]
+      c.PrintfLine("250 OK")
+    case "RCPT":
+      got.to = append(got.to, strings.TrimPrefix(line, "RCPT TO:"))
+      c.PrintfLine("250 OK")
+    case "DATA":
+      c.PrintfLine("354 go ahead")
+      b, err := c.ReadDotBytes()
+      if err != nil {
+        return
+      }
+      got.data = string(b)
+      c.PrintfLine("250 OK")
+    case "QUIT":
+      c.PrintfLine("221 bye")
+      letters <- got
+      return
+    default:
+      c.PrintfLine("502 not implemented")
+    }
+  }
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+  "github.com/hashicorp/raft"
+  "google.golang.org/grpc/credentials"
+
+  "github.com/bd878/gallery/server/internal/streamlayer"
+)
+
+type Config struct {
+  Raft         raft.Config
+  StreamLayer *streamlayer.StreamLayer
+  Bootstrap    bool
+  DataDir      string
+  Servers      []string
+  // dial leader when forwarding writes, plain when nil
+  Credentials  credentials.TransportCredentials
+  // leader takes forwarded writes with it only
+  ServiceToken string
+}
@@ -0,0 +1,222 @@
+package repository
+
+import (
+  "io"
+  "os"
+  "log"
+  "fmt"
+  "time"
+  "errors"
+  "context"
+  "encoding/json"
+  "path/filepath"
+  "database/sql"
+
+  "github.com/hashicorp/raft"
+  "github.com/mattn/go-sqlite3"
+
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+  "github.com/bd878/gallery/server/utils"
+)
+
+var _ raft.FSM = (*fsm)(nil)
+
+/**
+ * Applies replicated writes to local sqlite database.
+ * Database outlives raft log replay on restart, so last
+ * applied index is stored along and older records are skipped
+ */
+type fsm struct {
+  repo *sqlite.Repository
+  dataDir string
+  appliedIndex uint64
+}
+
+/**
+ * Returns either an error, or *response
+ * of request applied to repo
+ */
+func (f *fsm) Apply(record *raft.Log) interface{} {
+  if record.Index <= f.appliedIndex {
+    return nil
+  }
+
+  buf := record.Data
+  if len(buf) == 0 {
+    return errors.New("empty log record")
+  }
+
+  var req request
+  if err := json.Unmarshal(buf[1:], &req); err != nil {
+    return err
+  }
+
+  // write and its index are committed together
+  ctx := context.Background()
+  tx, err := f.repo.Begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  res, applyErr := f.apply(ctx, RequestType(buf[0]), tx.At(req.Time), &req)
+  if applyErr != nil && dbFailure(applyErr) {
+    // index stays, record is applied again on restart
+    log.Printf("record %d not applied, database error: %v\n", record.Index, applyErr)
+    return applyErr
+  }
+
+  if err := tx.SetAppliedIndex(ctx, record.Index); err != nil {
+    return err
+  }
+  if err := tx.Commit(); err != nil {
+    log.Printf("record %d not applied, commit error: %v\n", record.Index, err)
+    return err
+  }
+  f.appliedIndex = record.Index
+
+  if applyErr != nil {
+    return applyErr
+  }
+  return res
+}
+
+/**
+ * dbFailure tells errors of database itself from rejected
+ * writes, those fail same way on every node and replay
+ */
+func dbFailure(err error) bool {
+  var sqliteErr sqlite3.Error
+  if errors.As(err, &sqliteErr) {
+    return sqliteErr.Code != sqlite3.ErrConstraint
+  }
+  return errors.Is(err, sql.ErrConnDone) || errors.Is(err, sql.ErrTxDone)
+}
+
+func (f *fsm) apply(ctx context.Context, reqType RequestType, repo *sqlite.Repository, req *request) (
+  *response, error,
+) {
+  res := &response{}
+
+  var err error
+  switch reqType {
+  case AddRequestType:
+    err = repo.Add(ctx, req.User)
+  case RefreshRequestType:
+    err = repo.Refresh(ctx, req.User)
+  case AddFailureRequestType:
+    res.Attempts, err = repo.AddFailure(ctx, req.Key, req.At, req.Window)
+  case LockUntilRequestType:
+    err = repo.LockUntil(ctx, req.Key, req.At)
+  case ResetAttemptsRequestType:
+    err = repo.ResetAttempts(ctx, req.Key)
+  case DeleteRequestType:
+    err = repo.Delete(ctx, req.Deletion)
+  case UpdateDeletionRequestType:
+    err = repo.UpdateDeletion(ctx, req.Deletion)
+  case UpdatePasswordRequestType:
+    err = repo.UpdatePassword(ctx, req.User)
+  case AddPasswordResetRequestType:
+    err = repo.AddPasswordReset(ctx, req.Reset)
+  case UsePasswordResetRequestType:
+    res.UserId, err = repo.UsePasswordReset(ctx, req.Key, req.At)
+  case SetDisabledRequestType:
+    err = repo.SetDisabled(ctx, req.Id, req.Flag)
+  case SetRoleRequestType:
+    err = repo.SetRole(ctx, req.Name, req.Value)
+  case LogoutRequestType:
+    err = repo.Logout(ctx, req.Id)
+  case AddInvitedRequestType:
+    err = repo.AddInvited(ctx, req.User, req.Key, req.At)
+    res.InvitedBy = req.User.InvitedBy
+  case AddInviteRequestType:
+    err = repo.AddInvite(ctx, req.Invite, req.At, req.Quota)
+  case RevokeInviteRequestType:
+    err = repo.RevokeInvite(ctx, req.Key, req.Id)
+  case UpdateProfileRequestType:
+    err = repo.UpdateProfile(ctx, req.Id, req.Profile)
+  case SetAvatarRequestType:
+    err = repo.SetAvatar(ctx, req.Id, req.Value, req.Data)
+  case AddIdentityRequestType:
+    err = repo.AddIdentity(ctx, req.Identity)
+  case AddWithIdentityRequestType:
+    err = repo.AddWithIdentity(ctx, req.User, req.Identity, req.Key, req.At)
+    res.UserId = req.Identity.UserId
+    res.InvitedBy = req.User.InvitedBy
+  case AddAuditRequestType:
+    // leader time in the command, not the one log is replayed at
+    req.Audit.Time = req.Time.UTC().Format(time.RFC3339)
+    err = repo.AddAudit(ctx, req.Audit)
+  case PruneAuditRequestType:
+    res.Count, err = repo.PruneAudit(ctx, req.At)
+  case ForgiveFailureRequestType:
+    err = repo.ForgiveFailure(ctx, req.Key)
+  case AddFirstRequestType:
+    err = repo.AddFirst(ctx, req.User)
+  default:
+    return nil, fmt.Errorf("unknown request type: %d", reqType)
+  }
+
+  if err != nil {
+    return nil, err
+  }
+  return res, nil
+}
+
+// Database copy is taken right away, between applies
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+  path := filepath.Join(f.dataDir, "snapshot-" + utils.RandomString(10) + ".db")
+  if err := f.repo.Snapshot(context.Background(), path); err != nil {
+    return nil, err
+  }
+  return &snapshot{path: path}, nil
+}
+
+func (f *fsm) Restore(r io.ReadCloser) error {
+  defer r.Close()
+
+  path := filepath.Join(f.dataDir, "restore-" + utils.RandomString(10) + ".db")
+  file, err := os.Create(path)
+  if err != nil {
+    return err
+  }
+  defer os.Remove(path)
+
+  _, err = io.Copy(file, r)
+  if closeErr := file.Close(); err == nil {
+    err = closeErr
+  }
+  if err != nil {
+    return err
+  }
+
+  ctx := context.Background()
+  if err := f.repo.Restore(ctx, path); err != nil {
+    return err
+  }
+  f.appliedIndex, err = f.repo.AppliedIndex(ctx)
+  return err
+}
+
+type snapshot struct {
+  path string
+}
+
+func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+  file, err := os.Open(s.path)
+  if err != nil {
+    _ = sink.Cancel()
+    return err
+  }
+  defer file.Close()
+
+  if _, err := io.Copy(sink, file); err != nil {
+    _ = sink.Cancel()
+    return err
+  }
+  return sink.Close()
+}
+
+func (s *snapshot) Release() {
+  os.Remove(s.path)
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+  "time"
+  "bytes"
+  "context"
+  "testing"
+  "path/filepath"
+  "encoding/json"
+
+  "github.com/hashicorp/raft"
+  "github.com/stretchr/testify/require"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+func TestApplyIndex(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "users.db")
+  repo, err := sqlite.New(path)
+  require.NoError(t, err)
+  f := &fsm{repo: repo, dataDir: t.TempDir()}
+
+  ctx := context.Background()
+  now := time.Now()
+  var index uint64
+  apply := func(reqType RequestType, req *request) interface{} {
+    req.Time = now
+    var buf bytes.Buffer
+    buf.WriteByte(byte(reqType))
+    require.NoError(t, json.NewEncoder(&buf).Encode(req))
+    index += 1
+    return f.Apply(&raft.Log{Index: index, Data: buf.Bytes()})
+  }
+  applied := func() uint64 {
+    index, err := repo.AppliedIndex(ctx)
+    require.NoError(t, err)
+    return index
+  }
+
+  require.IsType(t, &response{}, apply(AddFirstRequestType, &request{User: &model.User{Name: "admin"}}))
+  require.IsType(t, &response{}, apply(AddIdentityRequestType, &request{
+    Identity: &model.Identity{Issuer: "issuer", Subject: "subject", UserId: 1},
+  }))
+  require.IsType(t, &response{}, apply(AddInviteRequestType, &request{
+    Invite: &model.Invite{Code: "code", CreatedBy: 1, MaxUses: 1}, At: now.Add(time.Hour),
+  }))
+
+  // rejected write is undone whole, index moves on
+  res := apply(AddWithIdentityRequestType, &request{
+    User: &model.User{Name: "guest"},
+    Identity: &model.Identity{Issuer: "issuer", Subject: "subject"},
+    Key: "code",
+    At: now,
+  })
+  require.Error(t, res.(error))
+  require.Equal(t, index, applied())
+  _, err = repo.Get(ctx, &model.User{Name: "guest"})
+  require.ErrorIs(t, err, repository.ErrNoUser)
+  invite, err := repo.GetInvite(ctx, "code")
+  require.NoError(t, err)
+  require.Equal(t, 0, invite.Uses)
+
+  // database failure keeps index, record is applied again on restart
+  db, err := sqlite.Open(path)
+  require.NoError(t, err)
+  defer db.Close()
+  _, err = db.Exec("DROP TABLE audit_log")
+  require.NoError(t, err)
+
+  res = apply(AddAuditRequestType, &request{Audit: &model.AuditEvent{Event: "login"}})
+  require.Error(t, res.(error))
+  require.Equal(t, index - 1, applied())
+}
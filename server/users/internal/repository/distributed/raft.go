@@ -0,0 +1,444 @@
+package repository
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "bytes"
+  "errors"
+  "context"
+  "encoding/json"
+  "path/filepath"
+
+  raftboltdb "github.com/hashicorp/raft-boltdb"
+  "github.com/hashicorp/raft"
+  "google.golang.org/grpc"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+)
+
+var ErrNotLeader = errors.New("not a leader")
+
+// MaxCommandSize of forwarded write, servers taking
+// UserRaft calls have room for avatar image in it
+const MaxCommandSize = 16 << 20
+
+/**
+ * Users repository replicated with raft. Writes go
+ * through the leader, followers forward them over UserRaft
+ * service. Reads are served by the embedded local database
+ */
+type Repository struct {
+  *sqlite.Repository
+  config Config
+  raft  *raft.Raft
+}
+
+func New(local *sqlite.Repository, config Config) (*Repository, error) {
+  r := &Repository{
+    Repository: local,
+    config: config,
+  }
+  if err := r.setupRaft(); err != nil {
+    return nil, err
+  }
+  return r, nil
+}
+
+func (r *Repository) setupRaft() error {
+  ctx := context.Background()
+
+  raftPath := filepath.Join(r.config.DataDir, "raft")
+  if err := os.MkdirAll(raftPath, 0755); err != nil {
+    return err
+  }
+
+  logStore, err := raftboltdb.NewBoltStore(
+    filepath.Join(raftPath, "log"),
+  )
+  if err != nil {
+    return err
+  }
+  stableStore, err := raftboltdb.NewBoltStore(
+    filepath.Join(raftPath, "stable"),
+  )
+  if err != nil {
+    return err
+  }
+  retain := 1
+  snapshotStore, err := raft.NewFileSnapshotStore(
+    filepath.Join(raftPath, "raft"),
+    retain,
+    nil,
+  )
+  if err != nil {
+    return err
+  }
+
+  hasState, err := raft.HasExistingState(
+    logStore,
+    stableStore,
+    snapshotStore,
+  )
+  if err != nil {
+    return err
+  }
+
+  // fresh log replays from the first index
+  if !hasState {
+    if err := r.Repository.SetAppliedIndex(ctx, 0); err != nil {
+      return err
+    }
+  }
+  appliedIndex, err := r.Repository.AppliedIndex(ctx)
+  if err != nil {
+    return err
+  }
+
+  fsm := &fsm{
+    repo: r.Repository,
+    dataDir: r.config.DataDir,
+    appliedIndex: appliedIndex,
+  }
+
+  maxPool := 5
+  timeout := 10*time.Second
+  transport := raft.NewNetworkTransport(
+    r.config.StreamLayer,
+    maxPool,
+    timeout,
+    os.Stderr,
+  )
+
+  config := raft.DefaultConfig()
+  config.LocalID = r.config.Raft.LocalID
+  config.LogLevel = r.config.Raft.LogLevel
+  if r.config.Raft.HeartbeatTimeout != 0 {
+    config.HeartbeatTimeout = r.config.Raft.HeartbeatTimeout
+  }
+  if r.config.Raft.ElectionTimeout != 0 {
+    config.ElectionTimeout = r.config.Raft.ElectionTimeout
+  }
+  if r.config.Raft.LeaderLeaseTimeout != 0 {
+    config.LeaderLeaseTimeout = r.config.Raft.LeaderLeaseTimeout
+  }
+  if r.config.Raft.CommitTimeout != 0 {
+    config.CommitTimeout = r.config.Raft.CommitTimeout
+  }
+
+  r.raft, err = raft.NewRaft(
+    config,
+    fsm,
+    logStore,
+    stableStore,
+    snapshotStore,
+    transport,
+  )
+  if err != nil {
+    return err
+  }
+
+  if r.config.Bootstrap && !hasState {
+    servers := []raft.Server{{
+      ID: r.config.Raft.LocalID,
+      Address: transport.LocalAddr(),
+    }}
+
+    for _, addr := range r.config.Servers {
+      servers = append(servers, raft.Server{
+        ID: raft.ServerID(addr),
+        Address: raft.ServerAddress(addr),
+      })
+    }
+
+    configuration := raft.Configuration{
+      Servers: servers,
+    }
+    err = r.raft.BootstrapCluster(configuration).Error()
+  }
+  return err
+}
+
+/**
+ * Applies write on the leader, or forwards it there,
+ * the leader stamps its time then. Returns after the
+ * write is applied locally as well, so following
+ * reads on this node see it
+ */
+func (r *Repository) apply(ctx context.Context, reqType RequestType, req *request) (*response, error) {
+  req.Time = time.Now()
+
+  var buf bytes.Buffer
+  buf.WriteByte(byte(reqType))
+  if err := json.NewEncoder(&buf).Encode(req); err != nil {
+    return nil, err
+  }
+
+  if r.IsLeader() {
+    return r.applyLocal(buf.Bytes())
+  }
+  return r.forward(ctx, buf.Bytes())
+}
+
+func (r *Repository) applyLocal(cmd []byte) (*response, error) {
+  timeout := 10*time.Second
+  future := r.raft.Apply(cmd, timeout)
+  if err := future.Error(); err != nil {
+    return nil, err
+  }
+
+  switch res := future.Response().(type) {
+  case error:
+    return nil, res
+  case *response:
+    return res, nil
+  default:
+    return nil, errors.New("fsm.apply returns undefined result")
+  }
+}
+
+func (r *Repository) forward(ctx context.Context, cmd []byte) (*response, error) {
+  addr, _ := r.raft.LeaderWithID()
+  if addr == "" {
+    return nil, ErrNotLeader
+  }
+
+  conn, err := grpcutil.ServiceConnection(ctx, string(addr), r.config.Credentials)
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+
+  out, err := api.NewUserRaftClient(conn).Apply(ctx, &api.ApplyUserCommandRequest{
+    Command: cmd,
+  }, grpc.PerRPCCredentials(grpcutil.ServiceCredentials(r.config.ServiceToken)))
+  if err != nil {
+    return nil, err
+  }
+
+  if err := r.waitApplied(ctx, out.Index); err != nil {
+    return nil, err
+  }
+  if out.Error != "" {
+    return nil, repositoryError(out.Error)
+  }
+
+  var res response
+  if err := json.Unmarshal(out.Result, &res); err != nil {
+    return nil, err
+  }
+  return &res, nil
+}
+
+func (r *Repository) waitApplied(ctx context.Context, index uint64) error {
+  ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+  defer cancel()
+
+  ticker := time.NewTicker(10*time.Millisecond)
+  defer ticker.Stop()
+  for r.raft.AppliedIndex() < index {
+    select {
+    case <-ctx.Done():
+      return fmt.Errorf("wait for index %d: %w", index, ctx.Err())
+    case <-ticker.C:
+    }
+  }
+  return nil
+}
+
+// repositoryError restores errors callers compare against
+func repositoryError(text string) error {
+  for _, err := range []error{
+    repository.ErrNoUser,
+    repository.ErrNoDeletion,
+    repository.ErrNoReset,
+    repository.ErrNoInvite,
+    repository.ErrNoIdentity,
+    repository.ErrUsersExist,
+    repository.ErrInviteQuota,
+    repository.ErrNoAvatar,
+  } {
+    if err.Error() == text {
+      return err
+    }
+  }
+  return errors.New(text)
+}
+
+/**
+ * Applies command forwarded by a follower. Write error
+ * is returned as text along with the log index,
+ * error is only returned when the write is not applied
+ */
+func (r *Repository) ApplyCommand(_ context.Context, cmd []byte) (
+  result []byte, index uint64, applyErr string, err error,
+) {
+  if !r.IsLeader() {
+    return nil, 0, "", ErrNotLeader
+  }
+  cmd, err = stamp(cmd)
+  if err != nil {
+    return nil, 0, "", err
+  }
+
+  timeout := 10*time.Second
+  future := r.raft.Apply(cmd, timeout)
+  if err := future.Error(); err != nil {
+    return nil, 0, "", err
+  }
+
+  switch res := future.Response().(type) {
+  case error:
+    return nil, future.Index(), res.Error(), nil
+  case *response:
+    result, err = json.Marshal(res)
+    return result, future.Index(), "", err
+  default:
+    return nil, 0, "", errors.New("fsm.apply returns undefined result")
+  }
+}
+
+// stamp sets forwarded request time to leader clock
+func stamp(cmd []byte) ([]byte, error) {
+  if len(cmd) == 0 {
+    return nil, errors.New("empty command")
+  }
+  var req request
+  if err := json.Unmarshal(cmd[1:], &req); err != nil {
+    return nil, err
+  }
+  req.Time = time.Now()
+
+  var buf bytes.Buffer
+  buf.WriteByte(cmd[0])
+  if err := json.NewEncoder(&buf).Encode(&req); err != nil {
+    return nil, err
+  }
+  return buf.Bytes(), nil
+}
+
+func (r *Repository) IsLeader() bool {
+  return r.raft.State() == raft.Leader
+}
+
+func (r *Repository) WaitForLeader(timeout time.Duration) error {
+  timeoutc := time.After(timeout)
+  ticker := time.NewTicker(100*time.Millisecond)
+  defer ticker.Stop()
+  for {
+    select {
+    case <- timeoutc:
+      return fmt.Errorf("no leader, timeout")
+    case <-ticker.C:
+      if lead, _ := r.raft.LeaderWithID(); lead != "" {
+        return nil
+      }
+    }
+  }
+}
+
+// GetServers lists raft servers, their raft address serves gRPC as well
+func (r *Repository) GetServers(_ context.Context) ([]*api.UserServer, error) {
+  future := r.raft.GetConfiguration()
+  if err := future.Error(); err != nil {
+    return nil, err
+  }
+  var servers []*api.UserServer
+  _, id := r.raft.LeaderWithID()
+  for _, server := range future.Configuration().Servers {
+    servers = append(servers, &api.UserServer{
+      Id: string(server.ID),
+      RpcAddr: string(server.Address),
+      IsLeader: id == server.ID,
+    })
+  }
+  return servers, nil
+}
+
+func (r *Repository) Join(id, addr string) error {
+  leaderFuture := r.raft.VerifyLeader()
+  if err := leaderFuture.Error(); err != nil {
+    return errors.New("cannot join node to cluster: not a leader")
+  }
+
+  configFuture := r.raft.GetConfiguration()
+  if err := configFuture.Error(); err != nil {
+    return err
+  }
+
+  serverID := raft.ServerID(id)
+  serverAddr := raft.ServerAddress(addr)
+
+  for _, srv := range configFuture.Configuration().Servers {
+    if srv.ID == serverID || srv.Address == serverAddr {
+      if srv.ID == serverID && srv.Address == serverAddr {
+        return nil
+      }
+
+      removeFuture := r.raft.RemoveServer(serverID, 0, 0)
+      if err := removeFuture.Error(); err != nil {
+        return err
+      }
+    }
+  }
+
+  addFuture := r.raft.AddVoter(serverID, serverAddr, 0, 0)
+  return addFuture.Error()
+}
+
+func (r *Repository) Leave(id string) error {
+  leaderFuture := r.raft.VerifyLeader()
+  if err := leaderFuture.Error(); err != nil {
+    return errors.New("cannot remove node from cluster: not a leader")
+  }
+
+  removeFuture := r.raft.RemoveServer(raft.ServerID(id), 0, 0)
+  return removeFuture.Error()
+}
+
+func (r *Repository) Shutdown() error {
+  return r.raft.Shutdown().Error()
+}
+
+func (r *Repository) PrintLeader() error {
+  addr, id := r.raft.LeaderWithID()
+  fmt.Println("=== LEADER ===")
+  if r.config.Raft.LocalID == id {
+    fmt.Println("i am the leader")
+  }
+  fmt.Printf("Addr: %v\n", addr)
+  fmt.Printf("Id: %v\n", id)
+  fmt.Println()
+  return nil
+}
+
+func (r *Repository) PrintMyAddr() error {
+  fmt.Println("=== ME ===")
+  if r.IsLeader() {
+    fmt.Println("i am the leader")
+  }
+  fmt.Printf("Address: %v\n", r.config.StreamLayer.Addr())
+  fmt.Printf("ID: %v\n", r.config.Raft.LocalID)
+  fmt.Println()
+  return nil
+}
+
+func (r *Repository) PrintConfig() error {
+  future := r.raft.GetConfiguration()
+  if err := future.Error(); err != nil {
+    return err
+  }
+
+  fmt.Println("=== SERVERS ===")
+  for i, serv := range future.Configuration().Servers {
+    fmt.Printf("# %d:\n", i)
+    fmt.Printf("Suffrage: %d\n", serv.Suffrage)
+    fmt.Printf("Id: %s\n", serv.ID)
+    fmt.Printf("Address: %s\n", serv.Address)
+    fmt.Println()
+  }
+  return nil
+}
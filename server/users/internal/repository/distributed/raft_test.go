@@ -0,0 +1,230 @@
+package repository_test
+
+import (
+  "bytes"
+  "crypto/tls"
+  "fmt"
+  "net"
+  "time"
+  "context"
+  "testing"
+  "path/filepath"
+
+  _ "github.com/mattn/go-sqlite3"
+  "github.com/hashicorp/raft"
+  "github.com/soheilhy/cmux"
+  "github.com/stretchr/testify/require"
+  "google.golang.org/grpc"
+
+  "github.com/bd878/gallery/server/api"
+  "github.com/bd878/gallery/server/internal/grpcutil"
+  "github.com/bd878/gallery/server/internal/tlsconfig"
+  "github.com/bd878/gallery/server/internal/tlsconfig/tlstest"
+  "github.com/bd878/gallery/server/internal/streamlayer"
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+  grpchandler "github.com/bd878/gallery/server/users/internal/handler/grpc"
+  sqlite "github.com/bd878/gallery/server/users/internal/repository/sqlite"
+  distributed "github.com/bd878/gallery/server/users/internal/repository/distributed"
+)
+
+// setupDB returns fresh database path, sqlite.New migrates it
+func setupDB(t *testing.T) string {
+  return filepath.Join(t.TempDir(), "users.db")
+}
+
+// node serves raft and UserRaft on one listener, as main does,
+// over mutual tls when tlsCfg is set
+func setupNode(t *testing.T, id int, bootstrap bool, tlsCfg *tlsconfig.Config) (*distributed.Repository, string) {
+  local, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  require.NoError(t, err)
+  mux := cmux.New(ln)
+
+  var serverTLS, peerTLS *tls.Config
+  if tlsCfg != nil {
+    serverTLS, err = tlsCfg.Server()
+    require.NoError(t, err)
+    peerTLS, err = tlsCfg.Client()
+    require.NoError(t, err)
+  }
+
+  config := distributed.Config{}
+  config.StreamLayer = streamlayer.New(mux.Match(streamlayer.Match), serverTLS, peerTLS)
+  config.Credentials = grpcutil.Credentials(peerTLS)
+  config.ServiceToken = "nodes"
+  config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", id))
+  config.DataDir = t.TempDir()
+  config.Bootstrap = bootstrap
+  config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+  config.Raft.ElectionTimeout = 50 * time.Millisecond
+  config.Raft.LeaderLeaseTimeout = 20 * time.Millisecond
+  config.Raft.CommitTimeout = 5 * time.Millisecond
+
+  repo, err := distributed.New(local, config)
+  require.NoError(t, err)
+
+  srv := grpc.NewServer(grpc.Creds(grpcutil.Credentials(serverTLS)), grpc.MaxRecvMsgSize(distributed.MaxCommandSize))
+  api.RegisterUserRaftServer(srv, grpchandler.NewRaft(repo, "nodes"))
+  go srv.Serve(mux.Match(cmux.Any()))
+  go mux.Serve()
+
+  t.Cleanup(func() {
+    repo.Shutdown()
+    srv.Stop()
+    ln.Close()
+  })
+  return repo, ln.Addr().String()
+}
+
+func TestDistributed(t *testing.T) {
+  ctx := context.Background()
+  nodeCount := 3
+
+  var nodes []*distributed.Repository
+  for i := 0; i < nodeCount; i++ {
+    repo, addr := setupNode(t, i, i == 0, nil)
+    if i == 0 {
+      require.NoError(t, repo.WaitForLeader(3 * time.Second))
+    } else {
+      require.NoError(t, nodes[0].Join(fmt.Sprintf("%d", i), addr))
+    }
+    nodes = append(nodes, repo)
+  }
+  require.True(t, nodes[0].IsLeader())
+  follower := nodes[1]
+
+  // forwarded write is readable on the follower right away
+  require.NoError(t, follower.Add(ctx, &model.User{Name: "alice", Password: "secret"}))
+  user, err := follower.Get(ctx, &model.User{Name: "alice"})
+  require.NoError(t, err)
+
+  for _, node := range nodes {
+    require.Eventually(t, func() bool {
+      got, err := node.Get(ctx, &model.User{Name: "alice"})
+      return err == nil && got.Id == user.Id
+    }, time.Second, 10*time.Millisecond)
+  }
+
+  // results and errors come back from the leader
+  now := time.Now()
+  attempts, err := follower.AddFailure(ctx, "alice", now, time.Hour)
+  require.NoError(t, err)
+  require.Equal(t, 1, attempts.Failures)
+
+  err = follower.AddInvited(ctx, &model.User{Name: "bob"}, "nosuchcode", now)
+  require.Equal(t, repository.ErrNoInvite, err)
+
+  require.NoError(t, follower.AddInvite(ctx, &model.Invite{
+    Code: "code", CreatedBy: user.Id, MaxUses: 1,
+  }, now.Add(time.Hour), 0))
+  bob := &model.User{Name: "bob"}
+  require.NoError(t, follower.AddInvited(ctx, bob, "code", now))
+  require.Equal(t, user.Id, bob.InvitedBy)
+
+  // avatar image is replicated, replaced one is gone on every node
+  first := make([]byte, 256 << 10)
+  first[0] = 1
+  require.NoError(t, nodes[2].SetAvatar(ctx, user.Id, "first.png", first))
+  for _, node := range nodes {
+    require.Eventually(t, func() bool {
+      data, err := node.GetAvatar(ctx, "first.png")
+      return err == nil && bytes.Equal(first, data)
+    }, time.Second, 10*time.Millisecond)
+  }
+  require.NoError(t, nodes[0].SetAvatar(ctx, user.Id, "second.png", []byte("second")))
+  for _, node := range nodes {
+    require.Eventually(t, func() bool {
+      data, err := node.GetAvatar(ctx, "second.png")
+      return err == nil && string(data) == "second"
+    }, time.Second, 10*time.Millisecond)
+    _, err := node.GetAvatar(ctx, "first.png")
+    require.Equal(t, repository.ErrNoAvatar, err)
+  }
+
+  // audit time comes with the command, replicas agree on it
+  require.NoError(t, follower.AddAudit(ctx, &model.AuditEvent{Event: model.AuditLogin, UserId: user.Id}))
+  events, err := follower.ListAudit(ctx, &model.AuditFilter{UserId: user.Id, Limit: 1})
+  require.NoError(t, err)
+  require.Len(t, events, 1)
+  for _, node := range nodes {
+    require.Eventually(t, func() bool {
+      got, err := node.ListAudit(ctx, &model.AuditFilter{UserId: user.Id, Limit: 1})
+      return err == nil && len(got) == 1 && got[0].Time == events[0].Time
+    }, time.Second, 10*time.Millisecond)
+  }
+
+  servers, err := follower.GetServers(ctx)
+  require.NoError(t, err)
+  require.Equal(t, nodeCount, len(servers))
+  require.True(t, servers[0].IsLeader)
+
+  require.NoError(t, nodes[0].Leave("2"))
+  servers, err = nodes[0].GetServers(ctx)
+  require.NoError(t, err)
+  require.Equal(t, nodeCount-1, len(servers))
+}
+
+func TestDistributedTLS(t *testing.T) {
+  ctx := context.Background()
+  dir := t.TempDir()
+  ca, err := tlstest.NewCA(dir)
+  require.NoError(t, err)
+
+  var nodes []*distributed.Repository
+  for i := 0; i < 2; i++ {
+    name := fmt.Sprintf("users-%d", i)
+    certFile, keyFile, err := ca.Issue(name, "users")
+    require.NoError(t, err)
+
+    repo, addr := setupNode(t, i, i == 0, &tlsconfig.Config{
+      CAFile: ca.File,
+      CertFile: certFile,
+      KeyFile: keyFile,
+      ServerName: "users",
+      AllowedPeers: []string{"users-0", "users-1"},
+    })
+    if i == 0 {
+      require.NoError(t, repo.WaitForLeader(3 * time.Second))
+    } else {
+      require.NoError(t, nodes[0].Join(fmt.Sprintf("%d", i), addr))
+    }
+    nodes = append(nodes, repo)
+  }
+
+  // raft replicates and follower forwards over tls
+  require.NoError(t, nodes[1].WaitForLeader(3 * time.Second))
+  require.NoError(t, nodes[1].Add(ctx, &model.User{Name: "alice", Password: "secret"}))
+  require.Eventually(t, func() bool {
+    _, err := nodes[0].Get(ctx, &model.User{Name: "alice"})
+    return err == nil
+  }, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+  ctx := context.Background()
+
+  src, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+  require.NoError(t, src.Add(ctx, &model.User{Name: "alice", Password: "secret"}))
+  require.NoError(t, src.SetAppliedIndex(ctx, 7))
+
+  path := filepath.Join(t.TempDir(), "snapshot.db")
+  require.NoError(t, src.Snapshot(ctx, path))
+
+  dst, err := sqlite.New(setupDB(t))
+  require.NoError(t, err)
+  require.NoError(t, dst.Add(ctx, &model.User{Name: "stale", Password: "secret"}))
+  require.NoError(t, dst.Restore(ctx, path))
+
+  _, err = dst.Get(ctx, &model.User{Name: "alice"})
+  require.NoError(t, err)
+  _, err = dst.Get(ctx, &model.User{Name: "stale"})
+  require.Error(t, err)
+
+  index, err := dst.AppliedIndex(ctx)
+  require.NoError(t, err)
+  require.Equal(t, uint64(7), index)
+}
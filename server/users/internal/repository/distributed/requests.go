@@ -0,0 +1,73 @@
+package repository
+
+import (
+  "time"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * Leading byte of raft log data, tells fsm
+ * which repository write to apply
+ */
+type RequestType uint8
+
+const (
+  AddRequestType RequestType = iota
+  RefreshRequestType
+  AddFailureRequestType
+  LockUntilRequestType
+  ResetAttemptsRequestType
+  DeleteRequestType
+  UpdateDeletionRequestType
+  UpdatePasswordRequestType
+  AddPasswordResetRequestType
+  UsePasswordResetRequestType
+  SetDisabledRequestType
+  SetRoleRequestType
+  LogoutRequestType
+  AddInvitedRequestType
+  AddInviteRequestType
+  RevokeInviteRequestType
+  UpdateProfileRequestType
+  SetAvatarRequestType
+  AddIdentityRequestType
+  AddWithIdentityRequestType
+  AddAuditRequestType
+  PruneAuditRequestType
+  ForgiveFailureRequestType
+  AddFirstRequestType
+)
+
+/**
+ * Arguments of any write, each request type
+ * sets its own. Time is taken on the leader,
+ * so every node stores the same
+ */
+type request struct {
+  Time time.Time              `json:"time"`
+  User *model.User            `json:"user,omitempty"`
+  Deletion *model.Deletion    `json:"deletion,omitempty"`
+  Reset *model.PasswordReset  `json:"reset,omitempty"`
+  Invite *model.Invite        `json:"invite,omitempty"`
+  Profile *model.Profile      `json:"profile,omitempty"`
+  Identity *model.Identity    `json:"identity,omitempty"`
+  Audit *model.AuditEvent     `json:"audit,omitempty"`
+  Id model.UserId             `json:"id,omitempty"`
+  Key string                  `json:"key,omitempty"`
+  Name string                 `json:"name,omitempty"`
+  Value string                `json:"value,omitempty"`
+  Flag bool                   `json:"flag,omitempty"`
+  At time.Time                `json:"at,omitempty"`
+  Window time.Duration        `json:"window,omitempty"`
+  Quota int                   `json:"quota,omitempty"`
+  Data []byte                 `json:"data,omitempty"`
+}
+
+// Results of writes that return anything
+type response struct {
+  Attempts *model.LoginAttempts `json:"attempts,omitempty"`
+  UserId model.UserId           `json:"userid,omitempty"`
+  InvitedBy model.UserId        `json:"invitedby,omitempty"`
+  Count int64                   `json:"count,omitempty"`
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+  "time"
+  "context"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * Replicated writes of users repository.
+ * Every write method of sqlite repository is
+ * overridden here, so none bypasses the log
+ */
+
+func (r *Repository) Add(ctx context.Context, user *model.User) error {
+  _, err := r.apply(ctx, AddRequestType, &request{User: user})
+  return err
+}
+
+func (r *Repository) AddFirst(ctx context.Context, user *model.User) error {
+  _, err := r.apply(ctx, AddFirstRequestType, &request{User: user})
+  return err
+}
+
+func (r *Repository) Refresh(ctx context.Context, user *model.User) error {
+  _, err := r.apply(ctx, RefreshRequestType, &request{User: user})
+  return err
+}
+
+func (r *Repository) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (
+  *model.LoginAttempts, error,
+) {
+  res, err := r.apply(ctx, AddFailureRequestType, &request{Key: key, At: now, Window: window})
+  if err != nil {
+    return nil, err
+  }
+  return res.Attempts, nil
+}
+
+func (r *Repository) LockUntil(ctx context.Context, key string, until time.Time) error {
+  _, err := r.apply(ctx, LockUntilRequestType, &request{Key: key, At: until})
+  return err
+}
+
+func (r *Repository) ForgiveFailure(ctx context.Context, key string) error {
+  _, err := r.apply(ctx, ForgiveFailureRequestType, &request{Key: key})
+  return err
+}
+
+func (r *Repository) ResetAttempts(ctx context.Context, key string) error {
+  _, err := r.apply(ctx, ResetAttemptsRequestType, &request{Key: key})
+  return err
+}
+
+func (r *Repository) Delete(ctx context.Context, deletion *model.Deletion) error {
+  _, err := r.apply(ctx, DeleteRequestType, &request{Deletion: deletion})
+  return err
+}
+
+func (r *Repository) UpdateDeletion(ctx context.Context, deletion *model.Deletion) error {
+  _, err := r.apply(ctx, UpdateDeletionRequestType, &request{Deletion: deletion})
+  return err
+}
+
+func (r *Repository) UpdatePassword(ctx context.Context, user *model.User) error {
+  _, err := r.apply(ctx, UpdatePasswordRequestType, &request{User: user})
+  return err
+}
+
+func (r *Repository) AddPasswordReset(ctx context.Context, reset *model.PasswordReset) error {
+  _, err := r.apply(ctx, AddPasswordResetRequestType, &request{Reset: reset})
+  return err
+}
+
+func (r *Repository) UsePasswordReset(ctx context.Context, tokenHash string, now time.Time) (model.UserId, error) {
+  res, err := r.apply(ctx, UsePasswordResetRequestType, &request{Key: tokenHash, At: now})
+  if err != nil {
+    return 0, err
+  }
+  return res.UserId, nil
+}
+
+func (r *Repository) SetDisabled(ctx context.Context, id model.UserId, disabled bool) error {
+  _, err := r.apply(ctx, SetDisabledRequestType, &request{Id: id, Flag: disabled})
+  return err
+}
+
+func (r *Repository) SetRole(ctx context.Context, name, role string) error {
+  _, err := r.apply(ctx, SetRoleRequestType, &request{Name: name, Value: role})
+  return err
+}
+
+func (r *Repository) Logout(ctx context.Context, id model.UserId) error {
+  _, err := r.apply(ctx, LogoutRequestType, &request{Id: id})
+  return err
+}
+
+func (r *Repository) AddInvited(ctx context.Context, user *model.User, code string, now time.Time) error {
+  res, err := r.apply(ctx, AddInvitedRequestType, &request{User: user, Key: code, At: now})
+  if err != nil {
+    return err
+  }
+  user.InvitedBy = res.InvitedBy
+  return nil
+}
+
+func (r *Repository) AddInvite(ctx context.Context, invite *model.Invite, expiresAt time.Time, quota int) error {
+  _, err := r.apply(ctx, AddInviteRequestType, &request{Invite: invite, At: expiresAt, Quota: quota})
+  return err
+}
+
+func (r *Repository) RevokeInvite(ctx context.Context, code string, createdBy model.UserId) error {
+  _, err := r.apply(ctx, RevokeInviteRequestType, &request{Key: code, Id: createdBy})
+  return err
+}
+
+func (r *Repository) UpdateProfile(ctx context.Context, id model.UserId, profile *model.Profile) error {
+  _, err := r.apply(ctx, UpdateProfileRequestType, &request{Id: id, Profile: profile})
+  return err
+}
+
+// SetAvatar replicates image itself, every node serves it
+func (r *Repository) SetAvatar(ctx context.Context, id model.UserId, avatarId string, data []byte) error {
+  _, err := r.apply(ctx, SetAvatarRequestType, &request{Id: id, Value: avatarId, Data: data})
+  return err
+}
+
+func (r *Repository) AddIdentity(ctx context.Context, identity *model.Identity) error {
+  _, err := r.apply(ctx, AddIdentityRequestType, &request{Identity: identity})
+  return err
+}
+
+func (r *Repository) AddWithIdentity(ctx context.Context, user *model.User, identity *model.Identity,
+  code string, now time.Time,
+) error {
+  res, err := r.apply(ctx, AddWithIdentityRequestType, &request{User: user, Identity: identity,
+    Key: code, At: now})
+  if err != nil {
+    return err
+  }
+  identity.UserId = res.UserId
+  user.InvitedBy = res.InvitedBy
+  return nil
+}
+
+func (r *Repository) AddAudit(ctx context.Context, event *model.AuditEvent) error {
+  _, err := r.apply(ctx, AddAuditRequestType, &request{Audit: event})
+  return err
+}
+
+func (r *Repository) PruneAudit(ctx context.Context, before time.Time) (int64, error) {
+  res, err := r.apply(ctx, PruneAuditRequestType, &request{At: before})
+  if err != nil {
+    return 0, err
+  }
+  return res.Count, nil
+}
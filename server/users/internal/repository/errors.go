@@ -3,3 +3,10 @@ package repository
 import "errors"
 
 var ErrNoUser = errors.New("no user")
+var ErrNoDeletion = errors.New("no deletion")
+var ErrNoReset = errors.New("no password reset")
+var ErrNoInvite = errors.New("no invite")
+var ErrNoIdentity = errors.New("no identity")
+var ErrUsersExist = errors.New("users exist")
+var ErrInviteQuota = errors.New("invite quota exceeded")
+var ErrNoAvatar = errors.New("no avatar")
@@ -0,0 +1,115 @@
+package repository
+
+import (
+  "log"
+  "time"
+  "context"
+  "strings"
+  "encoding/json"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+)
+
+/**
+ * AddAudit appends event at event time, RFC3339, or
+ * at the repository clock when it is not set
+ */
+func (r *Repository) AddAudit(ctx context.Context, event *model.AuditEvent) error {
+  at := r.now()
+  if event.Time != "" {
+    var err error
+    if at, err = time.Parse(time.RFC3339, event.Time); err != nil {
+      return err
+    }
+  }
+
+  var details []byte
+  if len(event.Details) != 0 {
+    var err error
+    if details, err = json.Marshal(event.Details); err != nil {
+      return err
+    }
+  }
+
+  _, err := r.q.ExecContext(ctx, "INSERT INTO audit_log(time, event, user_id, name, actor_id, ip, success, details) " +
+    "VALUES (?,?,?,?,?,?,?,?)", at.Unix(), event.Event, int(event.UserId), event.Name,
+    int(event.ActorId), event.IP, event.Success, string(details))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
+
+// ListAudit returns newest events first
+func (r *Repository) ListAudit(ctx context.Context, filter *model.AuditFilter) ([]*model.AuditEvent, error) {
+  query := "SELECT id, time, event, user_id, name, actor_id, ip, success, details FROM audit_log WHERE 1 = 1"
+  var args []any
+
+  if filter.UserId != 0 {
+    query += " AND user_id = ?"
+    args = append(args, int(filter.UserId))
+  }
+  if len(filter.Events) != 0 {
+    query += " AND event IN (?" + strings.Repeat(",?", len(filter.Events)-1) + ")"
+    for _, event := range filter.Events {
+      args = append(args, event)
+    }
+  }
+  if !filter.Since.IsZero() {
+    query += " AND time >= ?"
+    args = append(args, filter.Since.Unix())
+  }
+  if !filter.Until.IsZero() {
+    query += " AND time < ?"
+    args = append(args, filter.Until.Unix())
+  }
+  query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+  args = append(args, filter.Limit, filter.Offset)
+
+  rows, err := r.q.QueryContext(ctx, query, args...)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.AuditEvent
+  for rows.Next() {
+    var id, at int64
+    var userId, actorId int
+    var event, name, ip, details string
+    var success bool
+    if err := rows.Scan(&id, &at, &event, &userId, &name, &actorId, &ip, &success, &details); err != nil {
+      return nil, err
+    }
+
+    timeRaw, _ := time.Unix(at, 0).UTC().MarshalText()
+    auditEvent := &model.AuditEvent{
+      Id: id,
+      Time: string(timeRaw),
+      Event: event,
+      UserId: model.UserId(userId),
+      Name: name,
+      ActorId: model.UserId(actorId),
+      IP: ip,
+      Success: success,
+    }
+    if details != "" {
+      if err := json.Unmarshal([]byte(details), &auditEvent.Details); err != nil {
+        return nil, err
+      }
+    }
+    res = append(res, auditEvent)
+  }
+  return res, rows.Err()
+}
+
+// PruneAudit removes events older than before, returns how many
+func (r *Repository) PruneAudit(ctx context.Context, before time.Time) (int64, error) {
+  res, err := r.q.ExecContext(ctx, "DELETE FROM audit_log WHERE time < ?", before.Unix())
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return 0, err
+  }
+  return res.RowsAffected()
+}
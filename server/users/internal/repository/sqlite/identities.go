@@ -0,0 +1,118 @@
+package repository
+
+import (
+  "log"
+  "time"
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+)
+
+// GetIdentity finds identity linked to a user not deleted
+func (r *Repository) GetIdentity(ctx context.Context, issuer, subject string) (*model.Identity, error) {
+  var userId int
+  var email string
+  var createdAt int64
+
+  err := r.q.QueryRowContext(ctx, "SELECT i.user_id, i.email, i.created_at FROM identities i " +
+    "JOIN users u ON u.id = i.user_id AND u.deleted = 0 WHERE i.issuer = ? AND i.subject = ?",
+    issuer, subject,
+  ).Scan(&userId, &email, &createdAt)
+  switch {
+  case err == sql.ErrNoRows:
+    return nil, repository.ErrNoIdentity
+
+  case err != nil:
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+
+  createdRaw, _ := time.Unix(createdAt, 0).UTC().MarshalText()
+  return &model.Identity{
+    Issuer: issuer,
+    Subject: subject,
+    UserId: model.UserId(userId),
+    Email: email,
+    CreatedAt: string(createdRaw),
+  }, nil
+}
+
+// AddIdentity links identity to existing user
+func (r *Repository) AddIdentity(ctx context.Context, identity *model.Identity) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  if err := r.addIdentity(ctx, tx, identity); err != nil {
+    return err
+  }
+  return tx.Commit()
+}
+
+/**
+ * AddWithIdentity creates user signing in with identity.
+ * Both rows are written in one transaction, identity
+ * gets new user id. Non-empty code takes one use of
+ * invite in the same transaction, as AddInvited does
+ */
+func (r *Repository) AddWithIdentity(ctx context.Context, user *model.User, identity *model.Identity,
+  code string, now time.Time,
+) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  var invitedBy sql.NullInt64
+  if code != "" {
+    createdBy, err := r.useInvite(ctx, tx, code, now)
+    if err != nil {
+      return err
+    }
+    invitedBy = sql.NullInt64{Int64: int64(createdBy), Valid: true}
+  }
+
+  var userId int
+  err = tx.QueryRowContext(ctx, "INSERT INTO users(name,password,token,expires,email,invited_by,invite_code) " +
+    "VALUES(?,?,?,?,?,?,?) RETURNING id", user.Name, user.Password, user.Token, user.Expires,
+    sql.NullString{String: user.Email, Valid: user.Email != ""}, invitedBy,
+    sql.NullString{String: code, Valid: code != ""},
+  ).Scan(&userId)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  identity.UserId = model.UserId(userId)
+  if err := r.addIdentity(ctx, tx, identity); err != nil {
+    return err
+  }
+  if err := tx.Commit(); err != nil {
+    return err
+  }
+  user.InvitedBy = model.UserId(invitedBy.Int64)
+  return nil
+}
+
+// identity of a deleted user is given to the new one
+func (r *Repository) addIdentity(ctx context.Context, tx querier, identity *model.Identity) error {
+  _, err := tx.ExecContext(ctx, "DELETE FROM identities WHERE issuer = ? AND subject = ? " +
+    "AND user_id IN (SELECT id FROM users WHERE deleted = 1)", identity.Issuer, identity.Subject)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  _, err = tx.ExecContext(ctx, "INSERT INTO identities(issuer, subject, user_id, email, created_at) " +
+    "VALUES (?,?,?,?,?)", identity.Issuer, identity.Subject, int(identity.UserId),
+    identity.Email, r.now().Unix())
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+  "log"
+  "time"
+  "context"
+  "database/sql"
+
+  "github.com/bd878/gallery/server/users/pkg/model"
+  "github.com/bd878/gallery/server/users/internal/repository"
+)
+
+/**
+ * Takes one use of invite and adds invited user in one
+ * transaction, so a failed signup does not spend the invite
+ */
+func (r *Repository) AddInvited(ctx context.Context, user *model.User, code string, now time.Time) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  createdBy, err := r.useInvite(ctx, tx, code, now)
+  if err != nil {
+    return err
+  }
+
+  _, err = tx.ExecContext(ctx, "INSERT INTO users(name,password,token,expires,email,invited_by,invite_code) " +
+    "VALUES(?,?,?,?,?,?,?)", user.Name, user.Password, user.Token, user.Expires,
+    sql.NullString{String: user.Email, Valid: user.Email != ""}, createdBy, code)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  if err := tx.Commit(); err != nil {
+    return err
+  }
+  user.InvitedBy = model.UserId(createdBy)
+  return nil
+}
+
+// useInvite takes one use of invite, returns its creator
+func (r *Repository) useInvite(ctx context.Context, tx querier, code string, now time.Time) (int, error) {
+  var createdBy int
+  err := tx.QueryRowContext(ctx, "UPDATE invites SET uses = uses + 1 " +
+    "WHERE code = ? AND revoked = 0 AND uses < max_uses AND expires_at > ? RETURNING created_by",
+    code, now.Unix(),
+  ).Scan(&createdBy)
+  if err == sql.ErrNoRows {
+    return 0, repository.ErrNoInvite
+  }
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return createdBy, err
+}
+
+/**
+ * AddInvite adds invite unless seats of its creator would
+ * go over quota, in one statement. Quota 0 is unlimited
+ */
+func (r *Repository) AddInvite(ctx context.Context, invite *model.Invite, expiresAt time.Time, quota int) error {
+  res, err := r.q.ExecContext(ctx, "INSERT INTO invites(code, created_by, max_uses, expires_at, created_at) " +
+    "SELECT ?,?,?,?,? WHERE ? = 0 OR (" + inviteSeats + ") + ? <= ?",
+    invite.Code, int(invite.CreatedBy), invite.MaxUses, expiresAt.Unix(), r.now().Unix(),
+    quota, int(invite.CreatedBy), invite.MaxUses, quota)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrInviteQuota
+  }
+  return nil
+}
+
+// inviteSeats sums seats of created_by user invites, revoked ones count only what was used
+const inviteSeats = "SELECT coalesce(sum(CASE WHEN revoked = 1 THEN uses ELSE max_uses END), 0) " +
+  "FROM invites WHERE created_by = ?"
+
+const inviteColumns = "code, created_by, max_uses, uses, revoked, expires_at, created_at"
+
+func (r *Repository) GetInvite(ctx context.Context, code string) (*model.Invite, error) {
+  row := r.q.QueryRowContext(ctx, "SELECT " + inviteColumns + " FROM invites WHERE code = ?", code)
+  invite, err := scanInvite(row)
+  switch {
+  case err == sql.ErrNoRows:
+    return nil, repository.ErrNoInvite
+
+  case err != nil:
+    log.Printf("query error: %v\n", err)
+    return nil, err
+
+  default:
+    return invite, nil
+  }
+}
+
+// ListInvites returns invites created by user, all when createdBy is 0
+func (r *Repository) ListInvites(ctx context.Context, createdBy model.UserId) ([]*model.Invite, error) {
+  rows, err := r.q.QueryContext(ctx, "SELECT " + inviteColumns + " FROM invites " +
+    "WHERE ? = 0 OR created_by = ? ORDER BY created_at DESC", int(createdBy), int(createdBy))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.Invite
+  for rows.Next() {
+    invite, err := scanInvite(rows)
+    if err != nil {
+      return nil, err
+    }
+    res = append(res, invite)
+  }
+  return res, rows.Err()
+}
+
+/**
+ * CountInviteSeats counts signups user invites may admit.
+ * Revoked invites count only what was already used
+ */
+func (r *Repository) CountInviteSeats(ctx context.Context, createdBy model.UserId) (int, error) {
+  var count int
+  err := r.q.QueryRowContext(ctx, inviteSeats, int(createdBy)).Scan(&count)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return count, err
+}
+
+// RevokeInvite revokes invite of createdBy user, any invite when createdBy is 0
+func (r *Repository) RevokeInvite(ctx context.Context, code string, createdBy model.UserId) error {
+  res, err := r.q.ExecContext(ctx, "UPDATE invites SET revoked = 1 " +
+    "WHERE code = ? AND (? = 0 OR created_by = ?)", code, int(createdBy), int(createdBy))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrNoInvite
+  }
+  return nil
+}
+
+func scanInvite(row scanner) (*model.Invite, error) {
+  var code string
+  var createdBy, maxUses, uses int
+  var revoked bool
+  var expiresAt, createdAt int64
+
+  if err := row.Scan(&code, &createdBy, &maxUses, &uses, &revoked,
+    &expiresAt, &createdAt); err != nil {
+    return nil, err
+  }
+
+  expiresRaw, _ := time.Unix(expiresAt, 0).UTC().MarshalText()
+  createdRaw, _ := time.Unix(createdAt, 0).UTC().MarshalText()
+  return &model.Invite{
+    Code: code,
+    CreatedBy: model.UserId(createdBy),
+    MaxUses: maxUses,
+    Uses: uses,
+    Revoked: revoked,
+    ExpiresAt: string(expiresRaw),
+    CreatedAt: string(createdRaw),
+  }, nil
+}
@@ -1,5 +1,3 @@
-PRAGMA user_version=1;
-PRAGMA foreign_keys=ON;
 CREATE TABLE IF NOT EXISTS users(
   id INTEGER PRIMARY KEY,
   name TEXT,
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN deleted INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS account_deletions(
+  id TEXT PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  status TEXT NOT NULL,
+  messages_deleted INTEGER NOT NULL DEFAULT 0,
+  files_deleted INTEGER NOT NULL DEFAULT 0,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS account_deletions_status ON account_deletions(status);
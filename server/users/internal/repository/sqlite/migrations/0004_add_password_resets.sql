@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN email TEXT;
+CREATE TABLE IF NOT EXISTS password_resets(
+  token_hash TEXT PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  expires_at INTEGER NOT NULL,
+  used_at INTEGER
+);
+CREATE INDEX IF NOT EXISTS password_resets_user ON password_resets(user_id);
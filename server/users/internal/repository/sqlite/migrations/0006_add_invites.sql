@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN invited_by INTEGER;
+ALTER TABLE users ADD COLUMN invite_code TEXT;
+CREATE TABLE IF NOT EXISTS invites(
+  code TEXT PRIMARY KEY,
+  created_by INTEGER NOT NULL,
+  max_uses INTEGER NOT NULL DEFAULT 1,
+  uses INTEGER NOT NULL DEFAULT 0,
+  expires_at INTEGER NOT NULL,
+  created_at INTEGER NOT NULL,
+  revoked INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS invites_created_by ON invites(created_by);
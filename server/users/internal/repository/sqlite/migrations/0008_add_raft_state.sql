@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS raft_state(
+  id INTEGER PRIMARY KEY CHECK (id = 0),
+  applied_index INTEGER NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS identities(
+  issuer TEXT NOT NULL,
+  subject TEXT NOT NULL,
+  user_id INTEGER NOT NULL,
+  email TEXT NOT NULL DEFAULT '',
+  created_at INTEGER NOT NULL,
+  PRIMARY KEY(issuer, subject)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS identities_user ON identities(user_id, issuer);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS audit_log(
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  time INTEGER NOT NULL,
+  event TEXT NOT NULL,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  name TEXT NOT NULL DEFAULT '',
+  actor_id INTEGER NOT NULL DEFAULT 0,
+  ip TEXT NOT NULL DEFAULT '',
+  success INTEGER NOT NULL DEFAULT 1,
+  details TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_time ON audit_log(time);
+CREATE INDEX IF NOT EXISTS audit_log_user ON audit_log(user_id, time);
+CREATE INDEX IF NOT EXISTS audit_log_event ON audit_log(event, time);
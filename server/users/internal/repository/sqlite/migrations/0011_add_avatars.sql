@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS avatars(
+  id TEXT PRIMARY KEY,
+  data BLOB NOT NULL
+);
@@ -0,0 +1,115 @@
+package repository
+
+import (
+  "log"
+  "context"
+  "strings"
+  "database/sql"
+)
+
+// replicated tables, in restore order
+var snapshotTables = []string{
+  "users",
+  "login_attempts",
+  "account_deletions",
+  "password_resets",
+  "invites",
+  "identities",
+  "audit_log",
+  "avatars",
+  "raft_state",
+}
+
+func (r *Repository) AppliedIndex(ctx context.Context) (uint64, error) {
+  var index uint64
+  err := r.q.QueryRowContext(ctx, "SELECT applied_index FROM raft_state WHERE id = 0").Scan(&index)
+  if err == sql.ErrNoRows {
+    return 0, nil
+  }
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return index, err
+}
+
+func (r *Repository) SetAppliedIndex(ctx context.Context, index uint64) error {
+  _, err := r.q.ExecContext(ctx, "INSERT INTO raft_state(id, applied_index) VALUES (0, ?) " +
+    "ON CONFLICT(id) DO UPDATE SET applied_index = excluded.applied_index", index)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
+
+// Snapshot writes consistent copy of the database to path
+func (r *Repository) Snapshot(ctx context.Context, path string) error {
+  _, err := r.db.ExecContext(ctx, "VACUUM INTO ?", path)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
+
+/**
+ * Restore replaces replicated tables with the ones
+ * of database copy at path, in one transaction.
+ * Columns are matched by name
+ */
+func (r *Repository) Restore(ctx context.Context, path string) error {
+  conn, err := r.db.Conn(ctx)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+
+  if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS snap", path); err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  defer conn.ExecContext(context.Background(), "DETACH DATABASE snap")
+
+  tx, err := conn.BeginTx(ctx, nil)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  for _, table := range snapshotTables {
+    columns, err := tableColumns(ctx, tx, table)
+    if err != nil {
+      return err
+    }
+
+    if _, err := tx.ExecContext(ctx, "DELETE FROM main." + table); err != nil {
+      log.Printf("query error: %v\n", err)
+      return err
+    }
+    _, err = tx.ExecContext(ctx, "INSERT INTO main." + table + "(" + columns + ") " +
+      "SELECT " + columns + " FROM snap." + table)
+    if err != nil {
+      log.Printf("query error: %v\n", err)
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) (string, error) {
+  rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?, 'main')", table)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return "", err
+  }
+  defer rows.Close()
+
+  var columns []string
+  for rows.Next() {
+    var name string
+    if err := rows.Scan(&name); err != nil {
+      return "", err
+    }
+    columns = append(columns, name)
+  }
+  return strings.Join(columns, ", "), rows.Err()
+}
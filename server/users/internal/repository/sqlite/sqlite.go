@@ -4,6 +4,8 @@ import (
   "errors"
   "context"
   "log"
+  "time"
+  "strings"
   "database/sql"
 
   _ "github.com/mattn/go-sqlite3"
@@ -15,25 +17,63 @@ var ErrNotImplemented = errors.New("not implemented")
 
 type Repository struct {
   db *sql.DB
+  // set in repository of Begin
+  tx *sql.Tx
+  q querier
+  // replicated writes are stamped with leader time
+  now func() time.Time
 }
 
+// Open returns database without migrating it
+func Open(dbpath string) (*sql.DB, error) {
+  // busy timeout lets http and grpc processes
+  // share one database file without SQLITE_BUSY on writes
+  return sql.Open("sqlite3", "file:" + dbpath + "?_busy_timeout=5000")
+}
+
+// New opens database and applies pending migrations
 func New(dbpath string) (*Repository, error) {
-  db, err := sql.Open("sqlite3", "file:" + dbpath)
+  db, err := Open(dbpath)
   if err != nil {
     return nil, err
   }
-  return &Repository{db}, nil
+  if err := migrateUp(db); err != nil {
+    db.Close()
+    return nil, err
+  }
+  return &Repository{db, nil, db, time.Now}, nil
+}
+
+// At returns repository writing t as current time
+func (r *Repository) At(t time.Time) *Repository {
+  return &Repository{r.db, r.tx, r.q, func() time.Time { return t }}
 }
 
 func (r *Repository) Add(ctx context.Context, user *model.User) error {
-  _, err := r.db.ExecContext(ctx, "INSERT INTO users(name,password,token,expires)" +
-    "VALUES(?,?,?,?)", user.Name, user.Password, user.Token, user.Expires)
+  _, err := r.q.ExecContext(ctx, "INSERT INTO users(name,password,token,expires,email)" +
+    "VALUES(?,?,?,?,?)", user.Name, user.Password, user.Token, user.Expires,
+    sql.NullString{String: user.Email, Valid: user.Email != ""})
   if err != nil {
     log.Printf("query error: %v\n", err)
   }
   return err
 }
 
+// AddFirst adds user only when there are no users yet, in one statement
+func (r *Repository) AddFirst(ctx context.Context, user *model.User) error {
+  res, err := r.q.ExecContext(ctx, "INSERT INTO users(name,password,token,expires,email) " +
+    "SELECT ?,?,?,?,? WHERE NOT EXISTS (SELECT 1 FROM users)", user.Name, user.Password,
+    user.Token, user.Expires, sql.NullString{String: user.Email, Valid: user.Email != ""})
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrUsersExist
+  }
+  return nil
+}
+
 func (r *Repository) Has(ctx context.Context, user *model.User) (bool, error) {
   if user.Password == "" {
     return r.hasUser(ctx, user.Name)
@@ -47,38 +87,45 @@ func (r *Repository) Get(ctx context.Context, user *model.User) (*model.User, er
     return r.getByToken(ctx, user.Token)
   } else if user.Name != "" {
     return r.getByUserName(ctx, user.Name)
+  } else if user.Id != 0 {
+    return r.getById(ctx, user.Id)
   }
   return nil, ErrNotImplemented
 }
 
 func (r *Repository) Refresh(ctx context.Context, user *model.User) error {
-  _, err := r.db.ExecContext(ctx, "UPDATE users SET token = ?, expires = ? WHERE name = ?",
+  _, err := r.q.ExecContext(ctx, "UPDATE users SET token = ?, expires = ? WHERE name = ? AND deleted = 0",
     user.Token, user.Expires, user.Name)
   return err
 }
 
-func (r *Repository) getByUserName(ctx context.Context, name string) (*model.User, error) {
-  var password, token string
-  var expires sql.NullString
-  var id int
+const userColumns = "id, name, password, token, expires, email, role, disabled, invited_by, " +
+  "display_name, avatar_id, time_zone, locale, sort_order"
 
-  err := r.db.QueryRowContext(ctx, "SELECT id, name, password, token, expires FROM users WHERE " +
-    "name = ?", name).Scan(&id, &name, &password, &token, &expires)
+func (r *Repository) getByUserName(ctx context.Context, name string) (*model.User, error) {
+  row := r.q.QueryRowContext(ctx, "SELECT " + userColumns + " FROM users WHERE " +
+    "name = ? AND deleted = 0", name)
+  user, err := scanUser(row)
+  switch {
+  case err == sql.ErrNoRows:
+    log.Printf("no rows for name %v\n", name)
+    return nil, repository.ErrNoUser
 
-  msg := &model.User{
-    Id: model.UserId(id),
-    Name: name,
-    Password: password,
-    Token: token,
-  }
+  case err != nil:
+    log.Printf("query error: %v\n", err)
+    return nil, err
 
-  if expires.Valid {
-    msg.Expires = expires.String
+  default:
+    return user, nil
   }
+}
 
+func (r *Repository) getByToken(ctx context.Context, token string) (*model.User, error) {
+  row := r.q.QueryRowContext(ctx, "SELECT " + userColumns + " FROM users WHERE " +
+    "token = ? AND deleted = 0", token)
+  user, err := scanUser(row)
   switch {
   case err == sql.ErrNoRows:
-    log.Printf("no rows for name %v\n", name)
     return nil, repository.ErrNoUser
 
   case err != nil:
@@ -86,19 +133,17 @@ func (r *Repository) getByUserName(ctx context.Context, name string) (*model.Use
     return nil, err
 
   default:
-    return msg, nil
+    return user, nil
   }
 }
 
-func (r *Repository) getByToken(ctx context.Context, token string) (*model.User, error) {
-  var name, password, expires string
-  var id int
-
-  err := r.db.QueryRowContext(ctx, "SELECT id, name, password, token, expires FROM users WHERE " +
-    "token = ?", token).Scan(&id, &name, &password, &token, &expires)
+func (r *Repository) getById(ctx context.Context, id model.UserId) (*model.User, error) {
+  row := r.q.QueryRowContext(ctx, "SELECT " + userColumns + " FROM users WHERE " +
+    "id = ? AND deleted = 0", int(id))
+  user, err := scanUser(row)
   switch {
   case err == sql.ErrNoRows:
-    log.Printf("no rows for token %v\n", token)
+    log.Printf("no rows for id %v\n", id)
     return nil, repository.ErrNoUser
 
   case err != nil:
@@ -106,20 +151,14 @@ func (r *Repository) getByToken(ctx context.Context, token string) (*model.User,
     return nil, err
 
   default:
-    return &model.User{
-      Id: model.UserId(id),
-      Name: name,
-      Password: password,
-      Token: token,
-      Expires: expires,
-    }, nil
+    return user, nil
   }
 }
 
 func (r *Repository) hasUserAndPassword(ctx context.Context, user *model.User) (bool, error) {
   var count int
-  err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE " +
-    "name = ? AND password = ?", user.Name, user.Password).Scan(&count)
+  err := r.q.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE " +
+    "name = ? AND password = ? AND deleted = 0", user.Name, user.Password).Scan(&count)
   switch {
   case err != nil:
     log.Printf("query error: %v\n", err)
@@ -135,8 +174,8 @@ func (r *Repository) hasUserAndPassword(ctx context.Context, user *model.User) (
 
 func (r *Repository) hasUser(ctx context.Context, name string) (bool, error) {
   var count int
-  err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE " +
-    "name = ?", name).Scan(&count)
+  err := r.q.QueryRowContext(ctx, "SELECT count(*) FROM users WHERE " +
+    "name = ? AND deleted = 0", name).Scan(&count)
   switch {
   case err != nil:
     log.Printf("query error: %v\n", err)
@@ -148,4 +187,468 @@ func (r *Repository) hasUser(ctx context.Context, name string) (bool, error) {
     }
     return true, nil
   }
-}
\ No newline at end of file
+}
+
+func (r *Repository) GetAttempts(ctx context.Context, key string) (*model.LoginAttempts, error) {
+  var failures int
+  var lastFailure, lockedUntil int64
+
+  err := r.q.QueryRowContext(ctx, "SELECT failures, last_failure, locked_until " +
+    "FROM login_attempts WHERE key = ?", key).Scan(&failures, &lastFailure, &lockedUntil)
+  switch {
+  case err == sql.ErrNoRows:
+    return &model.LoginAttempts{Key: key}, nil
+
+  case err != nil:
+    log.Printf("query error: %v\n", err)
+    return nil, err
+
+  default:
+    return &model.LoginAttempts{
+      Key: key,
+      Failures: failures,
+      LastFailure: time.Unix(lastFailure, 0),
+      LockedUntil: time.Unix(lockedUntil, 0),
+    }, nil
+  }
+}
+
+/**
+ * Counts one more failure for key in a single statement,
+ * so that concurrent processes on the same database
+ * never lose an increment. Failures older than
+ * window start the count over, attempts on a locked
+ * key are not counted
+ */
+func (r *Repository) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (
+  *model.LoginAttempts,
+  error,
+) {
+  var failures int
+  var lockedUntil int64
+
+  err := r.q.QueryRowContext(ctx, "INSERT INTO login_attempts(key, failures, last_failure, locked_until) " +
+    "VALUES (?, 1, ?, 0) " +
+    "ON CONFLICT(key) DO UPDATE SET " +
+      "failures = CASE WHEN locked_until > excluded.last_failure THEN failures " +
+        "WHEN last_failure < ? THEN 1 ELSE failures + 1 END, " +
+      "last_failure = excluded.last_failure " +
+    "RETURNING failures, locked_until",
+    key, now.Unix(), now.Add(-window).Unix(),
+  ).Scan(&failures, &lockedUntil)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+
+  return &model.LoginAttempts{
+    Key: key,
+    Failures: failures,
+    LastFailure: now,
+    LockedUntil: time.Unix(lockedUntil, 0),
+  }, nil
+}
+
+// LockUntil never shortens a lock set by another process
+func (r *Repository) LockUntil(ctx context.Context, key string, until time.Time) error {
+  _, err := r.q.ExecContext(ctx, "UPDATE login_attempts SET locked_until = max(locked_until, ?) " +
+    "WHERE key = ?", until.Unix(), key)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
+
+// ForgiveFailure takes back one failure counted for key
+func (r *Repository) ForgiveFailure(ctx context.Context, key string) error {
+  _, err := r.q.ExecContext(ctx, "UPDATE login_attempts SET failures = max(failures - 1, 0) " +
+    "WHERE key = ?", key)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
+
+func (r *Repository) ResetAttempts(ctx context.Context, key string) error {
+  _, err := r.q.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = ?", key)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return err
+}
+
+/**
+ * Marks user deleted and records deletion in one transaction.
+ * User row stays until messages are purged, so its id
+ * is not handed to a new user meanwhile
+ */
+func (r *Repository) Delete(ctx context.Context, deletion *model.Deletion) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  res, err := tx.ExecContext(ctx, "UPDATE users SET deleted = 1, token = '', expires = '' " +
+    "WHERE id = ? AND deleted = 0", int(deletion.UserId))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrNoUser
+  }
+
+  now := r.now().Unix()
+  _, err = tx.ExecContext(ctx, "INSERT INTO account_deletions(id, user_id, status, created_at, updated_at) " +
+    "VALUES (?,?,?,?,?)", deletion.Id, int(deletion.UserId), deletion.Status, now, now)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  return tx.Commit()
+}
+
+const deletionColumns = "id, user_id, status, messages_deleted, files_deleted, created_at, updated_at"
+
+func (r *Repository) GetDeletion(ctx context.Context, id string) (*model.Deletion, error) {
+  row := r.q.QueryRowContext(ctx, "SELECT " + deletionColumns + " FROM account_deletions " +
+    "WHERE id = ?", id)
+  deletion, err := scanDeletion(row)
+  switch {
+  case err == sql.ErrNoRows:
+    return nil, repository.ErrNoDeletion
+
+  case err != nil:
+    log.Printf("query error: %v\n", err)
+    return nil, err
+
+  default:
+    return deletion, nil
+  }
+}
+
+func (r *Repository) ListDeletions(ctx context.Context) ([]*model.Deletion, error) {
+  rows, err := r.q.QueryContext(ctx, "SELECT " + deletionColumns + " FROM account_deletions " +
+    "WHERE status != ? ORDER BY created_at ASC", model.DeletionDone)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.Deletion
+  for rows.Next() {
+    deletion, err := scanDeletion(rows)
+    if err != nil {
+      return nil, err
+    }
+    res = append(res, deletion)
+  }
+  return res, rows.Err()
+}
+
+/**
+ * Adds deleted counts to the stored ones. Once done,
+ * user row is removed for good
+ */
+func (r *Repository) UpdateDeletion(ctx context.Context, deletion *model.Deletion) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  var userId int
+  err = tx.QueryRowContext(ctx, "UPDATE account_deletions SET status = ?, " +
+    "messages_deleted = messages_deleted + ?, files_deleted = files_deleted + ?, updated_at = ? " +
+    "WHERE id = ? RETURNING user_id",
+    deletion.Status, deletion.MessagesDeleted, deletion.FilesDeleted, r.now().Unix(), deletion.Id,
+  ).Scan(&userId)
+  if err == sql.ErrNoRows {
+    return repository.ErrNoDeletion
+  }
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  if deletion.Status == model.DeletionDone {
+    _, err = tx.ExecContext(ctx, "DELETE FROM users WHERE id = ? AND deleted = 1", userId)
+    if err != nil {
+      log.Printf("query error: %v\n", err)
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+type scanner interface {
+  Scan(dest ...any) error
+}
+
+func scanUser(row scanner) (*model.User, error) {
+  var id int
+  var name, password string
+  var token, expires, email sql.NullString
+  var role string
+  var disabled bool
+  var invitedBy sql.NullInt64
+  var profile model.Profile
+
+  if err := row.Scan(&id, &name, &password, &token, &expires, &email,
+    &role, &disabled, &invitedBy, &profile.DisplayName, &profile.AvatarId,
+    &profile.TimeZone, &profile.Locale, &profile.SortOrder); err != nil {
+    return nil, err
+  }
+  profile.Email = email.String
+
+  return &model.User{
+    Id: model.UserId(id),
+    Name: name,
+    Password: password,
+    Token: token.String,
+    Expires: expires.String,
+    Email: email.String,
+    Role: role,
+    Disabled: disabled,
+    InvitedBy: model.UserId(invitedBy.Int64),
+    Profile: &profile,
+  }, nil
+}
+
+func scanDeletion(row scanner) (*model.Deletion, error) {
+  var id, status string
+  var userId, messagesDeleted, filesDeleted int
+  var createdAt, updatedAt int64
+
+  if err := row.Scan(&id, &userId, &status, &messagesDeleted, &filesDeleted,
+    &createdAt, &updatedAt); err != nil {
+    return nil, err
+  }
+
+  createdRaw, _ := time.Unix(createdAt, 0).UTC().MarshalText()
+  updatedRaw, _ := time.Unix(updatedAt, 0).UTC().MarshalText()
+  return &model.Deletion{
+    Id: id,
+    UserId: model.UserId(userId),
+    Status: status,
+    MessagesDeleted: messagesDeleted,
+    FilesDeleted: filesDeleted,
+    CreatedAt: string(createdRaw),
+    UpdatedAt: string(updatedRaw),
+  }, nil
+}
+
+// UpdatePassword sets new password and session token,
+// so sessions started with the old token end
+func (r *Repository) UpdatePassword(ctx context.Context, user *model.User) error {
+  res, err := r.q.ExecContext(ctx, "UPDATE users SET password = ?, token = ?, expires = ? " +
+    "WHERE id = ? AND deleted = 0", user.Password, user.Token, user.Expires, int(user.Id))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrNoUser
+  }
+  return nil
+}
+
+// AddPasswordReset replaces unused resets of the same user
+func (r *Repository) AddPasswordReset(ctx context.Context, reset *model.PasswordReset) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  _, err = tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = ? AND used_at IS NULL",
+    int(reset.UserId))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  _, err = tx.ExecContext(ctx, "INSERT INTO password_resets(token_hash, user_id, expires_at) " +
+    "VALUES (?,?,?)", reset.TokenHash, int(reset.UserId), reset.ExpiresAt.Unix())
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  return tx.Commit()
+}
+
+/**
+ * Marks reset used and returns its user. Single statement,
+ * so a token is used once even by concurrent processes
+ */
+func (r *Repository) UsePasswordReset(ctx context.Context, tokenHash string, now time.Time) (model.UserId, error) {
+  var userId int
+
+  err := r.q.QueryRowContext(ctx, "UPDATE password_resets SET used_at = ? " +
+    "WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING user_id",
+    now.Unix(), tokenHash, now.Unix(),
+  ).Scan(&userId)
+  switch {
+  case err == sql.ErrNoRows:
+    return 0, repository.ErrNoReset
+
+  case err != nil:
+    log.Printf("query error: %v\n", err)
+    return 0, err
+
+  default:
+    return model.UserId(userId), nil
+  }
+}
+
+// List finds users with name containing query, all when query is empty
+func (r *Repository) List(ctx context.Context, query string, limit, offset int32) ([]*model.User, error) {
+  pattern := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(query) + "%"
+
+  rows, err := r.q.QueryContext(ctx, "SELECT " + userColumns + " FROM users " +
+    "WHERE deleted = 0 AND name LIKE ? ESCAPE '\\' ORDER BY id ASC LIMIT ? OFFSET ?",
+    pattern, limit, offset)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.User
+  for rows.Next() {
+    user, err := scanUser(rows)
+    if err != nil {
+      return nil, err
+    }
+    res = append(res, user)
+  }
+  return res, rows.Err()
+}
+
+// GetByIds returns found users ordered by id
+func (r *Repository) GetByIds(ctx context.Context, ids []model.UserId) ([]*model.User, error) {
+  if len(ids) == 0 {
+    return nil, nil
+  }
+
+  args := make([]any, len(ids))
+  for i, id := range ids {
+    args[i] = int(id)
+  }
+
+  rows, err := r.q.QueryContext(ctx, "SELECT " + userColumns + " FROM users " +
+    "WHERE deleted = 0 AND id IN (?" + strings.Repeat(",?", len(ids)-1) + ") ORDER BY id ASC",
+    args...)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+  defer rows.Close()
+
+  var res []*model.User
+  for rows.Next() {
+    user, err := scanUser(rows)
+    if err != nil {
+      return nil, err
+    }
+    res = append(res, user)
+  }
+  return res, rows.Err()
+}
+
+// UpdateProfile sets all profile fields but avatar
+func (r *Repository) UpdateProfile(ctx context.Context, id model.UserId, profile *model.Profile) error {
+  return r.updateUser(ctx, "UPDATE users SET email = ?, display_name = ?, time_zone = ?, " +
+    "locale = ?, sort_order = ? WHERE id = ? AND deleted = 0",
+    sql.NullString{String: profile.Email, Valid: profile.Email != ""},
+    profile.DisplayName, profile.TimeZone, profile.Locale, profile.SortOrder, int(id))
+}
+
+/**
+ * SetAvatar stores avatar image and points profile to it.
+ * Previous image is deleted in the same transaction
+ */
+func (r *Repository) SetAvatar(ctx context.Context, id model.UserId, avatarId string, data []byte) error {
+  tx, err := r.begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+
+  var prev string
+  err = tx.QueryRowContext(ctx, "SELECT avatar_id FROM users WHERE id = ? AND deleted = 0",
+    int(id)).Scan(&prev)
+  if err == sql.ErrNoRows {
+    return repository.ErrNoUser
+  }
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+
+  _, err = tx.ExecContext(ctx, "INSERT OR REPLACE INTO avatars(id, data) VALUES (?,?)", avatarId, data)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  _, err = tx.ExecContext(ctx, "UPDATE users SET avatar_id = ? WHERE id = ?", avatarId, int(id))
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if prev != "" && prev != avatarId {
+    if _, err := tx.ExecContext(ctx, "DELETE FROM avatars WHERE id = ?", prev); err != nil {
+      log.Printf("query error: %v\n", err)
+      return err
+    }
+  }
+
+  return tx.Commit()
+}
+
+// GetAvatar returns image of avatar some user has set
+func (r *Repository) GetAvatar(ctx context.Context, avatarId string) ([]byte, error) {
+  var data []byte
+  err := r.q.QueryRowContext(ctx, "SELECT a.data FROM avatars a JOIN users u ON u.avatar_id = a.id " +
+    "WHERE a.id = ? AND u.deleted = 0", avatarId).Scan(&data)
+  if err == sql.ErrNoRows {
+    return nil, repository.ErrNoAvatar
+  }
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+  }
+  return data, err
+}
+
+func (r *Repository) SetDisabled(ctx context.Context, id model.UserId, disabled bool) error {
+  return r.updateUser(ctx, "UPDATE users SET disabled = ? WHERE id = ? AND deleted = 0",
+    disabled, int(id))
+}
+
+func (r *Repository) SetRole(ctx context.Context, name, role string) error {
+  return r.updateUser(ctx, "UPDATE users SET role = ? WHERE name = ? AND deleted = 0",
+    role, name)
+}
+
+// Logout drops user session token
+func (r *Repository) Logout(ctx context.Context, id model.UserId) error {
+  return r.updateUser(ctx, "UPDATE users SET token = '', expires = '' WHERE id = ? AND deleted = 0",
+    int(id))
+}
+
+func (r *Repository) updateUser(ctx context.Context, query string, args ...any) error {
+  res, err := r.q.ExecContext(ctx, query, args...)
+  if err != nil {
+    log.Printf("query error: %v\n", err)
+    return err
+  }
+  if n, _ := res.RowsAffected(); n == 0 {
+    return repository.ErrNoUser
+  }
+  return nil
+}
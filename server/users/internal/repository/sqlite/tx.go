@@ -0,0 +1,82 @@
+package repository
+
+import (
+  "log"
+  "context"
+  "database/sql"
+)
+
+// querier runs statements on database or on open transaction
+type querier interface {
+  ExecContext(context.Context, string, ...any) (sql.Result, error)
+  QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+  QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+/**
+ * Begin returns repository writing in one transaction,
+ * ended with Commit or Rollback. Fsm applies a log record
+ * and its index in it
+ */
+func (r *Repository) Begin(ctx context.Context) (*Repository, error) {
+  tx, err := r.db.BeginTx(ctx, nil)
+  if err != nil {
+    log.Printf("begin error: %v\n", err)
+    return nil, err
+  }
+  return &Repository{r.db, tx, tx, r.now}, nil
+}
+
+func (r *Repository) Commit() error {
+  return r.tx.Commit()
+}
+
+func (r *Repository) Rollback() error {
+  return r.tx.Rollback()
+}
+
+// txn is a transaction of one write, or savepoint in the one of Begin
+type txn struct {
+  querier
+  commit func() error
+  rollback func() error
+}
+
+func (t *txn) Commit() error {
+  return t.commit()
+}
+
+// Rollback after Commit does nothing, so it can be deferred
+func (t *txn) Rollback() error {
+  return t.rollback()
+}
+
+func (r *Repository) begin(ctx context.Context) (*txn, error) {
+  if r.tx == nil {
+    tx, err := r.db.BeginTx(ctx, nil)
+    if err != nil {
+      return nil, err
+    }
+    return &txn{tx, tx.Commit, tx.Rollback}, nil
+  }
+
+  if _, err := r.tx.ExecContext(ctx, "SAVEPOINT write"); err != nil {
+    log.Printf("query error: %v\n", err)
+    return nil, err
+  }
+  done := false
+  release := func() error {
+    done = true
+    _, err := r.tx.ExecContext(ctx, "RELEASE write")
+    return err
+  }
+  return &txn{r.tx, release, func() error {
+    if done {
+      return nil
+    }
+    if _, err := r.tx.ExecContext(ctx, "ROLLBACK TO write"); err != nil {
+      return err
+    }
+    return release()
+  }}, nil
+}
@@ -5,19 +5,81 @@ import (
 )
 
 func UserToProto(u *User) *api.User {
+  var profile *api.Profile
+  if u.Profile != nil {
+    profile = ProfileToProto(u.Profile)
+  }
   return &api.User{
     Id: int32(u.Id),
     Name: u.Name,
     Token: u.Token,
     Expires: u.Expires,
+    Role: u.Role,
+    Disabled: u.Disabled,
+    Email: u.Email,
+    Profile: profile,
   }
 }
 
 func UserFromProto(u *api.User) *User {
+  var profile *Profile
+  if u.Profile != nil {
+    profile = ProfileFromProto(u.Profile)
+  }
   return &User{
     Id: UserId(u.Id),
     Name: u.Name,
     Token: u.Token,
     Expires: u.Expires,
+    Role: u.Role,
+    Disabled: u.Disabled,
+    Email: u.Email,
+    Profile: profile,
+  }
+}
+
+func DeletionToProto(d *Deletion) *api.Deletion {
+  return &api.Deletion{
+    Id: d.Id,
+    UserId: int32(d.UserId),
+    Status: d.Status,
+    MessagesDeleted: int32(d.MessagesDeleted),
+    FilesDeleted: int32(d.FilesDeleted),
+    CreatedAt: d.CreatedAt,
+    UpdatedAt: d.UpdatedAt,
+  }
+}
+
+func DeletionFromProto(d *api.Deletion) *Deletion {
+  return &Deletion{
+    Id: d.Id,
+    UserId: UserId(d.UserId),
+    Status: d.Status,
+    MessagesDeleted: int(d.MessagesDeleted),
+    FilesDeleted: int(d.FilesDeleted),
+    CreatedAt: d.CreatedAt,
+    UpdatedAt: d.UpdatedAt,
   }
-}
\ No newline at end of file
+}
+
+func ProfileToProto(p *Profile) *api.Profile {
+  return &api.Profile{
+    Email: p.Email,
+    DisplayName: p.DisplayName,
+    AvatarId: p.AvatarId,
+    TimeZone: p.TimeZone,
+    Locale: p.Locale,
+    SortOrder: p.SortOrder,
+  }
+}
+
+func ProfileFromProto(p *api.Profile) *Profile {
+  return &Profile{
+    Email: p.Email,
+    DisplayName: p.DisplayName,
+    AvatarId: p.AvatarId,
+    TimeZone: p.TimeZone,
+    Locale: p.Locale,
+    SortOrder: p.SortOrder,
+  }
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // TODO: UserId -> UserID
 type UserId int
 // TODO: type UserName string
@@ -11,6 +13,141 @@ type User struct {
   Password string `json:"password"`
   Token string `json:"token"`
   Expires string `json:"expires"`
+  Email string `json:"email,omitempty"`
+  Role string `json:"role,omitempty"`
+  Disabled bool `json:"disabled,omitempty"`
+  InvitedBy UserId `json:"invitedby,omitempty"`
+  Profile *Profile `json:"profile,omitempty"`
+}
+
+// User editable fields. Avatar is set by upload only,
+// empty SortOrder and TimeZone leave client defaults
+type Profile struct {
+  Email string `json:"email,omitempty"`
+  DisplayName string `json:"displayname"`
+  AvatarId string `json:"avatarid"`
+  TimeZone string `json:"timezone"`
+  Locale string `json:"locale"`
+  SortOrder string `json:"sortorder"`
+}
+
+const (
+  SortAsc = "asc"
+  SortDesc = "desc"
+)
+
+const (
+  RoleUser = "user"
+  RoleAdmin = "admin"
+)
+
+// Single-use password reset, only token hash is stored
+type PasswordReset struct {
+  TokenHash string
+  UserId UserId
+  ExpiresAt time.Time
+}
+
+// Invite code, valid until used MaxUses times or expired
+type Invite struct {
+  Code string `json:"code"`
+  CreatedBy UserId `json:"createdby"`
+  MaxUses int `json:"maxuses"`
+  Uses int `json:"uses"`
+  Revoked bool `json:"revoked,omitempty"`
+  ExpiresAt string `json:"expiresat"`
+  CreatedAt string `json:"createdat"`
+}
+
+// External account, issuer and subject identify it
+// at an OpenID Connect provider
+type Identity struct {
+  Issuer string `json:"issuer"`
+  Subject string `json:"subject"`
+  UserId UserId `json:"userid"`
+  Email string `json:"email,omitempty"`
+  CreatedAt string `json:"createdat"`
+}
+
+// Audit log event types
+const (
+  AuditSignup = "signup"
+  AuditLogin = "login"
+  AuditLoginLocked = "login_locked"
+  AuditRefresh = "refresh"
+  AuditLogout = "logout"
+  AuditPasswordChange = "password_change"
+  AuditPasswordResetRequest = "password_reset_request"
+  AuditPasswordReset = "password_reset"
+  AuditIdentityLink = "identity_link"
+  AuditAccountDelete = "account_delete"
+  AuditAdminDisable = "admin_disable"
+  AuditAdminEnable = "admin_enable"
+  AuditAdminLogout = "admin_logout"
+  AuditAdminResetPassword = "admin_reset_password"
+  AuditAdminUnlock = "admin_unlock"
+  AuditAdminSetRole = "admin_set_role"
+)
+
+/**
+ * Security relevant event, written once and never
+ * changed. UserId is the account affected, ActorId
+ * the admin acting on it. Details never hold secrets
+ */
+type AuditEvent struct {
+  Id int64 `json:"id"`
+  Time string `json:"time"`
+  Event string `json:"event"`
+  UserId UserId `json:"userid,omitempty"`
+  Name string `json:"name,omitempty"`
+  ActorId UserId `json:"actorid,omitempty"`
+  IP string `json:"ip,omitempty"`
+  Success bool `json:"success"`
+  Details map[string]string `json:"details,omitempty"`
+}
+
+// Audit query, zero fields do not filter
+type AuditFilter struct {
+  UserId UserId
+  Events []string
+  Since time.Time
+  Until time.Time
+  Limit int32
+  Offset int32
+}
+
+// Failed login attempts, counted per account
+// and per client address
+type LoginAttempts struct {
+  Key string
+  Failures int
+  LastFailure time.Time
+  LockedUntil time.Time
+}
+
+// Lockout state reported to the client
+type Lockout struct {
+  Locked bool `json:"locked"`
+  Until string `json:"until,omitempty"`
+  RetryAfter int `json:"retryafter,omitempty"`
+}
+
+const (
+  DeletionPending = "pending"
+  DeletionPurging = "purging"
+  DeletionDone = "done"
+)
+
+// Account deletion, kept until messages service
+// purges all user messages and files
+type Deletion struct {
+  Id string `json:"id"`
+  UserId UserId `json:"userid"`
+  Status string `json:"status"`
+  MessagesDeleted int `json:"messagesdeleted"`
+  FilesDeleted int `json:"filesdeleted"`
+  CreatedAt string `json:"createdat"`
+  UpdatedAt string `json:"updatedat"`
 }
 
 // Response to return to the client
@@ -24,3 +161,38 @@ type ServerAuthorizeResponse struct {
   Expired bool `json:"expired"`
   User User `json:"user"`
 }
+
+type ServerLoginResponse struct {
+  ServerResponse
+  Lockout Lockout `json:"lockout"`
+}
+
+type ServerDeletionResponse struct {
+  ServerResponse
+  Deletion Deletion `json:"deletion"`
+}
+
+type ServerUsersResponse struct {
+  ServerResponse
+  Users []User `json:"users"`
+}
+
+type ServerInviteResponse struct {
+  ServerResponse
+  Invite Invite `json:"invite"`
+}
+
+type ServerInvitesResponse struct {
+  ServerResponse
+  Invites []Invite `json:"invites"`
+}
+
+type ServerProfileResponse struct {
+  ServerResponse
+  Profile Profile `json:"profile"`
+}
+
+type ServerAuditResponse struct {
+  ServerResponse
+  Events []AuditEvent `json:"events"`
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+)
+
+// RandomToken returns n random bytes hex encoded,
+// for secrets that must not be guessed
+func RandomToken(n int) (string, error) {
+  b := make([]byte, n)
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+  return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+  "io"
+  "os"
+  "strings"
+  "path/filepath"
+)
+
+// SaveFile copies attachment to dir under random id
+// keeping file extension, returns the id and bytes written
+func SaveFile(r io.Reader, dir, fileName string) (string, int64, error) {
+  fileId := strings.ToLower(RandomString(10) + filepath.Ext(fileName))
+
+  f, err := os.OpenFile(filepath.Join(dir, fileId), os.O_WRONLY|os.O_CREATE, 0666)
+  if err != nil {
+    return "", 0, err
+  }
+  defer f.Close()
+
+  written, err := io.Copy(f, r)
+  if err != nil {
+    return "", 0, err
+  }
+  return fileId, written, nil
+}